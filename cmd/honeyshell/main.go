@@ -4,6 +4,8 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/wisepythagoras/honeyshell/core"
 	"github.com/wisepythagoras/honeyshell/plugin"
@@ -19,6 +21,19 @@ func main() {
 	key := flag.String("key", "", "The RSA key to use")
 	pluginsFolder := flag.String("plugins", "", "The path to the folder containing the plugins")
 	vfsPath := flag.String("vfs", "", "The path to the VFS (virtual file system) JSON file")
+	artifactsDir := flag.String("artifacts", "artifacts", "The directory where uploaded and created files are stored")
+	fetcher := flag.String("fetcher", "", "Where downloads come from: 'none', 'local' (from -fetch-dir) or 'http'")
+	fetchDir := flag.String("fetch-dir", "", "A directory with files to serve to wget, curl, etc, instead of placeholders")
+	fetchMaxSize := flag.Int64("fetch-max-size", 10<<20, "The largest download (in bytes) that the http fetcher accepts")
+	fetchTimeout := flag.Duration("fetch-timeout", 30*time.Second, "How long the http fetcher waits for a download")
+	fetchAllow := flag.String("fetch-allow", "", "Comma separated domains or CIDRs the http fetcher may download from (default all)")
+	fetchDeny := flag.String("fetch-deny", "", "Comma separated domains or CIDRs the http fetcher may never download from")
+	fetchProxy := flag.String("fetch-proxy", "", "Route the http fetcher through a proxy (e.g. socks5://127.0.0.1:9050)")
+	fetchPrivate := flag.Bool("fetch-allow-private", false, "Allow the http fetcher to connect to private and loopback addresses")
+	persist := flag.String("persist", "", "Keep the files of returning attackers, recognized by 'ip' or 'credentials'")
+	persistTTL := flag.Duration("persist-ttl", 24*time.Hour, "How long the files of an attacker are kept after they leave (0 is forever)")
+	persona := flag.String("persona", "", "Generate the identity of the machine per 'listener' or per attacker 'ip'")
+	personaSeed := flag.String("persona-seed", "", "A secret that goes into every persona, so that they can't be predicted")
 	verbose := flag.Bool("verbose", false, "Print out debug messages")
 
 	// Parse the command line arguments (flags).
@@ -34,6 +49,10 @@ func main() {
 		log.Fatalf("Invalid port number %d\n", *port)
 	}
 
+	if *persona != "" && *persona != plugin.PersonaListener && *persona != plugin.PersonaIP {
+		log.Fatalf("Unknown persona %q\n", *persona)
+	}
+
 	var pluginManager *plugin.PluginManager
 	var vfs *plugin.VFS
 
@@ -48,6 +67,22 @@ func main() {
 		log.Fatalln(err)
 	}
 
+	artifacts, err := core.NewArtifactStore(*artifactsDir, db)
+
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	var state *core.StateStore
+
+	if len(*persist) > 0 {
+		state, err = core.NewStateStore(*persist, *persistTTL, db)
+
+		if err != nil {
+			log.Fatalln("Error:", err)
+		}
+	}
+
 	if len(*vfsPath) > 0 {
 		vfs, err = plugin.ReadVFSJSONFile(*vfsPath)
 
@@ -62,6 +97,28 @@ func main() {
 			PluginVFS: vfs,
 		}
 
+		if *fetcher == "" && len(*fetchDir) > 0 {
+			*fetcher = "local"
+		}
+
+		switch *fetcher {
+		case "", "none":
+			pluginManager.Fetcher = &core.DisabledFetcher{}
+		case "local":
+			pluginManager.Fetcher = &core.LocalFetcher{Dir: *fetchDir}
+		case "http":
+			httpFetcher, err := core.NewHTTPFetcher(*fetchMaxSize, *fetchTimeout, splitList(*fetchAllow), splitList(*fetchDeny), *fetchProxy)
+
+			if err != nil {
+				log.Fatalln("Error:", err)
+			}
+
+			httpFetcher.AllowPrivate = *fetchPrivate
+			pluginManager.Fetcher = httpFetcher
+		default:
+			log.Fatalf("Unknown fetcher %q\n", *fetcher)
+		}
+
 		if err := pluginManager.LoadPlugins(*pluginsFolder); err != nil {
 			log.Fatalln("Error:", err)
 		}
@@ -74,6 +131,10 @@ func main() {
 		Key:           *key,
 		Banner:        *banner,
 		PluginManager: pluginManager,
+		Artifacts:     artifacts,
+		State:         state,
+		Persona:       *persona,
+		PersonaSeed:   *personaSeed,
 		Logger:        logman,
 	}
 
@@ -115,3 +176,16 @@ func main() {
 
 	logman.Println("Terminating process")
 }
+
+// splitList splits a comma separated list from the command line.
+func splitList(list string) []string {
+	items := make([]string, 0)
+
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
@@ -15,6 +15,27 @@ import (
 	"github.com/wisepythagoras/honeyshell/plugin"
 )
 
+// templates are the patterns of the files whose contents should be rendered as
+// templates when they're read, like "/etc/motd".
+var templates []string
+
+// isTemplate returns whether a file of the cloned directory is a template.
+func isTemplate(path, basePath string) bool {
+	rel, err := filepath.Rel(basePath, path)
+
+	if err != nil {
+		return false
+	}
+
+	for _, pattern := range templates {
+		if ok, _ := filepath.Match(pattern, "/"+rel); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
 func readDir(path, basePath string) (map[string]plugin.VFSFile, error) {
 	files, err := os.ReadDir(path)
 
@@ -77,6 +98,7 @@ func readDir(path, basePath string) (map[string]plugin.VFSFile, error) {
 			vfsFile.Type = plugin.T_FILE
 			vfsFile.Contents = string(buff)
 			vfsFile.Mode = fs.FileMode(stat.Mode)
+			vfsFile.Template = isTemplate(newFilePath, basePath)
 		} else if info.Mode()&os.ModeSymlink != 0 {
 			flPath, err := os.Readlink(newFilePath)
 			flPath = strings.Replace(flPath, basePath, "", 1)
@@ -119,9 +141,16 @@ func main() {
 	path := flag.String("path", "", "The path to the directory structure to clone")
 	home := flag.String("home", "/home/{}", "Specify the home directory (add '{}' in place of the username)")
 	out := flag.String("out", "out.json", "Where to write the VFS to")
+	template := flag.String("template", "", "Comma separated patterns of files that are templates (e.g. '/etc/motd,/etc/issue*')")
 
 	flag.Parse()
 
+	for _, pattern := range strings.Split(*template, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			templates = append(templates, pattern)
+		}
+	}
+
 	if len(*path) == 0 {
 		fmt.Println("A path is required")
 		os.Exit(1)
@@ -147,6 +176,8 @@ func main() {
 		User: &plugin.User{
 			Username: "{}",
 			Group:    "{}",
+			UID:      1000,
+			GID:      1000,
 		},
 	}
 
@@ -0,0 +1,118 @@
+package core
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"gorm.io/gorm"
+)
+
+// ArtifactStore keeps a copy of every file that an attacker writes, uploads or
+// downloads. The contents are content-addressed on disk by their SHA-256 hash,
+// so the same payload dropped by a thousand bots is only stored once, and the
+// metadata of each write is saved in the database.
+type ArtifactStore struct {
+	Dir string
+	db  *gorm.DB
+}
+
+// NewArtifactStore creates the artifact store in the given directory.
+func NewArtifactStore(dir string, db *gorm.DB) (*ArtifactStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &ArtifactStore{Dir: dir, db: db}, nil
+}
+
+// PathFor returns the path on disk where the contents with the given SHA-256
+// hash are stored.
+func (as *ArtifactStore) PathFor(sha256Hex string) string {
+	return filepath.Join(as.Dir, sha256Hex[:2], sha256Hex)
+}
+
+// Save stores the contents on disk (if they weren't already there) and records
+// where they came from in the database.
+func (as *ArtifactStore) Save(session, ip, path, source string, data []byte) (*Artifact, error) {
+	md5Sum := md5.Sum(data)
+	sha1Sum := sha1.Sum(data)
+	sha256Sum := sha256.Sum256(data)
+
+	artifact := &Artifact{
+		Session:   session,
+		IPAddress: ip,
+		Path:      path,
+		Source:    source,
+		Size:      int64(len(data)),
+		MD5:       hex.EncodeToString(md5Sum[:]),
+		SHA1:      hex.EncodeToString(sha1Sum[:]),
+		SHA256:    hex.EncodeToString(sha256Sum[:]),
+		MimeType:  DetectMimeType(data),
+	}
+
+	if err := as.writeBlob(artifact.SHA256, data); err != nil {
+		return nil, err
+	}
+
+	if as.db != nil {
+		if err := as.db.Create(artifact).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return artifact, nil
+}
+
+// writeBlob writes the contents to their content-addressed location. The file
+// is first written to a temporary file and then renamed, so that a partially
+// written blob is never visible.
+func (as *ArtifactStore) writeBlob(sha256Hex string, data []byte) error {
+	blobPath := as.PathFor(sha256Hex)
+
+	if _, err := os.Stat(blobPath); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(blobPath), 0700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(blobPath), ".tmp-")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), blobPath)
+}
+
+// DetectMimeType returns the mime type of the contents. On top of what the
+// standard library can detect, it recognizes the executable and script formats
+// that usually get dropped on a honeypot.
+func DetectMimeType(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("\x7fELF")):
+		return "application/x-executable"
+	case bytes.HasPrefix(data, []byte("#!")):
+		return "text/x-shellscript"
+	}
+
+	return http.DetectContentType(data)
+}
@@ -0,0 +1,63 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArtifactStore(t *testing.T) {
+	db := newTestDB(t)
+	store, err := NewArtifactStore(filepath.Join(t.TempDir(), "artifacts"), db)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("\x7fELF\x02\x01\x01\x00")
+	a, err := store.Save("s1", "10.0.0.1", "/tmp/a", "vfs", data)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same payload dropped again is recorded again, but stored only once.
+	b, err := store.Save("s2", "10.0.0.2", "/tmp/b", "download", data)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.SHA256 != "e94466faac02d08efbb3109dbe52d0c13c5a53859e328d50dbeb2e6f0ee89871" || b.SHA256 != a.SHA256 || a.MD5 == "" || a.SHA1 == "" || a.Size != int64(len(data)) || a.MimeType != "application/x-executable" {
+		t.Errorf("Unexpected artifact %+v", a)
+	}
+
+	blobPath := store.PathFor(a.SHA256)
+
+	if blobPath != filepath.Join(store.Dir, "e9", a.SHA256) {
+		t.Errorf("Unexpected path %s", blobPath)
+	} else if contents, err := os.ReadFile(blobPath); err != nil || string(contents) != string(data) {
+		t.Errorf("Unexpected blob %q (%v)", contents, err)
+	}
+
+	blobs := 0
+
+	filepath.WalkDir(store.Dir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			blobs++
+		}
+
+		return nil
+	})
+
+	if blobs != 1 {
+		t.Errorf("Expected 1 blob, got %d", blobs)
+	}
+
+	artifacts := []Artifact{}
+	db.Order("id").Find(&artifacts)
+
+	if len(artifacts) != 2 || artifacts[0].Session != "s1" || artifacts[1].Path != "/tmp/b" || artifacts[1].Source != "download" {
+		t.Errorf("Unexpected artifacts %+v", artifacts)
+	}
+}
@@ -21,7 +21,8 @@ type PasswordConnection struct {
 }
 
 // KeyConnection defines the model that describes the table in which all the usernames
-// and public key that any peer attempts to access the system with will be stored.
+// and public key that any peer attempts to access the system with will be stored. A key
+// that an attacker planted in an earlier session is linked to that session by PlantedBy.
 type KeyConnection struct {
 	gorm.Model
 	ID        uint64    `gorm:"primaryKey; autoIncrement; not_null;"` // type:bigint for MySQL
@@ -30,10 +31,128 @@ type KeyConnection struct {
 	Key       string    `gorm:"index; not null"`
 	KeyHash   string    `gorm:"index; not null; unique_index:uidx_key_ip"`
 	Type      string    `gorm:"not null"`
+	PlantedBy string    `gorm:"index"`
 	CreatedAt time.Time `gorm:"autoCreateTime:milli"`
 	UpdatedAt time.Time `gorm:"autoCreateTime:milli"`
 }
 
+// Artifact defines the model that describes every file an attacker created, uploaded
+// or downloaded. The contents themselves live in the artifact store on disk and can be
+// found by their SHA-256 hash.
+type Artifact struct {
+	gorm.Model
+	ID        uint64    `gorm:"primaryKey; autoIncrement; not_null;"` // type:bigint for MySQL
+	Session   string    `gorm:"index; not null"`
+	IPAddress string    `gorm:"index; type:mediumtext not null"`
+	Path      string    `gorm:"not null"`
+	Source    string    `gorm:"index; not null"`
+	Size      int64     `gorm:"not null"`
+	MD5       string    `gorm:"index; not null"`
+	SHA1      string    `gorm:"index; not null"`
+	SHA256    string    `gorm:"index; not null"`
+	MimeType  string    `gorm:"not null"`
+	CreatedAt time.Time `gorm:"autoCreateTime:milli"`
+	UpdatedAt time.Time `gorm:"autoCreateTime:milli"`
+}
+
+// Download defines the model that describes every attempt to download a file from inside the
+// honeypot (with wget, curl, etc), whether or not the contents were actually fetched.
+type Download struct {
+	gorm.Model
+	ID        uint64    `gorm:"primaryKey; autoIncrement; not_null;"` // type:bigint for MySQL
+	Session   string    `gorm:"index; not null"`
+	IPAddress string    `gorm:"index; type:mediumtext not null"`
+	Command   string    `gorm:"not null"`
+	URL       string    `gorm:"index; not null"`
+	Method    string    `gorm:"not null"`
+	Headers   string    `gorm:"not null"`
+	Body      string    `gorm:"not null"`
+	Path      string    `gorm:"not null"`
+	Size      int       `gorm:"not null"`
+	Fetched   bool      `gorm:"not null"`
+	SHA256    string    `gorm:"index"`
+	CreatedAt time.Time `gorm:"autoCreateTime:milli"`
+	UpdatedAt time.Time `gorm:"autoCreateTime:milli"`
+}
+
+// Escalation defines the model that describes every attempt to become another user (mostly root)
+// with `su` or `sudo`, along with the password that was tried.
+type Escalation struct {
+	gorm.Model
+	ID        uint64    `gorm:"primaryKey; autoIncrement; not_null;"` // type:bigint for MySQL
+	Session   string    `gorm:"index; not null"`
+	IPAddress string    `gorm:"index; type:mediumtext not null"`
+	Command   string    `gorm:"not null"`
+	Username  string    `gorm:"index; not null"`
+	Target    string    `gorm:"index; not null"`
+	Password  string    `gorm:"index; not null"`
+	Accepted  bool      `gorm:"not null"`
+	CreatedAt time.Time `gorm:"autoCreateTime:milli"`
+	UpdatedAt time.Time `gorm:"autoCreateTime:milli"`
+}
+
+// Input defines the model that describes everything that a command read from an attacker
+// while it was running, like the answer to a prompt or a password.
+type Input struct {
+	gorm.Model
+	ID        uint64    `gorm:"primaryKey; autoIncrement; not_null;"` // type:bigint for MySQL
+	Session   string    `gorm:"index; not null"`
+	IPAddress string    `gorm:"index; type:mediumtext not null"`
+	Command   string    `gorm:"index; not null"`
+	Prompt    string    `gorm:"not null"`
+	Input     string    `gorm:"not null"`
+	Mode      string    `gorm:"not null"`
+	CreatedAt time.Time `gorm:"autoCreateTime:milli"`
+	UpdatedAt time.Time `gorm:"autoCreateTime:milli"`
+}
+
+// Execution defines the model that describes every program that an attacker launched in the
+// background or uploaded and ran, along with what kind of file it is and its hash, so that it
+// can be found in the artifact store.
+type Execution struct {
+	gorm.Model
+	ID         uint64    `gorm:"primaryKey; autoIncrement; not_null;"` // type:bigint for MySQL
+	Session    string    `gorm:"index; not null"`
+	IPAddress  string    `gorm:"index; type:mediumtext not null"`
+	Path       string    `gorm:"index; not null"`
+	Args       string    `gorm:"not null"`
+	Format     string    `gorm:"not null"`
+	Background bool      `gorm:"not null"`
+	SHA256     string    `gorm:"index"`
+	CreatedAt  time.Time `gorm:"autoCreateTime:milli"`
+	UpdatedAt  time.Time `gorm:"autoCreateTime:milli"`
+}
+
+// Persistence defines the model that describes every attempt to make something run again later or to
+// keep a way back in, like a cron job, an SSH key or a systemd service, along with what was written.
+type Persistence struct {
+	gorm.Model
+	ID        uint64    `gorm:"primaryKey; autoIncrement; not_null;"` // type:bigint for MySQL
+	Session   string    `gorm:"index; not null"`
+	IPAddress string    `gorm:"index; type:mediumtext not null"`
+	Command   string    `gorm:"not null"`
+	Mechanism string    `gorm:"index; not null"`
+	Path      string    `gorm:"index; not null"`
+	Data      string    `gorm:"not null"`
+	SHA256    string    `gorm:"index"`
+	CreatedAt time.Time `gorm:"autoCreateTime:milli"`
+	UpdatedAt time.Time `gorm:"autoCreateTime:milli"`
+}
+
+// FilesystemState defines the model that keeps the changes an attacker made to the VFS (as JSON), so
+// that they can be replayed when the same attacker connects again.
+type FilesystemState struct {
+	gorm.Model
+	ID        uint64    `gorm:"primaryKey; autoIncrement; not_null;"` // type:bigint for MySQL
+	Key       string    `gorm:"uniqueIndex; not null"`
+	Session   string    `gorm:"not null"`
+	IPAddress string    `gorm:"index; type:mediumtext not null"`
+	Username  string    `gorm:"not null"`
+	Changes   string    `gorm:"not null"`
+	CreatedAt time.Time `gorm:"autoCreateTime:milli"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime:milli"`
+}
+
 // ConnectDB connects to the database and returns the db object.
 func ConnectDB(verbose bool) (*gorm.DB, error) {
 	logLevel := logger.Silent
@@ -52,9 +171,20 @@ func ConnectDB(verbose bool) (*gorm.DB, error) {
 		return nil, err
 	}
 
-	// Create all the tables and make sure all possible migrations are applied automatically.
-	db.AutoMigrate(&PasswordConnection{})
-	db.AutoMigrate(&KeyConnection{})
+	migrateDB(db)
 
 	return db, nil
 }
+
+// migrateDB creates all the tables and makes sure all possible migrations are applied automatically.
+func migrateDB(db *gorm.DB) {
+	db.AutoMigrate(&PasswordConnection{})
+	db.AutoMigrate(&KeyConnection{})
+	db.AutoMigrate(&Artifact{})
+	db.AutoMigrate(&Download{})
+	db.AutoMigrate(&Escalation{})
+	db.AutoMigrate(&Input{})
+	db.AutoMigrate(&Execution{})
+	db.AutoMigrate(&Persistence{})
+	db.AutoMigrate(&FilesystemState{})
+}
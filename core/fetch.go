@@ -0,0 +1,256 @@
+package core
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/wisepythagoras/honeyshell/plugin"
+)
+
+// LocalFetcher serves downloads from a directory on the local disk, which is useful for
+// testing and for handing out payloads that were already collected. A URL is first looked
+// up by its host and path (e.g. `dir/example.com/bins/x86`) and then only by the name of
+// the file (e.g. `dir/x86`).
+type LocalFetcher struct {
+	Dir string
+}
+
+// Fetch returns the contents of the local file that matches the URL of the request.
+func (lf *LocalFetcher) Fetch(req *plugin.DownloadRequest) ([]byte, error) {
+	u, err := url.Parse(req.URL)
+
+	if err != nil {
+		return nil, plugin.ErrNotFetched
+	}
+
+	root, err := filepath.Abs(lf.Dir)
+
+	if err != nil {
+		return nil, plugin.ErrNotFetched
+	}
+
+	urlPath := path.Clean("/" + u.Path)
+	candidates := []string{
+		filepath.Join(root, u.Hostname(), filepath.FromSlash(urlPath)),
+		filepath.Join(root, path.Base(urlPath)),
+	}
+
+	for _, candidate := range candidates {
+		// Never serve anything from outside of the directory.
+		if rel, err := filepath.Rel(root, candidate); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+
+		if info, err := os.Stat(candidate); err != nil || info.IsDir() {
+			continue
+		}
+
+		return os.ReadFile(candidate)
+	}
+
+	return nil, plugin.ErrNotFetched
+}
+
+// DisabledFetcher never fetches anything, so every download results in a placeholder. This
+// is the default, since the honeypot shouldn't reach out to the network on its own.
+type DisabledFetcher struct{}
+
+// Fetch always returns plugin.ErrNotFetched.
+func (df *DisabledFetcher) Fetch(req *plugin.DownloadRequest) ([]byte, error) {
+	return nil, plugin.ErrNotFetched
+}
+
+// HTTPFetcher downloads the payloads over HTTP(S), as the attacker asked. The size and the
+// duration of the downloads are limited, the hosts can be restricted with allow and deny
+// lists, and everything can be routed through an HTTP or SOCKS5 proxy, so that the requests
+// don't originate from the honeypot itself.
+type HTTPFetcher struct {
+	MaxSize      int64
+	Timeout      time.Duration
+	Allow        []string
+	Deny         []string
+	Proxy        string
+	AllowPrivate bool
+	client       *http.Client
+}
+
+// NewHTTPFetcher creates the fetcher and its HTTP client. The proxy is a URL, such as
+// `socks5://127.0.0.1:9050` or `http://proxy:3128`.
+func NewHTTPFetcher(maxSize int64, timeout time.Duration, allow, deny []string, proxy string) (*HTTPFetcher, error) {
+	hf := &HTTPFetcher{
+		MaxSize: maxSize,
+		Timeout: timeout,
+		Allow:   allow,
+		Deny:    deny,
+		Proxy:   proxy,
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	transport := &http.Transport{
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: timeout,
+		// Malware is rarely hosted on servers with valid certificates.
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+
+	if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+
+		if err != nil {
+			return nil, err
+		}
+
+		transport.Proxy = http.ProxyURL(proxyURL)
+	} else {
+		// Without a proxy, make sure that the attacker can't use the honeypot to reach
+		// anything on the internal network.
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			host, _, _ := net.SplitHostPort(address)
+
+			if ip := net.ParseIP(host); ip != nil && !hf.AllowPrivate && isPrivateIP(ip) {
+				return fmt.Errorf("connection to %s is not allowed", ip)
+			}
+
+			return nil
+		}
+	}
+
+	hf.client = &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return fmt.Errorf("too many redirects")
+			}
+
+			if !hf.hostAllowed(req.URL.Hostname()) {
+				return fmt.Errorf("redirect to %q is not allowed", req.URL.Hostname())
+			}
+
+			return nil
+		},
+	}
+
+	return hf, nil
+}
+
+// Fetch downloads the URL of the request with the same method, headers and body.
+func (hf *HTTPFetcher) Fetch(req *plugin.DownloadRequest) ([]byte, error) {
+	u, err := url.Parse(req.URL)
+
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, plugin.ErrNotFetched
+	}
+
+	if !hf.hostAllowed(u.Hostname()) {
+		return nil, plugin.ErrNotFetched
+	}
+
+	method := req.Method
+
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	httpReq, err := http.NewRequest(method, req.URL, strings.NewReader(req.Body))
+
+	if err != nil {
+		return nil, err
+	}
+
+	for name, value := range req.Headers {
+		httpReq.Header.Set(name, value)
+	}
+
+	res, err := hf.client.Do(httpReq)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, fmt.Errorf("unexpected status %q", res.Status)
+	}
+
+	if hf.MaxSize > 0 && res.ContentLength > hf.MaxSize {
+		return nil, fmt.Errorf("the response is too large (%d bytes)", res.ContentLength)
+	}
+
+	reader := io.Reader(res.Body)
+
+	if hf.MaxSize > 0 {
+		reader = io.LimitReader(res.Body, hf.MaxSize+1)
+	}
+
+	data, err := io.ReadAll(reader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if hf.MaxSize > 0 && int64(len(data)) > hf.MaxSize {
+		return nil, fmt.Errorf("the response is larger than %d bytes", hf.MaxSize)
+	}
+
+	return data, nil
+}
+
+// hostAllowed checks the host against the deny and allow lists. An entry matches the domain
+// itself and all of its subdomains, or, if it's a CIDR, any IP address in it. An empty allow
+// list allows everything that isn't denied.
+func (hf *HTTPFetcher) hostAllowed(host string) bool {
+	if matchesHostList(host, hf.Deny) {
+		return false
+	}
+
+	return len(hf.Allow) == 0 || matchesHostList(host, hf.Allow)
+}
+
+// matchesHostList returns whether the host matches any of the entries of the list.
+func matchesHostList(host string, list []string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	ip := net.ParseIP(host)
+
+	for _, entry := range list {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+
+		if entry == "" {
+			continue
+		}
+
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+
+			continue
+		}
+
+		entry = strings.TrimPrefix(entry, ".")
+
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isPrivateIP returns whether the address belongs to a loopback, private, or otherwise
+// non-public network.
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast()
+}
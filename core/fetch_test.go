@@ -0,0 +1,150 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wisepythagoras/honeyshell/plugin"
+)
+
+func TestLocalFetcher(t *testing.T) {
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "payloads")
+	os.MkdirAll(filepath.Join(dir, "example.com", "bins"), 0755)
+	os.WriteFile(filepath.Join(dir, "example.com", "bins", "x86"), []byte("by host"), 0644)
+	os.WriteFile(filepath.Join(dir, "arm7"), []byte("by name"), 0644)
+	os.WriteFile(filepath.Join(parent, "secret"), []byte("secret"), 0644)
+
+	fetcher := &LocalFetcher{Dir: dir}
+	tests := []struct {
+		url      string
+		contents string
+	}{
+		{"http://example.com/bins/x86", "by host"},
+		{"http://other.com/bins/arm7", "by name"},
+		{"http://other.com/bins/x86", ""},
+		{"http://example.com/bins/", ""},
+		{"http://example.com/../../secret", ""},
+		{"http://../secret", ""},
+		{"http://..%2f/secret", ""},
+	}
+
+	for _, test := range tests {
+		data, err := fetcher.Fetch(&plugin.DownloadRequest{URL: test.url})
+
+		if test.contents == "" && err != plugin.ErrNotFetched {
+			t.Errorf("%s: expected it not to be fetched, got %q (%v)", test.url, data, err)
+		} else if test.contents != "" && (err != nil || string(data) != test.contents) {
+			t.Errorf("%s: unexpected contents %q (%v)", test.url, data, err)
+		}
+	}
+
+	// The directory can be relative to where the honeypot runs (e.g.
+	// `-fetch-dir .`).
+	t.Chdir(dir)
+	fetcher = &LocalFetcher{Dir: "."}
+
+	if data, err := fetcher.Fetch(&plugin.DownloadRequest{URL: "http://other.com/arm7"}); err != nil || string(data) != "by name" {
+		t.Errorf("Unexpected contents %q (%v)", data, err)
+	} else if data, err := fetcher.Fetch(&plugin.DownloadRequest{URL: "http://../secret"}); err != plugin.ErrNotFetched {
+		t.Errorf("Expected it not to be fetched, got %q (%v)", data, err)
+	}
+}
+
+func TestDisabledFetcher(t *testing.T) {
+	if data, err := (&DisabledFetcher{}).Fetch(&plugin.DownloadRequest{URL: "http://example.com/x"}); data != nil || err != plugin.ErrNotFetched {
+		t.Errorf("Unexpected fetch %q (%v)", data, err)
+	}
+}
+
+func TestHTTPFetcher(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/big":
+			w.Write([]byte(strings.Repeat("x", 100)))
+		case "/stream":
+			// Without a Content-Length, the limit applies to what's read.
+			w.Write([]byte(strings.Repeat("x", 50)))
+			w.(http.Flusher).Flush()
+			w.Write([]byte(strings.Repeat("x", 50)))
+		case "/echo":
+			w.Write([]byte(r.Method + " " + r.Header.Get("User-Agent")))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	fetcher, err := NewHTTPFetcher(64, 5*time.Second, nil, nil, "")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server is on 127.0.0.1, which the attacker isn't allowed to reach.
+	if _, err := fetcher.Fetch(&plugin.DownloadRequest{URL: server.URL + "/echo"}); err == nil || !strings.Contains(err.Error(), "is not allowed") {
+		t.Errorf("Expected the connection to be refused, got %v", err)
+	}
+
+	fetcher.AllowPrivate = true
+
+	if data, err := fetcher.Fetch(&plugin.DownloadRequest{URL: server.URL + "/echo", Method: "POST", Headers: map[string]string{"User-Agent": "Wget"}}); err != nil || string(data) != "POST Wget" {
+		t.Errorf("Unexpected fetch %q (%v)", data, err)
+	}
+
+	for _, name := range []string{"/big", "/stream", "/missing"} {
+		if data, err := fetcher.Fetch(&plugin.DownloadRequest{URL: server.URL + name}); err == nil {
+			t.Errorf("%s: expected an error, got %d bytes", name, len(data))
+		}
+	}
+
+	if _, err := fetcher.Fetch(&plugin.DownloadRequest{URL: "ftp://127.0.0.1/x"}); err != plugin.ErrNotFetched {
+		t.Errorf("Expected ftp not to be fetched, got %v", err)
+	}
+
+	fetcher.Deny = []string{"127.0.0.0/8"}
+
+	if _, err := fetcher.Fetch(&plugin.DownloadRequest{URL: server.URL + "/echo"}); err != plugin.ErrNotFetched {
+		t.Errorf("Expected the denied host not to be fetched, got %v", err)
+	}
+}
+
+func TestHostAllowed(t *testing.T) {
+	fetcher := &HTTPFetcher{
+		Allow: []string{"example.com", ".cdn.net", "10.0.0.0/8"},
+		Deny:  []string{"bad.example.com", "10.6.6.6/32"},
+	}
+	tests := []struct {
+		host    string
+		allowed bool
+	}{
+		{"example.com", true},
+		{"EXAMPLE.com.", true},
+		{"files.example.com", true},
+		{"bad.example.com", false},
+		{"x.bad.example.com", false},
+		{"notexample.com", false},
+		{"a.cdn.net", true},
+		{"10.1.2.3", true},
+		{"10.6.6.6", false},
+		{"11.1.2.3", false},
+	}
+
+	for _, test := range tests {
+		if allowed := fetcher.hostAllowed(test.host); allowed != test.allowed {
+			t.Errorf("%s: expected %t, got %t", test.host, test.allowed, allowed)
+		}
+	}
+
+	// Without an allow list, anything that isn't denied is allowed.
+	fetcher.Allow = nil
+
+	if !fetcher.hostAllowed("anything.org") || fetcher.hostAllowed("bad.example.com") {
+		t.Error("Unexpected result without an allow list")
+	}
+}
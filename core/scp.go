@@ -0,0 +1,144 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/wisepythagoras/honeyshell/plugin"
+	"golang.org/x/crypto/ssh"
+)
+
+// isSCPSink returns whether the exec'd command is `scp -t`, which is what the
+// client runs on the server when it uploads files.
+func isSCPSink(args []string) bool {
+	if len(args) == 0 || args[0] != "scp" {
+		return false
+	}
+
+	for _, arg := range args[1:] {
+		if strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") && strings.Contains(arg, "t") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// runSCPSink implements the receiving end of the SCP protocol and writes every
+// uploaded file into the session's VFS.
+func (server *SSHServer) runSCPSink(session *plugin.Session, channel ssh.Channel, args []string) error {
+	target := "."
+
+	for _, arg := range args[1:] {
+		if !strings.HasPrefix(arg, "-") {
+			target = arg
+		}
+	}
+
+	reader := bufio.NewReader(channel)
+	dirs := []string{target}
+
+	ack := func() {
+		channel.Write([]byte{0})
+	}
+	fail := func(msg string) {
+		channel.Write([]byte(fmt.Sprintf("\x01scp: %s\n", msg)))
+	}
+
+	ack()
+
+	for {
+		line, err := reader.ReadString('\n')
+
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+
+		if len(line) == 0 {
+			continue
+		}
+
+		switch line[0] {
+		case 'C':
+			fields := strings.SplitN(line[1:], " ", 3)
+
+			if len(fields) != 3 {
+				fail("protocol error: bad file header")
+				return fmt.Errorf("bad scp file header %q", line)
+			}
+
+			size, err := strconv.ParseInt(fields[1], 10, 64)
+
+			if err != nil || size < 0 || size > maxUploadSize {
+				fail(fmt.Sprintf("%s: file too large", fields[2]))
+				return fmt.Errorf("invalid scp file size %q", fields[1])
+			}
+
+			ack()
+
+			contents := make([]byte, size)
+
+			if _, err = io.ReadFull(reader, contents); err != nil {
+				return err
+			}
+
+			// Every file is followed by a null byte.
+			if _, err = reader.ReadByte(); err != nil {
+				return err
+			}
+
+			dest := dirs[len(dirs)-1]
+
+			if _, file, err := session.VFS.FindFile(dest); err == nil && file.Type == plugin.T_DIR {
+				dest = filepath.Join(dest, fields[2])
+			}
+
+			if err = session.VFS.WriteFileFrom(dest, string(contents), plugin.ArtifactSourceSCP); err != nil {
+				fail(fmt.Sprintf("%s: %s", dest, err))
+				continue
+			}
+
+			ack()
+		case 'D':
+			fields := strings.SplitN(line[1:], " ", 3)
+
+			if len(fields) != 3 {
+				fail("protocol error: bad directory header")
+				return fmt.Errorf("bad scp directory header %q", line)
+			}
+
+			dir := filepath.Join(dirs[len(dirs)-1], fields[2])
+			mode, _ := strconv.ParseUint(fields[0], 8, 32)
+
+			if _, _, err := session.VFS.FindFile(dir); err != nil {
+				if _, err = session.VFS.Mkdir(dir, os.FileMode(mode)); err != nil {
+					fail(err.Error())
+					continue
+				}
+			}
+
+			dirs = append(dirs, dir)
+			ack()
+		case 'E':
+			if len(dirs) > 1 {
+				dirs = dirs[:len(dirs)-1]
+			}
+
+			ack()
+		case 'T':
+			ack()
+		default:
+			// Either a warning (0x01) or an error (0x02) from the client.
+			return nil
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/sftp"
+	"github.com/wisepythagoras/honeyshell/plugin"
+)
+
+// maxUploadSize is the largest file that will be accepted over SFTP or SCP.
+// Anything bigger is most likely not a payload and would only fill up memory.
+const maxUploadSize = 64 << 20
+
+// sftpHandler serves the SFTP subsystem from the session's VFS, so that the
+// uploaded files end up in the same place as everything the attacker does in
+// the shell.
+type sftpHandler struct {
+	session *plugin.Session
+}
+
+// Fileread returns a reader for the contents of a file in the VFS, which the
+// user needs to be allowed to read, just like in the shell.
+func (h *sftpHandler) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	_, file, err := h.session.VFS.FindFile(r.Filepath)
+
+	if err != nil {
+		return nil, sftp.ErrSSHFxNoSuchFile
+	}
+
+	if file.Type == plugin.T_DIR {
+		return nil, sftp.ErrSSHFxFailure
+	}
+
+	handle, err := h.session.VFS.OpenFile(r.Filepath, os.O_RDONLY, 0)
+
+	if err != nil {
+		return nil, sftpError(err)
+	}
+
+	defer handle.Close()
+
+	data, err := io.ReadAll(handle)
+
+	if err != nil {
+		return nil, sftp.ErrSSHFxFailure
+	}
+
+	return bytes.NewReader(data), nil
+}
+
+// Filewrite returns a writer which buffers the upload and writes it to the VFS
+// once the client closes the file.
+func (h *sftpHandler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	_, dir, err := h.session.VFS.FindFile(filepath.Dir(r.Filepath))
+
+	if err != nil || dir.Type != plugin.T_DIR {
+		return nil, sftp.ErrSSHFxNoSuchFile
+	}
+
+	return &sftpUpload{vfs: h.session.VFS, path: r.Filepath}, nil
+}
+
+// Filecmd handles the commands that modify the VFS.
+func (h *sftpHandler) Filecmd(r *sftp.Request) error {
+	var err error
+
+	switch r.Method {
+	case "Mkdir":
+		_, err = h.session.VFS.Mkdir(r.Filepath, 0)
+	case "Remove", "Rmdir":
+		err = h.session.VFS.Rmfile(r.Filepath)
+	case "Rename":
+		err = h.session.VFS.Rename(r.Filepath, r.Target)
+	case "Symlink":
+		// The request has the target of the link in its path, and the link
+		// itself in its target.
+		err = h.session.VFS.Symlink(r.Filepath, r.Target)
+	case "Link":
+		// Like with fs.protected_hardlinks, which doesn't let anyone link to
+		// files that they don't own.
+		return sftp.ErrSSHFxPermissionDenied
+	case "Setstat":
+		err = h.setstat(r)
+	default:
+		return sftp.ErrSSHFxOpUnsupported
+	}
+
+	if err != nil {
+		return sftpError(err)
+	}
+
+	return nil
+}
+
+// setstat changes the attributes of a file which the client sent, like `put
+// -p` does after an upload.
+func (h *sftpHandler) setstat(r *sftp.Request) error {
+	vfs := h.session.VFS
+	flags := r.AttrFlags()
+	attrs := r.Attributes()
+
+	if flags.Size {
+		if err := vfs.Truncate(r.Filepath, int64(attrs.Size)); err != nil {
+			return err
+		}
+	}
+
+	if flags.UidGid {
+		owner, group := h.ownerNames(attrs.UID, attrs.GID)
+
+		if err := vfs.Chown(r.Filepath, owner, group); err != nil {
+			return err
+		}
+	}
+
+	if flags.Permissions {
+		if err := vfs.Chmod(r.Filepath, attrs.FileMode()); err != nil {
+			return err
+		}
+	}
+
+	if flags.Acmodtime {
+		if err := vfs.Chtimes(r.Filepath, attrs.ModTime()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ownerNames returns the names of the user and the group with the IDs that
+// the client sent, or the IDs themselves when nobody has them.
+func (h *sftpHandler) ownerNames(uid, gid uint32) (string, string) {
+	vfs := h.session.VFS
+	owner := strconv.Itoa(int(uid))
+	group := strconv.Itoa(int(gid))
+
+	if user := vfs.User; user != nil && user.UID == int(uid) {
+		owner = user.Username
+	} else if user, err := vfs.LookupUID(int(uid)); err == nil {
+		owner = user.Username
+	}
+
+	if user := vfs.User; user != nil && user.GID == int(gid) {
+		group = user.Group
+	} else {
+		for _, g := range vfs.Groups() {
+			if g.GID == int(gid) {
+				group = g.Name
+				break
+			}
+		}
+	}
+
+	return owner, group
+}
+
+// sftpError returns the SFTP status for an error of the VFS.
+func sftpError(err error) error {
+	switch {
+	case errors.Is(err, plugin.ErrNotExist):
+		return sftp.ErrSSHFxNoSuchFile
+	case errors.Is(err, plugin.ErrPermission), errors.Is(err, plugin.ErrNotPermitted):
+		return sftp.ErrSSHFxPermissionDenied
+	}
+
+	return sftp.ErrSSHFxFailure
+}
+
+// Filelist handles directory listings, stats and symlink reads.
+func (h *sftpHandler) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	if r.Method == "Readlink" {
+		return h.readlink(r)
+	}
+
+	_, file, err := h.session.VFS.FindFile(r.Filepath)
+
+	if err != nil {
+		return nil, sftp.ErrSSHFxNoSuchFile
+	}
+
+	switch r.Method {
+	case "List":
+		if file.Type != plugin.T_DIR {
+			return nil, sftp.ErrSSHFxFailure
+		}
+
+		// Like `ls`, listing a directory needs read permission on it.
+		handle, err := h.session.VFS.OpenFile(r.Filepath, os.O_RDONLY, 0)
+
+		if err != nil {
+			return nil, sftpError(err)
+		}
+
+		handle.Close()
+
+		infos := make(listerAt, 0, len(file.Files))
+
+		for _, f := range file.Files {
+			infos = append(infos, f.Info())
+		}
+
+		return infos, nil
+	case "Stat":
+		return listerAt{file.Info()}, nil
+	}
+
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+// Lstat is like the Stat of Filelist, but it doesn't follow symbolic links.
+func (h *sftpHandler) Lstat(r *sftp.Request) (sftp.ListerAt, error) {
+	_, file, err := h.session.VFS.LFindFile(r.Filepath)
+
+	if err != nil {
+		return nil, sftp.ErrSSHFxNoSuchFile
+	}
+
+	return listerAt{file.Info()}, nil
+}
+
+// readlink returns the target of a symbolic link.
+func (h *sftpHandler) readlink(r *sftp.Request) (sftp.ListerAt, error) {
+	_, file, err := h.session.VFS.LFindFile(r.Filepath)
+
+	if err != nil {
+		return nil, sftp.ErrSSHFxNoSuchFile
+	} else if file.Type != plugin.T_SYMLINK {
+		return nil, sftp.ErrSSHFxFailure
+	}
+
+	return listerAt{(&plugin.VFSFile{Name: file.LinkTo}).Info()}, nil
+}
+
+// sftpUpload collects the chunks of an upload in memory.
+type sftpUpload struct {
+	vfs  *plugin.VFS
+	path string
+	buf  []byte
+	mu   sync.Mutex
+}
+
+// WriteAt writes a chunk of the file at the given offset.
+func (u *sftpUpload) WriteAt(p []byte, off int64) (int, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	end := off + int64(len(p))
+
+	if end > maxUploadSize {
+		return 0, sftp.ErrSSHFxFailure
+	}
+
+	if end > int64(len(u.buf)) {
+		u.buf = append(u.buf, make([]byte, end-int64(len(u.buf)))...)
+	}
+
+	copy(u.buf[off:], p)
+
+	return len(p), nil
+}
+
+// Close writes the complete file to the VFS.
+func (u *sftpUpload) Close() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.vfs.WriteFileFrom(u.path, string(u.buf), plugin.ArtifactSourceSFTP)
+}
+
+// listerAt is a static list of file infos.
+type listerAt []os.FileInfo
+
+// ListAt copies the file infos from the offset into the list.
+func (l listerAt) ListAt(ls []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+
+	n := copy(ls, l[offset:])
+
+	if n < len(ls) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
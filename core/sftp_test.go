@@ -0,0 +1,125 @@
+package core
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/wisepythagoras/honeyshell/plugin"
+)
+
+func newTestSFTP() *sftpHandler {
+	dir := func(name, owner string, mode os.FileMode, files map[string]plugin.VFSFile) plugin.VFSFile {
+		return plugin.VFSFile{Type: plugin.T_DIR, Name: name, Owner: owner, Group: owner, Mode: os.ModeDir | mode, Files: files}
+	}
+	file := func(name, owner string, mode os.FileMode, contents string) plugin.VFSFile {
+		return plugin.VFSFile{Type: plugin.T_FILE, Name: name, Owner: owner, Group: owner, Mode: mode, Contents: contents}
+	}
+
+	vfs := &plugin.VFS{
+		Root: dir("", "root", 0755, map[string]plugin.VFSFile{
+			"etc": dir("etc", "root", 0755, map[string]plugin.VFSFile{
+				"hostname": file("hostname", "root", 0644, "server\n"),
+				"shadow":   file("shadow", "root", 0640, "root:$6$x:19000:0:99999:7:::\n"),
+			}),
+			"root": dir("root", "root", 0700, map[string]plugin.VFSFile{
+				"notes": file("notes", "root", 0600, "secret\n"),
+			}),
+			"home": dir("home", "root", 0755, map[string]plugin.VFSFile{
+				"{}": dir("{}", "{}", 0755, map[string]plugin.VFSFile{
+					"a": file("a", "{}", 0644, "a\n"),
+				}),
+			}),
+		}),
+		Home: "/home/{}",
+		User: &plugin.User{Username: "test", Group: "test", UID: 1000, GID: 1000},
+	}
+
+	return &sftpHandler{session: &plugin.Session{VFS: vfs, User: vfs.User}}
+}
+
+func TestSFTPPermissions(t *testing.T) {
+	h := newTestSFTP()
+
+	if r, err := h.Fileread(sftp.NewRequest("Get", "/etc/hostname")); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	} else if data, _ := io.ReadAll(io.NewSectionReader(r, 0, 1<<20)); string(data) != "server\n" {
+		t.Errorf("Unexpected contents %q", data)
+	}
+
+	// The files that only root can read can't be downloaded, just like they
+	// can't be read in the shell.
+	for _, path := range []string{"/etc/shadow", "/root/notes"} {
+		if _, err := h.Fileread(sftp.NewRequest("Get", path)); err != sftp.ErrSSHFxPermissionDenied {
+			t.Errorf("%s: expected a permission error, got %v", path, err)
+		}
+	}
+
+	if _, err := h.Filelist(sftp.NewRequest("List", "/root")); err != sftp.ErrSSHFxPermissionDenied {
+		t.Errorf("Expected a permission error, got %v", err)
+	} else if _, err := h.Filelist(sftp.NewRequest("List", "/etc")); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	// Root can read everything.
+	h.session.VFS.User = &plugin.User{Username: "root", Group: "root"}
+
+	if _, err := h.Fileread(sftp.NewRequest("Get", "/etc/shadow")); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
+
+func TestSFTPCommands(t *testing.T) {
+	h := newTestSFTP()
+	vfs := h.session.VFS
+
+	rename := sftp.NewRequest("Rename", "/home/test/a")
+	rename.Target = "/home/test/b"
+
+	if err := h.Filecmd(rename); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	} else if _, _, err := vfs.FindFile("/home/test/b"); err != nil {
+		t.Errorf("The file wasn't renamed: %v", err)
+	}
+
+	symlink := sftp.NewRequest("Symlink", "/home/test/b")
+	symlink.Target = "/home/test/c"
+
+	if err := h.Filecmd(symlink); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	} else if _, file, err := vfs.LFindFile("/home/test/c"); err != nil || file.Type != plugin.T_SYMLINK || file.LinkTo != "/home/test/b" {
+		t.Errorf("Unexpected link %+v (%v)", file, err)
+	}
+
+	chmod := sftp.NewRequest("Setstat", "/home/test/b")
+	chmod.Flags = 0x4
+	chmod.Attrs = binary.BigEndian.AppendUint32(nil, 0100755)
+
+	if err := h.Filecmd(chmod); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	} else if _, file, _ := vfs.FindFile("/home/test/b"); file.Mode != 0755 {
+		t.Errorf("Unexpected mode %s", file.Mode)
+	}
+
+	chmod.Filepath = "/etc/hostname"
+
+	if err := h.Filecmd(chmod); err != sftp.ErrSSHFxPermissionDenied {
+		t.Errorf("Expected a permission error, got %v", err)
+	}
+
+	rename = sftp.NewRequest("Rename", "/etc/hostname")
+	rename.Target = "/home/test/hostname"
+
+	if err := h.Filecmd(rename); err != sftp.ErrSSHFxPermissionDenied {
+		t.Errorf("Expected a permission error, got %v", err)
+	}
+
+	link := sftp.NewRequest("Link", "/etc/shadow")
+	link.Target = "/home/test/shadow"
+
+	if err := h.Filecmd(link); err != sftp.ErrSSHFxPermissionDenied {
+		t.Errorf("Expected a permission error, got %v", err)
+	}
+}
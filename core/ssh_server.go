@@ -1,13 +1,16 @@
 package core
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
-	"path/filepath"
 	"strings"
 
+	"github.com/pkg/sftp"
 	"github.com/wisepythagoras/honeyshell/plugin"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/term"
@@ -25,10 +28,31 @@ type SSHServer struct {
 	config        *ssh.ServerConfig
 	listener      net.Listener
 	PluginManager *plugin.PluginManager
+	Artifacts     *ArtifactStore
+	State         *StateStore
+	Persona       string
+	PersonaSeed   string
 }
 
 // Init Initializes the SSH server.
 func (server *SSHServer) Init() bool {
+	if server.PluginManager != nil {
+		server.PluginManager.OnEvent(server.recordEvent)
+	}
+
+	// With a persona per listener, the base VFS pretends to be a single machine. With one per IP, every
+	// session gets its own machine, but the files that describe it are still generated from the base.
+	if server.Persona != "" && server.PluginManager != nil && server.PluginManager.PluginVFS != nil {
+		base := server.PluginManager.PluginVFS
+
+		if server.Persona == plugin.PersonaListener {
+			seed := fmt.Sprintf("%s|%s:%d", server.PersonaSeed, server.Address, server.Port)
+			base.SetPersona(plugin.NewPersona(seed, base.DistroID()))
+		}
+
+		base.MountPersona()
+	}
+
 	server.config = &ssh.ServerConfig{
 		PasswordCallback:  server.passwordChecker,
 		PublicKeyCallback: server.publicKeyChecker,
@@ -102,12 +126,9 @@ func (server *SSHServer) passwordChecker(c ssh.ConnMetadata, pass []byte) (*ssh.
 
 	// If a plugin manager was passed in and initialized, then call all of the plugins that offer a password login
 	// interception function.
-	if server.PluginManager != nil {
-		for _, pl := range server.PluginManager.GetPasswordIntercepts() {
-			if shouldLogin := pl.CallPasswordInterceptor(username, password, &ipObj); shouldLogin {
-				return nil, nil
-			}
-		}
+	if server.PluginManager != nil && server.PluginManager.CheckPassword(username, password, &ipObj) {
+		// Keep the password around, since the state of the file system (and sudo) may be tied to it.
+		return &ssh.Permissions{Extensions: map[string]string{"password": password}}, nil
 	}
 
 	return nil, fmt.Errorf("incorrect password for %q", c.User())
@@ -134,13 +155,31 @@ func (server *SSHServer) publicKeyChecker(c ssh.ConnMetadata, pubKey ssh.PublicK
 		ByteArrayToHex(pubKeyHash),
 		pubKey.Type())
 
-	// Add the key to the database.
-	server.db.Create(&KeyConnection{
+	connection := &KeyConnection{
 		IPAddress: ip.String(),
 		Username:  username,
 		Key:       string(pubKey.Marshal()),
 		KeyHash:   ByteArrayToHex(pubKeyHash),
-	}).Commit()
+	}
+
+	// Bots often add a key of their own to authorized_keys and come back with it. If it's in the files
+	// that were kept for them, they get in, and the connection is linked to the session that planted it.
+	if server.State != nil {
+		ipStr, _, _ := net.SplitHostPort(ip.String())
+
+		if key, plantedBy, ok := server.State.FindKey(ipStr, username, pubKey); ok {
+			connection.PlantedBy = plantedBy
+			server.db.Create(connection).Commit()
+
+			server.Logger.Printf("%s %s key accepted (planted in session:%s)\n", ip.String(), username, plantedBy)
+			log.Printf("%s %s key accepted (planted in session:%s)\n", ip.String(), username, plantedBy)
+
+			return &ssh.Permissions{Extensions: map[string]string{"state": key, "planted-by": plantedBy}}, nil
+		}
+	}
+
+	// Add the key to the database.
+	server.db.Create(connection).Commit()
 
 	return nil, fmt.Errorf("unknown public key for %q", c.User())
 }
@@ -182,96 +221,279 @@ func (server *SSHServer) HandleSSHAuth(connection *net.Conn) bool {
 			log.Fatalf("Could not accept channel: %v", err)
 		}
 
-		go func(in <-chan *ssh.Request) {
-			for req := range in {
-				if req.Type == "shell" || req.Type == "pty" {
-					req.Reply(true, nil)
-				}
+		session := server.newSession(conn, channel)
+
+		go server.handleRequests(conn, session, channel, requests)
+	}
+
+	return true
+}
+
+// newSession creates the session for a newly accepted channel, with its own copy-on-write overlay of
+// the VFS, so that nothing the attacker changes is seen by any other session.
+func (server *SSHServer) newSession(conn *ssh.ServerConn, channel ssh.Channel) *plugin.Session {
+	sessionVFS := server.PluginManager.PluginVFS.Overlay()
+	user := sessionVFS.SessionUser(conn.User())
+	sessionVFS.User = user
+
+	ipStr, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+
+	sessionVFS.RemoteIP = ipStr
+
+	if server.Persona == plugin.PersonaIP {
+		sessionVFS.SetPersona(plugin.NewPersona(server.PersonaSeed+"|"+ipStr, sessionVFS.DistroID()))
+	}
+
+	sessionTerm := term.NewTerminal(channel, "$ ")
+	session := &plugin.Session{
+		ID:      newSessionID(),
+		IP:      ipStr,
+		VFS:     sessionVFS,
+		Term:    sessionTerm,
+		Channel: channel,
+		Manager: server.PluginManager,
+		User:    user,
+	}
+
+	if conn.Permissions != nil {
+		session.Password = conn.Permissions.Extensions["password"]
+	}
+	sessionTerm.AutoCompleteCallback = session.AutoCompleteCallback
+
+	// Every file that the attacker writes to the VFS, either from the shell or by uploading it, should
+	// be kept in the artifact store.
+	if server.Artifacts != nil {
+		sessionVFS.AddWriteHook(func(path string, contents []byte, source string) {
+			// The shell appends to the history file after every command, which isn't worth keeping.
+			if source == plugin.ArtifactSourceHistory {
+				return
+			}
+
+			artifact, err := server.Artifacts.Save(session.ID, ipStr, path, source, contents)
+
+			if err != nil {
+				log.Println("Unable to save artifact", path, err)
+				server.Logger.Println("Unable to save artifact", path, err)
+				return
+			}
+
+			log.Printf("%s %s artifact:%s %s (%d bytes, %s)\n", ipStr, source, artifact.SHA256, path, artifact.Size, artifact.MimeType)
+			server.Logger.Printf("%s %s artifact:%s %s (%d bytes, %s)\n", ipStr, source, artifact.SHA256, path, artifact.Size, artifact.MimeType)
+		})
+	}
+
+	// Cron jobs, SSH keys and the like are reported as persistence, no matter how they were written.
+	session.WatchPersistence()
+
+	if conn.Permissions != nil && conn.Permissions.Extensions["planted-by"] != "" {
+		log.Printf("%s session:%s logged in with the key planted in session:%s\n", ipStr, session.ID, conn.Permissions.Extensions["planted-by"])
+		server.Logger.Printf("%s session:%s logged in with the key planted in session:%s\n", ipStr, session.ID, conn.Permissions.Extensions["planted-by"])
+	}
+
+	// Bring back whatever this attacker left behind in an earlier session.
+	if server.State != nil {
+		changes, err := server.State.Load(server.State.Key(conn, ipStr))
+
+		if err != nil {
+			log.Println("Unable to load the file system state", err)
+			server.Logger.Println("Unable to load the file system state", err)
+		} else if len(changes) > 0 {
+			sessionVFS.Apply(changes)
+			log.Printf("%s session:%s replayed %d file system changes\n", ipStr, session.ID, len(changes))
+			server.Logger.Printf("%s session:%s replayed %d file system changes\n", ipStr, session.ID, len(changes))
+		}
+	}
+
+	// Change over to the home directory so that the session starts from there.
+	session.Chdir(server.PluginManager.PluginVFS.Home)
+	session.StartShell()
+
+	return session
+}
+
+// handleRequests replies to the requests of a session channel and starts either the interactive shell,
+// a single command, or the SFTP subsystem, depending on what the client asked for.
+func (server *SSHServer) handleRequests(conn *ssh.ServerConn, session *plugin.Session, channel ssh.Channel, in <-chan *ssh.Request) {
+	for req := range in {
+		switch req.Type {
+		case "shell":
+			req.Reply(true, nil)
+			go server.runShell(session, channel)
+		case "exec":
+			var payload struct{ Command string }
+
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				req.Reply(false, nil)
+				continue
 			}
-		}(requests)
 
-		user := &plugin.User{
-			Username: conn.User(),
-			Group:    conn.User(),
+			req.Reply(true, nil)
+			go server.runExec(session, channel, payload.Command)
+		case "subsystem":
+			var payload struct{ Name string }
+
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil || payload.Name != "sftp" {
+				req.Reply(false, nil)
+				continue
+			}
+
+			req.Reply(true, nil)
+			go server.runSFTP(session, channel)
+		case "pty", "pty-req":
+			session.OpenTTY()
+			req.Reply(true, nil)
+		case "env", "window-change":
+			req.Reply(true, nil)
+		default:
+			req.Reply(false, nil)
 		}
+	}
 
-		sessionVFS := *server.PluginManager.PluginVFS
-		sessionVFS.User = user
+	server.endSession(conn, session)
+}
+
+// endSession logs what the attacker changed in the file system once the channel of a session closes,
+// and stores it so that it can be replayed when the attacker comes back.
+func (server *SSHServer) endSession(conn *ssh.ServerConn, session *plugin.Session) {
+	changes := session.VFS.Diff()
 
-		sessionTerm := term.NewTerminal(channel, "$ ")
-		session := &plugin.Session{
-			VFS:     &sessionVFS,
-			Term:    sessionTerm,
-			Manager: server.PluginManager,
-			User:    user,
+	if server.State != nil {
+		if err := server.State.Save(server.State.Key(conn, session.IP), session.ID, session.IP, conn.User(), changes); err != nil {
+			log.Println("Unable to save the file system state", err)
+			server.Logger.Println("Unable to save the file system state", err)
 		}
-		sessionTerm.AutoCompleteCallback = session.AutoCompleteCallback
+	}
 
-		// Change over to the home directory so that the session starts from there.
-		session.Chdir(server.PluginManager.PluginVFS.Home)
+	log.Printf("%s session:%s closed (%d file system changes)\n", session.IP, session.ID, len(changes))
+	server.Logger.Printf("%s session:%s closed (%d file system changes)\n", session.IP, session.ID, len(changes))
 
-		if server.PluginManager.LoginMessageFn != nil {
-			loginMessage := server.PluginManager.LoginMessageFn(session)
-			session.TermWrite(loginMessage)
+	for _, change := range changes {
+		action := "changed"
+
+		if change.Deleted {
+			action = "deleted"
 		}
 
-		// Set the initial prompt.
-		sessionTerm.SetPrompt(server.PluginManager.PromptPlugin(session))
+		server.Logger.Printf("%s session:%s %s %s\n", session.IP, session.ID, action, change.Path)
+	}
+}
 
-		go func() {
-			defer channel.Close()
-
-			for {
-				line, err := sessionTerm.ReadLine()
-
-				if err != nil {
-					break
-				}
-
-				if strings.Trim(line, " ") == "" {
-					continue
-				}
-
-				parts := strings.SplitN(line, " ", 2)
-				cmd := parts[0]
-				args := &plugin.CmdArgs{}
-
-				if strings.HasPrefix(cmd, ".") {
-					pwd := session.GetPWD()
-					cmd = filepath.Join(pwd, cmd)
-				}
-
-				if len(parts) > 1 {
-					args.RawArgs = parts[1]
-					args.Parse()
-				}
-
-				if commandFn, ok := server.PluginManager.GetCommand(cmd); ok {
-					commandFn(args, session)
-				} else {
-					out := ""
-
-					if strings.HasPrefix(cmd, "/") {
-						// TODO: In this case we want to handle what happens if a directory is found:
-						//     bash: /dir/here: Is a directory
-						// Or if a file is not executable:
-						//     bash: ./path/to/file: Permission denied
-						out = fmt.Sprintf("%s: No such file or directory\n", line)
-					} else {
-						out = fmt.Sprintf("%s: command not found\n", line)
-					}
-
-					sessionTerm.Write([]byte(out))
-					server.Logger.Println("[client] $", line)
-					log.Println("[client] $", line)
-				}
-
-				sessionTerm.SetPrompt(server.PluginManager.PromptPlugin(session))
-			}
-		}()
+// runShell runs the interactive shell loop for a session.
+func (server *SSHServer) runShell(session *plugin.Session, channel ssh.Channel) {
+	defer channel.Close()
+
+	if server.PluginManager.LoginMessageFn != nil {
+		loginMessage := server.PluginManager.LoginMessageFn(session)
+		session.TermWrite(loginMessage)
 	}
 
-	return true
+	session.Interactive = true
+	session.StartHistory()
+
+	// Set the initial prompt.
+	session.SetPrompt(server.PluginManager.PromptPlugin(session))
+
+	for {
+		session.ReportJobs()
+		line, err := session.Term.ReadLine()
+
+		// Like bash, ask for the rest of a command that isn't over yet, such
+		// as a loop that was pasted a line at a time.
+		for err == nil && session.Incomplete(line) {
+			var more string
+
+			session.Term.SetPrompt("> ")
+			more, err = session.Term.ReadLine()
+			line += "\n" + more
+		}
+
+		if err != nil {
+			break
+		}
+
+		if strings.Trim(line, " ") == "" {
+			continue
+		}
+
+		// Like bash, `!!` and the like are expanded before the line is remembered and run.
+		expanded, ok := session.ExpandHistory(line)
+
+		if !ok {
+			session.SetPrompt(server.PluginManager.PromptPlugin(session))
+			continue
+		}
+
+		session.AddHistory(expanded)
+		server.runCommand(session, expanded)
+
+		if session.Exited() {
+			break
+		}
+
+		session.SetPrompt(server.PluginManager.PromptPlugin(session))
+	}
+}
+
+// runExec runs a single command that was passed to the `exec` request (for example `ssh host uname -a`).
+// Uploads with `scp` also arrive this way.
+func (server *SSHServer) runExec(session *plugin.Session, channel ssh.Channel, command string) {
+	defer channel.Close()
+
+	log.Println("[client] exec", command)
+	server.Logger.Println("[client] exec", command)
+
+	args := strings.Fields(command)
+	status := 0
+
+	if isSCPSink(args) {
+		if err := server.runSCPSink(session, channel, args); err != nil {
+			log.Println("SCP error:", err)
+			server.Logger.Println("SCP error:", err)
+			status = 1
+		}
+	} else if strings.Trim(command, " ") != "" {
+		// There's no prompt to redraw after a command (like sudo) reads from the terminal.
+		session.SetPrompt("")
+		status = session.Run(command)
+	}
+
+	channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+}
+
+// runSFTP serves the SFTP subsystem from the session's VFS.
+func (server *SSHServer) runSFTP(session *plugin.Session, channel ssh.Channel) {
+	defer channel.Close()
+
+	handler := &sftpHandler{session: session}
+	sftpServer := sftp.NewRequestServer(channel, sftp.Handlers{
+		FileGet:  handler,
+		FilePut:  handler,
+		FileCmd:  handler,
+		FileList: handler,
+	}, sftp.WithStartDirectory(session.GetPWD()))
+
+	if err := sftpServer.Serve(); err != nil && err != io.EOF {
+		log.Println("SFTP error:", err)
+		server.Logger.Println("SFTP error:", err)
+	}
+
+	sftpServer.Close()
+}
+
+// runCommand logs and runs a single line of input in the session's shell. It returns the exit status.
+func (server *SSHServer) runCommand(session *plugin.Session, line string) int {
+	server.Logger.Println("[client] $", line)
+	log.Println("[client] $", line)
+
+	return session.Run(line)
+}
+
+// newSessionID returns a random identifier for a session.
+func newSessionID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	return hex.EncodeToString(id)
 }
 
 // ListenLoop Run the listener for our server.
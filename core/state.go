@@ -0,0 +1,283 @@
+package core
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/wisepythagoras/honeyshell/plugin"
+	"golang.org/x/crypto/ssh"
+	"gorm.io/gorm"
+)
+
+// These are the ways in which the file system state of an attacker can be recognized when they
+// come back.
+const (
+	StateKeyIP          = "ip"
+	StateKeyCredentials = "credentials"
+)
+
+// storedChange is how a change to the VFS is kept in the database. The contents of its files are
+// stored as bytes, since a JSON string would replace what isn't valid UTF-8 in the binaries that
+// attackers drop.
+type storedChange struct {
+	Path    string      `json:"p"`
+	Deleted bool        `json:"d,omitempty"`
+	File    *storedFile `json:"f,omitempty"`
+}
+
+// storedFile is a VFSFile whose contents are in Data (base64 in JSON) rather than in Contents.
+type storedFile struct {
+	plugin.VFSFile
+	Data  []byte                `json:"b,omitempty"`
+	Files map[string]storedFile `json:"f"`
+}
+
+// StateStore keeps the changes that every attacker made to the VFS, so that the files they dropped
+// are still there when they connect again.
+type StateStore struct {
+	KeyBy string
+	TTL   time.Duration
+	db    *gorm.DB
+}
+
+// NewStateStore creates a state store that recognizes attackers either by their IP address or by
+// the credentials they logged in with. A TTL of 0 keeps the state forever.
+func NewStateStore(keyBy string, ttl time.Duration, db *gorm.DB) (*StateStore, error) {
+	if keyBy != StateKeyIP && keyBy != StateKeyCredentials {
+		return nil, fmt.Errorf("unknown state key %q", keyBy)
+	}
+
+	return &StateStore{
+		KeyBy: keyBy,
+		TTL:   ttl,
+		db:    db,
+	}, nil
+}
+
+// Key returns the key under which the state of a connection is stored. A connection that logged in
+// with a key that was planted in a state gets that state.
+func (store *StateStore) Key(conn *ssh.ServerConn, ip string) string {
+	if conn.Permissions != nil && conn.Permissions.Extensions["state"] != "" {
+		return conn.Permissions.Extensions["state"]
+	}
+
+	if store.KeyBy == StateKeyCredentials {
+		password := ""
+
+		if conn.Permissions != nil {
+			password = conn.Permissions.Extensions["password"]
+		}
+
+		return fmt.Sprintf("credentials:%s:%s", conn.User(), password)
+	}
+
+	return "ip:" + ip
+}
+
+// Load returns the changes that were stored under the key, unless they expired.
+func (store *StateStore) Load(key string) ([]plugin.VFSChange, error) {
+	state := &FilesystemState{}
+	result := store.db.Where("key = ?", key).Limit(1).Find(state)
+
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil, result.Error
+	}
+
+	if store.TTL > 0 && time.Since(state.UpdatedAt) > store.TTL {
+		store.db.Unscoped().Delete(state)
+		return nil, nil
+	}
+
+	return decodeChanges(state.Changes)
+}
+
+// Save stores the changes of a session under the key, replacing whatever was there. The username is
+// who the "{}" placeholder in the paths of the changes stands for.
+func (store *StateStore) Save(key, session, ip, username string, changes []plugin.VFSChange) error {
+	data, err := encodeChanges(changes)
+
+	if err != nil {
+		return err
+	}
+
+	state := &FilesystemState{}
+	result := store.db.Where("key = ?", key).Limit(1).Find(state)
+
+	if result.Error != nil {
+		return result.Error
+	} else if result.RowsAffected == 0 && len(changes) == 0 {
+		return nil
+	}
+
+	state.Key = key
+	state.Session = session
+	state.IPAddress = ip
+	state.Username = username
+	state.Changes = data
+	state.UpdatedAt = time.Now()
+
+	return store.db.Save(state).Error
+}
+
+// FindKey looks for a public key that an attacker added to the authorized_keys of a user, in the state
+// that a connection from the IP address would get. When attackers are recognized by their credentials,
+// every state is searched, since the key is a credential of its own. It returns the key of the state
+// that has it, and the ID of the session that planted it.
+func (store *StateStore) FindKey(ip, username string, pubKey ssh.PublicKey) (string, string, bool) {
+	states := []FilesystemState{}
+	query := store.db.Where("changes LIKE ?", "%authorized_keys%")
+
+	if store.KeyBy == StateKeyIP {
+		query = query.Where("key = ?", "ip:"+ip)
+	}
+
+	if err := query.Order("updated_at desc").Find(&states).Error; err != nil {
+		return "", "", false
+	}
+
+	for _, state := range states {
+		if store.TTL > 0 && time.Since(state.UpdatedAt) > store.TTL {
+			continue
+		}
+
+		changes, err := decodeChanges(state.Changes)
+
+		if err != nil {
+			continue
+		}
+
+		for _, change := range changes {
+			if change.File == nil || !isAuthorizedKeys(change.Path, username, state.Username) || !hasKey(change.File.Contents, pubKey) {
+				continue
+			}
+
+			return state.Key, store.plantedBy(pubKey, state.Session), true
+		}
+	}
+
+	return "", "", false
+}
+
+// encodeChanges turns the changes of a session into the JSON that's stored.
+func encodeChanges(changes []plugin.VFSChange) (string, error) {
+	stored := make([]storedChange, len(changes))
+
+	for i, change := range changes {
+		stored[i] = storedChange{Path: change.Path, Deleted: change.Deleted}
+
+		if change.File != nil {
+			stored[i].File = toStoredFile(change.File)
+		}
+	}
+
+	data, err := json.Marshal(stored)
+
+	return string(data), err
+}
+
+// decodeChanges turns the stored JSON back into the changes of a session.
+func decodeChanges(data string) ([]plugin.VFSChange, error) {
+	stored := []storedChange{}
+
+	if err := json.Unmarshal([]byte(data), &stored); err != nil {
+		return nil, err
+	}
+
+	changes := make([]plugin.VFSChange, len(stored))
+
+	for i, change := range stored {
+		changes[i] = plugin.VFSChange{Path: change.Path, Deleted: change.Deleted}
+
+		if change.File != nil {
+			changes[i].File = change.File.vfsFile()
+		}
+	}
+
+	return changes, nil
+}
+
+// toStoredFile converts a file, and the files in it, to the shape that's stored.
+func toStoredFile(file *plugin.VFSFile) *storedFile {
+	stored := &storedFile{VFSFile: *file, Data: []byte(file.Contents)}
+	stored.Contents = ""
+	stored.VFSFile.Files = nil
+
+	if file.Files != nil {
+		stored.Files = make(map[string]storedFile, len(file.Files))
+
+		for name, child := range file.Files {
+			stored.Files[name] = *toStoredFile(&child)
+		}
+	}
+
+	return stored
+}
+
+// vfsFile converts a stored file, and the files in it, back to a VFSFile.
+func (stored *storedFile) vfsFile() *plugin.VFSFile {
+	file := stored.VFSFile
+	file.Contents = string(stored.Data)
+
+	if stored.Files != nil {
+		file.Files = make(map[string]plugin.VFSFile, len(stored.Files))
+
+		for name, child := range stored.Files {
+			file.Files[name] = *child.vfsFile()
+		}
+	}
+
+	return &file
+}
+
+// plantedBy returns the ID of the session that first wrote the key to an authorized_keys file, or the
+// fallback if that wasn't recorded.
+func (store *StateStore) plantedBy(pubKey ssh.PublicKey, fallback string) string {
+	encoded := base64.StdEncoding.EncodeToString(pubKey.Marshal())
+	persistence := &Persistence{}
+	result := store.db.Where("mechanism = ? AND data LIKE ?", "ssh-key", "%"+encoded+"%").Order("id").Limit(1).Find(persistence)
+
+	if result.Error != nil || result.RowsAffected == 0 {
+		return fallback
+	}
+
+	return persistence.Session
+}
+
+// isAuthorizedKeys returns whether a path of the VFS is the authorized_keys file of a user. The owner
+// is the user that the "{}" placeholder of the state stands for.
+func isAuthorizedKeys(path, username, owner string) bool {
+	homes := []string{"/home/" + username}
+
+	if username == "root" {
+		homes = []string{"/root"}
+	}
+
+	if username == owner {
+		homes = append(homes, "/home/{}")
+	}
+
+	for _, home := range homes {
+		if path == home+"/.ssh/authorized_keys" || path == home+"/.ssh/authorized_keys2" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasKey returns whether the contents of an authorized_keys file have the public key.
+func hasKey(contents string, pubKey ssh.PublicKey) bool {
+	for _, line := range strings.Split(contents, "\n") {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+
+		if err == nil && bytes.Equal(key.Marshal(), pubKey.Marshal()) {
+			return true
+		}
+	}
+
+	return false
+}
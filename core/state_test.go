@@ -0,0 +1,238 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wisepythagoras/honeyshell/plugin"
+	"golang.org/x/crypto/ssh"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "honeyshell.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrateDB(db)
+
+	return db
+}
+
+func TestStateContents(t *testing.T) {
+	store, err := NewStateStore(StateKeyIP, 0, newTestDB(t))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	binary := "\x7fELF\x02\x01\x01\x00\xb7\x00\xff\xfe"
+	changes := []plugin.VFSChange{
+		{Path: "/tmp/x", File: &plugin.VFSFile{Type: plugin.T_FILE, Name: "x", Contents: binary, Mode: 0755, Owner: "root"}},
+		{Path: "/tmp/d", File: &plugin.VFSFile{Type: plugin.T_DIR, Name: "d", Mode: 0755, Files: map[string]plugin.VFSFile{
+			"y": {Type: plugin.T_FILE, Name: "y", Contents: binary + "\x80"},
+		}}},
+		{Path: "/tmp/old", Deleted: true},
+	}
+
+	if err := store.Save("ip:10.0.0.1", "session", "10.0.0.1", "test", changes); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := store.Load("ip:10.0.0.1")
+
+	if err != nil {
+		t.Fatal(err)
+	} else if len(loaded) != len(changes) {
+		t.Fatalf("Expected %d changes, got %d", len(changes), len(loaded))
+	}
+
+	if loaded[0].File == nil || loaded[0].File.Contents != binary || loaded[0].File.Mode != 0755 || loaded[0].File.Owner != "root" {
+		t.Errorf("The binary file wasn't kept: %+v", loaded[0].File)
+	}
+
+	if loaded[1].File == nil || loaded[1].File.Files["y"].Contents != binary+"\x80" {
+		t.Errorf("The file in the directory wasn't kept: %+v", loaded[1].File)
+	}
+
+	if loaded[2].Path != "/tmp/old" || !loaded[2].Deleted || loaded[2].File != nil {
+		t.Errorf("The deletion wasn't kept: %+v", loaded[2])
+	}
+}
+
+// testConn is the connection of a user, which is all that the state needs.
+type testConn struct {
+	ssh.Conn
+	user string
+	addr net.Addr
+}
+
+func (c testConn) User() string {
+	return c.user
+}
+
+func (c testConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func TestStateKey(t *testing.T) {
+	byIP, _ := NewStateStore(StateKeyIP, 0, nil)
+	byCredentials, _ := NewStateStore(StateKeyCredentials, 0, nil)
+	conn := &ssh.ServerConn{
+		Conn:        testConn{user: "root"},
+		Permissions: &ssh.Permissions{Extensions: map[string]string{"password": "123456"}},
+	}
+
+	if key := byIP.Key(conn, "10.0.0.1"); key != "ip:10.0.0.1" {
+		t.Errorf("Unexpected key %q", key)
+	}
+
+	if key := byCredentials.Key(conn, "10.0.0.1"); key != "credentials:root:123456" {
+		t.Errorf("Unexpected key %q", key)
+	}
+
+	// A login with a planted key gets the state that the key was planted in.
+	conn.Permissions.Extensions["state"] = "ip:10.0.0.9"
+
+	if key := byCredentials.Key(conn, "10.0.0.1"); key != "ip:10.0.0.9" {
+		t.Errorf("Unexpected key %q", key)
+	}
+
+	if _, err := NewStateStore("nope", 0, nil); err == nil {
+		t.Error("Expected an unknown key to be an error")
+	}
+}
+
+func TestStateTTL(t *testing.T) {
+	db := newTestDB(t)
+	store, _ := NewStateStore(StateKeyIP, time.Hour, db)
+	changes := []plugin.VFSChange{{Path: "/tmp/x", File: &plugin.VFSFile{Type: plugin.T_FILE, Name: "x", Contents: "x"}}}
+
+	// Nothing is stored for a session that didn't change anything.
+	if err := store.Save("ip:10.0.0.1", "s1", "10.0.0.1", "test", nil); err != nil {
+		t.Fatal(err)
+	} else if loaded, err := store.Load("ip:10.0.0.1"); loaded != nil || err != nil {
+		t.Errorf("Unexpected state %+v (%v)", loaded, err)
+	}
+
+	store.Save("ip:10.0.0.1", "s1", "10.0.0.1", "test", changes)
+	store.Save("ip:10.0.0.2", "s2", "10.0.0.2", "test", changes)
+
+	if loaded, err := store.Load("ip:10.0.0.1"); len(loaded) != 1 || err != nil {
+		t.Errorf("Unexpected state %+v (%v)", loaded, err)
+	}
+
+	// The state expires once it wasn't saved for longer than the TTL.
+	db.Model(&FilesystemState{}).Where("key = ?", "ip:10.0.0.1").UpdateColumn("updated_at", time.Now().Add(-2*time.Hour))
+
+	if loaded, err := store.Load("ip:10.0.0.1"); loaded != nil || err != nil {
+		t.Errorf("Unexpected expired state %+v (%v)", loaded, err)
+	}
+
+	var count int64
+	db.Unscoped().Model(&FilesystemState{}).Count(&count)
+
+	if count != 1 {
+		t.Errorf("Expected the expired state to be deleted, %d left", count)
+	}
+
+	if loaded, _ := store.Load("ip:10.0.0.2"); len(loaded) != 1 {
+		t.Errorf("Unexpected state %+v", loaded)
+	}
+}
+
+func newTestKey(t *testing.T) (ssh.PublicKey, string) {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pubKey, err := ssh.NewPublicKey(public)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pubKey, string(ssh.MarshalAuthorizedKey(pubKey))
+}
+
+func TestFindKey(t *testing.T) {
+	db := newTestDB(t)
+	store, _ := NewStateStore(StateKeyIP, 0, db)
+	pubKey, line := newTestKey(t)
+	otherKey, _ := newTestKey(t)
+
+	// The key was planted in one session, and the state was last saved by another.
+	db.Create(&Persistence{Session: "s1", IPAddress: "10.0.0.1", Command: "bash", Mechanism: "ssh-key", Path: "/home/{}/.ssh/authorized_keys", Data: line})
+	store.Save("ip:10.0.0.1", "s2", "10.0.0.1", "test", []plugin.VFSChange{
+		{Path: "/home/{}/.ssh/authorized_keys", File: &plugin.VFSFile{Type: plugin.T_FILE, Name: "authorized_keys", Contents: "ssh-rsa AAAAB3Nza\n" + line}},
+		{Path: "/root/.ssh/authorized_keys2", File: &plugin.VFSFile{Type: plugin.T_FILE, Name: "authorized_keys2", Contents: line}},
+	})
+
+	tests := []struct {
+		ip       string
+		username string
+		pubKey   ssh.PublicKey
+		found    bool
+	}{
+		{"10.0.0.1", "test", pubKey, true},
+		{"10.0.0.1", "root", pubKey, true},
+		{"10.0.0.1", "test", otherKey, false},
+		{"10.0.0.1", "admin", pubKey, false},
+		{"10.0.0.2", "test", pubKey, false},
+	}
+
+	for _, test := range tests {
+		key, plantedBy, ok := store.FindKey(test.ip, test.username, test.pubKey)
+
+		if ok != test.found || (ok && (key != "ip:10.0.0.1" || plantedBy != "s1")) {
+			t.Errorf("%s@%s: unexpected result %q %q %t", test.username, test.ip, key, plantedBy, ok)
+		}
+	}
+
+	// When attackers are recognized by their credentials, the key works from
+	// anywhere.
+	store.KeyBy = StateKeyCredentials
+
+	if key, plantedBy, ok := store.FindKey("10.0.0.2", "test", pubKey); !ok || key != "ip:10.0.0.1" || plantedBy != "s1" {
+		t.Errorf("Unexpected result %q %q %t", key, plantedBy, ok)
+	}
+
+	// The key lets the attacker log in, and the login is linked to the session
+	// that planted it.
+	server := &SSHServer{Logger: CreateLogmanLogger(filepath.Join(t.TempDir(), "honeyshell.log")), State: store}
+	server.SetDB(db)
+	conn := testConn{user: "test", addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 50000}}
+
+	if perms, err := server.publicKeyChecker(conn, pubKey); err != nil || perms.Extensions["state"] != "ip:10.0.0.1" || perms.Extensions["planted-by"] != "s1" {
+		t.Errorf("Unexpected login %+v (%v)", perms, err)
+	} else if _, err := server.publicKeyChecker(conn, otherKey); err == nil {
+		t.Error("Expected another key to be refused")
+	}
+
+	connection := &KeyConnection{}
+	db.Where("planted_by = ?", "s1").Limit(1).Find(connection)
+
+	if connection.Username != "test" || connection.IPAddress != "10.0.0.1:50000" {
+		t.Errorf("Unexpected connection %+v", connection)
+	}
+
+	// Without a record of the planting, the session that saved the state is
+	// the one that planted it.
+	db.Where("1 = 1").Delete(&Persistence{})
+
+	if _, plantedBy, ok := store.FindKey("10.0.0.1", "test", pubKey); !ok || plantedBy != "s2" {
+		t.Errorf("Unexpected result %q %t", plantedBy, ok)
+	}
+}
@@ -3,6 +3,7 @@ module github.com/wisepythagoras/honeyshell
 go 1.24.0
 
 require (
+	github.com/pkg/sftp v1.13.10
 	github.com/yuin/gopher-lua v1.1.1
 	golang.org/x/crypto v0.45.0
 	golang.org/x/term v0.37.0
@@ -14,6 +15,7 @@ require (
 require (
 	github.com/jinzhu/inflection v1.0.0 // indirect
 	github.com/jinzhu/now v1.1.5 // indirect
+	github.com/kr/fs v0.1.0 // indirect
 	github.com/mattn/go-sqlite3 v1.14.32 // indirect
 	golang.org/x/sys v0.38.0 // indirect
 	golang.org/x/text v0.31.0 // indirect
@@ -0,0 +1,115 @@
+package plugin
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const base64Usage = "Try 'base64 --help' for more information.\n"
+
+// base64Command emulates the base64 command of coreutils. Decoding is the
+// most common way of dropping a binary without downloading it.
+func base64Command(args *CmdArgs, s *Session) {
+	opts, err := GetOpt(args.Array(), "diw:", []string{"decode", "ignore-garbage", "wrap=", "help", "version"})
+
+	if err != nil {
+		s.ErrWrite("base64: ", err.Error(), "\n", base64Usage)
+		s.SetStatus(1)
+		return
+	}
+
+	if opts.Has("help") {
+		s.TermWrite("Usage: base64 [OPTION]... [FILE]\n")
+		s.TermWrite("Base64 encode or decode FILE, or standard input, to standard output.\n")
+		return
+	} else if opts.Has("version") {
+		s.TermWrite("base64 (GNU coreutils) 8.32\n")
+		return
+	}
+
+	if len(opts.Args) > 1 {
+		s.ErrWrite(fmt.Sprintf("base64: extra operand ‘%s’\n", opts.Args[1]), base64Usage)
+		s.SetStatus(1)
+		return
+	}
+
+	input := ""
+
+	if len(opts.Args) == 0 || opts.Args[0] == "-" {
+		input = s.Stdin()
+	} else {
+		input, err = s.VFS.ReadFile(opts.Args[0])
+
+		if err != nil {
+			s.ErrWrite(fmt.Sprintf("base64: %s: %s\n", opts.Args[0], strError(err)))
+			s.SetStatus(1)
+			return
+		}
+	}
+
+	if opts.Has("d", "decode") {
+		data, err := base64Decode(input, opts.Has("i", "ignore-garbage"))
+		s.TermWrite(string(data))
+
+		if err != nil {
+			s.ErrWrite("base64: invalid input\n")
+			s.SetStatus(1)
+		}
+
+		return
+	}
+
+	wrap := 76
+
+	if w := opts.Get("w", "wrap"); w != "" {
+		if wrap, err = strconv.Atoi(w); err != nil || wrap < 0 {
+			s.ErrWrite(fmt.Sprintf("base64: invalid wrap size: ‘%s’\n", w))
+			s.SetStatus(1)
+			return
+		}
+	}
+
+	encoded := base64.StdEncoding.EncodeToString([]byte(input))
+
+	if wrap == 0 {
+		s.TermWrite(encoded)
+		return
+	}
+
+	for len(encoded) > wrap {
+		s.TermWrite(encoded[:wrap], "\n")
+		encoded = encoded[wrap:]
+	}
+
+	if encoded != "" {
+		s.TermWrite(encoded, "\n")
+	}
+}
+
+// base64Decode decodes the input, ignoring newlines. Whatever was decoded
+// before an error is still returned, just like coreutils outputs it.
+func base64Decode(input string, ignoreGarbage bool) ([]byte, error) {
+	clean := &strings.Builder{}
+
+	for _, c := range input {
+		switch {
+		case c == '\n' || c == '\r':
+		case ignoreGarbage && !strings.ContainsRune("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/=", c):
+		default:
+			clean.WriteRune(c)
+		}
+	}
+
+	src := clean.String()
+
+	if len(src)%4 != 0 && !strings.HasSuffix(src, "=") {
+		src += strings.Repeat("=", 4-len(src)%4)
+	}
+
+	data := make([]byte, base64.StdEncoding.DecodedLen(len(src)))
+	n, err := base64.StdEncoding.Decode(data, []byte(src))
+
+	return data[:n], err
+}
@@ -0,0 +1,75 @@
+package plugin
+
+import "strconv"
+
+// trueCommand is the `true` (and `:`) builtin of bash, which does nothing and
+// succeeds.
+func trueCommand(args *CmdArgs, s *Session) {
+	s.SetStatus(0)
+}
+
+// falseCommand is the `false` builtin of bash, which does nothing and fails.
+func falseCommand(args *CmdArgs, s *Session) {
+	s.SetStatus(1)
+}
+
+// breakCommand is the `break` (and `continue`) builtin of bash, which ends the
+// loop that it's in (or the iteration of it), or one that loop is in.
+func breakCommand(args *CmdArgs, s *Session) {
+	argv := args.Array()
+	n := 1
+
+	if len(argv) > 0 {
+		var err error
+
+		if n, err = strconv.Atoi(argv[0]); err != nil {
+			s.ErrWrite("bash: ", s.command, ": ", argv[0], ": numeric argument required\n")
+			s.SetStatus(128)
+			return
+		} else if n < 1 {
+			s.ErrWrite("bash: ", s.command, ": ", argv[0], ": loop count out of range\n")
+			s.SetStatus(1)
+			return
+		}
+	}
+
+	if s.loops == 0 {
+		s.ErrWrite("bash: ", s.command, ": only meaningful in a `for', `while', or `until' loop\n")
+		return
+	}
+
+	n = min(n, s.loops)
+
+	if s.command == "continue" {
+		s.continuing = n
+	} else {
+		s.breaking = n
+	}
+}
+
+// returnCommand is the `return` builtin of bash, which ends the function (or
+// the sourced file) that's running, with the status that it's given.
+func returnCommand(args *CmdArgs, s *Session) {
+	argv := args.Array()
+	status := s.status
+
+	if s.returnable == 0 {
+		s.ErrWrite("bash: return: can only `return' from a function or sourced script\n")
+		s.SetStatus(1)
+		return
+	}
+
+	if len(argv) > 0 {
+		n, err := strconv.Atoi(argv[0])
+
+		if err != nil {
+			s.ErrWrite("bash: return: ", argv[0], ": numeric argument required\n")
+			n = 2
+		}
+
+		status = n & 0xff
+	}
+
+	s.returning = true
+	s.SetStatus(status)
+}
@@ -0,0 +1,348 @@
+package plugin
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const crontabUsage = "usage:\tcrontab [-u user] file\n\tcrontab [ -u user ] [ -i ] { -e | -l | -r }\n\t\t(default operation is replace, per 1003.2)\n\t-e\t(edit user's crontab)\n\t-l\t(list user's crontab)\n\t-r\t(delete user's crontab)\n\t-i\t(prompt before deleting user's crontab)\n"
+
+// crontabHeader is what the crontab of Debian puts at the top of the crontabs
+// that it installs, and leaves out when it lists them.
+const crontabHeader = "# DO NOT EDIT THIS FILE - edit the master and reinstall.\n# (%s installed on %s)\n# (Cron version -- $Id: crontab.c,v 2.13 1994/01/17 03:20:37 vixie Exp $)\n"
+
+// cronEnvLine matches the lines of a crontab that set a variable.
+var cronEnvLine = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*\s*=`)
+
+// cronFields are the time fields of a line of a crontab, with their ranges and
+// the message that a field that's out of them gets.
+var cronFields = []struct {
+	min, max int
+	names    []string
+	err      string
+}{
+	{0, 59, nil, "bad minute"},
+	{0, 23, nil, "bad hour"},
+	{1, 31, nil, "bad day-of-month"},
+	{1, 12, []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}, "bad month"},
+	{0, 7, []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}, "bad day-of-week"},
+}
+
+// cronSpecials are the `@` shorthands that can take the place of the time
+// fields.
+var cronSpecials = []string{"@reboot", "@yearly", "@annually", "@monthly", "@weekly", "@daily", "@midnight", "@hourly"}
+
+// crontabCommand emulates the crontab of Debian's cron. The crontabs are kept
+// in the spool of the VFS, which the attacker can't write to directly, and
+// installing one is reported as persistence. Editing a crontab adds the lines
+// that are typed (until Ctrl-D) to it.
+func crontabCommand(args *CmdArgs, s *Session) {
+	opts, err := GetOpt(args.Array(), "u:elri", nil)
+
+	if err != nil {
+		s.ErrWrite("crontab: ", err.Error(), "\ncrontab: usage error: unrecognized option\n", crontabUsage)
+		s.SetStatus(1)
+		return
+	}
+
+	ops := 0
+
+	for _, op := range []string{"e", "l", "r"} {
+		if opts.Has(op) {
+			ops++
+		}
+	}
+
+	usageErr := ""
+
+	switch {
+	case ops > 1:
+		usageErr = "only one operation permitted"
+	case ops == 1 && len(opts.Args) > 0:
+		usageErr = "no arguments permitted after this option"
+	case ops == 0 && len(opts.Args) == 0:
+		usageErr = "file name must be specified for replace"
+	case ops == 0 && len(opts.Args) > 1:
+		usageErr = "too many arguments"
+	}
+
+	if usageErr != "" {
+		s.ErrWrite("crontab: usage error: ", usageErr, "\n", crontabUsage)
+		s.SetStatus(1)
+		return
+	}
+
+	user := s.VFS.user().Username
+
+	if s.User != nil {
+		user = s.User.Username
+	}
+
+	if name := opts.Get("u"); name != "" {
+		if !s.VFS.isRoot() {
+			s.ErrWrite("must be privileged to use -u\n")
+			s.SetStatus(1)
+			return
+		} else if _, err := s.VFS.LookupUser(name); err != nil {
+			s.ErrWrite("crontab: user `", name, "' unknown\n")
+			s.SetStatus(1)
+			return
+		}
+
+		user = name
+	}
+
+	current, exists := s.readCrontab(user)
+
+	switch {
+	case opts.Has("l"):
+		if !exists {
+			s.ErrWrite("no crontab for ", user, "\n")
+			s.SetStatus(1)
+			return
+		}
+
+		s.TermWrite(current)
+	case opts.Has("r"):
+		if !exists {
+			s.ErrWrite("no crontab for ", user, "\n")
+			s.SetStatus(1)
+			return
+		}
+
+		if opts.Has("i") && !s.Confirm(fmt.Sprintf("crontab: really delete %s's crontab? (y/n) ", user), false) {
+			return
+		}
+
+		defer s.asRoot()()
+		s.VFS.RemoveAll(s.crontabPath(user))
+	case opts.Has("e"):
+		s.editCrontab(user, current, exists)
+	default:
+		name := opts.Args[0]
+		contents := ""
+
+		if name == "-" {
+			contents = s.readLines()
+		} else if contents, err = s.VFS.ReadFile(name); err != nil {
+			s.ErrWrite(name, ": ", strError(err), "\n")
+			s.SetStatus(1)
+			return
+		}
+
+		if !s.installCrontab(user, name, contents) {
+			s.SetStatus(1)
+		}
+	}
+}
+
+// editCrontab stands in for the editor that `crontab -e` starts: it shows the
+// crontab and adds whatever is typed to it.
+func (s *Session) editCrontab(user, current string, exists bool) {
+	if !exists {
+		s.ErrWrite("no crontab for ", user, " - using an empty one\n")
+	}
+
+	s.TermWrite(current)
+	tmp := "/tmp/crontab.7Xq2Lm/crontab"
+
+	for {
+		added := s.readLines()
+
+		if added == "" {
+			s.ErrWrite("crontab: no changes made to crontab\n")
+			return
+		}
+
+		current += added
+		s.ErrWrite("crontab: installing new crontab\n")
+
+		if s.installCrontab(user, tmp, current) {
+			return
+		} else if !s.Confirm("Do you want to retry the same edit? (y/n) ", false) {
+			s.ErrWrite("crontab: edits left in ", tmp, "\n")
+			s.SetStatus(1)
+			return
+		}
+	}
+}
+
+// readLines reads everything that was piped into the command, or the lines
+// that the attacker types until Ctrl-D.
+func (s *Session) readLines() string {
+	if s.stdin != nil {
+		return s.Stdin()
+	}
+
+	text := ""
+
+	for {
+		line, err := s.ReadLine("")
+
+		if err != nil {
+			return text
+		}
+
+		text += line + "\n"
+	}
+}
+
+// crontabPath returns where the crontab of a user is kept, which depends on
+// the distro.
+func (s *Session) crontabPath(user string) string {
+	if s.VFS.DistroID() == "centos" {
+		return "/var/spool/cron/" + user
+	}
+
+	return "/var/spool/cron/crontabs/" + user
+}
+
+// readCrontab returns the crontab of a user without its header, and whether
+// they have one.
+func (s *Session) readCrontab(user string) (string, bool) {
+	defer s.asRoot()()
+	contents, err := s.VFS.ReadFile(s.crontabPath(user))
+
+	if err != nil {
+		return "", false
+	}
+
+	if strings.HasPrefix(contents, "# DO NOT EDIT THIS FILE") {
+		lines := strings.SplitAfterN(contents, "\n", 4)
+		contents = lines[len(lines)-1]
+	}
+
+	return contents, true
+}
+
+// installCrontab checks a crontab and puts it in the spool, if it's right.
+func (s *Session) installCrontab(user, name, contents string) bool {
+	if contents != "" && !strings.HasSuffix(contents, "\n") {
+		s.ErrWrite("new crontab file is missing newline before EOF, can't install.\n")
+		return false
+	}
+
+	for i, line := range strings.Split(contents, "\n") {
+		if msg := checkCronLine(line); msg != "" {
+			s.ErrWrite(fmt.Sprintf("%q:%d: %s\nerrors in crontab file, can't install.\n", name, i+1, msg))
+			return false
+		}
+	}
+
+	path := s.crontabPath(user)
+
+	defer s.asRoot()()
+	s.VFS.MkdirAll(filepath.Dir(path), 0730)
+
+	if s.VFS.DistroID() != "centos" {
+		contents = fmt.Sprintf(crontabHeader, name, time.Now().Format("Mon Jan  2 15:04:05 2006")) + contents
+	}
+
+	if err := s.VFS.WriteFile(path, contents); err != nil {
+		s.ErrWrite("crontab: ", path, ": ", strError(err), "\n")
+		return false
+	}
+
+	s.VFS.Chmod(path, 0600)
+
+	if s.VFS.DistroID() != "centos" {
+		s.VFS.Chown(path, user, "crontab")
+	}
+
+	return true
+}
+
+// checkCronLine returns what's wrong with a line of a crontab, if anything.
+func checkCronLine(line string) string {
+	line = strings.TrimSpace(line)
+
+	if line == "" || line[0] == '#' || cronEnvLine.MatchString(line) {
+		return ""
+	}
+
+	fields := strings.Fields(line)
+
+	if strings.HasPrefix(line, "@") {
+		if !slices.Contains(cronSpecials, strings.ToLower(fields[0])) {
+			return "bad time specifier"
+		} else if len(fields) < 2 {
+			return "bad command"
+		}
+
+		return ""
+	}
+
+	for i, f := range cronFields {
+		if i >= len(fields) || !checkCronField(fields[i], f.min, f.max, f.names) {
+			return f.err
+		}
+	}
+
+	if len(fields) < 6 {
+		return "bad command"
+	}
+
+	return ""
+}
+
+// checkCronField checks a time field of a crontab, which is a list of values
+// or ranges (or `*`), each with an optional step (e.g. "1-5,*/10").
+func checkCronField(field string, min, max int, names []string) bool {
+	value := func(v string) bool {
+		for _, name := range names {
+			if strings.EqualFold(v, name) {
+				return true
+			}
+		}
+
+		n, err := strconv.Atoi(v)
+
+		return err == nil && n >= min && n <= max
+	}
+
+	for _, item := range strings.Split(field, ",") {
+		item, step, hasStep := strings.Cut(item, "/")
+
+		if n, err := strconv.Atoi(step); hasStep && (err != nil || n < 1) {
+			return false
+		}
+
+		if item == "*" {
+			continue
+		}
+
+		from, to, isRange := strings.Cut(item, "-")
+
+		if !value(from) || (isRange && !value(to)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// asRoot makes the VFS act as root, the way a setuid program does, and returns
+// the function that switches back.
+func (s *Session) asRoot() func() {
+	user, login := s.VFS.User, s.VFS.Login
+	root, err := s.VFS.LookupUser("root")
+
+	if err != nil {
+		root = &User{Username: "root", Group: "root"}
+	}
+
+	if login == nil {
+		s.VFS.Login = user
+	}
+
+	s.VFS.User = root
+
+	return func() {
+		s.VFS.User, s.VFS.Login = user, login
+	}
+}
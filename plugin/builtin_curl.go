@@ -0,0 +1,182 @@
+package plugin
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"strings"
+)
+
+const curlUsage = "curl: try 'curl --help' or 'curl --manual' for more information\n"
+
+var curlLongOpts = []string{
+	"output=", "remote-name", "remote-name-all", "output-dir=", "create-dirs",
+	"silent", "show-error", "location", "insecure", "fail", "fail-with-body",
+	"include", "head", "request=", "header=", "data=", "data-binary=",
+	"data-raw=", "data-ascii=", "data-urlencode=", "get", "user-agent=", "user=",
+	"proxy=", "socks5=", "socks5-hostname=", "max-time=", "connect-timeout=",
+	"retry=", "retry-delay=", "referer=", "cookie=", "cookie-jar=", "write-out=",
+	"upload-file=", "form=", "compressed", "url=", "progress-bar",
+	"no-progress-meter", "http1.0", "http1.1", "http2", "ipv4", "ipv6",
+	"verbose", "version", "help", "tlsv1", "tlsv1.2", "cacert=", "cert=",
+	"key=", "limit-rate=", "range=", "continue-at=", "interface=", "resolve=",
+	"max-filesize=", "remote-time", "dump-header=", "trace=", "trace-ascii=",
+}
+
+// curlCommand emulates curl. Every URL is recorded as a download, and the
+// contents are either written to the VFS or printed to the terminal.
+func curlCommand(args *CmdArgs, s *Session) {
+	opts, err := GetOpt(args.Array(), "o:OsSLkfiIX:H:d:GA:u:x:m:e:b:c:w:T:F:r:C:D:E:K:Y:y:z:Rv#qVh0123456Zng", curlLongOpts)
+
+	if err != nil {
+		s.ErrWrite("curl: ", err.Error(), "\n", curlUsage)
+		s.SetStatus(2)
+		return
+	}
+
+	if opts.Has("V", "version") {
+		s.TermWrite("curl 7.81.0 (x86_64-pc-linux-gnu) libcurl/7.81.0 OpenSSL/3.0.2 zlib/1.2.11\n")
+		s.TermWrite("Protocols: dict file ftp ftps gopher gophers http https imap imaps ldap ldaps mqtt pop3 pop3s rtmp rtsp scp sftp smb smbs smtp smtps telnet tftp\n")
+		return
+	} else if opts.Has("h", "help") {
+		s.TermWrite("Usage: curl [options...] <url>\n")
+		return
+	}
+
+	urls := append(opts.Args, opts.All("url")...)
+
+	if len(urls) == 0 {
+		s.ErrWrite(curlUsage)
+		s.SetStatus(2)
+		return
+	}
+
+	silent := opts.Has("s", "silent")
+	showError := opts.Has("S", "show-error")
+	headOnly := opts.Has("I", "head")
+
+	req := &DownloadRequest{
+		Command: "curl",
+		Method:  "GET",
+		Headers: map[string]string{
+			"User-Agent": "curl/7.81.0",
+			"Accept":     "*/*",
+		},
+	}
+
+	for _, header := range opts.All("H", "header") {
+		if name, value, ok := strings.Cut(header, ":"); ok {
+			req.Headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+
+	if ua := opts.Get("A", "user-agent"); ua != "" {
+		req.Headers["User-Agent"] = ua
+	}
+
+	if data := opts.All("d", "data", "data-binary", "data-raw", "data-ascii", "data-urlencode"); len(data) > 0 {
+		req.Body = strings.Join(data, "&")
+		req.Method = "POST"
+
+		if opts.Has("G", "get") {
+			req.Method = "GET"
+		}
+	}
+
+	if headOnly {
+		req.Method = "HEAD"
+	}
+
+	if method := opts.Get("X", "request"); method != "" {
+		req.Method = method
+	}
+
+	outputs := opts.All("o", "output")
+	outputDir := opts.Get("output-dir")
+	remoteName := opts.Has("O", "remote-name", "remote-name-all")
+
+	for i, rawURL := range urls {
+		u, err := parseDownloadURL(rawURL, "http")
+
+		if err != nil {
+			if !silent || showError {
+				s.ErrWrite("curl: (3) URL using bad/illegal format or missing URL\n")
+			}
+
+			s.SetStatus(3)
+
+			continue
+		}
+
+		switch u.Scheme {
+		case "http", "https", "ftp", "ftps", "tftp", "file", "scp", "sftp":
+		default:
+			if !silent || showError {
+				s.ErrWrite(fmt.Sprintf("curl: (1) Protocol \"%s\" not supported or disabled in libcurl\n", u.Scheme))
+			}
+
+			s.SetStatus(1)
+
+			continue
+		}
+
+		urlReq := *req
+		urlReq.URL = u.String()
+
+		dest := ""
+
+		if i < len(outputs) {
+			dest = outputs[i]
+		} else if remoteName {
+			dest = remoteFileName(u)
+
+			if dest == "" {
+				if !silent || showError {
+					s.ErrWrite("curl: Remote file name has no length!\n")
+					s.ErrWrite("curl: (23) Failed writing received data to disk/application\n")
+				}
+
+				s.SetStatus(23)
+
+				continue
+			}
+		}
+
+		if dest != "" && dest != "-" && outputDir != "" {
+			dest = filepath.Join(outputDir, dest)
+		}
+
+		data, fetched := fetchDownload(s, &urlReq)
+
+		if headOnly {
+			emitDownload(s, &urlReq, "", data, fetched)
+			s.TermWrite("HTTP/1.1 200 OK\n")
+			s.TermWrite(fmt.Sprintf("Content-Length: %d\n", len(data)))
+			s.TermWrite("Content-Type: application/octet-stream\n\n")
+			continue
+		}
+
+		if dest == "" || dest == "-" {
+			emitDownload(s, &urlReq, "", data, fetched)
+			s.TermWrite(terminalPayload(data, fetched))
+			continue
+		}
+
+		if !silent {
+			rate := humanSize(len(data) * (2 + rand.Intn(20)))
+			s.ErrWrite("  % Total    % Received % Xferd  Average Speed   Time    Time     Time  Current\n")
+			s.ErrWrite("                                 Dload  Upload   Total   Spent    Left  Speed\n")
+			s.ErrWrite(fmt.Sprintf("100 %5s  100 %5s    0     0  %5s      0 --:--:-- --:--:-- --:--:-- %5s\n",
+				humanSize(len(data)), humanSize(len(data)), rate, rate))
+		}
+
+		if err := saveDownload(s, &urlReq, dest, data, fetched); err != nil {
+			if !silent || showError {
+				s.ErrWrite(fmt.Sprintf("Warning: Failed to open the file %s: %s\n", dest, err))
+				s.ErrWrite("curl: (23) Failure writing output to destination\n")
+			}
+
+			s.SetStatus(23)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package plugin
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// echoCommand emulates the echo builtin of bash, including the escapes of -e,
+// which are often used to drop binaries byte by byte (`echo -ne '\x7fELF...'`).
+func echoCommand(args *CmdArgs, s *Session) {
+	argv := args.Array()
+	newline := true
+	escapes := false
+
+	for len(argv) > 0 && isEchoOption(argv[0]) {
+		for _, c := range argv[0][1:] {
+			switch c {
+			case 'n':
+				newline = false
+			case 'e':
+				escapes = true
+			case 'E':
+				escapes = false
+			}
+		}
+
+		argv = argv[1:]
+	}
+
+	out := strings.Join(argv, " ")
+
+	if escapes {
+		expanded, stop := expandEscapes(out, false)
+		out = string(expanded)
+
+		if stop {
+			s.TermWrite(out)
+			return
+		}
+	}
+
+	if newline {
+		out += "\n"
+	}
+
+	s.TermWrite(out)
+}
+
+// isEchoOption returns whether the argument is made up only of options that
+// echo knows about. Anything else (even `--`) is printed as is.
+func isEchoOption(arg string) bool {
+	if len(arg) < 2 || arg[0] != '-' {
+		return false
+	}
+
+	return strings.Trim(arg[1:], "neE") == ""
+}
+
+// expandEscapes interprets backslash escapes, like `echo -e` and `printf` do.
+// The only difference between the two is how octal escapes are written: echo
+// expects `\0nnn`, while the format of printf takes `\nnn`. It also returns
+// whether a `\c` was found, after which no more output should be produced.
+func expandEscapes(str string, printfFormat bool) ([]byte, bool) {
+	out := []byte{}
+
+	for i := 0; i < len(str); i++ {
+		if str[i] != '\\' || i+1 >= len(str) {
+			out = append(out, str[i])
+			continue
+		}
+
+		n, consumed, stop := parseEscape(str[i+1:], printfFormat)
+
+		if stop {
+			return out, true
+		}
+
+		if consumed == 0 {
+			out = append(out, '\\')
+			continue
+		}
+
+		out = append(out, n...)
+		i += consumed
+	}
+
+	return out, false
+}
+
+// parseEscape parses a single escape sequence (without the backslash). It
+// returns the bytes it stands for and how many characters it used up.
+func parseEscape(str string, printfFormat bool) ([]byte, int, bool) {
+	simple := map[byte]byte{
+		'a': '\a', 'b': '\b', 'e': 0x1b, 'E': 0x1b, 'f': '\f', 'n': '\n',
+		'r': '\r', 't': '\t', 'v': '\v', '\\': '\\',
+	}
+
+	if printfFormat {
+		simple['"'] = '"'
+		simple['\''] = '\''
+	}
+
+	c := str[0]
+
+	if b, ok := simple[c]; ok {
+		return []byte{b}, 1, false
+	}
+
+	switch {
+	case c == 'c':
+		return nil, 0, true
+	case c == 'x':
+		digits := leadingDigits(str[1:], 2, 16)
+
+		if digits == "" {
+			return nil, 0, false
+		}
+
+		n, _ := strconv.ParseUint(digits, 16, 8)
+
+		return []byte{byte(n)}, 1 + len(digits), false
+	case c == 'u' || c == 'U':
+		max := 4
+
+		if c == 'U' {
+			max = 8
+		}
+
+		digits := leadingDigits(str[1:], max, 16)
+
+		if digits == "" {
+			return nil, 0, false
+		}
+
+		n, _ := strconv.ParseUint(digits, 16, 32)
+
+		return utf8.AppendRune(nil, rune(n)), 1 + len(digits), false
+	case c == '0' && !printfFormat:
+		digits := leadingDigits(str[1:], 3, 8)
+		n, _ := strconv.ParseUint("0"+digits, 8, 16)
+
+		return []byte{byte(n)}, 1 + len(digits), false
+	case c >= '0' && c <= '7':
+		digits := leadingDigits(str, 3, 8)
+		n, _ := strconv.ParseUint(digits, 8, 16)
+
+		return []byte{byte(n)}, len(digits), false
+	}
+
+	return nil, 0, false
+}
+
+// leadingDigits returns up to `max` digits of the given base from the start of
+// the string.
+func leadingDigits(str string, max, base int) string {
+	i := 0
+
+	for i < len(str) && i < max {
+		if _, err := strconv.ParseUint(str[i:i+1], base, 8); err != nil {
+			break
+		}
+
+		i++
+	}
+
+	return str[:i]
+}
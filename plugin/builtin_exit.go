@@ -0,0 +1,34 @@
+package plugin
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// exitCommand is the `exit` (and `logout`) builtin of the shell. It ends the
+// script that's running, the shell that `su` or `sudo -i` started, or else the
+// session.
+func exitCommand(args *CmdArgs, s *Session) {
+	status := s.status
+	argv := args.Array()
+
+	if len(argv) > 0 {
+		n, err := strconv.Atoi(argv[0])
+
+		if err != nil {
+			s.ErrWrite(fmt.Sprintf("bash: exit: %s: numeric argument required\n", argv[0]))
+			n = 2
+		}
+
+		status = n & 0xff
+	}
+
+	// In a script, exit only ends the script.
+	if s.scripts > 0 {
+		s.exiting = true
+	} else {
+		s.ExitShell()
+	}
+
+	s.SetStatus(status)
+}
@@ -0,0 +1,113 @@
+package plugin
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const historyUsage = "history: usage: history [-c] [-d offset] [n] or history -anrw [filename] or history -ps arg [arg...]\n"
+
+// historyCommand is the `history` builtin of bash, which lists the history of
+// the shell, or clears it, or reads and writes its file. Since every line is
+// appended to the file as soon as it's run, `-a` and `-n` have nothing to do.
+func historyCommand(args *CmdArgs, s *Session) {
+	opts, err := GetOpt(args.Array(), "+cd:anrwps", nil)
+
+	if err != nil {
+		s.ErrWrite("bash: history: ", err.Error(), "\n", historyUsage)
+		s.SetStatus(2)
+		return
+	}
+
+	file, _ := s.GetVar("HISTFILE")
+
+	if len(opts.Args) > 0 && opts.Has("a", "r", "w") {
+		file = opts.Args[0]
+	}
+
+	switch {
+	case opts.Has("c"):
+		s.history = nil
+		s.historyStart = 1
+	case opts.Has("d"):
+		offset := opts.Get("d")
+		n, err := strconv.Atoi(offset)
+
+		if n < 0 {
+			n += len(s.history)
+		} else {
+			n -= s.historyStart
+		}
+
+		if err != nil || n < 0 || n >= len(s.history) {
+			s.ErrWrite("bash: history: ", offset, ": history position out of range\n")
+			s.SetStatus(1)
+			return
+		}
+
+		s.history = slices.Delete(s.history, n, n+1)
+	case opts.Has("p"):
+		for _, arg := range opts.Args {
+			expanded, _, err := s.expandHistory(arg)
+
+			if err != nil {
+				s.ErrWrite("bash: history: ", err.Error(), "\n")
+				s.SetStatus(1)
+				return
+			}
+
+			s.TermWrite(expanded, "\n")
+		}
+	case opts.Has("s"):
+		// The `history -s` itself is replaced by its arguments.
+		if len(s.history) > 0 {
+			s.history = s.history[:len(s.history)-1]
+		}
+
+		if line := strings.Join(opts.Args, " "); strings.TrimSpace(line) != "" {
+			s.appendHistory(line)
+		}
+	case opts.Has("w"), opts.Has("a") && len(opts.Args) > 0:
+		if err := s.writeHistory(file, s.history, opts.Has("a")); err != nil {
+			s.ErrWrite("bash: history: ", file, ": cannot create: ", strError(err), "\n")
+			s.SetStatus(1)
+		}
+	case opts.Has("r"):
+		if s.readHistory(file) != nil {
+			s.SetStatus(1)
+		}
+	case opts.Has("a", "n"):
+	default:
+		s.listHistory(opts.Args)
+	}
+}
+
+// listHistory shows the lines of the history with their numbers, or only the
+// last few of them.
+func (s *Session) listHistory(args []string) {
+	lines := s.history
+
+	if len(args) > 1 {
+		s.ErrWrite("bash: history: too many arguments\n")
+		s.SetStatus(1)
+		return
+	} else if len(args) == 1 {
+		n, err := strconv.Atoi(args[0])
+
+		if err != nil || n < 0 {
+			s.ErrWrite("bash: history: ", args[0], ": numeric argument required\n")
+			s.SetStatus(1)
+			return
+		}
+
+		lines = lines[len(lines)-min(n, len(lines)):]
+	}
+
+	start := s.historyStart + len(s.history) - len(lines)
+
+	for i, line := range lines {
+		s.TermWrite(fmt.Sprintf("%5d  %s\n", start+i, line))
+	}
+}
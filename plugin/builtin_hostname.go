@@ -0,0 +1,63 @@
+package plugin
+
+import "strings"
+
+// hostnameCommand emulates the hostname command of net-tools, from the
+// persona of the VFS.
+func hostnameCommand(args *CmdArgs, s *Session) {
+	opts, err := GetOpt(args.Array(), "adfiIsFy:hV", []string{
+		"alias", "domain", "fqdn", "long", "ip-address", "all-ip-addresses",
+		"short", "file=", "help", "version", "boot",
+	})
+
+	if err != nil {
+		s.ErrWrite("hostname: ", err.Error(), "\n")
+		s.SetStatus(1)
+		return
+	}
+
+	if opts.Has("h", "help") {
+		s.TermWrite("Usage: hostname [-b] {hostname|-F file}         set host name (from file)\n")
+		s.TermWrite("       hostname [-a|-A|-d|-f|-i|-I|-s|-y]       display formatted name\n")
+		s.TermWrite("       hostname                                 display host name\n")
+		return
+	} else if opts.Has("V", "version") {
+		s.TermWrite("hostname 3.23\n")
+		return
+	}
+
+	if len(opts.Args) > 0 || opts.Has("F", "file") {
+		if !s.VFS.isRoot() {
+			s.ErrWrite("hostname: you must be root to change the host name\n")
+			s.SetStatus(1)
+			return
+		}
+
+		// Only the kernel's idea of the name changes, so /etc/hostname stays
+		// the way it was.
+		if len(opts.Args) > 0 && s.VFS.Persona != nil {
+			persona := *s.VFS.Persona
+			persona.Hostname = opts.Args[0]
+			s.VFS.Persona = &persona
+		}
+
+		return
+	}
+
+	hostname := s.VFS.Hostname()
+	ip := s.VFS.localIP()
+
+	switch {
+	case opts.Has("i", "ip-address"):
+		s.TermWrite(ip, "\n")
+	case opts.Has("I", "all-ip-addresses"):
+		s.TermWrite(ip, " \n")
+	case opts.Has("s", "short"):
+		s.TermWrite(strings.SplitN(hostname, ".", 2)[0], "\n")
+	case opts.Has("d", "domain", "y"):
+		_, domain, _ := strings.Cut(hostname, ".")
+		s.TermWrite(domain, "\n")
+	default:
+		s.TermWrite(hostname, "\n")
+	}
+}
@@ -0,0 +1,107 @@
+package plugin
+
+import (
+	"slices"
+	"strconv"
+)
+
+// jobsCommand emulates the jobs builtin of bash, which lists the jobs that
+// were started in the background. The ones that are over are listed once and
+// then forgotten.
+func jobsCommand(args *CmdArgs, s *Session) {
+	opts, err := GetOpt(args.Array(), "lnprsx", nil)
+
+	if err != nil {
+		s.ErrWrite("bash: jobs: ", err.Error(), "\njobs: usage: jobs [-lnprs] [jobspec ...] or jobs -x command [args]\n")
+		s.SetStatus(2)
+		return
+	}
+
+	jobs := s.jobs
+
+	if len(opts.Args) > 0 {
+		jobs = []*job{}
+
+		for _, spec := range opts.Args {
+			if j := s.findJob(spec); j != nil {
+				jobs = append(jobs, j)
+			} else {
+				s.ErrWrite("bash: jobs: ", spec, ": no such job\n")
+				s.SetStatus(1)
+			}
+		}
+	}
+
+	for _, j := range jobs {
+		state := s.jobState(j)
+
+		if (opts.Has("r") && state != "Running") || (opts.Has("s") && state != "Stopped") {
+			continue
+		}
+
+		if opts.Has("p") {
+			s.TermWrite(strconv.Itoa(j.pid), "\n")
+		} else {
+			s.TermWrite(s.jobLine(j, opts.Has("l")))
+		}
+	}
+
+	s.forgetJobs()
+}
+
+// disownCommand emulates the disown builtin of bash, which removes jobs from
+// the job table, so that their processes keep running after the shell exits.
+func disownCommand(args *CmdArgs, s *Session) {
+	opts, err := GetOpt(args.Array(), "ahr", nil)
+
+	if err != nil {
+		s.ErrWrite("bash: disown: ", err.Error(), "\ndisown: usage: disown [-h] [-ar] [jobspec ... | pid ...]\n")
+		s.SetStatus(2)
+		return
+	}
+
+	// With -h the jobs stay in the table, and only won't get a SIGHUP.
+	if opts.Has("h") {
+		return
+	}
+
+	if opts.Has("a", "r") && len(opts.Args) == 0 {
+		s.jobs = slices.DeleteFunc(s.jobs, func(j *job) bool {
+			return !opts.Has("r") || s.jobState(j) == "Running"
+		})
+		return
+	}
+
+	specs := opts.Args
+
+	if len(specs) == 0 {
+		specs = []string{"%+"}
+	}
+
+	for _, spec := range specs {
+		j := s.findJob(spec)
+
+		// Jobs can also be given by the PID of their process.
+		if pid, err := strconv.Atoi(spec); err == nil {
+			for _, candidate := range s.jobs {
+				if candidate.pid == pid || slices.Contains(candidate.pids, pid) {
+					j = candidate
+				}
+			}
+		}
+
+		if j == nil {
+			if spec == "%+" {
+				spec = "current"
+			}
+
+			s.ErrWrite("bash: disown: ", spec, ": no such job\n")
+			s.SetStatus(1)
+			continue
+		}
+
+		s.jobs = slices.DeleteFunc(s.jobs, func(candidate *job) bool {
+			return candidate == j
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package plugin
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const killUsage = "kill: usage: kill [-s sigspec | -n signum | -sigspec] pid | jobspec ... or kill -l [sigspec]\n"
+
+// killCommand emulates the kill builtin of bash.
+func killCommand(args *CmdArgs, s *Session) {
+	argv := args.Array()
+	signal := sigTERM
+
+	if len(argv) == 0 {
+		s.ErrWrite("bash: ", killUsage)
+		s.SetStatus(2)
+		return
+	}
+
+	switch arg := argv[0]; {
+	case arg == "-l" || arg == "-L" || arg == "--list":
+		killList(argv[1:], s)
+		return
+	case arg == "-s" || arg == "-n":
+		if len(argv) < 2 {
+			s.ErrWrite("bash: kill: ", arg, ": option requires an argument\n", killUsage)
+			s.SetStatus(2)
+			return
+		}
+
+		argv = argv[1:]
+		fallthrough
+	case strings.HasPrefix(arg, "-") && arg != "-" && arg != "--":
+		name := strings.TrimPrefix(argv[0], "-")
+
+		if argv[0] != arg {
+			name = argv[0]
+		}
+
+		n, ok := parseSignal(name)
+
+		if !ok {
+			s.ErrWrite("bash: kill: ", name, ": invalid signal specification\n")
+			s.SetStatus(1)
+			return
+		}
+
+		signal = n
+		argv = argv[1:]
+	}
+
+	if len(argv) > 0 && argv[0] == "--" {
+		argv = argv[1:]
+	}
+
+	if len(argv) == 0 {
+		s.ErrWrite("bash: ", killUsage)
+		s.SetStatus(2)
+		return
+	}
+
+	status := 0
+
+	for _, arg := range argv {
+		if strings.HasPrefix(arg, "%") {
+			j := s.findJob(arg)
+
+			if j == nil {
+				s.ErrWrite("bash: kill: ", arg, ": no such job\n")
+				status = 1
+				continue
+			} else if len(j.pids) == 0 {
+				s.ErrWrite(fmt.Sprintf("bash: kill: (%d) - %s\n", j.pid, ErrNoProcess))
+				status = 1
+				continue
+			}
+
+			// Killing the processes takes them out of the job.
+			for _, pid := range slices.Clone(j.pids) {
+				if err := s.Kill(pid, signal); err != nil {
+					s.ErrWrite(fmt.Sprintf("bash: kill: (%d) - %s\n", pid, err))
+					status = 1
+				}
+			}
+
+			continue
+		}
+
+		pid, err := strconv.Atoi(arg)
+
+		if err != nil {
+			s.ErrWrite("bash: kill: ", arg, ": arguments must be process or job IDs\n")
+			status = 1
+			continue
+		}
+
+		// -1 is every process that the user is allowed to signal, except for
+		// the shell itself.
+		if pid == -1 {
+			for _, p := range s.VFS.Processes() {
+				if p.PID != s.PID {
+					s.Kill(p.PID, signal)
+				}
+			}
+
+			continue
+		}
+
+		if err := s.Kill(max(pid, -pid), signal); err != nil {
+			s.ErrWrite(fmt.Sprintf("bash: kill: (%d) - %s\n", pid, err))
+			status = 1
+		}
+	}
+
+	s.SetStatus(status)
+}
+
+// killList prints the names of the signals, or translates them between their
+// names and numbers.
+func killList(args []string, s *Session) {
+	if len(args) == 0 {
+		out := strings.Builder{}
+		i := 0
+
+		for n := 1; n <= 64; n++ {
+			if n == 32 || n == 33 {
+				continue
+			}
+
+			i++
+			fmt.Fprintf(&out, "%2d) SIG%s", n, signalName(n))
+
+			if i%5 == 0 {
+				out.WriteString("\n")
+			} else {
+				out.WriteString("\t")
+			}
+		}
+
+		s.TermWrite(out.String(), "\n")
+		return
+	}
+
+	for _, arg := range args {
+		n, err := strconv.Atoi(arg)
+
+		// The exit status of a process that was killed is 128 plus the signal.
+		if err == nil && n > 128 {
+			n -= 128
+		}
+
+		if err == nil && n > 0 && n <= 64 {
+			s.TermWrite(signalName(n), "\n")
+		} else if n, ok := parseSignal(arg); ok && err != nil {
+			s.TermWrite(strconv.Itoa(n), "\n")
+		} else {
+			s.ErrWrite("bash: kill: ", arg, ": invalid signal specification\n")
+			s.SetStatus(1)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// nohupCommand emulates nohup from GNU coreutils, which runs a command that
+// ignores hangups, with its output going to nohup.out when it would otherwise
+// go to the terminal.
+func nohupCommand(args *CmdArgs, s *Session) {
+	argv := args.Array()
+
+	if len(argv) > 0 && argv[0] == "--" {
+		argv = argv[1:]
+	} else if len(argv) > 0 && argv[0] == "--help" {
+		s.TermWrite("Usage: nohup COMMAND [ARG]...\n  or:  nohup OPTION\nRun COMMAND, ignoring hangup signals.\n\n      --help     display this help and exit\n      --version  output version information and exit\n")
+		return
+	} else if len(argv) > 0 && argv[0] == "--version" {
+		s.TermWrite("nohup (GNU coreutils) 8.32\n")
+		return
+	} else if len(argv) > 0 && strings.HasPrefix(argv[0], "-") && argv[0] != "-" {
+		s.ErrWrite(fmt.Sprintf("nohup: invalid option -- '%s'\nTry 'nohup --help' for more information.\n", strings.TrimLeft(argv[0], "-")[:1]))
+		s.SetStatus(125)
+		return
+	}
+
+	if len(argv) == 0 {
+		s.ErrWrite("nohup: missing operand\nTry 'nohup --help' for more information.\n")
+		s.SetStatus(125)
+		return
+	}
+
+	stdin, stdout, stderr := s.stdin, s.stdout, s.stderr
+	defer func() { s.stdin, s.stdout, s.stderr = stdin, stdout, stderr }()
+
+	ignoringInput := s.stdin == nil
+	redirectingStdout := s.isTerminal(s.stdoutWriter())
+	redirectingStderr := s.isTerminal(s.stderrWriter())
+	out := "nohup.out"
+
+	if redirectingStdout {
+		file, err := s.VFS.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+
+		if err != nil {
+			out = s.home() + "/nohup.out"
+			file, err = s.VFS.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		}
+
+		if err != nil {
+			s.ErrWrite("nohup: failed to open 'nohup.out': ", strError(err), "\n")
+			s.SetStatus(125)
+			return
+		}
+
+		defer file.Close()
+		s.stdout = file
+	}
+
+	switch {
+	case ignoringInput && redirectingStdout:
+		s.ErrWrite("nohup: ignoring input and appending output to '", out, "'\n")
+	case ignoringInput && redirectingStderr:
+		s.ErrWrite("nohup: ignoring input and redirecting stderr to stdout\n")
+	case ignoringInput:
+		s.ErrWrite("nohup: ignoring input\n")
+	case redirectingStdout:
+		s.ErrWrite("nohup: appending output to '", out, "'\n")
+	case redirectingStderr:
+		s.ErrWrite("nohup: redirecting stderr to stdout\n")
+	}
+
+	if ignoringInput {
+		s.stdin = strings.NewReader("")
+	}
+
+	if redirectingStderr {
+		s.stderr = s.stdoutWriter()
+	}
+
+	if err := s.exec(argv); err != nil {
+		s.ErrWrite("nohup: failed to run command '", argv[0], "': ", strError(err), "\n")
+		s.SetStatus(execStatus(err))
+	}
+}
+
+// setsidCommand emulates setsid from util-linux, which runs a command in a new
+// session, without a controlling terminal.
+func setsidCommand(args *CmdArgs, s *Session) {
+	opts, err := GetOpt(args.Array(), "+cfwhV", []string{"ctty", "fork", "wait", "help", "version"})
+
+	if err != nil {
+		s.ErrWrite("setsid: ", err.Error(), "\nTry 'setsid --help' for more information.\n")
+		s.SetStatus(1)
+		return
+	}
+
+	if opts.Has("h", "help") {
+		s.TermWrite("\nUsage:\n setsid [options] <program> [arguments ...]\n\nRun a program in a new session.\n\nOptions:\n -c, --ctty     set the controlling terminal to the current one\n -f, --fork     always fork\n -w, --wait     wait program to exit, and use the same return\n\n -h, --help     display this help\n -V, --version  display version\n\nFor more details see setsid(1).\n")
+		return
+	} else if opts.Has("V", "version") {
+		s.TermWrite("setsid from util-linux 2.37.2\n")
+		return
+	}
+
+	if len(opts.Args) == 0 {
+		s.ErrWrite("setsid: no command specified\nTry 'setsid --help' for more information.\n")
+		s.SetStatus(1)
+		return
+	}
+
+	// The command inherits the new session, which has no terminal.
+	if p := s.VFS.FindProcess(s.VFS.procSelf()); p != nil && p.PID != s.PID && !opts.Has("c", "ctty") {
+		leader := *p
+		leader.TTY = ""
+		leader.State = "Ss"
+		s.VFS.procs[p.PID] = &leader
+	}
+
+	if err := s.exec(opts.Args); err != nil {
+		s.ErrWrite("setsid: failed to execute ", opts.Args[0], ": ", strError(err), "\n")
+		s.SetStatus(execStatus(err))
+	}
+}
+
+// execStatus returns the exit status of a command that couldn't run another
+// one, which like in bash is 127 if it wasn't found and 126 otherwise.
+func execStatus(err error) int {
+	if errors.Is(err, ErrNotExist) {
+		return StatusNotFound
+	}
+
+	return StatusNotExecutable
+}
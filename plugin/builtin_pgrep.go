@@ -0,0 +1,187 @@
+package plugin
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// pgrepCommand emulates pgrep from procps-ng, which finds processes by their
+// name (or, with -f, their whole command line).
+func pgrepCommand(args *CmdArgs, s *Session) {
+	pgrep("pgrep", args, s)
+}
+
+// pkillCommand emulates pkill from procps-ng, which is like pgrep, but signals
+// the processes that it finds instead of printing them.
+func pkillCommand(args *CmdArgs, s *Session) {
+	pgrep("pkill", args, s)
+}
+
+// pgrep finds the processes for pgrep and pkill, which are told apart by their
+// name.
+func pgrep(name string, args *CmdArgs, s *Session) {
+	argv := args.Array()
+	signal := sigTERM
+
+	// Like kill, pkill takes the signal as the first option (e.g. -9 or -HUP).
+	if name == "pkill" && len(argv) > 0 && strings.HasPrefix(argv[0], "-") && len(argv[0]) > 1 {
+		if n, ok := parseSignal(argv[0][1:]); ok {
+			signal = n
+			argv = argv[1:]
+		}
+	}
+
+	opts, err := GetOpt(argv, "acd:efg:ilnoP:s:t:u:U:vwx", []string{"signal=", "count", "delimiter=", "echo", "full", "list-name", "list-full", "newest", "oldest", "parent=", "euid=", "uid=", "inverse", "exact", "ignore-case", "help", "version"})
+
+	if err != nil {
+		s.ErrWrite(name, ": ", err.Error(), "\nTry `", name, " --help' for more information.\n")
+		s.SetStatus(2)
+		return
+	}
+
+	if opts.Has("help") {
+		s.TermWrite("\nUsage:\n ", name, " [options] <pattern>\n\nOptions:\n -f, --full                use full process name to match\n -u, --euid <ID,...>       match by effective IDs\n -x, --exact               match exactly with the command name\n\nFor more details see ", name, "(1).\n")
+		return
+	} else if opts.Has("version") {
+		s.TermWrite(name, " from procps-ng 3.3.17\n")
+		return
+	}
+
+	if sig := opts.Get("signal"); sig != "" {
+		n, ok := parseSignal(sig)
+
+		if !ok {
+			s.ErrWrite(name, ": Unknown signal \"", sig, "\".\n")
+			s.SetStatus(2)
+			return
+		}
+
+		signal = n
+	}
+
+	if len(opts.Args) > 1 {
+		s.ErrWrite(name, ": only one pattern can be provided\nTry `", name, " --help' for more information.\n")
+		s.SetStatus(2)
+		return
+	}
+
+	users := opts.All("u", "U", "euid", "uid")
+	parents := opts.All("P", "parent")
+
+	if len(opts.Args) == 0 && len(users) == 0 && len(parents) == 0 {
+		s.ErrWrite(name, ": no matching criteria specified\nTry `", name, " --help' for more information.\n")
+		s.SetStatus(2)
+		return
+	}
+
+	pattern := ""
+
+	if len(opts.Args) > 0 {
+		pattern = opts.Args[0]
+	}
+
+	if opts.Has("x", "exact") {
+		pattern = "^(?:" + pattern + ")$"
+	}
+
+	if opts.Has("i", "ignore-case") {
+		pattern = "(?i)" + pattern
+	}
+
+	re, err := regexp.Compile(pattern)
+
+	if err != nil {
+		s.ErrWrite(name, ": Invalid regular expression\n")
+		s.SetStatus(2)
+		return
+	}
+
+	full := opts.Has("f", "full")
+	users = strings.Split(strings.Join(users, ","), ",")
+	parents = strings.Split(strings.Join(parents, ","), ",")
+	matches := []*Process{}
+	self := s.VFS.procSelf()
+
+	for _, p := range s.VFS.Processes() {
+		// Like the real one, pgrep never finds itself.
+		if p.PID == self {
+			continue
+		}
+
+		subject := p.Name
+
+		if full {
+			subject = strings.Join(p.Cmdline, " ")
+		}
+
+		match := re.MatchString(subject)
+
+		if users[0] != "" {
+			match = match && (slices.Contains(users, s.VFS.processUser(p)) || slices.Contains(users, strconv.Itoa(p.UID)))
+		}
+
+		if parents[0] != "" {
+			match = match && slices.Contains(parents, strconv.Itoa(p.PPID))
+		}
+
+		if match != opts.Has("v", "inverse") {
+			matches = append(matches, p)
+		}
+	}
+
+	if len(matches) > 0 && opts.Has("n", "newest") {
+		matches = matches[len(matches)-1:]
+	} else if len(matches) > 0 && opts.Has("o", "oldest") {
+		matches = matches[:1]
+	}
+
+	if len(matches) == 0 {
+		s.SetStatus(1)
+	}
+
+	if name == "pkill" {
+		for _, p := range matches {
+			if err := s.Kill(p.PID, signal); err != nil {
+				s.ErrWrite(fmt.Sprintf("pkill: killing pid %d failed: %s\n", p.PID, err))
+			} else if opts.Has("e", "echo") {
+				s.TermWrite(fmt.Sprintf("%s killed (pid %d)\n", p.Name, p.PID))
+			}
+		}
+
+		if opts.Has("c", "count") {
+			s.TermWrite(strconv.Itoa(len(matches)), "\n")
+		}
+
+		return
+	}
+
+	if opts.Has("c", "count") {
+		s.TermWrite(strconv.Itoa(len(matches)), "\n")
+		return
+	}
+
+	delimiter := "\n"
+
+	if opts.Has("d", "delimiter") {
+		delimiter = opts.Get("d", "delimiter")
+	}
+
+	out := make([]string, len(matches))
+
+	for i, p := range matches {
+		out[i] = strconv.Itoa(p.PID)
+
+		if opts.Has("a", "list-full") {
+			out[i] += " " + p.Command()
+		} else if opts.Has("l", "list-name") {
+			out[i] += " " + p.Name
+		}
+	}
+
+	if len(out) > 0 {
+		s.TermWrite(strings.Join(out, delimiter), "\n")
+	}
+}
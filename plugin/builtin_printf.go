@@ -0,0 +1,245 @@
+package plugin
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// printfCommand emulates the printf builtin of bash.
+func printfCommand(args *CmdArgs, s *Session) {
+	argv := args.Array()
+
+	if len(argv) > 0 && argv[0] == "--" {
+		argv = argv[1:]
+	}
+
+	if len(argv) == 0 {
+		s.ErrWrite("bash: printf: usage: printf [-v var] format [arguments]\n")
+		s.SetStatus(2)
+		return
+	}
+
+	p := &printfState{args: argv[1:]}
+	out := p.format(argv[0])
+
+	s.TermWrite(string(out))
+
+	for _, err := range p.errors {
+		s.ErrWrite("bash: printf: ", err, "\n")
+	}
+
+	if len(p.errors) > 0 {
+		s.SetStatus(1)
+	}
+}
+
+// printfState keeps track of the arguments that are consumed by the format.
+type printfState struct {
+	args   []string
+	used   int
+	errors []string
+}
+
+// next returns the next argument, or an empty string once there are none.
+func (p *printfState) next() string {
+	if p.used >= len(p.args) {
+		p.used++
+		return ""
+	}
+
+	p.used++
+
+	return p.args[p.used-1]
+}
+
+// format applies the format to the arguments. Just like bash, the format is
+// reused for as long as there are arguments left.
+func (p *printfState) format(format string) []byte {
+	out := []byte{}
+
+	for {
+		before := p.used
+		chunk, stop := p.formatOnce(format)
+		out = append(out, chunk...)
+
+		if stop || p.used >= len(p.args) || p.used == before {
+			return out
+		}
+	}
+}
+
+// formatOnce goes through the format a single time.
+func (p *printfState) formatOnce(format string) ([]byte, bool) {
+	out := []byte{}
+
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+
+		if c == '\\' && i+1 < len(format) {
+			b, consumed, stop := parseEscape(format[i+1:], true)
+
+			if stop {
+				return out, true
+			} else if consumed == 0 {
+				out = append(out, c)
+				continue
+			}
+
+			out = append(out, b...)
+			i += consumed
+			continue
+		}
+
+		if c != '%' {
+			out = append(out, c)
+			continue
+		}
+
+		if i+1 < len(format) && format[i+1] == '%' {
+			out = append(out, '%')
+			i++
+			continue
+		}
+
+		// Read the flags, width and precision of the conversion.
+		j := i + 1
+		spec := "%"
+
+		for j < len(format) && strings.IndexByte("-+ #0", format[j]) >= 0 {
+			spec += format[j : j+1]
+			j++
+		}
+
+		spec, j = p.readNumber(format, spec, j)
+
+		if j < len(format) && format[j] == '.' {
+			spec += "."
+			spec, j = p.readNumber(format, spec, j+1)
+		}
+
+		if j >= len(format) {
+			p.errors = append(p.errors, fmt.Sprintf("`%s': missing format character", format[i:]))
+			return out, true
+		}
+
+		chunk, stop := p.convert(spec, format[j])
+		out = append(out, chunk...)
+		i = j
+
+		if stop {
+			return out, true
+		}
+	}
+
+	return out, false
+}
+
+// readNumber reads the width or precision of a conversion, which can either
+// be written in the format or taken from the arguments (`*`).
+func (p *printfState) readNumber(format, spec string, j int) (string, int) {
+	if j < len(format) && format[j] == '*' {
+		return spec + strconv.FormatInt(p.integer(p.next()), 10), j + 1
+	}
+
+	for j < len(format) && format[j] >= '0' && format[j] <= '9' {
+		spec += format[j : j+1]
+		j++
+	}
+
+	return spec, j
+}
+
+// convert formats the next argument with a single conversion.
+func (p *printfState) convert(spec string, verb byte) ([]byte, bool) {
+	switch verb {
+	case 's':
+		return []byte(fmt.Sprintf(spec+"s", p.next())), false
+	case 'b':
+		expanded, stop := expandEscapes(p.next(), false)
+		return []byte(fmt.Sprintf(spec+"s", expanded)), stop
+	case 'q':
+		return []byte(fmt.Sprintf(spec+"s", shellQuote(p.next()))), false
+	case 'c':
+		arg := p.next()
+
+		if arg == "" {
+			return []byte(fmt.Sprintf(spec+"s", "")), false
+		}
+
+		return []byte(fmt.Sprintf(spec+"s", arg[:1])), false
+	case 'd', 'i':
+		return []byte(fmt.Sprintf(spec+"d", p.integer(p.next()))), false
+	case 'o', 'u', 'x', 'X':
+		if verb == 'u' {
+			verb = 'd'
+		}
+
+		return []byte(fmt.Sprintf(spec+string(verb), uint64(p.integer(p.next())))), false
+	case 'e', 'E', 'f', 'F', 'g', 'G':
+		arg := p.next()
+		n, err := strconv.ParseFloat(arg, 64)
+
+		if err != nil && arg != "" {
+			p.errors = append(p.errors, fmt.Sprintf("%s: invalid number", arg))
+		}
+
+		if verb == 'F' {
+			verb = 'f'
+		}
+
+		return []byte(fmt.Sprintf(spec+string(verb), n)), false
+	}
+
+	p.errors = append(p.errors, fmt.Sprintf("%%%c: invalid format character", verb))
+
+	return nil, true
+}
+
+// integer parses a numeric argument like bash does: in decimal, octal (`0`),
+// hexadecimal (`0x`), or as the value of a character (`'A`).
+func (p *printfState) integer(arg string) int64 {
+	if arg == "" {
+		return 0
+	}
+
+	if arg[0] == '\'' || arg[0] == '"' {
+		if len(arg) < 2 {
+			return 0
+		}
+
+		return int64(arg[1])
+	}
+
+	n, err := strconv.ParseInt(strings.TrimSpace(arg), 0, 64)
+
+	if err != nil {
+		if u, uerr := strconv.ParseUint(strings.TrimSpace(arg), 0, 64); uerr == nil {
+			return int64(u)
+		}
+
+		p.errors = append(p.errors, fmt.Sprintf("%s: invalid number", arg))
+	}
+
+	return n
+}
+
+// shellQuote quotes a string so that it can be reused as shell input, the way
+// `printf %q` does.
+func shellQuote(str string) string {
+	if str == "" {
+		return "''"
+	}
+
+	out := &strings.Builder{}
+
+	for _, c := range str {
+		if strings.ContainsRune(" \t\n!\"#$&'()*;<>?[\\]^`{|}~", c) {
+			out.WriteByte('\\')
+		}
+
+		out.WriteRune(c)
+	}
+
+	return out.String()
+}
@@ -0,0 +1,422 @@
+package plugin
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const psUsage = `
+Usage:
+ ps [options]
+
+ Try 'ps --help <simple|list|output|threads|misc|all>'
+  or 'ps --help <s|l|o|t|m|a>'
+ for additional help text.
+
+For more details see ps(1).
+`
+
+// psColumn is a column that ps can show, with the width that procps gives it
+// and the value that it has for a process.
+type psColumn struct {
+	header string
+	width  int
+	left   bool
+	value  func(s *Session, p *Process) string
+}
+
+var psColumns = map[string]psColumn{
+	"pid":     {"PID", 7, false, func(s *Session, p *Process) string { return strconv.Itoa(p.PID) }},
+	"ppid":    {"PPID", 7, false, func(s *Session, p *Process) string { return strconv.Itoa(p.PPID) }},
+	"uid":     {"UID", 5, false, func(s *Session, p *Process) string { return strconv.Itoa(p.UID) }},
+	"user":    {"USER", 8, true, psUser},
+	"comm":    {"COMMAND", 0, true, func(s *Session, p *Process) string { return p.Name }},
+	"args":    {"COMMAND", 0, true, func(s *Session, p *Process) string { return p.Command() }},
+	"%cpu":    {"%CPU", 4, false, func(s *Session, p *Process) string { return fmt.Sprintf("%.1f", p.CPU) }},
+	"%mem":    {"%MEM", 4, false, psMem},
+	"vsz":     {"VSZ", 6, false, func(s *Session, p *Process) string { return strconv.Itoa(p.VSZ) }},
+	"rss":     {"RSS", 5, false, func(s *Session, p *Process) string { return strconv.Itoa(p.RSS) }},
+	"tty":     {"TT", 8, true, func(s *Session, p *Process) string { return p.Terminal() }},
+	"stat":    {"STAT", 4, true, func(s *Session, p *Process) string { return p.Stat() }},
+	"s":       {"S", 1, true, func(s *Session, p *Process) string { return p.Stat()[:1] }},
+	"stime":   {"STIME", 5, true, func(s *Session, p *Process) string { return psStart(p.Started) }},
+	"time":    {"TIME", 8, false, func(s *Session, p *Process) string { return psTime(p.CPUTime()) }},
+	"bsdtime": {"TIME", 6, false, func(s *Session, p *Process) string { return psBSDTime(p.CPUTime()) }},
+	"etime":   {"ELAPSED", 11, false, func(s *Session, p *Process) string { return psElapsed(time.Since(p.Started)) }},
+	"c":       {"C", 2, false, func(s *Session, p *Process) string { return strconv.Itoa(int(p.CPU)) }},
+	"ni":      {"NI", 3, false, func(s *Session, p *Process) string { return "0" }},
+}
+
+// psAliases are the other names that the columns go by.
+var psAliases = map[string]string{
+	"pcpu":       "%cpu",
+	"pmem":       "%mem",
+	"cmd":        "args",
+	"command":    "args",
+	"ucomm":      "comm",
+	"ucmd":       "comm",
+	"euser":      "user",
+	"uname":      "user",
+	"euid":       "uid",
+	"vsize":      "vsz",
+	"rssize":     "rss",
+	"tt":         "tty",
+	"tname":      "tty",
+	"state":      "s",
+	"start":      "stime",
+	"start_time": "stime",
+	"bsdstart":   "stime",
+	"cputime":    "time",
+	"nice":       "ni",
+}
+
+// psFormat builds a list of columns from their names, each of which may be
+// followed by "=" and the header that it should have instead.
+func psFormat(specs ...string) ([]psColumn, error) {
+	columns := []psColumn{}
+
+	for _, spec := range specs {
+		for _, field := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == ' ' }) {
+			name, header, custom := strings.Cut(field, "=")
+
+			if alias, ok := psAliases[name]; ok {
+				name = alias
+			}
+
+			column, ok := psColumns[name]
+
+			if !ok {
+				return nil, fmt.Errorf("unknown user-defined format specifier \"%s\"", name)
+			}
+
+			if custom {
+				column.header = header
+			}
+
+			columns = append(columns, column)
+		}
+	}
+
+	return columns, nil
+}
+
+// psCommand emulates the ps command of procps-ng, with both its UNIX (-ef)
+// and BSD (aux) options, from the process table of the VFS.
+func psCommand(args *CmdArgs, s *Session) {
+	var all, withTTY, ownAny, noLeaders, noHeaders, bsd bool
+	var pids, users, names []string
+	format := ""
+	custom := []string{}
+
+	argv := args.Array()
+
+	for i := 0; i < len(argv); i++ {
+		arg := argv[i]
+
+		// Takes the value of an option, either from the rest of the argument or
+		// from the next one.
+		value := func(rest string) string {
+			if rest != "" {
+				return rest
+			} else if i+1 < len(argv) {
+				i++
+				return argv[i]
+			}
+
+			return ""
+		}
+
+		switch {
+		case arg == "--help":
+			s.TermWrite(psUsage[1:])
+			return
+		case arg == "-V" || arg == "--version" || arg == "V":
+			s.TermWrite("ps from procps-ng 3.3.17\n")
+			return
+		case arg == "--no-headers" || arg == "--no-heading" || arg == "--noheaders":
+			noHeaders = true
+		case strings.HasPrefix(arg, "--sort"), arg == "--forest", arg == "--cols", arg == "--width":
+			if !strings.Contains(arg, "=") && arg != "--forest" {
+				i++
+			}
+		case strings.HasPrefix(arg, "--pid"), strings.HasPrefix(arg, "--user"), strings.HasPrefix(arg, "--format"):
+			name, v, ok := strings.Cut(arg, "=")
+
+			if !ok {
+				v = value("")
+			}
+
+			switch name {
+			case "--pid":
+				pids = append(pids, v)
+			case "--user":
+				users = append(users, v)
+			default:
+				custom = append(custom, v)
+			}
+		case strings.HasPrefix(arg, "--"):
+			s.ErrWrite("error: unknown gnu long option\n", psUsage)
+			s.SetStatus(1)
+			return
+		case strings.HasPrefix(arg, "-") && len(arg) > 1 && strings.Trim(arg[1:], "aux") == "" && len(arg) == 4:
+			// procps takes "-aux" to mean "aux".
+			bsd, all, format = true, true, "u"
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			for j := 1; j < len(arg); j++ {
+				switch c := arg[j]; c {
+				case 'e', 'A':
+					all = true
+				case 'a':
+					withTTY, noLeaders = true, true
+				case 'd':
+					all, noLeaders = true, true
+				case 'f', 'F', 'l':
+					format = "f"
+				case 'w', 'H', 'j', 'y', 'c', 'L', 'T', 'M', 'Z', 'N':
+				case 'p', 'q', 'u', 'U', 'C', 'o', 'O', 't', 'g', 'G', 's':
+					v := value(arg[j+1:])
+					j = len(arg)
+
+					switch c {
+					case 'p', 'q':
+						pids = append(pids, v)
+					case 'u', 'U':
+						users = append(users, v)
+					case 'C':
+						names = append(names, v)
+					case 'o', 'O':
+						custom = append(custom, v)
+					}
+				default:
+					s.ErrWrite("error: unsupported SysV option\n", psUsage)
+					s.SetStatus(1)
+					return
+				}
+			}
+		case strings.Trim(arg, "0123456789,") == "":
+			pids = append(pids, arg)
+		default:
+			bsd = true
+
+			for j := 0; j < len(arg); j++ {
+				switch c := arg[j]; c {
+				case 'a':
+					withTTY = true
+				case 'x':
+					ownAny = true
+				case 'u', 'v':
+					format = "u"
+				case 'p', 'U', 't', 'o', 'O':
+					v := value(arg[j+1:])
+					j = len(arg)
+
+					switch c {
+					case 'p':
+						pids = append(pids, v)
+					case 'U':
+						users = append(users, v)
+					case 'o', 'O':
+						custom = append(custom, v)
+					}
+				case 'w', 'f', 'e', 'h', 'j', 'l', 'c', 'r', 'S', 'm', 'n', 'g', 's', 'T', 'X', 'Z':
+				default:
+					s.ErrWrite("error: unsupported option (BSD syntax)\n", psUsage)
+					s.SetStatus(1)
+					return
+				}
+			}
+		}
+	}
+
+	var columns []psColumn
+	var err error
+
+	switch {
+	case len(custom) > 0:
+		columns, err = psFormat(custom...)
+	case format == "u":
+		columns, _ = psFormat("user,pid,%cpu,%mem,vsz,rss,tty=TTY,stat,bsdstart=START,bsdtime,command")
+	case format == "f":
+		columns, _ = psFormat("user=UID,pid,ppid,c,stime,tty=TTY,time,cmd=CMD")
+	case bsd:
+		columns, _ = psFormat("pid,tty=TTY,stat,bsdtime,command")
+	default:
+		columns, _ = psFormat("pid,tty=TTY,time,comm=CMD")
+	}
+
+	if err != nil {
+		s.ErrWrite("error: ", err.Error(), "\n", psUsage)
+		s.SetStatus(1)
+		return
+	}
+
+	euid := 1000
+
+	if s.VFS.User != nil {
+		euid = s.VFS.User.UID
+	}
+
+	selected := []*Process{}
+	explicit := len(pids)+len(users)+len(names) > 0
+	pidList := strings.Split(strings.Join(pids, ","), ",")
+	userList := strings.Split(strings.Join(users, ","), ",")
+	nameList := strings.Split(strings.Join(names, ","), ",")
+
+	for _, p := range s.VFS.Processes() {
+		own := p.UID == euid
+		ok := false
+
+		switch {
+		case all:
+			ok = !noLeaders || !strings.Contains(p.Stat(), "s")
+		case withTTY && ownAny:
+			ok = true
+		case withTTY:
+			ok = p.TTY != "" && (!noLeaders || !strings.Contains(p.Stat(), "s"))
+		case ownAny:
+			ok = own
+		case !explicit:
+			ok = own && p.TTY == s.tty
+		}
+
+		ok = ok || slices.Contains(pidList, strconv.Itoa(p.PID)) ||
+			slices.Contains(nameList, p.Name) ||
+			slices.Contains(userList, strconv.Itoa(p.UID)) ||
+			slices.Contains(userList, s.VFS.processUser(p))
+
+		if ok {
+			selected = append(selected, p)
+		}
+	}
+
+	header := !noHeaders && slices.ContainsFunc(columns, func(c psColumn) bool { return c.header != "" })
+	s.TermWrite(psTable(s, columns, selected, header))
+
+	if len(selected) == 0 {
+		s.SetStatus(1)
+	}
+}
+
+// psTable formats the processes into the columns, which grow when their values
+// don't fit. The last column is never padded.
+func psTable(s *Session, columns []psColumn, procs []*Process, header bool) string {
+	rows := [][]string{}
+	widths := make([]int, len(columns))
+
+	for i, column := range columns {
+		widths[i] = max(column.width, len(column.header))
+	}
+
+	for _, p := range procs {
+		row := []string{}
+
+		for i, column := range columns {
+			v := column.value(s, p)
+			row = append(row, v)
+			widths[i] = max(widths[i], len(v))
+		}
+
+		rows = append(rows, row)
+	}
+
+	if header {
+		row := []string{}
+
+		for _, column := range columns {
+			row = append(row, column.header)
+		}
+
+		rows = append([][]string{row}, rows...)
+	}
+
+	out := strings.Builder{}
+
+	for _, row := range rows {
+		for i, v := range row {
+			if i > 0 {
+				out.WriteString(" ")
+			}
+
+			if i == len(row)-1 && columns[i].left {
+				out.WriteString(v)
+			} else if columns[i].left {
+				fmt.Fprintf(&out, "%-*s", widths[i], v)
+			} else {
+				fmt.Fprintf(&out, "%*s", widths[i], v)
+			}
+		}
+
+		out.WriteString("\n")
+	}
+
+	return out.String()
+}
+
+// psUser returns the user of a process, which is cut short with a "+" when
+// it's too long for the column.
+func psUser(s *Session, p *Process) string {
+	name := s.VFS.processUser(p)
+
+	if len(name) > 8 {
+		return name[:7] + "+"
+	}
+
+	return name
+}
+
+// psMem returns the share of the memory of the machine that a process uses.
+func psMem(s *Session, p *Process) string {
+	return fmt.Sprintf("%.1f", float64(p.RSS)*100/float64(max(s.VFS.machine().MemTotal, 1)))
+}
+
+// psStart returns when a process started: the time if it was today, the day if
+// it was this year, and otherwise the year.
+func psStart(t time.Time) string {
+	now := time.Now()
+
+	switch {
+	case t.YearDay() == now.YearDay() && t.Year() == now.Year():
+		return t.Format("15:04")
+	case t.Year() == now.Year():
+		return t.Format("Jan02")
+	}
+
+	return t.Format("2006")
+}
+
+// psTime formats the CPU time of a process like "00:01:02", with the days in
+// front when there are any.
+func psTime(d time.Duration) string {
+	secs := int(d.Seconds())
+	out := fmt.Sprintf("%02d:%02d:%02d", secs/3600%24, secs/60%60, secs%60)
+
+	if days := secs / 86400; days > 0 {
+		return fmt.Sprintf("%d-%s", days, out)
+	}
+
+	return out
+}
+
+// psBSDTime formats the CPU time of a process in minutes and seconds.
+func psBSDTime(d time.Duration) string {
+	secs := int(d.Seconds())
+	return fmt.Sprintf("%d:%02d", secs/60, secs%60)
+}
+
+// psElapsed formats how long a process has been running like
+// "[[dd-]hh:]mm:ss".
+func psElapsed(d time.Duration) string {
+	secs := int(d.Seconds())
+
+	switch days, hours := secs/86400, secs/3600%24; {
+	case days > 0:
+		return fmt.Sprintf("%d-%02d:%02d:%02d", days, hours, secs/60%60, secs%60)
+	case hours > 0:
+		return fmt.Sprintf("%02d:%02d:%02d", hours, secs/60%60, secs%60)
+	}
+
+	return fmt.Sprintf("%02d:%02d", secs/60%60, secs%60)
+}
@@ -0,0 +1,165 @@
+package plugin
+
+import (
+	"strconv"
+	"strings"
+)
+
+// readCommand is the `read` builtin of bash, which reads a line into variables
+// by splitting it into fields on $IFS. The last variable gets the rest of the
+// line, and without any variables, the whole line goes to $REPLY.
+func readCommand(args *CmdArgs, s *Session) {
+	opts, err := GetOpt(args.Array(), "ersa:d:i:n:N:p:t:u:", nil)
+
+	if err != nil {
+		s.ErrWrite("bash: read: ", err.Error(), "\nread: usage: read [-ers] [-a array] [-d delim] [-i text] [-n nchars] [-N nchars] [-p prompt] [-t timeout] [-u fd] [name ...]\n")
+		s.SetStatus(2)
+		return
+	}
+
+	names := opts.Args
+
+	// Arrays aren't supported, so `-a name` gets the whole line.
+	if opts.Has("a") {
+		names = []string{opts.Get("a")}
+	}
+
+	for _, name := range names {
+		if !isName(name) {
+			s.ErrWrite("bash: read: `", name, "': not a valid identifier\n")
+			s.SetStatus(1)
+			return
+		}
+	}
+
+	var line string
+	prompt := opts.Get("p")
+
+	if n := opts.Get("n", "N"); n != "" {
+		count, convErr := strconv.Atoi(n)
+
+		if convErr != nil || count < 0 {
+			s.ErrWrite("bash: read: ", n, ": invalid number\n")
+			s.SetStatus(1)
+			return
+		}
+
+		line, err = s.readChars(count, prompt, opts.Has("s"), opts.Has("n"))
+	} else if opts.Has("s") {
+		line, err = s.ReadPassword(prompt)
+	} else {
+		line, err = s.ReadLine(prompt)
+	}
+
+	if !opts.Has("r") {
+		line = unescapeRead(line)
+	}
+
+	if err != nil {
+		s.SetStatus(1)
+	}
+
+	if len(names) == 0 {
+		s.SetVar("REPLY", line)
+		return
+	}
+
+	ifs, _ := s.GetVar("IFS")
+	fields := splitRead(line, ifs, len(names))
+
+	for i, name := range names {
+		value := ""
+
+		if i < len(fields) {
+			value = fields[i]
+		}
+
+		if err := s.SetVar(name, value); err != nil {
+			s.ErrWrite("bash: ", err.Error(), "\n")
+			s.SetStatus(1)
+		}
+	}
+}
+
+// readChars reads a number of characters for `read -n` (which stops early at
+// the end of the line) and `read -N`, a key at a time when they're typed.
+func (s *Session) readChars(count int, prompt string, hidden, stopAtNewline bool) (string, error) {
+	if s.stdin == nil {
+		s.ErrWrite(prompt)
+	}
+
+	line := ""
+
+	for range count {
+		key, err := s.ReadKey()
+
+		if err != nil {
+			return line, err
+		} else if stopAtNewline && (key == "\r" || key == "\n") {
+			break
+		}
+
+		// Unlike the line editor, reading keys doesn't echo them.
+		if !hidden && s.stdin == nil {
+			s.termWriter().Write([]byte(key))
+		}
+
+		line += key
+	}
+
+	return line, nil
+}
+
+// unescapeRead removes the backslashes from what `read` read, which only keep
+// the characters after them from being split on.
+func unescapeRead(line string) string {
+	out := strings.Builder{}
+
+	for i := 0; i < len(line); i++ {
+		if line[i] == '\\' {
+			i++
+		}
+
+		if i < len(line) {
+			out.WriteByte(line[i])
+		}
+	}
+
+	return out.String()
+}
+
+// splitRead splits a line into at most `n` fields for `read`. Whitespace in
+// $IFS is trimmed and runs of it separate fields, while any other character
+// in it separates fields on its own.
+func splitRead(line, ifs string, n int) []string {
+	isIFS := func(c rune) bool {
+		return strings.ContainsRune(ifs, c)
+	}
+	isSpace := func(c rune) bool {
+		return isIFS(c) && strings.ContainsRune(" \t\n", c)
+	}
+
+	line = strings.TrimFunc(line, isSpace)
+	fields := []string{}
+
+	for len(fields) < n-1 && line != "" {
+		end := strings.IndexFunc(line, isIFS)
+
+		if end < 0 {
+			break
+		}
+
+		fields = append(fields, line[:end])
+		line = strings.TrimLeftFunc(line[end:], isSpace)
+
+		if line != "" && isIFS(rune(line[0])) {
+			line = strings.TrimLeftFunc(line[1:], isSpace)
+		}
+	}
+
+	if line != "" {
+		fields = append(fields, line)
+	}
+
+	return fields
+}
@@ -0,0 +1,123 @@
+package plugin
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// shCommand emulates bash, along with the sh and dash that it stands in for.
+// It runs the commands of -c, a script or whatever was piped into it in a copy
+// of the session's shell, or else starts a new interactive shell.
+func shCommand(args *CmdArgs, s *Session) {
+	argv := args.Array()
+	name := s.command
+	command, login := false, false
+	i := 0
+
+	for ; i < len(argv); i++ {
+		arg := argv[i]
+
+		if arg == "--" || arg == "-" {
+			i++
+			break
+		} else if arg == "--version" {
+			s.TermWrite("GNU bash, version 5.1.16(1)-release (x86_64-pc-linux-gnu)\nCopyright (C) 2020 Free Software Foundation, Inc.\nLicense GPLv3+: GNU GPL version 3 or later <http://gnu.org/licenses/gpl.html>\n\nThis is free software; you are free to change and redistribute it.\nThere is NO WARRANTY, to the extent permitted by law.\n")
+			return
+		} else if arg == "--login" {
+			login = true
+			continue
+		} else if strings.HasPrefix(arg, "--") {
+			// Like --norc, --noprofile or --posix, which don't change anything
+			// here.
+			continue
+		} else if len(arg) < 2 || (arg[0] != '-' && arg[0] != '+') {
+			break
+		}
+
+		for _, c := range arg[1:] {
+			switch {
+			case c == 'c':
+				command = true
+			case c == 'l':
+				login = true
+			case c == 'o' || c == 'O':
+				i++
+			case strings.ContainsRune("abefhikmnprstuvxBCEHPT", c):
+			default:
+				s.ErrWrite(fmt.Sprintf("%s: -%c: invalid option\n", name, c))
+				s.ErrWrite("Usage:\t", name, " [GNU long option] [option] ...\n\t", name, " [GNU long option] [option] script-file ...\n")
+				s.SetStatus(2)
+				return
+			}
+		}
+	}
+
+	operands := argv[min(i, len(argv)):]
+
+	switch {
+	case command && len(operands) == 0:
+		s.ErrWrite(name, ": -c: option requires an argument\n")
+		s.SetStatus(2)
+	case command:
+		// The arguments after the command start from $0.
+		params := operands[1:]
+
+		if len(params) == 0 {
+			params = []string{name}
+		}
+
+		s.runShellScript(operands[0], params)
+	case len(operands) > 0:
+		s.runScriptFile(name, operands)
+	case s.stdin != nil:
+		s.runShellScript(s.Stdin(), []string{name})
+	default:
+		// A new interactive shell, which the session is in until it exits.
+		sudoUntil := s.sudoUntil
+		s.SwitchUser(s.User, login)
+		s.sudoUntil = sudoUntil
+
+		if p := s.VFS.FindProcess(s.PID); p != nil {
+			p.Name = name
+			p.Cmdline = []string{name}
+
+			if login {
+				p.Cmdline = []string{"-" + name}
+			}
+		}
+	}
+}
+
+// runScriptFile runs a script that was given to the shell as its argument,
+// which, unlike running it by its path, only needs to be readable.
+func (s *Session) runScriptFile(name string, argv []string) {
+	path := argv[0]
+	_, file, err := s.VFS.FindFile(path)
+
+	switch {
+	case err != nil:
+		s.ErrWrite(name, ": ", path, ": ", strError(err), "\n")
+		s.SetStatus(StatusNotFound)
+		return
+	case file.Type == T_DIR:
+		s.ErrWrite(name, ": ", path, ": Is a directory\n")
+		s.SetStatus(StatusNotExecutable)
+		return
+	case !s.VFS.access(file).Read:
+		s.ErrWrite(name, ": ", path, ": Permission denied\n")
+		s.SetStatus(StatusNotExecutable)
+		return
+	}
+
+	contents := s.VFS.FileContents(file)
+	s.logExecution(s.VFS.AbsPath(path), slices.Concat([]string{name}, argv), s.job != nil)
+
+	if isBinary(contents) {
+		s.ErrWrite(name, ": ", path, ": cannot execute binary file\n")
+		s.SetStatus(StatusNotExecutable)
+		return
+	}
+
+	s.runShellScript(contents, argv)
+}
@@ -0,0 +1,82 @@
+package plugin
+
+import (
+	"slices"
+	"strings"
+)
+
+// sourceCommand is the `source` (and `.`) builtin of bash, which runs the
+// commands of a file in the session's own shell, so that the variables and
+// functions that it sets stay. Its `return` ends only the file.
+func sourceCommand(args *CmdArgs, s *Session) {
+	argv := args.Array()
+
+	if len(argv) > 0 && argv[0] == "--" {
+		argv = argv[1:]
+	}
+
+	if len(argv) == 0 {
+		s.ErrWrite("bash: ", s.command, ": filename argument required\n", s.command, ": usage: ", s.command, " filename [arguments]\n")
+		s.SetStatus(2)
+		return
+	}
+
+	path := argv[0]
+
+	// Like bash, a name without a slash is looked for in the PATH first.
+	if !strings.Contains(path, "/") {
+		for _, dir := range commandPath {
+			if _, file, err := s.VFS.FindFile(dir + "/" + path); err == nil && file.Type == T_FILE {
+				path = dir + "/" + path
+				break
+			}
+		}
+	}
+
+	_, file, err := s.VFS.FindFile(path)
+
+	switch {
+	case err != nil:
+		s.ErrWrite("bash: ", argv[0], ": ", strError(err), "\n")
+		s.SetStatus(1)
+		return
+	case file.Type == T_DIR:
+		s.ErrWrite("bash: ", s.command, ": ", argv[0], ": is a directory\n")
+		s.SetStatus(1)
+		return
+	case !s.VFS.access(file).Read:
+		s.ErrWrite("bash: ", argv[0], ": Permission denied\n")
+		s.SetStatus(1)
+		return
+	}
+
+	contents := s.VFS.FileContents(file)
+	s.logExecution(s.VFS.AbsPath(path), slices.Concat([]string{s.command}, argv), s.job != nil)
+
+	if isBinary(contents) {
+		s.ErrWrite("bash: ", s.command, ": ", argv[0], ": cannot execute binary file\n")
+		s.SetStatus(StatusNotExecutable)
+		return
+	}
+
+	params := s.args
+
+	if len(argv) > 1 {
+		s.setPositional(argv[1:])
+	}
+
+	s.returnable++
+	s.Run(contents)
+	s.returnable--
+	s.returning = false
+
+	if len(argv) > 1 {
+		s.args = params
+	}
+}
+
+// evalCommand is the `eval` builtin of bash, which runs its arguments as a
+// command line.
+func evalCommand(args *CmdArgs, s *Session) {
+	s.Run(strings.Join(args.Array(), " "))
+}
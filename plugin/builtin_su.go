@@ -0,0 +1,80 @@
+package plugin
+
+import (
+	"fmt"
+	"slices"
+)
+
+const suUsage = "Try 'su --help' for more information.\n"
+
+// suCommand emulates the su command of util-linux. Only root can become
+// someone else without their password.
+func suCommand(args *CmdArgs, s *Session) {
+	argv := args.Array()
+	login := slices.Contains(argv, "-")
+	argv = slices.DeleteFunc(slices.Clone(argv), func(arg string) bool { return arg == "-" })
+
+	opts, err := GetOpt(argv, "lc:s:mphV", []string{
+		"login", "command=", "shell=", "preserve-environment", "pty", "help", "version",
+	})
+
+	if err != nil {
+		s.ErrWrite("su: ", err.Error(), "\n", suUsage)
+		s.SetStatus(1)
+		return
+	}
+
+	if opts.Has("h", "help") {
+		s.TermWrite("\nUsage:\n su [options] [-] [<user> [<argument>...]]\n\nChange the effective user ID and group ID to that of <user>.\nA mere - implies -l.  If <user> is not given, root is assumed.\n")
+		return
+	} else if opts.Has("V", "version") {
+		s.TermWrite("su from util-linux 2.37.2\n")
+		return
+	}
+
+	name := "root"
+
+	if len(opts.Args) > 0 {
+		name = opts.Args[0]
+	}
+
+	target, err := s.VFS.LookupUser(name)
+
+	if err != nil && name == "root" {
+		target = s.VFS.SessionUser("root")
+	} else if err != nil {
+		s.ErrWrite(fmt.Sprintf("su: user %s does not exist or the user entry does not contain all the required fields\n", name))
+		s.SetStatus(1)
+		return
+	}
+
+	if !s.User.IsRoot() {
+		password, err := s.readPassword("Password: ")
+		accepted := err == nil && s.passwordAccepted(target.Username, password)
+
+		if err == nil {
+			s.Emit(&EscalationEvent{
+				Command:  "su",
+				Username: s.User.Username,
+				Target:   target.Username,
+				Password: password,
+				Accepted: accepted,
+			})
+		}
+
+		if !accepted {
+			s.ErrWrite("su: Authentication failure\n")
+			s.SetStatus(1)
+			return
+		}
+	}
+
+	if command := opts.Get("c", "command"); command != "" {
+		s.runAs(target, func() {
+			s.Run(command)
+		})
+		return
+	}
+
+	s.SwitchUser(target, login || opts.Has("l", "login"))
+}
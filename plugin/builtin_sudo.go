@@ -0,0 +1,199 @@
+package plugin
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+// sudoTimeout is how long sudo remembers that the password was right, like the
+// timestamp_timeout of sudoers.
+const sudoTimeout = 15 * time.Minute
+
+const sudoUsage = `usage: sudo -h | -K | -k | -V
+usage: sudo -v [-ABkNnS] [-g group] [-h host] [-p prompt] [-u user]
+usage: sudo -l [-ABkNnS] [-g group] [-h host] [-p prompt] [-U user] [-u user] [command]
+usage: sudo [-ABbEHkNnPS] [-r role] [-t type] [-C num] [-D directory] [-g group] [-h host] [-p prompt] [-R directory] [-T timeout] [-u user] [VAR=value] [-i|-s] [<command>]
+usage: sudo -e [-ABkNnS] [-r role] [-t type] [-C num] [-D directory] [-g group] [-h host] [-p prompt] [-R directory] [-T timeout] [-u user] file ...
+`
+
+// shells are the commands that sudo treats like `sudo -s` when they're run
+// without arguments.
+var shells = []string{"sh", "bash", "dash", "zsh", "/bin/sh", "/bin/bash", "/usr/bin/bash", "/bin/dash", "/usr/bin/zsh"}
+
+// passwordAccepted decides whether a password is right for a user. The
+// password that the attacker logged in with is right for the user they logged
+// in as, and for everyone else it's up to the same plugins that decide about
+// logins.
+func (s *Session) passwordAccepted(username, password string) bool {
+	if login := s.VFS.login(); s.Password != "" && password == s.Password && login != nil && login.Username == username {
+		return true
+	} else if s.Manager == nil {
+		return false
+	}
+
+	ip := net.ParseIP(s.IP)
+
+	return s.Manager.CheckPassword(username, password, &ip)
+}
+
+// runAs runs a function as another user, like `sudo` runs a single command.
+// If the function starts a shell (e.g. `sudo su`), the session stays that user
+// until the shell exits.
+func (s *Session) runAs(user *User, fn func()) {
+	prevUser, prevVFSUser, prevLogin := s.User, s.VFS.User, s.VFS.Login
+	depth := len(s.shells)
+
+	if s.VFS.Login == nil {
+		s.VFS.Login = s.VFS.User
+	}
+
+	s.User, s.VFS.User = user, user
+	fn()
+
+	if len(s.shells) > depth {
+		s.shells[depth].user = prevUser
+		s.shells[depth].vfsUser = prevVFSUser
+		s.shells[depth].vfsLogin = prevLogin
+		return
+	}
+
+	s.User, s.VFS.User, s.VFS.Login = prevUser, prevVFSUser, prevLogin
+}
+
+// sudoCommand emulates sudo, with a policy that lets everyone do everything,
+// as long as they know their password.
+func sudoCommand(args *CmdArgs, s *Session) {
+	opts, err := GetOpt(args.Array(), "+hVlkKvnSisEHbu:g:p:", []string{
+		"help", "version", "list", "reset-timestamp", "remove-timestamp", "validate",
+		"non-interactive", "stdin", "login", "shell", "preserve-env", "set-home",
+		"background", "user=", "group=", "prompt=",
+	})
+
+	if err != nil {
+		s.ErrWrite("sudo: ", err.Error(), "\n", sudoUsage)
+		s.SetStatus(1)
+		return
+	}
+
+	if opts.Has("h", "help") {
+		s.TermWrite("sudo - execute a command as another user\n\n", sudoUsage)
+		return
+	} else if opts.Has("V", "version") {
+		s.TermWrite("Sudo version 1.9.9\nSudoers policy plugin version 1.9.9\nSudoers file grammar version 48\nSudoers I/O plugin version 1.9.9\nSudoers audit plugin version 1.9.9\n")
+		return
+	} else if opts.Has("k", "reset-timestamp", "K", "remove-timestamp") {
+		s.sudoUntil = time.Time{}
+
+		if len(opts.Args) == 0 && !opts.Has("v", "validate", "l", "list", "i", "login", "s", "shell") {
+			return
+		}
+	}
+
+	shell := opts.Has("i", "login", "s", "shell")
+
+	if len(opts.Args) == 0 && !shell && !opts.Has("l", "list", "v", "validate") {
+		s.ErrWrite(sudoUsage)
+		s.SetStatus(1)
+		return
+	}
+
+	name := "root"
+
+	if u := opts.Get("u", "user"); u != "" {
+		name = u
+	}
+
+	target, err := s.VFS.LookupUser(name)
+
+	if err != nil && name == "root" {
+		target = s.VFS.SessionUser("root")
+	} else if err != nil {
+		s.ErrWrite(fmt.Sprintf("sudo: unknown user %s\nsudo: error initializing audit plugin sudoers_audit\n", name))
+		s.SetStatus(1)
+		return
+	}
+
+	if !s.sudoAuthenticate(opts, target) {
+		s.SetStatus(1)
+		return
+	}
+
+	switch {
+	case opts.Has("v", "validate"):
+		return
+	case opts.Has("l", "list"):
+		hostname := s.VFS.Hostname()
+		s.TermWrite(fmt.Sprintf("Matching Defaults entries for %s on %s:\n", s.User.Username, hostname))
+		s.TermWrite("    env_reset, mail_badpass, secure_path=/usr/local/sbin\\:/usr/local/bin\\:/usr/sbin\\:/usr/bin\\:/sbin\\:/bin\\:/snap/bin, use_pty\n\n")
+		s.TermWrite(fmt.Sprintf("User %s may run the following commands on %s:\n    (ALL : ALL) ALL\n", s.User.Username, hostname))
+	case len(opts.Args) == 0 || (len(opts.Args) == 1 && slices.Contains(shells, opts.Args[0])):
+		s.SwitchUser(target, opts.Has("i", "login"))
+	default:
+		argv := opts.Args
+		s.runAs(target, func() {
+			s.runCommand(argv)
+		})
+	}
+}
+
+// sudoAuthenticate asks for the password of the user, unless they're root or
+// gave it in the last few minutes. Like sudo, it gives them three tries.
+func (s *Session) sudoAuthenticate(opts *Opts, target *User) bool {
+	if s.User.IsRoot() || time.Now().Before(s.sudoUntil) {
+		return true
+	} else if opts.Has("n", "non-interactive") {
+		s.ErrWrite("sudo: a password is required\n")
+		return false
+	}
+
+	prompt := fmt.Sprintf("[sudo] password for %s: ", s.User.Username)
+
+	if p := opts.Get("p", "prompt"); p != "" {
+		prompt = strings.NewReplacer("%p", s.User.Username, "%u", s.User.Username, "%U", target.Username, "%h", s.VFS.Hostname(), "%%", "%").Replace(p)
+	}
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		var password string
+		var err error
+
+		if opts.Has("S", "stdin") {
+			s.ErrWrite(prompt)
+			password, err = s.readStdinLine()
+		} else {
+			password, err = s.readPassword(prompt)
+		}
+
+		if err != nil {
+			if attempt == 1 {
+				s.ErrWrite("sudo: no password was provided\n")
+			} else {
+				s.ErrWrite(fmt.Sprintf("sudo: %d incorrect password attempt%s\n", attempt-1, map[bool]string{true: "s"}[attempt > 2]))
+			}
+
+			return false
+		}
+
+		accepted := s.passwordAccepted(s.User.Username, password)
+		s.Emit(&EscalationEvent{
+			Command:  "sudo",
+			Username: s.User.Username,
+			Target:   target.Username,
+			Password: password,
+			Accepted: accepted,
+		})
+
+		if accepted {
+			s.sudoUntil = time.Now().Add(sudoTimeout)
+			return true
+		} else if attempt < 3 {
+			s.ErrWrite("Sorry, try again.\n")
+		}
+	}
+
+	s.ErrWrite("sudo: 3 incorrect password attempts\n")
+
+	return false
+}
@@ -0,0 +1,567 @@
+package plugin
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// unitDirs are the directories that systemd loads the units of the system
+// from, in order.
+var unitDirs = []string{"/etc/systemd/system", "/run/systemd/system", "/lib/systemd/system", "/usr/lib/systemd/system"}
+
+// userUnitDirs are the same for the units of a user (with `--user`), after
+// the ones in their home directory.
+var userUnitDirs = []string{"/etc/systemd/user", "/usr/lib/systemd/user"}
+
+// unitTypes are the suffixes of the names of units. A name without any of them
+// is a service.
+var unitTypes = []string{".service", ".socket", ".timer", ".target", ".path", ".mount"}
+
+// systemdUnit is a unit of systemd, from its file in the VFS or, for the
+// services of the machine that don't have one, from their process.
+type systemdUnit struct {
+	name     string
+	path     string
+	contents string
+	process  *Process
+}
+
+// values returns the values of a setting of the unit (e.g. "WantedBy").
+func (u *systemdUnit) values(key string) []string {
+	values := []string{}
+
+	for _, line := range strings.Split(u.contents, "\n") {
+		if k, v, ok := strings.Cut(strings.TrimSpace(line), "="); ok && strings.TrimSpace(k) == key {
+			values = append(values, strings.TrimSpace(v))
+		}
+	}
+
+	return values
+}
+
+// description returns the description of the unit, or its name if it doesn't
+// have one.
+func (u *systemdUnit) description() string {
+	if values := u.values("Description"); len(values) > 0 {
+		return values[0]
+	}
+
+	return u.name
+}
+
+// execStart returns the command line that starts the service, without the
+// prefixes that change how it's run (e.g. "-" to ignore its failure).
+func (u *systemdUnit) execStart() []string {
+	values := u.values("ExecStart")
+
+	if len(values) == 0 {
+		return nil
+	}
+
+	return strings.Fields(strings.TrimLeft(values[0], "-@:+!"))
+}
+
+// systemctlCommand emulates systemctl, with the units in the VFS. Services
+// that are started run their program in the background, and enabling a unit
+// links it into the `.wants` directory of its target and is reported as
+// persistence. Only root can change the units of the system.
+func systemctlCommand(args *CmdArgs, s *Session) {
+	opts, err := GetOpt(args.Array(), "hqlat:", []string{"help", "version", "user", "system", "now", "quiet", "no-pager", "no-block", "full", "all", "type=", "force", "no-legend"})
+
+	if err != nil {
+		s.ErrWrite("systemctl: ", err.Error(), "\n")
+		s.SetStatus(1)
+		return
+	}
+
+	if opts.Has("h", "help") {
+		s.TermWrite("systemctl [OPTIONS...] COMMAND ...\n\nQuery or send control commands to the system manager.\n")
+		return
+	} else if opts.Has("version") {
+		s.TermWrite("systemd 249 (249.11-0ubuntu3.12)\n+PAM +AUDIT +SELINUX +APPARMOR +IMA +SMACK +SECCOMP +GCRYPT +GNUTLS +OPENSSL +ACL +BLKID +CURL +ELFUTILS +FIDO2 +IDN2 -IDN +IPTC +KMOD +LIBCRYPTSETUP +LIBFDISK +PCRE2 -PWQUALITY -P11KIT -QRENCODE +BZIP2 +LZ4 +XZ +ZLIB +ZSTD -XKBCOMMON +UTMP +SYSVINIT default-hierarchy=unified\n")
+		return
+	}
+
+	verb, units := "list-units", []string{}
+
+	if len(opts.Args) > 0 {
+		verb, units = opts.Args[0], opts.Args[1:]
+	}
+
+	user := opts.Has("user")
+	changes := []string{"start", "stop", "restart", "reload", "try-restart", "reload-or-restart", "enable", "disable", "reenable", "mask", "unmask", "daemon-reload", "daemon-reexec"}
+
+	if slices.Contains(changes, verb) && !user && !s.VFS.isRoot() {
+		what, hint := verb+" unit", ""
+
+		switch {
+		case strings.HasPrefix(verb, "daemon-"):
+			what = "reload daemon"
+		case len(units) > 0 && !strings.HasSuffix(verb, "able") && !strings.HasSuffix(verb, "mask"):
+			what = verb + " " + unitName(units[0])
+			hint = "See system logs and 'systemctl status " + unitName(units[0]) + "' for details.\n"
+		}
+
+		s.ErrWrite("Failed to ", what, ": Interactive authentication required.\n", hint)
+		s.SetStatus(1)
+		return
+	}
+
+	switch verb {
+	case "list-units":
+		s.listUnits(user)
+		return
+	case "list-unit-files":
+		s.listUnitFiles(user)
+		return
+	case "daemon-reload", "daemon-reexec":
+		return
+	}
+
+	if len(units) == 0 {
+		s.ErrWrite("Too few arguments.\n")
+		s.SetStatus(1)
+		return
+	}
+
+	for _, name := range units {
+		unit, found := s.findUnit(unitName(name), user)
+
+		switch verb {
+		case "start", "restart", "reload", "try-restart", "reload-or-restart":
+			if !found {
+				s.ErrWrite("Failed to ", verb, " ", unit.name, ": Unit ", unit.name, " not found.\n")
+				s.SetStatus(5)
+			} else if !s.startUnit(unit) {
+				s.ErrWrite("Job for ", unit.name, " failed because the control process exited with error code.\nSee \"systemctl status ", unit.name, "\" and \"journalctl -xeu ", unit.name, "\" for details.\n")
+				s.SetStatus(1)
+			}
+		case "stop":
+			if !found {
+				s.ErrWrite("Failed to stop ", unit.name, ": Unit ", unit.name, " not loaded.\n")
+				s.SetStatus(5)
+			} else if unit.process != nil && unit.contents != "" {
+				s.VFS.UnmountProcess(unit.process.PID)
+			}
+		case "enable", "reenable":
+			if !found {
+				s.ErrWrite("Failed to enable unit: Unit file ", unit.name, " does not exist.\n")
+				s.SetStatus(1)
+				continue
+			}
+
+			s.enableUnit(unit, user)
+
+			if opts.Has("now") && !s.startUnit(unit) {
+				s.SetStatus(1)
+			}
+		case "disable":
+			if !found {
+				s.ErrWrite("Failed to disable unit: Unit file ", unit.name, " does not exist.\n")
+				s.SetStatus(1)
+				continue
+			}
+
+			for _, link := range s.unitLinks(unit, user) {
+				s.VFS.RemoveAll(link)
+				s.ErrWrite("Removed ", link, ".\n")
+			}
+
+			if opts.Has("now") && unit.process != nil && unit.contents != "" {
+				s.VFS.UnmountProcess(unit.process.PID)
+			}
+		case "status":
+			if !found {
+				s.ErrWrite("Unit ", unit.name, " could not be found.\n")
+				s.SetStatus(4)
+				continue
+			}
+
+			s.unitStatus(unit, user)
+
+			if unit.process == nil {
+				s.SetStatus(3)
+			}
+		case "is-active":
+			if unit.process != nil {
+				s.TermWrite("active\n")
+			} else {
+				s.TermWrite("inactive\n")
+				s.SetStatus(3)
+			}
+		case "is-enabled":
+			if !found {
+				s.ErrWrite("Failed to get unit file state for ", unit.name, ": No such file or directory\n")
+				s.SetStatus(1)
+				continue
+			}
+
+			state := s.unitState(unit, user)
+			s.TermWrite(state, "\n")
+
+			if state == "disabled" {
+				s.SetStatus(1)
+			}
+		case "mask", "unmask":
+		default:
+			s.ErrWrite("Unknown command verb ", verb, ".\n")
+			s.SetStatus(1)
+			return
+		}
+	}
+}
+
+// systemctlVerbs are the verbs that are completed after `systemctl`.
+var systemctlVerbs = []string{"daemon-reload", "disable", "enable", "is-active", "is-enabled", "list-unit-files", "list-units", "mask", "reenable", "reload", "restart", "start", "status", "stop", "try-restart", "unmask"}
+
+// systemctlComplete completes the verb of `systemctl`, and then the units.
+func systemctlComplete(s *Session, args []string) []string {
+	words := slices.DeleteFunc(slices.Clone(args[1:len(args)-1]), func(arg string) bool {
+		return strings.HasPrefix(arg, "-")
+	})
+
+	if len(words) == 0 {
+		return systemctlVerbs
+	}
+
+	names := []string{}
+
+	for _, unit := range s.unitFiles(slices.Contains(args, "--user")) {
+		names = append(names, unit.name)
+	}
+
+	return names
+}
+
+// unitName adds ".service" to the name of a unit that doesn't have a type.
+func unitName(name string) string {
+	if slices.Contains(unitTypes, filepath.Ext(name)) {
+		return name
+	}
+
+	return name + ".service"
+}
+
+// unitDirs returns the directories of the units of the system, or of the user.
+func (s *Session) unitDirs(user bool) []string {
+	if user {
+		return slices.Concat([]string{s.home() + "/.config/systemd/user"}, userUnitDirs)
+	}
+
+	return unitDirs
+}
+
+// findUnit finds a unit by its file. The services of the machine, which are
+// running without a file in the VFS, are found by their process instead.
+func (s *Session) findUnit(name string, user bool) (*systemdUnit, bool) {
+	unit := &systemdUnit{name: name}
+
+	for _, dir := range s.unitDirs(user) {
+		path := dir + "/" + name
+
+		if _, file, err := s.VFS.FindFile(path); err == nil && file.Type != T_DIR {
+			unit.path, unit.contents = path, s.VFS.FileContents(file)
+
+			if argv := unit.execStart(); len(argv) > 0 {
+				unit.process = s.findProcess(func(p *Process) bool {
+					return p.Exe == argv[0]
+				})
+			}
+
+			return unit, true
+		}
+	}
+
+	if user || filepath.Ext(name) != ".service" {
+		return unit, false
+	}
+
+	base := strings.TrimSuffix(name, ".service")
+	unit.process = s.findProcess(func(p *Process) bool {
+		return p.PPID == 1 && (p.Name == truncateComm(base) || p.Name == truncateComm(base+"d"))
+	})
+	unit.path = "/lib/systemd/system/" + name
+
+	if s.VFS.DistroID() == "centos" {
+		unit.path = "/usr/lib/systemd/system/" + name
+	}
+
+	return unit, unit.process != nil
+}
+
+// findProcess returns the first process that matches.
+func (s *Session) findProcess(match func(*Process) bool) *Process {
+	for _, p := range s.VFS.Processes() {
+		if match(p) {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// startUnit runs the program of a service in the background, unless it's
+// already running. It returns false if the program isn't there.
+func (s *Session) startUnit(unit *systemdUnit) bool {
+	argv := unit.execStart()
+
+	if unit.process != nil || len(argv) == 0 {
+		return true
+	}
+
+	if _, file, err := s.VFS.FindFile(argv[0]); err != nil || file.Type == T_DIR {
+		return false
+	}
+
+	s.logExecution(argv[0], argv, true)
+	s.daemonize(argv, argv[0])
+	unit.process = s.findProcess(func(p *Process) bool {
+		return p.Exe == argv[0]
+	})
+
+	return true
+}
+
+// wantsDir returns the directory that the links of the units that are
+// enabled are in.
+func (s *Session) wantsDir(user bool) string {
+	if user {
+		return s.home() + "/.config/systemd/user"
+	}
+
+	return "/etc/systemd/system"
+}
+
+// unitLinks returns the links to a unit in the `.wants` directories of the
+// targets that it's enabled for.
+func (s *Session) unitLinks(unit *systemdUnit, user bool) []string {
+	links := []string{}
+	dir := s.wantsDir(user)
+	_, file, err := s.VFS.FindFile(dir)
+
+	if err != nil || file.Type != T_DIR {
+		return links
+	}
+
+	names := []string{}
+
+	for name, f := range file.Files {
+		if f.Type == T_DIR && strings.HasSuffix(name, ".wants") {
+			names = append(names, name)
+		}
+	}
+
+	slices.Sort(names)
+
+	for _, name := range names {
+		link := dir + "/" + name + "/" + unit.name
+
+		if _, _, err := s.VFS.LFindFile(link); err == nil {
+			links = append(links, link)
+		}
+	}
+
+	return links
+}
+
+// unitState returns whether a unit is enabled, like `systemctl is-enabled`.
+// Units that can't be enabled are static.
+func (s *Session) unitState(unit *systemdUnit, user bool) string {
+	switch {
+	case unit.contents == "" && unit.process != nil:
+		return "enabled"
+	case len(s.unitLinks(unit, user)) > 0:
+		return "enabled"
+	case len(unit.values("WantedBy")) == 0 && len(unit.values("RequiredBy")) == 0:
+		return "static"
+	}
+
+	return "disabled"
+}
+
+// enableUnit links a unit into the `.wants` directories of the targets that
+// want it, and reports it as persistence.
+func (s *Session) enableUnit(unit *systemdUnit, user bool) {
+	targets := slices.Concat(unit.values("WantedBy"), unit.values("RequiredBy"))
+
+	if unit.contents == "" {
+		return
+	} else if len(targets) == 0 {
+		s.ErrWrite("The unit files have no installation config (WantedBy=, RequiredBy=, Also=,\nAlias= settings in the [Install] section, and DefaultInstance= for template\nunits). This means they are not meant to be enabled using systemctl.\n")
+		return
+	}
+
+	for _, target := range targets {
+		for _, t := range strings.Fields(target) {
+			dir := s.wantsDir(user) + "/" + t + ".wants"
+			link := dir + "/" + unit.name
+
+			if _, _, err := s.VFS.LFindFile(link); err == nil {
+				continue
+			}
+
+			s.VFS.MkdirAll(dir, 0755)
+
+			if err := s.VFS.Symlink(unit.path, link); err == nil {
+				s.ErrWrite("Created symlink ", link, " → ", unit.path, ".\n")
+			}
+		}
+	}
+
+	s.Emit(&PersistenceEvent{
+		Command:   "systemctl",
+		Mechanism: "systemd",
+		Path:      unit.path,
+		Data:      []byte(unit.contents),
+	})
+}
+
+// unitStatus shows the state of a unit, like `systemctl status`.
+func (s *Session) unitStatus(unit *systemdUnit, user bool) {
+	dot, active := "○", "inactive (dead)"
+
+	if p := unit.process; p != nil {
+		dot = "●"
+		active = fmt.Sprintf("active (running) since %s; %s ago", p.Started.Format("Mon 2006-01-02 15:04:05 MST"), systemdTimespan(time.Since(p.Started)))
+	}
+
+	s.TermWrite(fmt.Sprintf("%s %s - %s\n", dot, unit.name, unit.description()))
+	s.TermWrite(fmt.Sprintf("     Loaded: loaded (%s; %s; vendor preset: enabled)\n", unit.path, s.unitState(unit, user)))
+	s.TermWrite("     Active: ", active, "\n")
+
+	if p := unit.process; p != nil {
+		slice := "system.slice"
+
+		if user {
+			slice = "user.slice"
+		}
+
+		s.TermWrite(fmt.Sprintf("   Main PID: %d (%s)\n      Tasks: 1 (limit: 4557)\n     Memory: %.1fM\n        CPU: %s\n     CGroup: /%s/%s\n             └─%d %s\n", p.PID, p.Name, float64(p.RSS)/1024, systemdTimespan(p.CPUTime()), slice, unit.name, p.PID, p.Command()))
+	}
+}
+
+// unitFiles returns the units that have a file, by their name.
+func (s *Session) unitFiles(user bool) []*systemdUnit {
+	names := []string{}
+
+	for _, dir := range s.unitDirs(user) {
+		if _, file, err := s.VFS.FindFile(dir); err == nil && file.Type == T_DIR {
+			for name, f := range file.Files {
+				if f.Type != T_DIR && slices.Contains(unitTypes, filepath.Ext(name)) {
+					names = append(names, name)
+				}
+			}
+		}
+	}
+
+	slices.Sort(names)
+	units := []*systemdUnit{}
+
+	for _, name := range slices.Compact(names) {
+		unit, _ := s.findUnit(name, user)
+		units = append(units, unit)
+	}
+
+	return units
+}
+
+// listUnits lists the services that are running, like `systemctl list-units`.
+// Programs that were left running during the session aren't services, unless
+// a unit started them.
+func (s *Session) listUnits(user bool) {
+	units := []*systemdUnit{}
+	seen := map[string]bool{}
+	since := time.Now()
+
+	for _, unit := range s.unitFiles(user) {
+		if unit.process != nil {
+			units = append(units, unit)
+			seen[unit.name] = true
+		}
+	}
+
+	if shell := s.VFS.FindProcess(s.PID); shell != nil {
+		since = shell.Started
+	}
+
+	for _, p := range s.VFS.Processes() {
+		name := p.Name
+
+		if p.Exe != "" {
+			name = filepath.Base(p.Exe)
+		}
+
+		if user || p.PPID != 1 || !p.Started.Before(since) || seen[unitName(name)] {
+			continue
+		}
+
+		unit, _ := s.findUnit(unitName(name), false)
+		unit.process = p
+		units = append(units, unit)
+		seen[unit.name] = true
+	}
+
+	slices.SortFunc(units, func(a, b *systemdUnit) int {
+		return strings.Compare(a.name, b.name)
+	})
+
+	width := len("UNIT")
+
+	for _, unit := range units {
+		width = max(width, len(unit.name))
+	}
+
+	s.TermWrite(fmt.Sprintf("  %-*s LOAD   ACTIVE SUB     DESCRIPTION\n", width, "UNIT"))
+
+	for _, unit := range units {
+		s.TermWrite(fmt.Sprintf("  %-*s loaded active running %s\n", width, unit.name, unit.description()))
+	}
+
+	s.TermWrite("\nLOAD   = Reflects whether the unit definition was properly loaded.\nACTIVE = The high-level unit activation state, i.e. generalization of SUB.\nSUB    = The low-level unit activation state, values depend on unit type.\n")
+	s.TermWrite(fmt.Sprintf("%d loaded units listed.\n", len(units)))
+}
+
+// listUnitFiles lists the units that have a file, like `systemctl
+// list-unit-files`.
+func (s *Session) listUnitFiles(user bool) {
+	units := s.unitFiles(user)
+	width := len("UNIT FILE")
+
+	for _, unit := range units {
+		width = max(width, len(unit.name))
+	}
+
+	s.TermWrite(fmt.Sprintf("%-*s STATE    VENDOR PRESET\n", width, "UNIT FILE"))
+
+	for _, unit := range units {
+		s.TermWrite(fmt.Sprintf("%-*s %-8s enabled\n", width, unit.name, s.unitState(unit, user)))
+	}
+
+	s.TermWrite(fmt.Sprintf("\n%d unit files listed.\n", len(units)))
+}
+
+// systemdTimespan formats how long ago something happened, like systemd does
+// (e.g. "2h 5min").
+func systemdTimespan(d time.Duration) string {
+	switch {
+	case d < time.Second:
+		return fmt.Sprintf("%dms", d.Milliseconds())
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dmin %ds", int(d.Minutes()), int(d.Seconds())%60)
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh %dmin", int(d.Hours()), int(d.Minutes())%60)
+	}
+
+	days := int(d.Hours()) / 24
+
+	if days == 1 {
+		return fmt.Sprintf("1 day %dh", int(d.Hours())%24)
+	}
+
+	return fmt.Sprintf("%d days %dh", days, int(d.Hours())%24)
+}
@@ -0,0 +1,208 @@
+package plugin
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+// testUnary are the unary operators of test, which check files and strings.
+var testUnary = []string{"-e", "-f", "-d", "-r", "-w", "-x", "-s", "-L", "-h", "-z", "-n", "-t"}
+
+// testBinary are the binary operators of test, which compare strings and
+// integers.
+var testBinary = []string{"=", "==", "!=", "<", ">", "-eq", "-ne", "-lt", "-le", "-gt", "-ge"}
+
+// testCommand is the `test` (and `[`) builtin of bash, which checks files and
+// compares strings and numbers. It succeeds if its expression is true, and
+// returns 2 if the expression is wrong.
+func testCommand(args *CmdArgs, s *Session) {
+	argv := args.Array()
+
+	if s.command == "[" {
+		if len(argv) == 0 || argv[len(argv)-1] != "]" {
+			s.ErrWrite("bash: [: missing `]'\n")
+			s.SetStatus(2)
+			return
+		}
+
+		argv = argv[:len(argv)-1]
+	}
+
+	t := &testParser{s: s, args: argv}
+	result, err := t.parseOr()
+
+	if err == nil && t.pos < len(t.args) {
+		err = fmt.Errorf("too many arguments")
+	}
+
+	if err != nil {
+		s.ErrWrite("bash: ", s.command, ": ", err.Error(), "\n")
+		s.SetStatus(2)
+	} else if !result {
+		s.SetStatus(1)
+	}
+}
+
+// testParser evaluates the expression of test, where `!` negates, `-a` and
+// `-o` are "and" and "or", and parentheses group.
+type testParser struct {
+	s    *Session
+	args []string
+	pos  int
+}
+
+func (t *testParser) peek(n int) string {
+	if t.pos+n < len(t.args) {
+		return t.args[t.pos+n]
+	}
+
+	return ""
+}
+
+func (t *testParser) parseOr() (bool, error) {
+	result, err := t.parseAnd()
+
+	for err == nil && t.peek(0) == "-o" && t.pos+1 < len(t.args) {
+		t.pos++
+		var right bool
+		right, err = t.parseAnd()
+		result = result || right
+	}
+
+	return result, err
+}
+
+func (t *testParser) parseAnd() (bool, error) {
+	result, err := t.parseNot()
+
+	for err == nil && t.peek(0) == "-a" && t.pos+1 < len(t.args) {
+		t.pos++
+		var right bool
+		right, err = t.parseNot()
+		result = result && right
+	}
+
+	return result, err
+}
+
+func (t *testParser) parseNot() (bool, error) {
+	// A lone `!` is just a string that isn't empty.
+	if t.peek(0) == "!" && t.pos+1 < len(t.args) {
+		t.pos++
+		result, err := t.parseNot()
+		return !result, err
+	}
+
+	return t.parsePrimary()
+}
+
+func (t *testParser) parsePrimary() (bool, error) {
+	arg := t.peek(0)
+
+	switch {
+	case t.pos >= len(t.args):
+		// An empty expression is false.
+		return false, nil
+	case slices.Contains(testBinary, t.peek(1)) && t.pos+2 < len(t.args):
+		t.pos += 3
+		return t.s.testBinary(arg, t.args[t.pos-2], t.args[t.pos-1])
+	case arg == "(" && t.pos+1 < len(t.args):
+		t.pos++
+		result, err := t.parseOr()
+
+		if err == nil && t.peek(0) != ")" {
+			return false, fmt.Errorf("`)' expected")
+		}
+
+		t.pos++
+
+		return result, err
+	case slices.Contains(testUnary, arg) && t.pos+1 < len(t.args):
+		t.pos += 2
+		return t.s.testUnary(arg, t.args[t.pos-1]), nil
+	}
+
+	t.pos++
+
+	return arg != "", nil
+}
+
+// testUnary checks a file or a string.
+func (s *Session) testUnary(op, arg string) bool {
+	switch op {
+	case "-z":
+		return arg == ""
+	case "-n":
+		return arg != ""
+	case "-t":
+		return s.tty != "" && ((arg == "0" && s.stdin == nil) || (arg == "1" && s.isTerminal(s.stdoutWriter())) || (arg == "2" && s.isTerminal(s.stderrWriter())))
+	case "-L", "-h":
+		_, file, err := s.VFS.LFindFile(arg)
+		return err == nil && file.Type == T_SYMLINK
+	}
+
+	_, file, err := s.VFS.FindFile(arg)
+
+	if err != nil {
+		return false
+	}
+
+	switch op {
+	case "-f":
+		return file.Type == T_FILE
+	case "-d":
+		return file.Type == T_DIR
+	case "-r":
+		return s.VFS.access(file).Read
+	case "-w":
+		return s.VFS.access(file).Write
+	case "-x":
+		return s.VFS.access(file).Exec
+	case "-s":
+		return file.Type == T_DIR || s.VFS.FileContents(file) != ""
+	}
+
+	return true
+}
+
+// testBinary compares two strings or integers.
+func (s *Session) testBinary(a, op, b string) (bool, error) {
+	switch op {
+	case "=", "==":
+		return a == b, nil
+	case "!=":
+		return a != b, nil
+	case "<":
+		return a < b, nil
+	case ">":
+		return a > b, nil
+	}
+
+	x, err := strconv.Atoi(a)
+
+	if err != nil {
+		return false, fmt.Errorf("%s: integer expression expected", a)
+	}
+
+	y, err := strconv.Atoi(b)
+
+	if err != nil {
+		return false, fmt.Errorf("%s: integer expression expected", b)
+	}
+
+	switch op {
+	case "-eq":
+		return x == y, nil
+	case "-ne":
+		return x != y, nil
+	case "-lt":
+		return x < y, nil
+	case "-le":
+		return x <= y, nil
+	case "-gt":
+		return x > y, nil
+	}
+
+	return x >= y, nil
+}
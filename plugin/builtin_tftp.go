@@ -0,0 +1,200 @@
+package plugin
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strings"
+)
+
+const tftpUsage = `BusyBox v1.30.1 (Ubuntu 1:1.30.1-7ubuntu3) multi-call binary.
+
+Usage: tftp [OPTIONS] HOST [PORT]
+
+Transfer a file from/to tftp server
+
+	-l FILE	Local FILE
+	-r FILE	Remote FILE
+	-g	Get file
+	-p	Put file
+	-b SIZE	Transfer blocks of SIZE octets
+`
+
+const ftpgetUsage = `BusyBox v1.30.1 (Ubuntu 1:1.30.1-7ubuntu3) multi-call binary.
+
+Usage: ftpget [OPTIONS] HOST [LOCAL_FILE] REMOTE_FILE
+
+Download a file via FTP
+
+	-c	Continue previous transfer
+	-v	Verbose
+	-u USER	Username
+	-p PASS	Password
+	-P NUM	Port
+`
+
+// tftpCommand emulates both the busybox tftp client (`tftp -g -r file host`)
+// and tftp-hpa (`tftp host -c get file`), which are the two forms that show up
+// in bot scripts.
+func tftpCommand(args *CmdArgs, s *Session) {
+	argv := args.Array()
+	hpaCommand := []string{}
+
+	for i, arg := range argv {
+		if arg == "-c" {
+			hpaCommand = argv[i+1:]
+			argv = argv[:i]
+			break
+		}
+	}
+
+	opts, err := GetOpt(argv, "gpl:r:b:46vVm:R:", []string{"get", "put", "local=", "remote=", "blocksize="})
+
+	if err != nil {
+		s.ErrWrite("tftp: ", err.Error(), "\n", tftpUsage)
+		s.SetStatus(1)
+		return
+	}
+
+	if len(opts.Args) == 0 {
+		s.ErrWrite(tftpUsage)
+		s.SetStatus(1)
+		return
+	}
+
+	host := opts.Args[0]
+	port := "69"
+
+	if len(opts.Args) > 1 {
+		port = opts.Args[1]
+	}
+
+	remote := opts.Get("r", "remote")
+	local := opts.Get("l", "local")
+	isGet := opts.Has("g", "get")
+
+	if len(hpaCommand) > 0 {
+		if hpaCommand[0] != "get" || len(hpaCommand) < 2 {
+			// Uploads and any other commands don't do anything.
+			return
+		}
+
+		isGet = true
+		remote = hpaCommand[1]
+
+		if len(hpaCommand) > 2 {
+			local = hpaCommand[2]
+		}
+	}
+
+	if !isGet {
+		if opts.Has("p", "put") && local != "" {
+			if _, _, err := s.VFS.FindFile(local); err != nil {
+				s.ErrWrite(fmt.Sprintf("tftp: can't open '%s': No such file or directory\n", local))
+				s.SetStatus(1)
+			}
+		} else {
+			s.ErrWrite(tftpUsage)
+			s.SetStatus(1)
+		}
+
+		return
+	}
+
+	if remote == "" {
+		s.ErrWrite(tftpUsage)
+		s.SetStatus(1)
+		return
+	}
+
+	if local == "" {
+		local = path.Base(remote)
+	}
+
+	req := &DownloadRequest{
+		Command: "tftp",
+		Method:  "GET",
+		URL:     fmt.Sprintf("tftp://%s/%s", net.JoinHostPort(host, port), strings.TrimPrefix(remote, "/")),
+	}
+
+	data, fetched := fetchDownload(s, req)
+
+	if local == "-" {
+		emitDownload(s, req, "", data, fetched)
+		s.TermWrite(terminalPayload(data, fetched))
+		return
+	}
+
+	if err := saveDownload(s, req, local, data, fetched); err != nil {
+		s.ErrWrite(fmt.Sprintf("tftp: can't open '%s': %s\n", local, err))
+		s.SetStatus(1)
+	}
+}
+
+// ftpgetCommand emulates the busybox ftpget applet.
+func ftpgetCommand(args *CmdArgs, s *Session) {
+	opts, err := GetOpt(args.Array(), "cvu:p:P:", []string{"continue", "verbose", "username=", "password=", "port="})
+
+	if err != nil {
+		s.ErrWrite("ftpget: ", err.Error(), "\n", ftpgetUsage)
+		s.SetStatus(1)
+		return
+	}
+
+	if len(opts.Args) < 2 {
+		s.ErrWrite(ftpgetUsage)
+		s.SetStatus(1)
+		return
+	}
+
+	host := opts.Args[0]
+	local := opts.Args[1]
+	remote := local
+
+	if len(opts.Args) > 2 {
+		remote = opts.Args[2]
+	} else {
+		local = path.Base(remote)
+	}
+
+	port := opts.Get("P", "port")
+
+	if port == "" {
+		port = "21"
+	}
+
+	userInfo := ""
+
+	if user := opts.Get("u", "username"); user != "" {
+		userInfo = user
+
+		if pass := opts.Get("p", "password"); pass != "" {
+			userInfo += ":" + pass
+		}
+
+		userInfo += "@"
+	}
+
+	req := &DownloadRequest{
+		Command: "ftpget",
+		Method:  "RETR",
+		URL:     fmt.Sprintf("ftp://%s%s/%s", userInfo, net.JoinHostPort(host, port), strings.TrimPrefix(remote, "/")),
+	}
+
+	if opts.Has("v", "verbose") {
+		s.ErrWrite(fmt.Sprintf("Connecting to %s (%s)\n", host, net.JoinHostPort(fakeResolve(host), port)))
+	}
+
+	data, fetched := fetchDownload(s, req)
+
+	if local == "-" {
+		emitDownload(s, req, "", data, fetched)
+		s.TermWrite(terminalPayload(data, fetched))
+		return
+	}
+
+	if err := saveDownload(s, req, local, data, fetched); err != nil {
+		s.ErrWrite(fmt.Sprintf("ftpget: can't open '%s': %s\n", local, err))
+		s.SetStatus(1)
+	}
+}
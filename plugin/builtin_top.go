@@ -0,0 +1,190 @@
+package plugin
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// topCommand emulates top from procps-ng. In batch mode (-b) it prints the
+// table once for every iteration (-n), and otherwise it redraws the screen
+// every time a key is pressed, until it's `q`.
+func topCommand(args *CmdArgs, s *Session) {
+	opts, err := GetOpt(args.Array(), "bcd:hn:p:u:U:vw:Hio:sS", nil)
+
+	if err != nil {
+		s.ErrWrite("top: ", err.Error(), "\nUsage:\n  top -hv | -bcEeHiOSs1 -d secs -n max -u|U user -p pid(s) -o field -w [cols]\n")
+		s.SetStatus(1)
+		return
+	}
+
+	if opts.Has("h", "v") {
+		s.TermWrite("  procps-ng 3.3.17\nUsage:\n  top -hv | -bcEeHiOSs1 -d secs -n max -u|U user -p pid(s) -o field -w [cols]\n")
+		return
+	}
+
+	iterations := 1
+	delay := 3 * time.Second
+
+	if n := opts.Get("n"); n != "" {
+		if iterations, err = strconv.Atoi(n); err != nil || iterations < 1 {
+			s.ErrWrite("top: bad iterations argument '", n, "'\n")
+			s.SetStatus(1)
+			return
+		}
+	}
+
+	if d := opts.Get("d"); d != "" {
+		secs, err := strconv.ParseFloat(d, 64)
+
+		if err != nil || secs < 0 {
+			s.ErrWrite("top: bad delay interval '", d, "'\n")
+			s.SetStatus(1)
+			return
+		}
+
+		delay = time.Duration(secs * float64(time.Second))
+	}
+
+	filter := func(p *Process) bool {
+		if pids := opts.All("p"); len(pids) > 0 {
+			return slices.Contains(strings.Split(strings.Join(pids, ","), ","), strconv.Itoa(p.PID))
+		} else if user := opts.Get("u", "U"); user != "" {
+			return s.VFS.processUser(p) == user || strconv.Itoa(p.UID) == user
+		}
+
+		return true
+	}
+
+	if opts.Has("b") {
+		for i := 0; i < iterations; i++ {
+			if i > 0 {
+				time.Sleep(min(delay, 3*time.Second))
+				s.TermWrite("\n")
+			}
+
+			s.TermWrite(topScreen(s, filter, opts.Has("c"), 0))
+		}
+
+		return
+	}
+
+	for {
+		s.TermWrite("\x1b[H\x1b[2J", strings.ReplaceAll(topScreen(s, filter, opts.Has("c"), 24), "\n", "\r\n"))
+
+		if key, err := s.ReadKey(); err != nil || key == "q" || key == "\x03" {
+			break
+		}
+	}
+
+	s.TermWrite("\r\n")
+}
+
+// topScreen renders what top shows: a summary of the machine and its processes
+// ordered by how much CPU they use. With `rows`, only as many lines as fit on
+// the screen are rendered.
+func topScreen(s *Session, filter func(*Process) bool, full bool, rows int) string {
+	m := s.VFS.machine()
+	procs := []*Process{}
+	states := map[byte]int{}
+
+	for _, p := range s.VFS.Processes() {
+		states[p.Stat()[0]]++
+
+		if filter(p) {
+			procs = append(procs, p)
+		}
+	}
+
+	slices.SortStableFunc(procs, func(a, b *Process) int {
+		switch {
+		case a.CPU > b.CPU:
+			return -1
+		case a.CPU < b.CPU:
+			return 1
+		}
+
+		return 0
+	})
+
+	load := strings.Fields(procLoadavg(s.VFS))
+	mem := meminfo(s.VFS)
+	free, total := float64(mem["MemFree"])/1024, float64(mem["MemTotal"])/1024
+	cache := float64(mem["Buffers"]+mem["Cached"]+mem["SReclaimable"]) / 1024
+	swap := float64(mem["SwapTotal"]) / 1024
+	idle := 99.0 - float64(len(procs)%7)/10
+	out := strings.Builder{}
+
+	fmt.Fprintf(&out, "top - %s up %s,  1 user,  load average: %s, %s, %s\n", time.Now().Format("15:04:05"), formatUptime(s.VFS.uptime()), strings.TrimSuffix(load[0], ","), load[1], load[2])
+	fmt.Fprintf(&out, "Tasks: %3d total, %3d running, %3d sleeping, %3d stopped, %3d zombie\n", len(s.VFS.procs), states['R'], states['S']+states['I']+states['D'], states['T'], states['Z'])
+	fmt.Fprintf(&out, "%%Cpu(s): %4.1f us, %4.1f sy, %4.1f ni, %4.1f id, %4.1f wa, %4.1f hi, %4.1f si, %4.1f st\n", (100-idle)*0.6, (100-idle)*0.4, 0.0, idle, 0.0, 0.0, 0.0, 0.0)
+	fmt.Fprintf(&out, "MiB Mem : %8.1f total, %8.1f free, %8.1f used, %8.1f buff/cache\n", total, free, total-free-cache, cache)
+	fmt.Fprintf(&out, "MiB Swap: %8.1f total, %8.1f free, %8.1f used. %8.1f avail Mem \n\n", swap, swap, 0.0, float64(mem["MemAvailable"])/1024)
+	out.WriteString("    PID USER      PR  NI    VIRT    RES    SHR S  %CPU  %MEM     TIME+ COMMAND\n")
+
+	for i, p := range procs {
+		if rows > 0 && i >= rows-7 {
+			break
+		}
+
+		pr, ni := "20", 0
+
+		if strings.Contains(p.Stat(), "<") {
+			pr, ni = "0", -20
+		} else if strings.Contains(p.Stat(), "N") {
+			pr, ni = "39", 19
+		} else if strings.HasPrefix(p.Name, "migration/") {
+			pr = "rt"
+		}
+
+		command := p.Name
+
+		if full {
+			command = p.Command()
+		}
+
+		cpu := p.CPUTime()
+		fmt.Fprintf(&out, "%7d %-8s %3s %3d %7d %6d %6d %c %5.1f %5.1f %9s %s\n",
+			p.PID, psUser(s, p), pr, ni, p.VSZ, p.RSS, p.RSS*2/3, p.Stat()[0], p.CPU,
+			float64(p.RSS)*100/float64(max(m.MemTotal, 1)),
+			fmt.Sprintf("%d:%02d.%02d", int(cpu.Minutes()), int(cpu.Seconds())%60, int(cpu.Milliseconds()/10)%100),
+			command)
+	}
+
+	return out.String()
+}
+
+// meminfo returns the fields of /proc/meminfo, in kB.
+func meminfo(vfs *VFS) map[string]int {
+	fields := map[string]int{}
+
+	for _, line := range strings.Split(procMeminfo(vfs), "\n") {
+		if name, value, ok := strings.Cut(line, ":"); ok && len(strings.Fields(value)) > 0 {
+			fields[name], _ = strconv.Atoi(strings.Fields(value)[0])
+		}
+	}
+
+	return fields
+}
+
+// formatUptime formats how long the machine has been up like top and uptime
+// do, e.g. "12 days,  3:04" or "5 min".
+func formatUptime(d time.Duration) string {
+	days := int(d.Hours()) / 24
+	hours, mins := int(d.Hours())%24, int(d.Minutes())%60
+	out := ""
+
+	if days == 1 {
+		out = "1 day, "
+	} else if days > 1 {
+		out = fmt.Sprintf("%d days, ", days)
+	}
+
+	if hours > 0 {
+		return out + fmt.Sprintf("%2d:%02d", hours, mins)
+	}
+
+	return out + fmt.Sprintf("%d min", mins)
+}
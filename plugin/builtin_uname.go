@@ -0,0 +1,71 @@
+package plugin
+
+import "strings"
+
+const unameUsage = "Try 'uname --help' for more information.\n"
+
+// unameCommand emulates the uname command of coreutils, from the machine and
+// the persona of the VFS.
+func unameCommand(args *CmdArgs, s *Session) {
+	opts, err := GetOpt(args.Array(), "asnrvmpio", []string{
+		"all", "kernel-name", "nodename", "kernel-release", "kernel-version",
+		"machine", "processor", "hardware-platform", "operating-system", "help", "version",
+	})
+
+	if err != nil {
+		s.ErrWrite("uname: ", err.Error(), "\n", unameUsage)
+		s.SetStatus(1)
+		return
+	}
+
+	if opts.Has("help") {
+		s.TermWrite("Usage: uname [OPTION]...\n")
+		s.TermWrite("Print certain system information.  With no OPTION, same as -s.\n")
+		return
+	} else if opts.Has("version") {
+		s.TermWrite("uname (GNU coreutils) 8.32\n")
+		return
+	}
+
+	if len(opts.Args) > 0 {
+		s.ErrWrite("uname: extra operand ‘", opts.Args[0], "’\n", unameUsage)
+		s.SetStatus(1)
+		return
+	}
+
+	m := s.VFS.machine()
+	all := opts.Has("a", "all")
+	processor := "x86_64"
+
+	// Debian's coreutils doesn't know the processor, and -a leaves it out.
+	if s.VFS.DistroID() == "debian" {
+		processor = "unknown"
+	}
+
+	fields := []struct {
+		short, long string
+		value       string
+	}{
+		{"s", "kernel-name", "Linux"},
+		{"n", "nodename", s.VFS.Hostname()},
+		{"r", "kernel-release", m.Release},
+		{"v", "kernel-version", m.Version},
+		{"m", "machine", "x86_64"},
+		{"p", "processor", processor},
+		{"i", "hardware-platform", processor},
+		{"o", "operating-system", "GNU/Linux"},
+	}
+	output := []string{}
+
+	for _, field := range fields {
+		if opts.Has(field.short, field.long) || (all && field.value != "unknown") {
+			output = append(output, field.value)
+		}
+	}
+
+	if len(output) == 0 {
+		output = append(output, "Linux")
+	}
+
+	s.TermWrite(strings.Join(output, " "), "\n")
+}
@@ -0,0 +1,190 @@
+package plugin
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// exportCommand is the `export` builtin of bash, which puts variables in the
+// environment of the commands (and scripts) that the shell runs.
+func exportCommand(args *CmdArgs, s *Session) {
+	opts, err := GetOpt(args.Array(), "fnp", nil)
+
+	if err != nil {
+		s.ErrWrite("bash: export: ", err.Error(), "\nexport: usage: export [-fn] [name[=value] ...] or export -p\n")
+		s.SetStatus(2)
+		return
+	}
+
+	if len(opts.Args) == 0 || opts.Has("p") {
+		names := slices.Concat(exportedVars, slices.Collect(maps.Keys(s.exported)))
+		slices.Sort(names)
+
+		for _, name := range slices.Compact(names) {
+			if value, ok := s.GetVar(name); ok {
+				s.TermWrite(fmt.Sprintf("declare -x %s=%q\n", name, value))
+			} else {
+				s.TermWrite("declare -x ", name, "\n")
+			}
+		}
+
+		return
+	}
+
+	for _, arg := range opts.Args {
+		name, value, hasValue := strings.Cut(arg, "=")
+
+		if !isName(name) {
+			s.ErrWrite("bash: export: `", arg, "': not a valid identifier\n")
+			s.SetStatus(1)
+			continue
+		}
+
+		if hasValue {
+			if err := s.SetVar(name, value); err != nil {
+				s.ErrWrite("bash: ", err.Error(), "\n")
+				s.SetStatus(1)
+				continue
+			}
+		}
+
+		if opts.Has("n") {
+			delete(s.exported, name)
+			continue
+		}
+
+		if s.exported == nil {
+			s.exported = map[string]bool{}
+		}
+
+		s.exported[name] = true
+	}
+}
+
+// unsetCommand is the `unset` builtin of bash, which removes variables or
+// functions.
+func unsetCommand(args *CmdArgs, s *Session) {
+	opts, err := GetOpt(args.Array(), "fnv", nil)
+
+	if err != nil {
+		s.ErrWrite("bash: unset: ", err.Error(), "\nunset: usage: unset [-f] [-v] [-n] [name ...]\n")
+		s.SetStatus(2)
+		return
+	}
+
+	for _, name := range opts.Args {
+		// Without -v, a name that isn't a variable can still be a function.
+		if _, isVar := s.vars[name]; opts.Has("f") || (!opts.Has("v") && !isVar && s.functions[name] != nil) {
+			delete(s.functions, name)
+			continue
+		}
+
+		if !isName(name) {
+			s.ErrWrite("bash: unset: `", name, "': not a valid identifier\n")
+			s.SetStatus(1)
+		} else if err := s.unsetVar(name); err != nil {
+			s.ErrWrite("bash: unset: ", err.Error(), "\n")
+			s.SetStatus(1)
+		}
+	}
+}
+
+// localCommand is the `local` builtin of bash, which sets variables that go
+// back to what they were once the function returns.
+func localCommand(args *CmdArgs, s *Session) {
+	if len(s.locals) == 0 {
+		s.ErrWrite("bash: local: can only be used in a function\n")
+		s.SetStatus(1)
+		return
+	}
+
+	locals := s.locals[len(s.locals)-1]
+
+	for _, arg := range args.Array() {
+		name, value, _ := strings.Cut(arg, "=")
+
+		if !isName(name) {
+			s.ErrWrite("bash: local: `", arg, "': not a valid identifier\n")
+			s.SetStatus(1)
+			continue
+		}
+
+		if _, ok := locals[name]; !ok {
+			locals[name] = nil
+
+			if old, ok := s.vars[name]; ok {
+				locals[name] = &old
+			}
+		}
+
+		if err := s.SetVar(name, value); err != nil {
+			s.ErrWrite("bash: ", err.Error(), "\n")
+			s.SetStatus(1)
+		}
+	}
+}
+
+// setCommand is the `set` builtin of bash. Its options (like -e or -x) don't
+// change anything here, but it can replace the positional parameters, and
+// without any arguments it lists the variables.
+func setCommand(args *CmdArgs, s *Session) {
+	argv := args.Array()
+
+	if len(argv) == 0 {
+		names := slices.Concat(exportedVars, slices.Collect(maps.Keys(s.vars)))
+		slices.Sort(names)
+
+		for _, name := range slices.Compact(names) {
+			value, _ := s.GetVar(name)
+			s.TermWrite(name, "=", shellQuote(value), "\n")
+		}
+
+		return
+	}
+
+	for i := 0; i < len(argv); i++ {
+		switch arg := argv[i]; {
+		case arg == "--":
+			s.setPositional(argv[i+1:])
+			return
+		case arg == "-o" || arg == "+o":
+			i++
+		case len(arg) < 2 || (arg[0] != '-' && arg[0] != '+'):
+			s.setPositional(argv[i:])
+			return
+		}
+	}
+}
+
+// shiftCommand is the `shift` builtin of bash, which drops the first
+// positional parameters.
+func shiftCommand(args *CmdArgs, s *Session) {
+	argv := args.Array()
+	n := 1
+
+	if len(argv) > 0 {
+		var err error
+
+		if n, err = strconv.Atoi(argv[0]); err != nil {
+			s.ErrWrite("bash: shift: ", argv[0], ": numeric argument required\n")
+			s.SetStatus(1)
+			return
+		} else if n < 0 {
+			s.ErrWrite("bash: shift: ", argv[0], ": shift count out of range\n")
+			s.SetStatus(1)
+			return
+		}
+	}
+
+	params := s.positional()
+
+	if n > len(params) {
+		s.SetStatus(1)
+		return
+	}
+
+	s.setPositional(params[n:])
+}
@@ -0,0 +1,229 @@
+package plugin
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const wgetUsage = "Usage: wget [OPTION]... [URL]...\n\nTry `wget --help' for more options.\n"
+
+var wgetLongOpts = []string{
+	"version", "help", "background", "execute=", "output-file=", "append-output=",
+	"debug", "quiet", "verbose", "no-verbose", "report-speed=", "input-file=",
+	"force-html", "base=", "config=", "bind-address=", "tries=", "retry-connrefused",
+	"output-document=", "no-clobber", "continue", "start-pos=", "progress=",
+	"show-progress", "timestamping", "server-response", "spider", "timeout=",
+	"dns-timeout=", "connect-timeout=", "read-timeout=", "wait=", "waitretry=",
+	"random-wait", "no-proxy", "quota=", "limit-rate=", "no-dns-cache",
+	"inet4-only", "inet6-only", "user=", "password=", "ask-password", "no-iri",
+	"no-directories", "force-directories", "no-host-directories",
+	"directory-prefix=", "cut-dirs=", "default-page=", "adjust-extension",
+	"http-user=", "http-password=", "no-cache", "no-cookies", "load-cookies=",
+	"save-cookies=", "header=", "max-redirect=", "proxy-user=", "proxy-password=",
+	"referer=", "save-headers", "user-agent=", "post-data=", "post-file=",
+	"method=", "body-data=", "body-file=", "content-disposition",
+	"content-on-error", "auth-no-challenge", "secure-protocol=", "https-only",
+	"no-check-certificate", "certificate=", "private-key=", "ca-certificate=",
+	"ftp-user=", "ftp-password=", "no-remove-listing", "no-glob", "no-passive-ftp",
+	"recursive", "level=", "delete-after", "convert-links", "mirror",
+	"page-requisites", "accept=", "reject=", "domains=", "no-parent",
+}
+
+// wgetCommand emulates GNU Wget. Every URL is recorded as a download, and
+// either the fetched contents or a placeholder is written to the VFS.
+func wgetCommand(args *CmdArgs, s *Session) {
+	opts, err := GetOpt(args.Array(), "Vhbe:o:a:dqvn:i:FB:t:O:cNST:w:Q:46U:P:rl:kmpA:R:D:x", wgetLongOpts)
+
+	if err != nil {
+		s.ErrWrite("wget: ", err.Error(), "\n", wgetUsage)
+		s.SetStatus(2)
+		return
+	}
+
+	if opts.Has("V", "version") {
+		s.TermWrite("GNU Wget 1.21.2 built on linux-gnu.\n")
+		return
+	} else if opts.Has("h", "help") {
+		s.TermWrite("GNU Wget 1.21.2, a non-interactive network retriever.\n", wgetUsage)
+		return
+	} else if len(opts.Args) == 0 {
+		s.ErrWrite("wget: missing URL\n", wgetUsage)
+		s.SetStatus(1)
+		return
+	}
+
+	noVerbose := opts.Has("no-verbose")
+	noClobber := opts.Has("no-clobber")
+
+	for _, n := range opts.All("n") {
+		noVerbose = noVerbose || strings.Contains(n, "v")
+		noClobber = noClobber || strings.Contains(n, "c")
+	}
+
+	quiet := opts.Has("q", "quiet")
+	background := opts.Has("b", "background")
+	out := &strings.Builder{}
+
+	req := &DownloadRequest{
+		Command: "wget",
+		Method:  "GET",
+		Headers: make(map[string]string),
+	}
+
+	for _, header := range opts.All("header") {
+		if name, value, ok := strings.Cut(header, ":"); ok {
+			req.Headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+
+	if ua := opts.Get("U", "user-agent"); ua != "" {
+		req.Headers["User-Agent"] = ua
+	} else if _, ok := req.Headers["User-Agent"]; !ok {
+		req.Headers["User-Agent"] = "Wget/1.21.2"
+	}
+
+	if body := opts.Get("post-data", "body-data"); body != "" {
+		req.Method = "POST"
+		req.Body = body
+	}
+
+	if method := opts.Get("method"); method != "" {
+		req.Method = strings.ToUpper(method)
+	}
+
+	outputDoc := opts.Get("O", "output-document")
+	prefix := opts.Get("P", "directory-prefix")
+	docContents := &strings.Builder{}
+
+	if background {
+		s.ErrWrite(fmt.Sprintf("Continuing in background, pid %d.\n", 1000+rand.Intn(30000)))
+		s.ErrWrite("Output will be written to ‘wget-log’.\n")
+	}
+
+	for _, rawURL := range opts.Args {
+		u, err := parseDownloadURL(rawURL, "http")
+
+		if err != nil {
+			fmt.Fprintf(out, "%s: Invalid URL %s: Invalid host name\n", rawURL, rawURL)
+			s.SetStatus(1)
+			continue
+		} else if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "ftp" {
+			fmt.Fprintf(out, "%s: Unsupported scheme ‘%s’.\n", rawURL, u.Scheme)
+			s.SetStatus(1)
+			continue
+		}
+
+		urlReq := *req
+		urlReq.URL = u.String()
+
+		dest := outputDoc
+
+		if dest == "" {
+			dest = remoteFileName(u)
+
+			if dest == "" {
+				dest = "index.html"
+			}
+
+			if prefix != "" {
+				dest = filepath.Join(prefix, dest)
+			}
+
+			if _, _, err := s.VFS.FindFile(dest); err == nil {
+				if noClobber {
+					fmt.Fprintf(out, "File ‘%s’ already there; not retrieving.\n\n", dest)
+					continue
+				}
+
+				// Like wget, don't overwrite existing files but add a number to the name.
+				for i := 1; ; i++ {
+					if _, _, err := s.VFS.FindFile(fmt.Sprintf("%s.%d", dest, i)); err != nil {
+						dest = fmt.Sprintf("%s.%d", dest, i)
+						break
+					}
+				}
+			}
+		}
+
+		start := time.Now()
+		ip := fakeResolve(u.Hostname())
+
+		if !noVerbose {
+			fmt.Fprintf(out, "--%s--  %s\n", start.Format("2006-01-02 15:04:05"), urlReq.URL)
+
+			if ip != u.Hostname() {
+				fmt.Fprintf(out, "Resolving %s (%s)... %s\n", u.Hostname(), u.Hostname(), ip)
+				fmt.Fprintf(out, "Connecting to %s (%s)|%s|:%s... connected.\n", u.Hostname(), u.Hostname(), ip, urlPort(u))
+			} else {
+				fmt.Fprintf(out, "Connecting to %s:%s... connected.\n", ip, urlPort(u))
+			}
+
+			fmt.Fprintf(out, "HTTP request sent, awaiting response... 200 OK\n")
+		}
+
+		data, fetched := fetchDownload(s, &urlReq)
+		elapsed := time.Duration(len(data)/(200+rand.Intn(2000))+1) * time.Millisecond
+		speed := humanSize(int(float64(len(data)) / elapsed.Seconds()))
+
+		if !noVerbose {
+			fmt.Fprintf(out, "Length: %d (%s) [application/octet-stream]\n", len(data), humanSize(len(data)))
+
+			if outputDoc == "-" {
+				fmt.Fprintf(out, "Saving to: ‘STDOUT’\n\n")
+			} else {
+				fmt.Fprintf(out, "Saving to: ‘%s’\n\n", dest)
+			}
+
+			name := filepath.Base(dest)
+
+			if outputDoc == "-" {
+				name = "-"
+			}
+
+			if len(name) > 18 {
+				name = name[:18]
+			}
+
+			fmt.Fprintf(out, "%-19s100%%[===================>] %7s  %sB/s    in %.3fs\n\n",
+				name, humanSize(len(data)), speed, elapsed.Seconds())
+		}
+
+		if outputDoc == "-" {
+			emitDownload(s, &urlReq, "", data, fetched)
+			s.TermWrite(terminalPayload(data, fetched))
+			continue
+		} else if outputDoc != "" {
+			// All of the URLs are concatenated into the output document.
+			docContents.Write(data)
+			data = []byte(docContents.String())
+		}
+
+		if err := saveDownload(s, &urlReq, dest, data, fetched); err != nil {
+			fmt.Fprintf(out, "%s: %s\n", dest, err)
+			fmt.Fprintf(out, "Cannot write to ‘%s’ (%s).\n", dest, err)
+			s.SetStatus(3)
+			continue
+		}
+
+		end := start.Add(elapsed)
+
+		if noVerbose {
+			fmt.Fprintf(out, "%s URL:%s [%d/%d] -> \"%s\" [1]\n",
+				end.Format("2006-01-02 15:04:05"), urlReq.URL, len(data), len(data), dest)
+		} else {
+			fmt.Fprintf(out, "%s (%sB/s) - ‘%s’ saved [%d/%d]\n\n",
+				end.Format("2006-01-02 15:04:05"), speed, dest, len(data), len(data))
+		}
+	}
+
+	if logFile := opts.Get("o", "output-file"); logFile != "" {
+		s.VFS.WriteFile(logFile, out.String())
+	} else if background {
+		s.VFS.WriteFile("wget-log", out.String())
+	} else if !quiet {
+		s.ErrWrite(out.String())
+	}
+}
@@ -0,0 +1,65 @@
+package plugin
+
+import "path/filepath"
+
+// builtinCommand is a command that's implemented in Go rather than by a
+// plugin. A plugin can still replace any of them by registering a command
+// with the same name.
+type builtinCommand struct {
+	name       string
+	dir        string
+	cmdFn      CommandFn
+	completeFn CompleterFn
+}
+
+var builtinCommands = []builtinCommand{
+	{name: "echo", dir: "/bin/", cmdFn: echoCommand},
+	{name: "printf", dir: "/usr/bin/", cmdFn: printfCommand},
+	{name: "base64", dir: "/usr/bin/", cmdFn: base64Command},
+	{name: "wget", dir: "/usr/bin/", cmdFn: wgetCommand},
+	{name: "curl", dir: "/usr/bin/", cmdFn: curlCommand},
+	{name: "tftp", dir: "/usr/bin/", cmdFn: tftpCommand},
+	{name: "ftpget", dir: "/usr/bin/", cmdFn: ftpgetCommand},
+	{name: "uname", dir: "/usr/bin/", cmdFn: unameCommand},
+	{name: "hostname", dir: "/usr/bin/", cmdFn: hostnameCommand},
+	{name: "sudo", dir: "/usr/bin/", cmdFn: sudoCommand},
+	{name: "su", dir: "/usr/bin/", cmdFn: suCommand},
+	{name: "ps", dir: "/usr/bin/", cmdFn: psCommand},
+	{name: "top", dir: "/usr/bin/", cmdFn: topCommand},
+	{name: "pgrep", dir: "/usr/bin/", cmdFn: pgrepCommand},
+	{name: "pkill", dir: "/usr/bin/", cmdFn: pkillCommand},
+	{name: "nohup", dir: "/usr/bin/", cmdFn: nohupCommand},
+	{name: "setsid", dir: "/usr/bin/", cmdFn: setsidCommand},
+	{name: "bash", dir: "/bin/", cmdFn: shCommand},
+	{name: "sh", dir: "/bin/", cmdFn: shCommand},
+	{name: "dash", dir: "/bin/", cmdFn: shCommand},
+	{name: "crontab", dir: "/usr/bin/", cmdFn: crontabCommand},
+	{name: "systemctl", dir: "/bin/", cmdFn: systemctlCommand, completeFn: systemctlComplete},
+}
+
+// loadBuiltins registers all of the builtin commands, both in the command
+// map and in the VFS.
+func (pm *PluginManager) loadBuiltins() {
+	config := &Config{vfs: pm.PluginVFS}
+	config.Init()
+
+	for _, cmd := range builtinCommands {
+		config.RegisterCommand(cmd.name, cmd.dir, cmd.cmdFn)
+
+		// The file may have already been in the VFS, but it should still be
+		// possible to run the command by its full path.
+		config.CommandCallbacks[filepath.Join(cmd.dir, cmd.name)] = cmd.cmdFn
+
+		if cmd.completeFn != nil {
+			config.RegisterCompleter(cmd.name, cmd.completeFn)
+		}
+	}
+
+	for cmd, commandFn := range config.CommandCallbacks {
+		pm.commandMap[cmd] = commandFn
+	}
+
+	for cmd, completerFn := range config.Completers {
+		pm.completers[cmd] = completerFn
+	}
+}
@@ -43,6 +43,7 @@ func (o *OptConfig) Get(arg string) any {
 
 type CmdArgs struct {
 	RawArgs string
+	Argv    []string
 	argMap  map[string]any
 }
 
@@ -107,10 +108,10 @@ func (args *CmdArgs) Parse() {
 			continue
 		}
 
-		if part[0] == '-' && part[1] == '-' {
+		if len(part) > 1 && part[0] == '-' && part[1] == '-' {
 			key := strings.Trim(part, "-")
 
-			if i < len(parts)-1 && parts[i+1][0] != '-' {
+			if i < len(parts)-1 && len(parts[i+1]) > 0 && parts[i+1][0] != '-' {
 				args.argMap[key] = parts[i+1]
 			}
 
@@ -141,9 +142,20 @@ func (args *CmdArgs) Get(key string) any {
 	return nil
 }
 
+// Array returns the arguments of the command. When the command was parsed by
+// the shell, quoted arguments with spaces are kept whole.
 func (args *CmdArgs) Array() []string {
+	if args.Argv != nil {
+		return args.Argv
+	}
+
 	re := regexp.MustCompile(`(\s+)`)
 	rawArgs := strings.Trim(re.ReplaceAllString(args.RawArgs, " "), " ")
+
+	if rawArgs == "" {
+		return []string{}
+	}
+
 	return strings.Split(rawArgs, " ")
 }
 
@@ -0,0 +1,393 @@
+package plugin
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// commandBreaks are the characters that end a command, so the word after them
+// is a command again, and wordBreaks are the ones that only end a word.
+const (
+	commandBreaks = ";|&(`"
+	wordBreaks    = " \t<>"
+)
+
+// completionEscapes are the characters that are escaped with a backslash when
+// they're completed into a word that isn't quoted.
+const completionEscapes = " \t\n\"'\\|&;()<>$`!*?[]{}#"
+
+// completionWidth is how wide the list of completions is laid out, since the
+// size of the attacker's terminal isn't known.
+const completionWidth = 80
+
+// completionWrappers are the commands that run the command after them, which
+// is then completed as a command too.
+var completionWrappers = []string{"sudo", "nohup", "setsid", "exec", "command", "time", "nice", "xargs", "watch"}
+
+// completionWord is the word under the cursor, along with the words before it
+// in the same command.
+type completionWord struct {
+	start int
+	text  string
+	quote byte
+	args  []string
+}
+
+// parseCompletionWord splits the line up to the cursor the way the shell would,
+// and returns the last word of it without its quotes and backslashes.
+func parseCompletionWord(line string) completionWord {
+	word := completionWord{start: len(line)}
+	text := strings.Builder{}
+	inWord, escaped := false, false
+
+	begin := func(i int) {
+		if !inWord {
+			inWord = true
+			word.start = i
+		}
+	}
+
+	end := func() {
+		if inWord {
+			word.args = append(word.args, text.String())
+		}
+
+		text.Reset()
+		inWord = false
+		word.start = len(line)
+	}
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+
+		switch {
+		case escaped:
+			text.WriteByte(c)
+			escaped = false
+		case word.quote == '\'':
+			if c == '\'' {
+				word.quote = 0
+			} else {
+				text.WriteByte(c)
+			}
+		case word.quote == '"':
+			if c == '"' {
+				word.quote = 0
+			} else if c == '\\' {
+				escaped = true
+			} else {
+				text.WriteByte(c)
+			}
+		case c == '\\':
+			begin(i)
+			escaped = true
+		case c == '\'' || c == '"':
+			begin(i)
+			word.quote = c
+		case strings.IndexByte(commandBreaks, c) >= 0:
+			end()
+			word.args = nil
+		case strings.IndexByte(wordBreaks, c) >= 0:
+			end()
+		default:
+			begin(i)
+			text.WriteByte(c)
+		}
+	}
+
+	word.text = text.String()
+
+	return word
+}
+
+// isCommand returns whether the word is where a command goes, which is the
+// first word (after any assignments) or the one after a command like `sudo`.
+func (w *completionWord) isCommand() bool {
+	if len(w.args) == 1 && slices.Contains(completionWrappers, w.args[0]) {
+		return true
+	}
+
+	for _, arg := range w.args {
+		if name, _, ok := strings.Cut(arg, "="); !ok || !isName(name) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// AutoCompleteCallback completes the word under the cursor when Tab is pressed,
+// like bash: the part that all of the completions share is filled in, and
+// pressing Tab again lists them.
+func (s *Session) AutoCompleteCallback(line string, pos int, key rune) (newLine string, newPos int, ok bool) {
+	if key != '\t' {
+		s.tabbed = false
+		return line, pos, false
+	}
+
+	word := parseCompletionWord(line[:pos])
+	completions, dir := s.completions(&word)
+	tabbed := s.tabbed
+	s.tabbed = false
+
+	switch {
+	case len(completions) == 0:
+		return line, pos, true
+	case len(completions) == 1:
+		completed := quoteCompletion(&word, completions[0])
+
+		if !strings.HasSuffix(completions[0], "/") {
+			if word.quote != 0 {
+				completed += string(word.quote)
+			}
+
+			completed += " "
+		}
+
+		return line[:word.start] + completed + line[pos:], word.start + len(completed), true
+	}
+
+	s.tabbed = true
+
+	if prefix := commonPrefix(completions); len(prefix) > len(word.text) {
+		completed := quoteCompletion(&word, prefix)
+
+		return line[:word.start] + completed + line[pos:], word.start + len(completed), true
+	} else if tabbed {
+		s.listCompletions(line, completions, dir)
+	}
+
+	return line, pos, true
+}
+
+// completions returns what the word under the cursor can be completed to, and
+// the part of them that's left out when they're listed (e.g. the directory of
+// a file).
+func (s *Session) completions(word *completionWord) ([]string, string) {
+	switch {
+	case strings.HasPrefix(word.text, "$") && word.quote != '\'':
+		return s.completeVars(word.text), ""
+	case word.isCommand() && strings.Contains(word.text, "/"):
+		return s.completeFiles(word.text, true)
+	case word.isCommand():
+		return s.completeCommands(word.text), ""
+	}
+
+	if completer, ok := s.Manager.GetCompleter(filepath.Base(word.args[0])); ok {
+		completions := []string{}
+
+		for _, completion := range completer(s, append(slices.Clone(word.args), word.text)) {
+			if strings.HasPrefix(completion, word.text) {
+				completions = append(completions, completion)
+			}
+		}
+
+		if len(completions) > 0 {
+			slices.Sort(completions)
+			return slices.Compact(completions), ""
+		}
+	}
+
+	// Like bash, the value of an option (e.g. --output=/tmp/x) is completed
+	// as a file on its own.
+	option, text := "", word.text
+
+	if i := strings.LastIndexByte(text, '='); i >= 0 {
+		option, text = text[:i+1], text[i+1:]
+	}
+
+	completions, dir := s.completeFiles(text, false)
+
+	for i := range completions {
+		completions[i] = option + completions[i]
+	}
+
+	return completions, option + dir
+}
+
+// completeCommands returns the commands, builtins, functions and programs in
+// the PATH whose names start with a prefix.
+func (s *Session) completeCommands(prefix string) []string {
+	_, commands := s.Manager.MatchCommand(prefix)
+	names := []string{}
+
+	for _, name := range commands {
+		if !strings.Contains(name, "/") {
+			names = append(names, name)
+		}
+	}
+
+	for name := range shellBuiltins {
+		names = append(names, name)
+	}
+
+	for name := range s.functions {
+		names = append(names, name)
+	}
+
+	path, _ := s.GetVar("PATH")
+
+	for _, dir := range filepath.SplitList(path) {
+		if dir == "" {
+			continue
+		}
+
+		files, _ := s.completeFiles(strings.TrimSuffix(dir, "/")+"/"+prefix, true)
+
+		for _, file := range files {
+			if !strings.HasSuffix(file, "/") {
+				names = append(names, filepath.Base(file))
+			}
+		}
+	}
+
+	names = slices.DeleteFunc(names, func(name string) bool {
+		return !strings.HasPrefix(name, prefix)
+	})
+	slices.Sort(names)
+
+	return slices.Compact(names)
+}
+
+// completeFiles returns the files in the VFS whose paths start with a prefix,
+// with a slash after the directories, and the directory that they're in. Only
+// directories and programs are completed when `programs` is set.
+func (s *Session) completeFiles(prefix string, programs bool) ([]string, string) {
+	dir, base := "", prefix
+
+	if i := strings.LastIndexByte(prefix, '/'); i >= 0 {
+		dir, base = prefix[:i+1], prefix[i+1:]
+	}
+
+	lookup := dir
+
+	if lookup == "" {
+		lookup = "."
+	}
+
+	realPath, file, err := s.VFS.FindFile(lookup)
+
+	if err != nil || file.Type != T_DIR || !s.VFS.access(file).Read {
+		return nil, dir
+	}
+
+	files := []string{}
+
+	for _, entry := range file.entries() {
+		name := entry.Name()
+
+		if realPath == "/home" && name == "{}" && s.VFS.login() != nil {
+			name = s.VFS.login().Username
+		}
+
+		if !strings.HasPrefix(name, base) || (name[0] == '.' && !strings.HasPrefix(base, ".")) {
+			continue
+		}
+
+		_, target, err := s.VFS.FindFile(dir + name)
+		isDir := err == nil && target.Type == T_DIR
+
+		if programs && !isDir && (err != nil || !s.VFS.access(target).Exec) {
+			continue
+		} else if isDir {
+			name += "/"
+		}
+
+		files = append(files, dir+name)
+	}
+
+	return files, dir
+}
+
+// completeVars returns the variables whose names start with a prefix, which
+// starts with the `$`.
+func (s *Session) completeVars(prefix string) []string {
+	names := slices.Concat(exportedVars, readonlyVars)
+
+	for name := range s.vars {
+		names = append(names, name)
+	}
+
+	vars := []string{}
+
+	for _, name := range names {
+		if strings.HasPrefix("$"+name, prefix) {
+			vars = append(vars, "$"+name)
+		}
+	}
+
+	slices.Sort(vars)
+
+	return slices.Compact(vars)
+}
+
+// quoteCompletion puts a completion back the way the word was written: after
+// its opening quote, or with backslashes before the characters that the shell
+// would treat differently.
+func quoteCompletion(word *completionWord, completion string) string {
+	if word.quote != 0 {
+		return string(word.quote) + completion
+	} else if strings.HasPrefix(completion, "$") {
+		return completion
+	}
+
+	quoted := strings.Builder{}
+
+	for _, c := range completion {
+		if strings.ContainsRune(completionEscapes, c) {
+			quoted.WriteByte('\\')
+		}
+
+		quoted.WriteRune(c)
+	}
+
+	return quoted.String()
+}
+
+// commonPrefix returns the longest prefix that all of the words share.
+func commonPrefix(words []string) string {
+	prefix := words[0]
+
+	for _, word := range words[1:] {
+		for !strings.HasPrefix(word, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+
+	for !utf8.ValidString(prefix) {
+		prefix = prefix[:len(prefix)-1]
+	}
+
+	return prefix
+}
+
+// listCompletions shows the completions in columns below the line, after which
+// the terminal draws the prompt and the line again.
+func (s *Session) listCompletions(line string, completions []string, dir string) {
+	names := make([]string, len(completions))
+	width := 0
+
+	for i, completion := range completions {
+		names[i] = strings.TrimPrefix(completion, dir)
+		width = max(width, utf8.RuneCountInString(names[i])+2)
+	}
+
+	cols := max(1, completionWidth/width)
+	rows := (len(names) + cols - 1) / cols
+	list := s.prompt + line + "\n"
+
+	for row := 0; row < rows; row++ {
+		text := ""
+
+		for col := 0; col < cols && col*rows+row < len(names); col++ {
+			name := names[col*rows+row]
+			text += name + strings.Repeat(" ", width-utf8.RuneCountInString(name))
+		}
+
+		list += strings.TrimRight(text, " ") + "\n"
+	}
+
+	s.Term.Write([]byte(list))
+}
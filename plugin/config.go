@@ -15,11 +15,16 @@ type PasswordInterceptFn func(string, string, *net.IP) bool
 type PromptFn func(*Session) string
 type LoginMessageFn func(*Session) string
 
+// CompleterFn returns what the last of the words of a command (the one that's
+// being completed, which is often empty) can be completed to.
+type CompleterFn func(*Session, []string) []string
+
 // Config is a struct that handles everything related to the sandbox. For
 // example, the registered commands, the password interceptor, the fake
 // prompt, and other things.
 type Config struct {
 	CommandCallbacks    map[string]CommandFn
+	Completers          map[string]CompleterFn
 	PasswordInterceptor PasswordInterceptFn
 	PromptFn            PromptFn
 	LoginMessageFn      LoginMessageFn
@@ -30,6 +35,7 @@ type Config struct {
 // command callbacks.
 func (c *Config) Init() {
 	c.CommandCallbacks = make(map[string]CommandFn)
+	c.Completers = make(map[string]CompleterFn)
 }
 
 // RegisterCommand adds a command to the list of supported commands. This
@@ -52,15 +58,6 @@ func (c *Config) RegisterCommand(cmd, dir string, cmdFn CommandFn) bool {
 			return false
 		}
 
-		if file.Type == T_SYMLINK {
-			_, file, err = c.vfs.FindFile(filepath.Join("/", file.LinkTo))
-
-			if err != nil {
-				log.Println("Error:", err)
-				return false
-			}
-		}
-
 		if _, ok := file.Files[cmd]; ok {
 			log.Printf("Error: command \"%s%s\" already exists\n", dir, cmd)
 			return false
@@ -81,6 +78,12 @@ func (c *Config) RegisterCommand(cmd, dir string, cmdFn CommandFn) bool {
 	return true
 }
 
+// RegisterCompleter sets the function that completes the arguments of a
+// command when Tab is pressed, instead of the files of the VFS.
+func (c *Config) RegisterCompleter(cmd string, completerFn CompleterFn) {
+	c.Completers[cmd] = completerFn
+}
+
 func (c *Config) RegisterLoginMessage(loginMsgFn LoginMessageFn) {
 	c.LoginMessageFn = loginMsgFn
 }
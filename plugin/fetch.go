@@ -0,0 +1,24 @@
+package plugin
+
+import "errors"
+
+// ErrNotFetched is returned by a Fetcher that doesn't have the contents of
+// a download, in which case a placeholder is written instead.
+var ErrNotFetched = errors.New("not fetched")
+
+// DownloadRequest describes a file that a command (wget, curl, etc) tried to
+// download.
+type DownloadRequest struct {
+	Command string
+	URL     string
+	Method  string
+	Headers map[string]string
+	Body    string
+}
+
+// Fetcher returns the contents of a download. The honeypot never reaches the
+// network by itself, so it's up to the fetcher to decide where the contents
+// come from.
+type Fetcher interface {
+	Fetch(req *DownloadRequest) ([]byte, error)
+}
@@ -0,0 +1,158 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+)
+
+// optValue is a single option that was found on the command line.
+type optValue struct {
+	name  string
+	value string
+}
+
+// Opts is the result of parsing a command line with GetOpt.
+type Opts struct {
+	values []optValue
+	Args   []string
+}
+
+// Has returns whether any of the given options was passed.
+func (o *Opts) Has(names ...string) bool {
+	for _, v := range o.values {
+		for _, name := range names {
+			if v.name == name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Get returns the value of the last occurrence of any of the given options.
+func (o *Opts) Get(names ...string) string {
+	value := ""
+
+	for _, v := range o.values {
+		for _, name := range names {
+			if v.name == name {
+				value = v.value
+			}
+		}
+	}
+
+	return value
+}
+
+// All returns the values of all occurrences of the given options, in order.
+func (o *Opts) All(names ...string) []string {
+	values := make([]string, 0)
+
+	for _, v := range o.values {
+		for _, name := range names {
+			if v.name == name {
+				values = append(values, v.value)
+			}
+		}
+	}
+
+	return values
+}
+
+// GetOpt parses the arguments like getopt_long(3) does. The `short` string
+// lists the single letter options, and a letter that's followed by a colon
+// takes an argument (e.g. "qO:"). Each of the `long` options is the name
+// without dashes, followed by an "=" if it takes an argument (e.g. "header=").
+// Options and bare arguments can be mixed, and "--" ends the options. Like
+// getopt, a `short` string that starts with "+" ends the options at the first
+// bare argument instead, which is what commands that run other commands (e.g.
+// sudo) need.
+func GetOpt(args []string, short string, long []string) (*Opts, error) {
+	opts := &Opts{Args: make([]string, 0)}
+	longOpts := make(map[string]bool)
+	inOrder := strings.HasPrefix(short, "+")
+	short = strings.TrimPrefix(short, "+")
+
+	for _, l := range long {
+		longOpts[strings.TrimSuffix(l, "=")] = strings.HasSuffix(l, "=")
+	}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		if arg == "--" {
+			opts.Args = append(opts.Args, args[i+1:]...)
+			break
+		} else if strings.HasPrefix(arg, "--") {
+			name, value, hasValue := strings.Cut(arg[2:], "=")
+			takesArg, ok := longOpts[name]
+
+			if !ok {
+				// Like getopt, allow unambiguous abbreviations of long options.
+				matches := make([]string, 0)
+
+				for l := range longOpts {
+					if strings.HasPrefix(l, name) {
+						matches = append(matches, l)
+					}
+				}
+
+				if len(matches) != 1 {
+					return opts, fmt.Errorf("unrecognized option '%s'", arg)
+				}
+
+				name = matches[0]
+				takesArg = longOpts[name]
+			}
+
+			if takesArg && !hasValue {
+				if i+1 >= len(args) {
+					return opts, fmt.Errorf("option '--%s' requires an argument", name)
+				}
+
+				i++
+				value = args[i]
+			} else if !takesArg && hasValue {
+				return opts, fmt.Errorf("option '--%s' doesn't allow an argument", name)
+			}
+
+			opts.values = append(opts.values, optValue{name: name, value: value})
+		} else if strings.HasPrefix(arg, "-") && arg != "-" {
+			for j := 1; j < len(arg); j++ {
+				idx := strings.IndexByte(short, arg[j])
+
+				if idx < 0 || arg[j] == ':' {
+					return opts, fmt.Errorf("invalid option -- '%c'", arg[j])
+				}
+
+				name := string(arg[j])
+
+				if idx+1 < len(short) && short[idx+1] == ':' {
+					value := arg[j+1:]
+
+					if value == "" {
+						if i+1 >= len(args) {
+							return opts, fmt.Errorf("option requires an argument -- '%c'", arg[j])
+						}
+
+						i++
+						value = args[i]
+					}
+
+					opts.values = append(opts.values, optValue{name: name, value: value})
+					break
+				}
+
+				opts.values = append(opts.values, optValue{name: name})
+			}
+		} else if inOrder {
+			opts.Args = append(opts.Args, args[i:]...)
+			break
+		} else {
+			opts.Args = append(opts.Args, arg)
+		}
+	}
+
+	return opts, nil
+}
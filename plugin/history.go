@@ -0,0 +1,389 @@
+package plugin
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// historyJoins are the words that a line of a command that takes more than one
+// line can end with, after which the next line is joined without a `;`.
+var historyJoins = []string{"do", "then", "else", "elif", "in", "{", "(", "|", "||", "&&", ";", "&"}
+
+// termHistory is what the terminal recalls with the arrow keys, which is the
+// history of the session's shell. The shell adds the lines to it itself, once
+// their `!` references are expanded.
+type termHistory struct {
+	s *Session
+}
+
+func (h termHistory) Add(string) {}
+
+func (h termHistory) Len() int {
+	return len(h.s.history)
+}
+
+func (h termHistory) At(idx int) string {
+	return h.s.history[len(h.s.history)-1-idx]
+}
+
+// StartHistory sets up the history of an interactive shell, the way bash does:
+// it's read from ~/.bash_history (or $HISTFILE), and the terminal recalls it
+// with the arrow keys.
+func (s *Session) StartHistory() {
+	defaults := map[string]string{
+		"HISTFILE":     s.home() + "/.bash_history",
+		"HISTSIZE":     "1000",
+		"HISTFILESIZE": "2000",
+		"HISTCONTROL":  "ignoreboth",
+	}
+
+	for name, value := range defaults {
+		if _, ok := s.vars[name]; !ok {
+			s.SetVar(name, value)
+		}
+	}
+
+	s.historyStart = 1
+	s.readHistory(s.vars["HISTFILE"])
+
+	if s.Term != nil {
+		s.Term.History = termHistory{s}
+	}
+}
+
+// AddHistory adds a line of input to the history, unless $HISTCONTROL leaves
+// it out, and appends it to the history file right away.
+func (s *Session) AddHistory(line string) {
+	line = historyLine(line)
+	control, _ := s.GetVar("HISTCONTROL")
+	ignore := strings.Split(control, ":")
+
+	if strings.TrimSpace(line) == "" {
+		return
+	} else if (slices.Contains(ignore, "ignorespace") || slices.Contains(ignore, "ignoreboth")) && strings.HasPrefix(line, " ") {
+		return
+	} else if (slices.Contains(ignore, "ignoredups") || slices.Contains(ignore, "ignoreboth")) && len(s.history) > 0 && s.history[len(s.history)-1] == line {
+		return
+	}
+
+	s.appendHistory(line)
+
+	if path, ok := s.GetVar("HISTFILE"); ok {
+		s.writeHistory(path, []string{line}, true)
+	}
+}
+
+// appendHistory adds lines to the history, and drops the oldest ones past
+// $HISTSIZE.
+func (s *Session) appendHistory(lines ...string) {
+	if s.historyStart == 0 {
+		s.historyStart = 1
+	}
+
+	s.history = append(s.history, lines...)
+	size, err := strconv.Atoi(s.vars["HISTSIZE"])
+
+	if err == nil && size >= 0 && len(s.history) > size {
+		s.historyStart += len(s.history) - size
+		s.history = slices.Clone(s.history[len(s.history)-size:])
+	}
+}
+
+// readHistory adds the lines of a history file to the history. The lines with
+// the times that commands were run at (e.g. "#1700000000") are skipped.
+func (s *Session) readHistory(path string) error {
+	contents, err := s.VFS.ReadFile(path)
+
+	if err != nil {
+		return err
+	}
+
+	lines := []string{}
+
+	for _, line := range strings.Split(contents, "\n") {
+		if _, err := strconv.Atoi(strings.TrimPrefix(line, "#")); strings.TrimSpace(line) == "" || (strings.HasPrefix(line, "#") && err == nil) {
+			continue
+		}
+
+		lines = append(lines, line)
+	}
+
+	s.appendHistory(lines...)
+
+	return nil
+}
+
+// writeHistory writes lines to a history file, or appends them to it. Like in
+// bash, it's fine for there to be no history file.
+func (s *Session) writeHistory(path string, lines []string, appending bool) error {
+	if path == "" || path == "/dev/null" {
+		return nil
+	}
+
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+
+	if appending {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+
+	file, err := s.VFS.openFile(path, flag, 0600, ArtifactSourceHistory)
+
+	if err != nil {
+		return err
+	}
+
+	for _, line := range lines {
+		file.Write([]byte(line + "\n"))
+	}
+
+	return file.Close()
+}
+
+// historyLine joins the lines of a command that took more than one (like a
+// loop) into a single line, the way that bash remembers them.
+func historyLine(input string) string {
+	lines := strings.Split(input, "\n")
+	joined := lines[0]
+
+	for _, line := range lines[1:] {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		words := strings.Fields(joined)
+
+		if len(words) == 0 || slices.Contains(historyJoins, words[len(words)-1]) || strings.HasSuffix(joined, ";") {
+			joined = strings.TrimRight(joined, " \t") + " " + strings.TrimLeft(line, " \t")
+		} else {
+			joined = strings.TrimRight(joined, " \t") + "; " + strings.TrimLeft(line, " \t")
+		}
+	}
+
+	return joined
+}
+
+// ExpandHistory replaces the references to the history in a line of input
+// (like `!!`, `!$` or `!42`) before it's run, the way bash does. The line is
+// shown again when it changed, and false is returned when it can't be run
+// because a reference didn't match.
+func (s *Session) ExpandHistory(line string) (string, bool) {
+	expanded, changed, err := s.expandHistory(line)
+
+	if err != nil {
+		s.ErrWrite("bash: ", err.Error(), "\n")
+		return "", false
+	} else if changed {
+		s.TermWrite(expanded, "\n")
+	}
+
+	return expanded, true
+}
+
+// expandHistory replaces the references to the history in a string, and
+// returns whether there were any.
+func (s *Session) expandHistory(line string) (string, bool, error) {
+	expanded := strings.Builder{}
+	quote := byte(0)
+	changed := false
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+
+		switch {
+		case c == '\\' && quote != '\'' && i+1 < len(line):
+			expanded.WriteString(line[i : i+2])
+			i++
+			continue
+		case (c == '\'' || c == '"') && (quote == 0 || quote == c):
+			if quote == 0 {
+				quote = c
+			} else {
+				quote = 0
+			}
+		case c == '!' && quote != '\'' && !strings.HasSuffix(line[:i], "$") && !strings.HasSuffix(line[:i], "${"):
+			ref := historyRef(line[i:], quote)
+
+			if ref == "" {
+				break
+			}
+
+			value, err := s.historyEvent(ref)
+
+			if err != nil {
+				return "", false, err
+			}
+
+			expanded.WriteString(value)
+			i += len(ref) - 1
+			changed = true
+			continue
+		}
+
+		expanded.WriteByte(c)
+	}
+
+	return expanded.String(), changed, nil
+}
+
+// historyRef returns the reference to the history at the start of a string
+// (e.g. "!!:2"), or an empty string if the `!` isn't one.
+func historyRef(str string, quote byte) string {
+	if len(str) < 2 || strings.IndexByte(" \t\n=(", str[1]) >= 0 || str[1] == quote {
+		return ""
+	}
+
+	end := 2
+
+	switch c := str[1]; {
+	case c == '!' || c == '$' || c == '^' || c == '*':
+		if c != '!' {
+			return str[:2]
+		}
+	case c == '?':
+		if i := strings.IndexByte(str[2:], '?'); i >= 0 {
+			end = i + 3
+		} else {
+			end = len(str)
+		}
+	case c == '-' || (c >= '0' && c <= '9'):
+		for end < len(str) && str[end] >= '0' && str[end] <= '9' {
+			end++
+		}
+
+		if end == 2 && c == '-' {
+			return ""
+		}
+	default:
+		for end < len(str) && strings.IndexByte(" \t\n;&|()<>:\"'", str[end]) < 0 {
+			end++
+		}
+	}
+
+	// The event can be followed by the word of it that's wanted.
+	if end+1 < len(str) && str[end] == ':' && strings.IndexByte("0123456789^$*", str[end+1]) >= 0 {
+		end += 2
+
+		for end < len(str) && str[end] >= '0' && str[end] <= '9' && str[end-1] >= '0' && str[end-1] <= '9' {
+			end++
+		}
+	}
+
+	return str[:end]
+}
+
+// historyEvent returns what a reference to the history (e.g. "!-2:1") stands
+// for, or the error that bash shows when there's no such line or word.
+func (s *Session) historyEvent(ref string) (string, error) {
+	event, word, hasWord := strings.Cut(ref[1:], ":")
+
+	switch event {
+	case "$", "^", "*":
+		event, word, hasWord = "!", event, true
+	}
+
+	var line string
+	found := false
+
+	switch {
+	case event == "!":
+		found = len(s.history) > 0
+
+		if found {
+			line = s.history[len(s.history)-1]
+		}
+	case strings.HasPrefix(event, "?"):
+		search := strings.TrimSuffix(event[1:], "?")
+
+		for i := len(s.history) - 1; i >= 0 && !found; i-- {
+			line, found = s.history[i], strings.Contains(s.history[i], search)
+		}
+	default:
+		if n, err := strconv.Atoi(event); err == nil {
+			if n < 0 {
+				n += len(s.history)
+			} else {
+				n -= s.historyStart
+			}
+
+			if found = n >= 0 && n < len(s.history); found {
+				line = s.history[n]
+			}
+
+			break
+		}
+
+		for i := len(s.history) - 1; i >= 0 && !found; i-- {
+			line, found = s.history[i], strings.HasPrefix(s.history[i], event)
+		}
+	}
+
+	if !found {
+		return "", fmt.Errorf("%s: event not found", strings.TrimSuffix(ref, ":"+word))
+	} else if !hasWord {
+		return line, nil
+	}
+
+	words := historyWords(line)
+
+	if len(words) == 0 {
+		return "", fmt.Errorf(":%s: bad word specifier", word)
+	}
+
+	switch word {
+	case "$":
+		return words[len(words)-1], nil
+	case "*":
+		return strings.Join(words[1:], " "), nil
+	case "^":
+		word = "1"
+	}
+
+	if n, err := strconv.Atoi(word); err == nil && n < len(words) {
+		return words[n], nil
+	}
+
+	return "", fmt.Errorf(":%s: bad word specifier", word)
+}
+
+// historyWords splits a line of the history into its words, which keep their
+// quotes.
+func historyWords(line string) []string {
+	words := []string{}
+	word := strings.Builder{}
+	quote := byte(0)
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+
+		switch {
+		case c == '\\' && quote != '\'' && i+1 < len(line):
+			word.WriteString(line[i : i+2])
+			i++
+			continue
+		case (c == '\'' || c == '"') && (quote == 0 || quote == c):
+			if quote == 0 {
+				quote = c
+			} else {
+				quote = 0
+			}
+		case (c == ' ' || c == '\t') && quote == 0:
+			if word.Len() > 0 {
+				words = append(words, word.String())
+				word.Reset()
+			}
+
+			continue
+		}
+
+		word.WriteByte(c)
+	}
+
+	if word.Len() > 0 {
+		words = append(words, word.String())
+	}
+
+	return words
+}
@@ -0,0 +1,210 @@
+package plugin
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// job is a command that was started in the background with `&`. The PID is
+// the one that the shell printed when it started it, and the processes that
+// it left behind stay in the process table until they're killed. The path and
+// arguments are those of the last program that it ran, which is logged unless
+// it already was.
+type job struct {
+	id      int
+	pid     int
+	command string
+	pids    []int
+	path    string
+	argv    []string
+	status  int
+	logged  bool
+	done    bool
+	noticed bool
+}
+
+// signalDescriptions are how bash describes the signals that killed a job.
+var signalDescriptions = map[int]string{
+	1:  "Hangup",
+	2:  "Interrupt",
+	3:  "Quit",
+	4:  "Illegal instruction",
+	6:  "Aborted",
+	9:  "Killed",
+	10: "User defined signal 1",
+	11: "Segmentation fault",
+	12: "User defined signal 2",
+	13: "Broken pipe",
+	14: "Alarm clock",
+	15: "Terminated",
+}
+
+// runBackground runs a command that was followed by `&`. The command runs to
+// the end right away, but unless it failed, its processes are left running in
+// the process table, which is what droppers expect of their payload.
+func (s *Session) runBackground(andOr *andOrList) {
+	j := &job{id: 1, pid: s.VFS.NextPID(), command: andOr.String()}
+
+	if len(s.jobs) > 0 {
+		j.id = s.jobs[len(s.jobs)-1].id + 1
+	}
+
+	s.jobs = append(s.jobs, j)
+	s.lastJob = j.pid
+
+	if s.Interactive {
+		s.ErrWrite(fmt.Sprintf("[%d] %d\n", j.id, j.pid))
+	}
+
+	prev := s.job
+	s.job = j
+	j.status = s.runAndOr(andOr)
+	s.job = prev
+
+	if j.path != "" && !j.logged {
+		s.logExecution(j.path, j.argv, true)
+	}
+
+	if j.status != 0 || len(j.pids) == 0 {
+		for _, pid := range j.pids {
+			s.VFS.UnmountProcess(pid)
+		}
+
+		j.pids = nil
+		j.done = true
+	}
+
+	// Starting a job always succeeds.
+	s.status = 0
+}
+
+// reapJob takes a process that was killed out of its job, which is over once
+// none of its processes are left.
+func (s *Session) reapJob(pid, status int) {
+	for _, j := range s.jobs {
+		if i := slices.Index(j.pids, pid); i >= 0 {
+			j.pids = slices.Delete(j.pids, i, i+1)
+
+			if len(j.pids) == 0 {
+				j.status, j.done, j.noticed = status, true, true
+			}
+
+			return
+		}
+	}
+}
+
+// findJob finds a job by its job spec, like `%1`, `%+` (or `%%`) for the
+// current job, `%-` for the previous one, `%name` for the one whose command
+// starts with a name or `%?text` for the one whose command contains some text.
+func (s *Session) findJob(spec string) *job {
+	if len(s.jobs) == 0 || !strings.HasPrefix(spec, "%") {
+		return nil
+	}
+
+	spec = spec[1:]
+
+	switch {
+	case spec == "" || spec == "%" || spec == "+":
+		return s.jobs[len(s.jobs)-1]
+	case spec == "-":
+		return s.jobs[max(len(s.jobs)-2, 0)]
+	}
+
+	for _, j := range slices.Backward(s.jobs) {
+		if id, err := strconv.Atoi(spec); err == nil {
+			if j.id == id {
+				return j
+			}
+		} else if text, ok := strings.CutPrefix(spec, "?"); ok && strings.Contains(j.command, text) {
+			return j
+		} else if !ok && strings.HasPrefix(j.command, spec) {
+			return j
+		}
+	}
+
+	return nil
+}
+
+// jobState returns what bash says that a job is doing: "Running", "Stopped",
+// "Done", "Exit 127" or how it was killed (e.g. "Terminated").
+func (s *Session) jobState(j *job) string {
+	switch {
+	case !j.done:
+		for _, pid := range j.pids {
+			if p := s.VFS.FindProcess(pid); p != nil && !strings.HasPrefix(p.Stat(), "T") {
+				return "Running"
+			}
+		}
+
+		return "Stopped"
+	case j.status == 0:
+		return "Done"
+	case j.status > 128:
+		if desc, ok := signalDescriptions[j.status-128]; ok {
+			return desc
+		}
+
+		return "Signal " + strconv.Itoa(j.status-128)
+	}
+
+	return "Exit " + strconv.Itoa(j.status)
+}
+
+// jobLine formats a job like `jobs` does, e.g. "[1]+  Running    ./x &". The
+// mark is "+" for the current job and "-" for the previous one.
+func (s *Session) jobLine(j *job, withPID bool) string {
+	mark := " "
+
+	if i := slices.Index(s.jobs, j); i == len(s.jobs)-1 {
+		mark = "+"
+	} else if i == len(s.jobs)-2 {
+		mark = "-"
+	}
+
+	command := j.command
+
+	if !j.done {
+		command += " &"
+	}
+
+	if withPID {
+		return fmt.Sprintf("[%d]%s %d %-24s%s\n", j.id, mark, j.pid, s.jobState(j), command)
+	}
+
+	return fmt.Sprintf("[%d]%s  %-24s%s\n", j.id, mark, s.jobState(j), command)
+}
+
+// forgetJobs removes the jobs that are over from the job table.
+func (s *Session) forgetJobs() {
+	s.jobs = slices.DeleteFunc(s.jobs, func(j *job) bool {
+		return j.done
+	})
+}
+
+// ReportJobs tells the attacker about the jobs that are over, the way bash
+// does before it shows the prompt. A job that failed as soon as it started is
+// only reported after the next command, since that's when bash notices it.
+func (s *Session) ReportJobs() {
+	out := strings.Builder{}
+	reported := []*job{}
+
+	for _, j := range s.jobs {
+		if j.done && j.noticed {
+			out.WriteString(s.jobLine(j, false))
+			reported = append(reported, j)
+		}
+	}
+
+	s.jobs = slices.DeleteFunc(s.jobs, func(j *job) bool {
+		return slices.Contains(reported, j)
+	})
+
+	for _, j := range s.jobs {
+		j.noticed = j.done
+	}
+
+	s.TermWrite(out.String())
+}
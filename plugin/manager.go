@@ -1,8 +1,9 @@
 package plugin
 
 import (
-	"fmt"
-	"regexp"
+	"net"
+	"slices"
+	"strings"
 
 	"gorm.io/gorm"
 )
@@ -15,8 +16,11 @@ type PluginManager struct {
 	plugins         []*Plugin
 	passwordPlugins []*Plugin
 	commandMap      map[string]CommandFn
+	completers      map[string]CompleterFn
 	PromptPlugin    PromptFn
 	LoginMessageFn  LoginMessageFn
+	Fetcher         Fetcher
+	eventHandlers   []EventHandler
 }
 
 // LoadPlugins loads the plugin by supplying a `path`.
@@ -25,11 +29,19 @@ func (pm *PluginManager) LoadPlugins(path string) error {
 	pm.plugins, err = LoadPlugins(path, pm.DB)
 	pm.passwordPlugins = make([]*Plugin, 0)
 	pm.commandMap = make(map[string]CommandFn)
+	pm.completers = make(map[string]CompleterFn)
 
 	if err != nil {
 		return err
 	}
 
+	// The builtin commands are loaded first, so that the plugins can override them.
+	pm.loadBuiltins()
+
+	if pm.PluginVFS != nil {
+		pm.PluginVFS.MountProc()
+	}
+
 	for _, pl := range pm.plugins {
 		err = pl.Init(pm.PluginVFS)
 
@@ -47,6 +59,10 @@ func (pm *PluginManager) LoadPlugins(path string) error {
 			}
 		}
 
+		for cmd, completerFn := range pl.Config.Completers {
+			pm.completers[cmd] = completerFn
+		}
+
 		if pl.HasPromptFn() {
 			pm.PromptPlugin = pl.Config.PromptFn
 		}
@@ -65,7 +81,7 @@ func (pm *PluginManager) LoadPlugins(path string) error {
 
 // defaultPrompt displays a very basic bash-like prompt.
 func (pm *PluginManager) defaultPrompt(s *Session) string {
-	if s.User.Username == "root" {
+	if s.User.IsRoot() {
 		return "# "
 	}
 
@@ -82,21 +98,59 @@ func (pm *PluginManager) GetCommand(cmd string) (CommandFn, bool) {
 	return nil, false
 }
 
+// MatchCommand returns the commands whose names (or paths) start with what was
+// typed, sorted by name.
 func (pm *PluginManager) MatchCommand(part string) ([]CommandFn, []string) {
 	commands := make([]string, 0)
-	cmdFns := make([]CommandFn, 0)
-	reg := regexp.MustCompile(fmt.Sprintf("^%s", part))
 
-	for cmd, cmdFn := range pm.commandMap {
-		if reg.MatchString(cmd) {
+	for cmd := range pm.commandMap {
+		if strings.HasPrefix(cmd, part) {
 			commands = append(commands, cmd)
-			cmdFns = append(cmdFns, cmdFn)
 		}
 	}
 
+	slices.Sort(commands)
+	cmdFns := make([]CommandFn, 0, len(commands))
+
+	for _, cmd := range commands {
+		cmdFns = append(cmdFns, pm.commandMap[cmd])
+	}
+
 	return cmdFns, commands
 }
 
+// GetCompleter returns the function that completes the arguments of a command,
+// if one was registered for it.
+func (pm *PluginManager) GetCompleter(cmd string) (CompleterFn, bool) {
+	completerFn, ok := pm.completers[cmd]
+
+	return completerFn, ok
+}
+
+// CheckPassword returns whether a password is right for a user of the VFS, which
+// the plugins that decide whether a login is allowed also decide.
+func (pm *PluginManager) CheckPassword(username, password string, ip *net.IP) bool {
+	for _, pl := range pm.GetPasswordIntercepts() {
+		if pl.CallPasswordInterceptor(username, password, ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (pm *PluginManager) GetPasswordIntercepts() []*Plugin {
 	return pm.passwordPlugins
 }
+
+// OnEvent registers a handler that will receive the events of every session.
+func (pm *PluginManager) OnEvent(handler EventHandler) {
+	pm.eventHandlers = append(pm.eventHandlers, handler)
+}
+
+// emit passes an event to all of the event handlers.
+func (pm *PluginManager) emit(s *Session, event Event) {
+	for _, handler := range pm.eventHandlers {
+		handler(s, event)
+	}
+}
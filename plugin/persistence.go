@@ -0,0 +1,112 @@
+package plugin
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// persistencePath is a place (a glob) where a file makes something run again,
+// and the way that it does it.
+type persistencePath struct {
+	pattern   string
+	mechanism string
+}
+
+// persistencePaths are the files of the system that attackers write to so
+// that they come back, or so that their programs do after a reboot.
+var persistencePaths = []persistencePath{
+	{"/etc/crontab", "cron"},
+	{"/etc/anacrontab", "cron"},
+	{"/etc/cron.d/*", "cron"},
+	{"/etc/cron.hourly/*", "cron"},
+	{"/etc/cron.daily/*", "cron"},
+	{"/etc/cron.weekly/*", "cron"},
+	{"/etc/cron.monthly/*", "cron"},
+	{"/var/spool/cron/*", "cron"},
+	{"/var/spool/cron/crontabs/*", "cron"},
+	{"/etc/systemd/system/*", "systemd"},
+	{"/etc/systemd/system/*/*", "systemd"},
+	{"/lib/systemd/system/*", "systemd"},
+	{"/usr/lib/systemd/system/*", "systemd"},
+	{"/etc/rc.local", "init"},
+	{"/etc/init.d/*", "init"},
+	{"/etc/rc?.d/*", "init"},
+	{"/etc/profile", "profile"},
+	{"/etc/profile.d/*", "profile"},
+	{"/etc/bash.bashrc", "profile"},
+	{"/etc/ld.so.preload", "preload"},
+}
+
+// persistenceHomePaths are the same, but for the files in the home directory
+// of root or of any other user.
+var persistenceHomePaths = []persistencePath{
+	{".ssh/authorized_keys", "ssh-key"},
+	{".ssh/authorized_keys2", "ssh-key"},
+	{".bashrc", "profile"},
+	{".bash_profile", "profile"},
+	{".bash_login", "profile"},
+	{".bash_logout", "profile"},
+	{".profile", "profile"},
+	{".config/systemd/user/*", "systemd"},
+	{".config/systemd/user/*/*", "systemd"},
+}
+
+// persistenceMechanism returns how a file makes something run again (e.g.
+// "cron" for /etc/crontab), or an empty string if it doesn't.
+func persistenceMechanism(path string) string {
+	for _, p := range persistencePaths {
+		if ok, _ := filepath.Match(p.pattern, path); ok {
+			return p.mechanism
+		}
+	}
+
+	rel, ok := strings.CutPrefix(path, "/root/")
+
+	if !ok {
+		if rel, ok = strings.CutPrefix(path, "/home/"); ok {
+			_, rel, ok = strings.Cut(rel, "/")
+		}
+	}
+
+	if !ok {
+		return ""
+	}
+
+	for _, p := range persistenceHomePaths {
+		if ok, _ := filepath.Match(p.pattern, rel); ok {
+			return p.mechanism
+		}
+	}
+
+	return ""
+}
+
+// WatchPersistence makes the session emit a PersistenceEvent every time one of
+// the files that make something run again is written, whether it's from the
+// shell (e.g. `echo ... >> /etc/crontab`) or it was uploaded.
+func (s *Session) WatchPersistence() {
+	s.VFS.AddWriteHook(func(path string, contents []byte, source string) {
+		mechanism := persistenceMechanism(path)
+
+		if mechanism == "" {
+			return
+		}
+
+		command := s.command
+
+		// Redirections are written once the command has returned, which
+		// makes it the shell that wrote them.
+		if source == ArtifactSourceSFTP || source == ArtifactSourceSCP {
+			command = source
+		} else if command == "" {
+			command = "bash"
+		}
+
+		s.Emit(&PersistenceEvent{
+			Command:   command,
+			Mechanism: mechanism,
+			Path:      path,
+			Data:      contents,
+		})
+	})
+}
@@ -0,0 +1,468 @@
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+// These decide what a persona is derived from.
+const (
+	PersonaListener = "listener"
+	PersonaIP       = "ip"
+)
+
+// Distro is a Linux distribution that a persona can pretend to run, along
+// with what its kernels look like.
+type Distro struct {
+	ID         string
+	Name       string
+	Version    string
+	VersionID  string
+	Codename   string
+	PrettyName string
+	Issue      string
+	kernel     func(r *rand.Rand) (release, version, compiler string)
+}
+
+// distros are the distributions that personas are picked from.
+var distros = []Distro{
+	{
+		ID:         "ubuntu",
+		Name:       "Ubuntu",
+		Version:    "22.04.3 LTS (Jammy Jellyfish)",
+		VersionID:  "22.04",
+		Codename:   "jammy",
+		PrettyName: "Ubuntu 22.04.3 LTS",
+		Issue:      "Ubuntu 22.04.3 LTS \\n \\l",
+		kernel: func(r *rand.Rand) (string, string, string) {
+			abi := 56 + r.IntN(50)
+			return fmt.Sprintf("5.15.0-%d-generic", abi),
+				fmt.Sprintf("#%d-Ubuntu SMP %s", abi+10, buildDate(r, 2023)),
+				"(buildd@lcy02-amd64-045) (gcc (Ubuntu 11.4.0-1ubuntu1~22.04) 11.4.0, GNU ld (GNU Binutils for Ubuntu) 2.38)"
+		},
+	},
+	{
+		ID:         "ubuntu",
+		Name:       "Ubuntu",
+		Version:    "20.04.6 LTS (Focal Fossa)",
+		VersionID:  "20.04",
+		Codename:   "focal",
+		PrettyName: "Ubuntu 20.04.6 LTS",
+		Issue:      "Ubuntu 20.04.6 LTS \\n \\l",
+		kernel: func(r *rand.Rand) (string, string, string) {
+			abi := 100 + r.IntN(70)
+			return fmt.Sprintf("5.4.0-%d-generic", abi),
+				fmt.Sprintf("#%d-Ubuntu SMP %s", abi+10, buildDate(r, 2022)),
+				"(buildd@lcy02-amd64-080) (gcc version 9.4.0 (Ubuntu 9.4.0-1ubuntu1~20.04.2))"
+		},
+	},
+	{
+		ID:         "ubuntu",
+		Name:       "Ubuntu",
+		Version:    "24.04.1 LTS (Noble Numbat)",
+		VersionID:  "24.04",
+		Codename:   "noble",
+		PrettyName: "Ubuntu 24.04.1 LTS",
+		Issue:      "Ubuntu 24.04.1 LTS \\n \\l",
+		kernel: func(r *rand.Rand) (string, string, string) {
+			abi := 31 + r.IntN(20)
+			return fmt.Sprintf("6.8.0-%d-generic", abi),
+				fmt.Sprintf("#%d-Ubuntu SMP PREEMPT_DYNAMIC %s", abi+1, buildDate(r, 2024)),
+				"(buildd@lcy02-amd64-014) (x86_64-linux-gnu-gcc-13 (Ubuntu 13.2.0-23ubuntu4) 13.2.0, GNU ld (GNU Binutils for Ubuntu) 2.42)"
+		},
+	},
+	{
+		ID:         "debian",
+		Name:       "Debian GNU/Linux",
+		Version:    "12 (bookworm)",
+		VersionID:  "12",
+		Codename:   "bookworm",
+		PrettyName: "Debian GNU/Linux 12 (bookworm)",
+		Issue:      "Debian GNU/Linux 12 \\n \\l",
+		kernel: func(r *rand.Rand) (string, string, string) {
+			abi := 10 + r.IntN(12)
+			return fmt.Sprintf("6.1.0-%d-amd64", abi),
+				fmt.Sprintf("#1 SMP PREEMPT_DYNAMIC Debian 6.1.%d-1 (%s)", 50+abi*5, buildDay(r, 2023)),
+				"(debian-kernel@lists.debian.org) (gcc-12 (Debian 12.2.0-14) 12.2.0, GNU ld (GNU Binutils for Debian) 2.40)"
+		},
+	},
+	{
+		ID:         "debian",
+		Name:       "Debian GNU/Linux",
+		Version:    "11 (bullseye)",
+		VersionID:  "11",
+		Codename:   "bullseye",
+		PrettyName: "Debian GNU/Linux 11 (bullseye)",
+		Issue:      "Debian GNU/Linux 11 \\n \\l",
+		kernel: func(r *rand.Rand) (string, string, string) {
+			abi := 20 + r.IntN(10)
+			return fmt.Sprintf("5.10.0-%d-amd64", abi),
+				fmt.Sprintf("#1 SMP Debian 5.10.%d-1 (%s)", 150+abi*3, buildDay(r, 2023)),
+				"(debian-kernel@lists.debian.org) (gcc-10 (Debian 10.2.1-6) 10.2.1 20210110, GNU ld (GNU Binutils for Debian) 2.35.2)"
+		},
+	},
+	{
+		ID:         "centos",
+		Name:       "CentOS Linux",
+		Version:    "7 (Core)",
+		VersionID:  "7",
+		Codename:   "core",
+		PrettyName: "CentOS Linux 7 (Core)",
+		Issue:      "\\S\nKernel \\r on an \\m",
+		kernel: func(r *rand.Rand) (string, string, string) {
+			return fmt.Sprintf("3.10.0-1160.%d.1.el7.x86_64", 6+r.IntN(100)),
+				fmt.Sprintf("#1 SMP %s", buildDate(r, 2022)),
+				"(mockbuild@kbuilder.bsys.centos.org) (gcc version 4.8.5 20150623 (Red Hat 4.8.5-44) (GCC) )"
+		},
+	},
+}
+
+// buildDate returns a kernel build date in the format of `uname -v`.
+func buildDate(r *rand.Rand, year int) string {
+	date := time.Date(year, time.Month(1+r.IntN(12)), 1+r.IntN(28), r.IntN(24), r.IntN(60), r.IntN(60), 0, time.UTC)
+	return date.Format("Mon Jan 2 15:04:05 UTC 2006")
+}
+
+// buildDay returns a kernel build day, the way Debian puts it in `uname -v`.
+func buildDay(r *rand.Rand, year int) string {
+	return time.Date(year, time.Month(1+r.IntN(12)), 1+r.IntN(28), 0, 0, 0, 0, time.UTC).Format("2006-01-02")
+}
+
+// cloud is a hosting provider, which decides what the hostname, the addresses
+// and the hardware of a persona look like.
+type cloud struct {
+	vendor   string
+	product  string
+	hostname func(r *rand.Rand, p *Persona) string
+	ip       func(r *rand.Rand) string
+	mac      string
+}
+
+var hostWords = []string{"web", "app", "api", "db", "mail", "node", "srv", "prod", "dev", "backup", "vpn", "git", "build", "worker", "cache"}
+
+var clouds = []cloud{
+	{
+		vendor:  "Amazon EC2",
+		product: "t3.medium",
+		hostname: func(r *rand.Rand, p *Persona) string {
+			return "ip-" + strings.ReplaceAll(p.IP, ".", "-")
+		},
+		ip: func(r *rand.Rand) string {
+			return fmt.Sprintf("172.31.%d.%d", r.IntN(64), 1+r.IntN(254))
+		},
+		mac: "0a",
+	},
+	{
+		vendor:  "DigitalOcean",
+		product: "Droplet",
+		hostname: func(r *rand.Rand, p *Persona) string {
+			regions := []string{"nyc1", "nyc3", "sfo3", "ams3", "fra1", "lon1", "sgp1", "blr1", "tor1"}
+			return fmt.Sprintf("%s-s-%dvcpu-%dgb-%s-%02d", p.Distro.ID, p.Machine.CPUs, memGB(p.Machine), regions[r.IntN(len(regions))], 1+r.IntN(4))
+		},
+		ip: func(r *rand.Rand) string {
+			return fmt.Sprintf("10.%d.0.%d", 104+r.IntN(32), 2+r.IntN(250))
+		},
+	},
+	{
+		vendor:  "Hetzner",
+		product: "vServer",
+		hostname: func(r *rand.Rand, p *Persona) string {
+			regions := []string{"nbg1", "fsn1", "hel1", "ash"}
+			return fmt.Sprintf("%s-%dgb-%s-%d", p.Distro.ID, memGB(p.Machine), regions[r.IntN(len(regions))], 1+r.IntN(9))
+		},
+		ip: func(r *rand.Rand) string {
+			return fmt.Sprintf("10.0.0.%d", 2+r.IntN(250))
+		},
+	},
+	{
+		vendor:  "QEMU",
+		product: "Standard PC (i440FX + PIIX, 1996)",
+		hostname: func(r *rand.Rand, p *Persona) string {
+			return fmt.Sprintf("%s%02d", hostWords[r.IntN(len(hostWords))], 1+r.IntN(12))
+		},
+		ip: func(r *rand.Rand) string {
+			return fmt.Sprintf("192.168.%d.%d", []int{0, 1, 10, 100}[r.IntN(4)], 10+r.IntN(240))
+		},
+		mac: "52:54:00",
+	},
+	{
+		vendor:  "VMware, Inc.",
+		product: "VMware Virtual Platform",
+		hostname: func(r *rand.Rand, p *Persona) string {
+			return fmt.Sprintf("%s-%s-%d", hostWords[r.IntN(len(hostWords))], hostWords[r.IntN(len(hostWords))], 1+r.IntN(9))
+		},
+		ip: func(r *rand.Rand) string {
+			return fmt.Sprintf("10.%d.%d.%d", r.IntN(256), r.IntN(256), 10+r.IntN(240))
+		},
+		mac: "00:50:56",
+	},
+}
+
+// cpuModels are the CPUs that personas have, with their clock speed in MHz.
+var cpuModels = []struct {
+	model string
+	mhz   float64
+}{
+	{"Intel(R) Xeon(R) CPU E5-2680 v4 @ 2.40GHz", 2399.998},
+	{"Intel(R) Xeon(R) CPU E5-2650 v4 @ 2.20GHz", 2199.998},
+	{"Intel(R) Xeon(R) Gold 6140 CPU @ 2.30GHz", 2294.608},
+	{"Intel(R) Xeon(R) Platinum 8259CL CPU @ 2.50GHz", 2499.996},
+	{"DO-Regular", 2494.140},
+	{"AMD EPYC 7282 16-Core Processor", 2794.748},
+	{"AMD EPYC-Rome Processor", 2445.404},
+}
+
+// userNames are the regular users that a persona may have.
+var userNames = []string{"ubuntu", "admin", "deploy", "dev", "john", "mike", "alex", "david", "git", "www", "user", "support"}
+
+// Persona is everything that makes one honeypot look like a different machine
+// from the next one: its hostname, addresses, hardware, kernel and distro. It's
+// derived from a seed, so the same seed always gives the same persona.
+type Persona struct {
+	Seed     string
+	Hostname string
+	IP       string
+	MAC      string
+	Distro   Distro
+	Users    []string
+	Machine  *Machine
+}
+
+// NewPersona derives a persona from a seed. When `distroID` is set (like
+// "ubuntu"), only versions of that distro are picked, so that the persona
+// agrees with the rest of the VFS.
+func NewPersona(seed, distroID string) *Persona {
+	r := seededRand(seed)
+	candidates := []Distro{}
+
+	for _, distro := range distros {
+		if distroID == "" || distro.ID == distroID {
+			candidates = append(candidates, distro)
+		}
+	}
+
+	if len(candidates) == 0 {
+		candidates = distros
+	}
+
+	distro := candidates[r.IntN(len(candidates))]
+	provider := clouds[r.IntN(len(clouds))]
+	cpu := cpuModels[r.IntN(len(cpuModels))]
+	cpus := []int{1, 2, 2, 4, 4, 8}[r.IntN(6)]
+	gb := []int{1, 2, 4, 4, 8, 16}[r.IntN(6)]
+	release, version, compiler := distro.kernel(r)
+
+	// The machine is rebooted every 45 days or so, at a moment that depends on
+	// the seed, so that the uptime keeps going between connections.
+	period := 45 * 24 * time.Hour
+	offset := time.Duration(r.Int64N(int64(period)))
+	booted := time.Now().Truncate(period).Add(offset)
+
+	if booted.After(time.Now()) {
+		booted = booted.Add(-period)
+	}
+
+	mac := provider.mac
+
+	if mac == "" {
+		mac = fmt.Sprintf("%02x", 0x02|r.IntN(64)<<2)
+	}
+
+	for strings.Count(mac, ":") < 5 {
+		mac += fmt.Sprintf(":%02x", r.IntN(256))
+	}
+
+	p := &Persona{
+		Seed:   seed,
+		IP:     provider.ip(r),
+		MAC:    mac,
+		Distro: distro,
+		Machine: &Machine{
+			Booted:   booted,
+			CPUs:     cpus,
+			CPUModel: cpu.model,
+			CPUMHz:   cpu.mhz,
+			MemTotal: gb*1024*1024 - gb*40960 - r.IntN(20000),
+			Release:  release,
+			Version:  version,
+			Compiler: compiler,
+			Vendor:   provider.vendor,
+			Product:  provider.product,
+			MAC:      mac,
+		},
+	}
+	p.Hostname = provider.hostname(r, p)
+
+	for i := 0; i < 1+r.IntN(2); i++ {
+		if name := userNames[r.IntN(len(userNames))]; !strings.Contains(strings.Join(p.Users, " "), name) {
+			p.Users = append(p.Users, name)
+		}
+	}
+
+	return p
+}
+
+// seededRand returns a random number generator that always gives the same
+// numbers for the same seed.
+func seededRand(seed string) *rand.Rand {
+	sum := sha256.Sum256([]byte(seed))
+	return rand.New(rand.NewPCG(binary.BigEndian.Uint64(sum[:8]), binary.BigEndian.Uint64(sum[8:16])))
+}
+
+// memGB returns the memory of a machine in whole gigabytes.
+func memGB(m *Machine) int {
+	return (m.MemTotal + 512*1024) / (1024 * 1024)
+}
+
+// OSRelease returns the /etc/os-release of the persona's distro.
+func (d *Distro) OSRelease() string {
+	lines := []string{
+		fmt.Sprintf("PRETTY_NAME=%q", d.PrettyName),
+		fmt.Sprintf("NAME=%q", d.Name),
+		fmt.Sprintf("VERSION_ID=%q", d.VersionID),
+		fmt.Sprintf("VERSION=%q", d.Version),
+		"VERSION_CODENAME=" + d.Codename,
+		"ID=" + d.ID,
+	}
+
+	switch d.ID {
+	case "ubuntu":
+		lines = append(lines,
+			"ID_LIKE=debian",
+			`HOME_URL="https://www.ubuntu.com/"`,
+			`SUPPORT_URL="https://help.ubuntu.com/"`,
+			`BUG_REPORT_URL="https://bugs.launchpad.net/ubuntu/"`,
+			`PRIVACY_POLICY_URL="https://www.ubuntu.com/legal/terms-and-policies/privacy-policy"`,
+			"UBUNTU_CODENAME="+d.Codename,
+		)
+	case "debian":
+		lines = append(lines,
+			`HOME_URL="https://www.debian.org/"`,
+			`SUPPORT_URL="https://www.debian.org/support"`,
+			`BUG_REPORT_URL="https://bugs.debian.org/"`,
+		)
+	case "centos":
+		lines = append(lines,
+			`ID_LIKE="rhel fedora"`,
+			`ANSI_COLOR="0;31"`,
+			`CPE_NAME="cpe:/o:centos:centos:7"`,
+			`HOME_URL="https://www.centos.org/"`,
+			`BUG_REPORT_URL="https://bugs.centos.org/"`,
+		)
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// SetPersona makes the VFS look like the machine of the persona. If /proc is
+// already mounted, the processes of the machine are replaced with the ones of
+// the persona, so this has to happen before a session starts its shell.
+func (vfs *VFS) SetPersona(p *Persona) {
+	vfs.Persona = p
+	vfs.Machine = p.Machine
+
+	if vfs.procs != nil {
+		vfs.mountSystemProcesses()
+	}
+}
+
+// Hostname returns the hostname of the machine, which comes from the persona
+// or otherwise from /etc/hostname.
+func (vfs *VFS) Hostname() string {
+	if vfs.Persona != nil {
+		return vfs.Persona.Hostname
+	}
+
+	if contents, err := vfs.ReadFile("/etc/hostname"); err == nil && strings.TrimSpace(contents) != "" {
+		return strings.TrimSpace(contents)
+	}
+
+	return "localhost"
+}
+
+// localIP returns the address of the machine, which comes from the persona.
+func (vfs *VFS) localIP() string {
+	if vfs.Persona != nil {
+		return vfs.Persona.IP
+	}
+
+	return "127.0.1.1"
+}
+
+// DistroID returns the ID of the distro of the persona, or otherwise the one in
+// the /etc/os-release of the VFS.
+func (vfs *VFS) DistroID() string {
+	if vfs.Persona != nil {
+		return vfs.Persona.Distro.ID
+	}
+
+	contents, err := vfs.ReadFile("/etc/os-release")
+
+	if err != nil {
+		return ""
+	}
+
+	for _, line := range strings.Split(contents, "\n") {
+		if id, ok := strings.CutPrefix(line, "ID="); ok {
+			return strings.Trim(id, `"`)
+		}
+	}
+
+	return ""
+}
+
+// MountPersona turns the files that tell the identity of the machine (its
+// hostname and distro) into files that are generated from the persona of the
+// VFS that reads them, so that every session can have a persona of its own.
+// Files that tell the distro are only replaced if the VFS has them.
+func (vfs *VFS) MountPersona() {
+	files := map[string]func(p *Persona) string{
+		"/etc/hostname": func(p *Persona) string {
+			return p.Hostname + "\n"
+		},
+		"/etc/os-release": func(p *Persona) string {
+			return p.Distro.OSRelease()
+		},
+		"/etc/lsb-release": func(p *Persona) string {
+			d := p.Distro
+			return fmt.Sprintf("DISTRIB_ID=%s\nDISTRIB_RELEASE=%s\nDISTRIB_CODENAME=%s\nDISTRIB_DESCRIPTION=%q\n", d.Name, d.VersionID, d.Codename, d.PrettyName)
+		},
+		"/etc/issue": func(p *Persona) string {
+			return p.Distro.Issue + "\n\n"
+		},
+		"/etc/issue.net": func(p *Persona) string {
+			return strings.TrimSuffix(p.Distro.Issue, " \\n \\l") + "\n"
+		},
+		"/etc/centos-release": func(p *Persona) string {
+			return p.Distro.PrettyName + "\n"
+		},
+	}
+
+	for path, fn := range files {
+		realPath, file, err := vfs.FindFile(path)
+
+		if path == "/etc/hostname" && err != nil {
+			realPath, file, err = path, &VFSFile{Type: T_FILE, Mode: 0644}, nil
+		}
+
+		if err != nil || file.Type != T_FILE {
+			continue
+		}
+
+		// Without a persona, the file keeps what it had.
+		original := file.Contents
+		file.Contents = ""
+		file.Provider = ProviderFunc(func(vfs *VFS) string {
+			if vfs.Persona == nil {
+				return original
+			}
+
+			return fn(vfs.Persona)
+		})
+		vfs.mount(realPath, *file)
+	}
+}
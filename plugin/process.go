@@ -0,0 +1,501 @@
+package plugin
+
+import (
+	"errors"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNoProcess is returned when a signal is sent to a process that doesn't
+// exist.
+var ErrNoProcess = errors.New("No such process")
+
+// The signals that processes react to in some special way.
+const (
+	sigINT   = 2
+	sigQUIT  = 3
+	sigTERM  = 15
+	sigCHLD  = 17
+	sigCONT  = 18
+	sigSTOP  = 19
+	sigTSTP  = 20
+	sigTTIN  = 21
+	sigTTOU  = 22
+	sigURG   = 23
+	sigWINCH = 28
+)
+
+// signalNames are the names of the signals, without the "SIG", by their
+// number.
+var signalNames = []string{
+	"", "HUP", "INT", "QUIT", "ILL", "TRAP", "ABRT", "BUS", "FPE", "KILL", "USR1", "SEGV", "USR2", "PIPE", "ALRM", "TERM",
+	"STKFLT", "CHLD", "CONT", "STOP", "TSTP", "TTIN", "TTOU", "URG", "XCPU", "XFSZ", "VTALRM", "PROF", "WINCH", "IO", "PWR", "SYS",
+}
+
+// Process is a process of the machine, as /proc, ps and top show it. The CPU
+// is the share of a CPU that it uses (in percent), and VSZ and RSS are its
+// virtual and resident memory in kB. The State is like the STAT column of
+// `ps aux` (e.g. "Ss" or "R+").
+type Process struct {
+	PID     int
+	PPID    int
+	UID     int
+	Name    string
+	Exe     string
+	Cmdline []string
+	TTY     string
+	State   string
+	CPU     float64
+	VSZ     int
+	RSS     int
+	Started time.Time
+}
+
+// Command returns the command line of the process. Kernel threads don't have
+// one, so their name is shown in brackets instead, like ps does.
+func (p *Process) Command() string {
+	if len(p.Cmdline) == 0 {
+		return "[" + p.Name + "]"
+	}
+
+	return strings.Join(p.Cmdline, " ")
+}
+
+// Stat returns the state of the process, which is sleeping unless it says
+// otherwise.
+func (p *Process) Stat() string {
+	if p.State == "" {
+		return "S"
+	}
+
+	return p.State
+}
+
+// Terminal returns the terminal of the process, or "?" if it has none.
+func (p *Process) Terminal() string {
+	if p.TTY == "" {
+		return "?"
+	}
+
+	return p.TTY
+}
+
+// CPUTime returns how long the process has spent on a CPU since it started.
+func (p *Process) CPUTime() time.Duration {
+	return time.Duration(float64(time.Since(p.Started)) * p.CPU / 100)
+}
+
+// isKernelThread returns whether the process is a thread of the kernel, which
+// nobody can kill.
+func (p *Process) isKernelThread() bool {
+	return len(p.Cmdline) == 0 && p.PID > 1
+}
+
+// parseSignal parses a signal by its number or its name, with or without the
+// "SIG" in front of it.
+func parseSignal(name string) (int, bool) {
+	if n, err := strconv.Atoi(name); err == nil {
+		return n, n >= 0 && n <= 64
+	}
+
+	name = strings.TrimPrefix(strings.ToUpper(name), "SIG")
+
+	if name == "POLL" {
+		return 29, true
+	}
+
+	i := slices.Index(signalNames[1:], name)
+
+	return i + 1, i >= 0
+}
+
+// signalName returns the name of a signal, without the "SIG".
+func signalName(n int) string {
+	switch {
+	case n > 0 && n < len(signalNames):
+		return signalNames[n]
+	case n == 34:
+		return "RTMIN"
+	case n > 34 && n <= 49:
+		return "RTMIN+" + strconv.Itoa(n-34)
+	case n >= 50 && n < 64:
+		return "RTMAX-" + strconv.Itoa(64-n)
+	case n == 64:
+		return "RTMAX"
+	}
+
+	return strconv.Itoa(n)
+}
+
+// Processes returns the processes of the machine, ordered by their PIDs.
+func (vfs *VFS) Processes() []*Process {
+	return slices.SortedFunc(maps.Values(vfs.procs), func(a, b *Process) int {
+		return a.PID - b.PID
+	})
+}
+
+// FindProcess returns the process with a PID, or nil if there's none.
+func (vfs *VFS) FindProcess(pid int) *Process {
+	return vfs.procs[pid]
+}
+
+// processUser returns the name of the user that a process runs as, or its UID
+// when the user isn't in /etc/passwd. Like with NSS, the UID 0 is always root,
+// since init and the kernel threads showing up as "0" would give it away.
+func (vfs *VFS) processUser(p *Process) string {
+	if user, err := vfs.LookupUID(p.UID); err == nil {
+		return user.Username
+	} else if login := vfs.login(); login != nil && login.UID == p.UID {
+		return login.Username
+	} else if p.UID == 0 {
+		return "root"
+	}
+
+	return strconv.Itoa(p.UID)
+}
+
+// Kill sends a signal to a process, like kill(2), as the user that the session
+// acts as. Like on Linux, init and the kernel threads can't be killed, and the
+// shell ignores the signals that an interactive bash ignores. Killing the shell
+// (or the sshd that it's running under) ends it.
+func (s *Session) Kill(pid, signal int) error {
+	p := s.VFS.FindProcess(pid)
+
+	if p == nil {
+		return ErrNoProcess
+	} else if user := s.VFS.User; user != nil && !user.IsRoot() && user.UID != p.UID {
+		return ErrNotPermitted
+	} else if signal == 0 || pid == 1 || p.isKernelThread() {
+		return nil
+	}
+
+	switch signal {
+	case sigSTOP, sigTSTP, sigTTIN, sigTTOU, sigCONT:
+		if pid == s.PID {
+			return nil
+		}
+
+		// The process may be shared with other sessions, so it's copied.
+		stopped := *p
+		stopped.State = "T"
+
+		if signal == sigCONT {
+			stopped.State = "S"
+		}
+
+		s.VFS.procs[pid] = &stopped
+		return nil
+	case sigCHLD, sigURG, sigWINCH:
+		return nil
+	}
+
+	if pid == s.PID {
+		if signal != sigTERM && signal != sigINT && signal != sigQUIT {
+			s.ExitShell()
+		}
+
+		return nil
+	} else if s.isAncestor(pid) {
+		s.exited = true
+		return nil
+	}
+
+	s.VFS.UnmountProcess(pid)
+	s.reapJob(pid, 128+signal)
+
+	return nil
+}
+
+// isAncestor returns whether a process is one that the shell of the session
+// runs under, like the sshd of the connection, but not the sshd that listens
+// for new ones.
+func (s *Session) isAncestor(pid int) bool {
+	for p := s.VFS.FindProcess(s.PID); p != nil && p.PPID > 1; {
+		if p.PPID == pid {
+			parent := s.VFS.FindProcess(pid)
+			return parent != nil && parent.PPID > 1
+		}
+
+		p = s.VFS.FindProcess(p.PPID)
+	}
+
+	return false
+}
+
+// daemon is a service that's running on the machine since it booted.
+type daemon struct {
+	user    string
+	cmdline string
+	name    string
+	exe     string
+	state   string
+	tty     string
+	vsz     int
+	rss     int
+	cpu     float64
+}
+
+// kernelThreads are the threads of the kernel that ps shows, with their state.
+// The ones that end in a slash run on every CPU.
+var kernelThreads = [][2]string{
+	{"rcu_gp", "I<"}, {"rcu_par_gp", "I<"}, {"slub_flushwq", "I<"}, {"netns", "I<"},
+	{"kworker/0:0H-events_highpri", "I<"}, {"mm_percpu_wq", "I<"}, {"rcu_tasks_rude_", "S"},
+	{"rcu_tasks_trace", "S"}, {"ksoftirqd/", "S"}, {"rcu_sched", "I"}, {"migration/", "S"},
+	{"idle_inject/", "S"}, {"cpuhp/", "S"}, {"kdevtmpfs", "S"}, {"inet_frag_wq", "I<"},
+	{"kauditd", "S"}, {"khungtaskd", "S"}, {"oom_reaper", "S"}, {"writeback", "I<"},
+	{"kcompactd0", "S"}, {"ksmd", "SN"}, {"khugepaged", "SN"}, {"kintegrityd", "I<"},
+	{"kblockd", "I<"}, {"blkcg_punt_bio", "I<"}, {"tpm_dev_wq", "I<"}, {"ata_sff", "I<"},
+	{"md", "I<"}, {"edac-poller", "I<"}, {"devfreq_wq", "I<"}, {"watchdogd", "S"},
+	{"kswapd0", "S"}, {"kthrotld", "I<"}, {"acpi_thermal_pm", "I<"}, {"scsi_eh_0", "S"},
+	{"scsi_tmf_0", "I<"}, {"mld", "I<"}, {"ipv6_addrconf", "I<"}, {"kstrp", "I<"},
+	{"charger_manager", "I<"}, {"jbd2/vda1-8", "S"}, {"ext4-rsv-conver", "I<"},
+	{"kworker/u4:1-events_unbound", "I"}, {"kworker/0:1-events", "I"},
+}
+
+// daemons are the services of each distro.
+var daemons = map[string][]daemon{
+	"ubuntu": {
+		{cmdline: "/lib/systemd/systemd-journald", state: "S<s", vsz: 48284, rss: 15232},
+		{cmdline: "/sbin/multipathd -d -s", state: "SLsl", vsz: 289320, rss: 27108},
+		{cmdline: "/lib/systemd/systemd-udevd", state: "Ss", vsz: 25232, rss: 6332},
+		{user: "systemd-network", cmdline: "/lib/systemd/systemd-networkd", vsz: 16124, rss: 8028, state: "Ss"},
+		{user: "systemd-resolve", cmdline: "/lib/systemd/systemd-resolved", vsz: 25528, rss: 12592, state: "Ss"},
+		{user: "systemd-timesync", cmdline: "/lib/systemd/systemd-timesyncd", vsz: 89356, rss: 6536, state: "Ssl"},
+		{cmdline: "/usr/sbin/cron -f -P", state: "Ss", vsz: 7288, rss: 2728},
+		{user: "messagebus", cmdline: "@dbus-daemon --system --address=systemd: --nofork --nopidfile --systemd-activation --syslog-only", name: "dbus-daemon", exe: "/usr/bin/dbus-daemon", state: "Ss", vsz: 8776, rss: 4888},
+		{cmdline: "/usr/sbin/irqbalance --foreground", state: "Ssl", vsz: 82836, rss: 3956},
+		{cmdline: "/usr/bin/python3 /usr/bin/networkd-dispatcher --run-startup-triggers", state: "Ss", vsz: 32628, rss: 18992},
+		{cmdline: "/usr/libexec/polkitd --no-debug", state: "Ssl", vsz: 234488, rss: 7384},
+		{user: "syslog", cmdline: "/usr/sbin/rsyslogd -n -iNONE", state: "Ssl", vsz: 222404, rss: 5912},
+		{cmdline: "/usr/lib/snapd/snapd", state: "Ssl", vsz: 1538752, rss: 31940, cpu: 0.1},
+		{cmdline: "/lib/systemd/systemd-logind", state: "Ss", vsz: 15332, rss: 7544},
+		{cmdline: "/usr/libexec/udisks2/udisksd", state: "Ssl", vsz: 392696, rss: 13196},
+		{cmdline: "/usr/bin/python3 /usr/share/unattended-upgrades/unattended-upgrade-shutdown --wait-for-signal", state: "Ssl", vsz: 110084, rss: 21340},
+		{cmdline: "/sbin/agetty -o -p -- \\u --keep-baud 115200,57600,38400,9600 ttyS0 vt220", state: "Ss+", tty: "ttyS0", vsz: 6220, rss: 2072},
+		{cmdline: "/sbin/agetty -o -p -- \\u --noclear tty1 linux", state: "Ss+", tty: "tty1", vsz: 6176, rss: 1904},
+		{cmdline: "sshd: /usr/sbin/sshd -D [listener] 0 of 10-100 startups", name: "sshd", exe: "/usr/sbin/sshd", state: "Ss", vsz: 15432, rss: 9064},
+	},
+	"debian": {
+		{cmdline: "/lib/systemd/systemd-journald", state: "Ss", vsz: 41228, rss: 11272},
+		{cmdline: "/lib/systemd/systemd-udevd", state: "Ss", vsz: 25272, rss: 6008},
+		{user: "systemd-timesync", cmdline: "/lib/systemd/systemd-timesyncd", state: "Ssl", vsz: 90208, rss: 6400},
+		{cmdline: "/sbin/dhclient -4 -v -i -pf /run/dhclient.eth0.pid -lf /var/lib/dhcp/dhclient.eth0.leases -I -df /var/lib/dhcp/dhclient6.eth0.leases eth0", state: "Ss", vsz: 5944, rss: 3312},
+		{cmdline: "/usr/sbin/cron -f", state: "Ss", vsz: 6612, rss: 2720},
+		{user: "messagebus", cmdline: "/usr/bin/dbus-daemon --system --address=systemd: --nofork --nopidfile --systemd-activation --syslog-only", state: "Ss", vsz: 8328, rss: 4412},
+		{cmdline: "/usr/sbin/rsyslogd -n -iNONE", state: "Ssl", vsz: 222216, rss: 4928},
+		{cmdline: "/lib/systemd/systemd-logind", state: "Ss", vsz: 16300, rss: 7056},
+		{cmdline: "/sbin/agetty -o -p -- \\u --keep-baud 115200,57600,38400,9600 ttyS0 vt220", state: "Ss+", tty: "ttyS0", vsz: 5872, rss: 1732},
+		{cmdline: "/sbin/agetty -o -p -- \\u --noclear - linux", state: "Ss+", tty: "tty1", vsz: 5828, rss: 1704},
+		{cmdline: "sshd: /usr/sbin/sshd -D [listener] 0 of 10-100 startups", name: "sshd", exe: "/usr/sbin/sshd", state: "Ss", vsz: 15408, rss: 9172},
+	},
+	"centos": {
+		{cmdline: "/usr/lib/systemd/systemd-journald", state: "Ss", vsz: 39080, rss: 6364},
+		{cmdline: "/usr/sbin/lvmetad -f", state: "Ss", vsz: 124932, rss: 1932},
+		{cmdline: "/usr/lib/systemd/systemd-udevd", state: "Ss", vsz: 46856, rss: 2312},
+		{cmdline: "/sbin/auditd", state: "S<sl", vsz: 55532, rss: 1092},
+		{user: "polkitd", cmdline: "/usr/lib/polkit-1/polkitd --no-debug", state: "Ssl", vsz: 612236, rss: 13416},
+		{user: "dbus", cmdline: "/usr/bin/dbus-daemon --system --address=systemd: --nofork --nopidfile --systemd-activation", state: "Ssl", vsz: 58112, rss: 2412},
+		{user: "chrony", cmdline: "/usr/sbin/chronyd", state: "S", vsz: 117812, rss: 1832},
+		{cmdline: "/usr/lib/systemd/systemd-logind", state: "Ss", vsz: 26384, rss: 1776},
+		{cmdline: "/usr/sbin/irqbalance --foreground", state: "Ss", vsz: 21692, rss: 1320},
+		{cmdline: "/usr/sbin/gssproxy -D", state: "Ssl", vsz: 201428, rss: 1316},
+		{cmdline: "/usr/sbin/NetworkManager --no-daemon", state: "Ssl", vsz: 476464, rss: 9364},
+		{cmdline: "/usr/bin/python2 -Es /usr/sbin/tuned -l -P", state: "Ssl", vsz: 574284, rss: 17448, cpu: 0.1},
+		{cmdline: "/usr/sbin/rsyslogd -n", state: "Ssl", vsz: 216408, rss: 4412},
+		{cmdline: "/usr/sbin/sshd -D", state: "Ss", vsz: 112920, rss: 4316},
+		{cmdline: "/usr/sbin/crond -n", state: "Ss", vsz: 126388, rss: 1616},
+		{cmdline: "/sbin/agetty --noclear tty1 linux", state: "Ss+", tty: "tty1", vsz: 110208, rss: 852},
+		{cmdline: "/usr/libexec/postfix/master -w", state: "Ss", vsz: 89808, rss: 2092},
+		{user: "postfix", cmdline: "qmgr -l -t unix -u", exe: "/usr/libexec/postfix/qmgr", state: "S", vsz: 90048, rss: 4004},
+	},
+}
+
+// vendorDaemons are the agents that each hosting provider (or hypervisor) runs
+// on its machines.
+var vendorDaemons = map[string][]daemon{
+	"Amazon EC2":   {{cmdline: "/usr/bin/amazon-ssm-agent", state: "Ssl", vsz: 1323236, rss: 18024}},
+	"DigitalOcean": {{cmdline: "/opt/digitalocean/bin/droplet-agent -syslog", state: "Ssl", vsz: 1251776, rss: 9876}},
+	"QEMU":         {{cmdline: "/usr/sbin/qemu-ga", state: "Ss", vsz: 80020, rss: 3512}},
+	"VMware, Inc.": {{cmdline: "/usr/bin/VGAuthService", state: "Ss", vsz: 50496, rss: 10832}, {cmdline: "/usr/bin/vmtoolsd", state: "Ssl", vsz: 242144, rss: 8624, cpu: 0.1}},
+}
+
+// systemProcesses makes up the processes that the machine has been running
+// since it booted: init, the kernel threads and the services of its distro.
+// With a persona, they're always the same for the same seed.
+func (vfs *VFS) systemProcesses() []*Process {
+	m := vfs.machine()
+	r := rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+	distro := vfs.DistroID()
+
+	if vfs.Persona != nil {
+		r = seededRand(vfs.Persona.Seed + "|processes")
+	}
+
+	if _, ok := daemons[distro]; !ok {
+		distro = "debian"
+	}
+
+	uid := func(name string) int {
+		if user, err := vfs.LookupUser(name); err == nil {
+			return user.UID
+		}
+
+		return 0
+	}
+
+	initProc := &Process{
+		PID:     1,
+		Name:    "systemd",
+		Exe:     "/usr/lib/systemd/systemd",
+		Cmdline: []string{"/sbin/init"},
+		State:   "Ss",
+		VSZ:     167000 + r.IntN(2000),
+		RSS:     11000 + r.IntN(2000),
+		Started: m.Booted,
+	}
+
+	if distro == "centos" {
+		initProc.Cmdline = strings.Fields("/usr/lib/systemd/systemd --switched-root --system --deserialize 22")
+	}
+
+	kthreadd := &Process{PID: 2, Name: "kthreadd", Started: m.Booted}
+	procs := []*Process{initProc, kthreadd}
+	pid := 3
+
+	for _, thread := range kernelThreads {
+		cpus := 1
+
+		if strings.HasSuffix(thread[0], "/") {
+			cpus = max(m.CPUs, 1)
+		}
+
+		for cpu := range cpus {
+			name := thread[0]
+
+			if strings.HasSuffix(name, "/") {
+				name += strconv.Itoa(cpu)
+			}
+
+			procs = append(procs, &Process{PID: pid, PPID: 2, Name: name, State: thread[1], Started: m.Booted})
+			pid += 1 + r.IntN(2)
+		}
+	}
+
+	services := append(slices.Clone(daemons[distro]), vendorDaemons[m.Vendor]...)
+	pid = max(pid, 150) + r.IntN(200)
+	started := m.Booted.Add(time.Duration(2+r.IntN(3)) * time.Second)
+
+	for _, d := range services {
+		args := strings.Fields(d.cmdline)
+		name, exe := d.name, d.exe
+
+		if exe == "" {
+			exe = args[0]
+		}
+
+		if name == "" {
+			name = exe[strings.LastIndex(exe, "/")+1:]
+		}
+
+		procs = append(procs, &Process{
+			PID:     pid,
+			PPID:    1,
+			UID:     uid(d.user),
+			Name:    truncateComm(name),
+			Exe:     exe,
+			Cmdline: args,
+			TTY:     d.tty,
+			State:   d.state,
+			CPU:     d.cpu + r.Float64()/50,
+			VSZ:     d.vsz + r.IntN(64)*4,
+			RSS:     d.rss + r.IntN(128)*4,
+			Started: started,
+		})
+		pid += 1 + r.IntN(25)
+		started = started.Add(time.Duration(r.IntN(800)) * time.Millisecond)
+	}
+
+	// Someone else that uses the machine may have left a tmux running.
+	if user := vfs.otherUser(r); user != nil && r.IntN(2) == 0 {
+		since := time.Duration(r.Int64N(int64(time.Since(m.Booted)) + 1))
+		tmux := &Process{
+			PID:     pid,
+			PPID:    1,
+			UID:     user.UID,
+			Name:    "tmux: server",
+			Exe:     "/usr/bin/tmux",
+			Cmdline: []string{"tmux"},
+			State:   "Ss",
+			VSZ:     11632 + r.IntN(512)*4,
+			RSS:     3800 + r.IntN(512)*4,
+			Started: time.Now().Add(-since),
+		}
+		procs = append(procs, tmux, &Process{
+			PID:     pid + 1,
+			PPID:    pid,
+			UID:     user.UID,
+			Name:    "bash",
+			Exe:     "/usr/bin/bash",
+			Cmdline: []string{"-bash"},
+			TTY:     "pts/1",
+			State:   "Ss+",
+			VSZ:     8964 + r.IntN(64)*4,
+			RSS:     5400 + r.IntN(64)*4,
+			Started: tmux.Started,
+		})
+	}
+
+	return procs
+}
+
+// otherUser picks one of the regular users of the machine, preferring the
+// ones of the persona.
+func (vfs *VFS) otherUser(r *rand.Rand) *User {
+	if vfs.Persona != nil {
+		for _, name := range vfs.Persona.Users {
+			if user, err := vfs.LookupUser(name); err == nil {
+				return user
+			}
+		}
+	}
+
+	users := []User{}
+
+	for _, user := range vfs.Users() {
+		if user.UID >= 1000 && user.UID < 65534 {
+			users = append(users, user)
+		}
+	}
+
+	if len(users) == 0 {
+		return nil
+	}
+
+	return &users[r.IntN(len(users))]
+}
+
+// mountSystemProcesses replaces all of the processes of the machine with the
+// ones that it's running since it booted.
+func (vfs *VFS) mountSystemProcesses() {
+	for pid := range vfs.procs {
+		vfs.UnmountProcess(pid)
+	}
+
+	for _, p := range vfs.systemProcesses() {
+		vfs.MountProcess(p)
+	}
+}
+
+// truncateComm cuts a name down to the 15 characters that the kernel keeps
+// for the name of a process.
+func truncateComm(name string) string {
+	if len(name) > 15 {
+		return name[:15]
+	}
+
+	return name
+}
@@ -1,50 +1,285 @@
 package plugin
 
 import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
 	"golang.org/x/term"
 )
 
 type Session struct {
-	VFS     *VFS
-	Term    *term.Terminal
-	Manager *PluginManager
-	pwd     string
-	User    *User
+	ID           string
+	IP           string
+	PID          int
+	VFS          *VFS
+	Term         *term.Terminal
+	Manager      *PluginManager
+	pwd          string
+	User         *User
+	Password     string
+	Channel      io.Reader
+	Interactive  bool
+	tty          string
+	prompt       string
+	keys         []byte
+	command      string
+	stdin        io.Reader
+	stdout       io.Writer
+	stderr       io.Writer
+	status       int
+	shells       []subshell
+	sudoUntil    time.Time
+	jobs         []*job
+	job          *job
+	execing      bool
+	scripts      int
+	exiting      bool
+	exited       bool
+	vars         map[string]string
+	exported     map[string]bool
+	functions    map[string]shellNode
+	args         []string
+	locals       []map[string]*string
+	lastJob      int
+	substituted  bool
+	expandFailed bool
+	runs         int
+	steps        int
+	aborted      bool
+	loops        int
+	breaking     int
+	continuing   int
+	returnable   int
+	returning    bool
+	tabbed       bool
+	history      []string
+	historyStart int
 }
 
-func (s *Session) AutoCompleteCallback(line string, pos int, key rune) (newLine string, newPos int, ok bool) {
-	if key == 9 {
-		_, matchingCmds := s.Manager.MatchCommand(line)
+// subshell is what a session goes back to when a shell that was started by
+// `su` or `sudo -i` exits.
+type subshell struct {
+	login     bool
+	user      *User
+	vfsUser   *User
+	vfsLogin  *User
+	pwd       string
+	pid       int
+	sudoUntil time.Time
+}
 
-		for _, cmd := range matchingCmds {
-			s.Term.Write([]byte(cmd))
-		}
+// TermWrite writes to the output of the current command, which is the
+// terminal unless it was redirected or piped.
+func (s *Session) TermWrite(data ...string) {
+	out := s.stdoutWriter()
 
-		s.Term.Write([]byte("\n"))
+	for _, v := range data {
+		out.Write([]byte(v))
 	}
-
-	return line, pos, ok
 }
 
-func (s *Session) TermWrite(data ...string) {
+// ErrWrite writes to the error output of the current command.
+func (s *Session) ErrWrite(data ...string) {
+	out := s.stderrWriter()
+
 	for _, v := range data {
-		s.Term.Write([]byte(v))
+		out.Write([]byte(v))
 	}
 }
 
 func (s *Session) Chdir(newPath string) error {
-	path, _, err := s.VFS.FindFile(newPath)
-
-	if err != nil {
+	if _, _, err := s.VFS.FindFile(newPath); err != nil {
 		return err
 	}
 
+	// Like bash, the working directory keeps the symbolic links it went
+	// through.
+	path := s.VFS.internalPath(newPath)
+
 	s.VFS.PWD = path
 	s.pwd = path
 
 	return nil
 }
 
+// StartShell gives the shell of the session a process in /proc, which is what
+// /proc/self points to, under the sshd processes of the connection.
+func (s *Session) StartShell() {
+	uid := 1000
+	name := "{}"
+
+	if s.User != nil {
+		uid, name = s.User.UID, s.User.Username
+	}
+
+	listener := 1
+
+	for _, p := range s.VFS.Processes() {
+		if p.Name == "sshd" && p.PPID == 1 {
+			listener = p.PID
+			break
+		}
+	}
+
+	now := time.Now()
+	priv := &Process{
+		PID:     s.VFS.NextPID(),
+		PPID:    listener,
+		Name:    "sshd",
+		Exe:     "/usr/sbin/sshd",
+		Cmdline: []string{"sshd: " + name + " [priv]"},
+		State:   "Ss",
+		VSZ:     17040,
+		RSS:     10880,
+		Started: now,
+	}
+	sshd := &Process{
+		PID:     s.VFS.NextPID(),
+		PPID:    priv.PID,
+		UID:     uid,
+		Name:    "sshd",
+		Exe:     "/usr/sbin/sshd",
+		Cmdline: []string{"sshd: " + name + "@notty"},
+		State:   "S",
+		VSZ:     17304,
+		RSS:     7012,
+		Started: now,
+	}
+	s.PID = s.VFS.NextPID()
+	s.VFS.MountProcess(priv)
+	s.VFS.MountProcess(sshd)
+	s.VFS.MountProcess(&Process{
+		PID:     s.PID,
+		PPID:    sshd.PID,
+		UID:     uid,
+		Name:    "bash",
+		Exe:     "/usr/bin/bash",
+		Cmdline: []string{"-bash"},
+		State:   "Ss",
+		VSZ:     8964,
+		RSS:     5376,
+		Started: now,
+	})
+	s.VFS.SetProcSelf(s.PID)
+}
+
+// OpenTTY gives the session a pseudo terminal (the first one that no other
+// process is using), once the client asks for one.
+func (s *Session) OpenTTY() {
+	for n := 0; s.tty == ""; n++ {
+		tty := fmt.Sprintf("pts/%d", n)
+
+		if !slices.ContainsFunc(s.VFS.Processes(), func(p *Process) bool { return p.TTY == tty }) {
+			s.tty = tty
+		}
+	}
+
+	if shell := s.VFS.FindProcess(s.PID); shell != nil {
+		shell.TTY = s.tty
+		shell.State = "Ss+"
+
+		if sshd := s.VFS.FindProcess(shell.PPID); sshd != nil && sshd.Name == "sshd" {
+			sshd.Cmdline = []string{strings.Replace(sshd.Cmdline[0], "@notty", "@"+s.tty, 1)}
+		}
+	}
+}
+
+// SwitchUser starts a shell as another user, like `su` does, which the session
+// acts as until it exits. With `login`, the shell starts in the home directory
+// of the user, like `su -`.
+func (s *Session) SwitchUser(user *User, login bool) {
+	s.shells = append(s.shells, subshell{
+		login:     login,
+		user:      s.User,
+		vfsUser:   s.VFS.User,
+		vfsLogin:  s.VFS.Login,
+		pwd:       s.pwd,
+		pid:       s.PID,
+		sudoUntil: s.sudoUntil,
+	})
+
+	if s.VFS.Login == nil {
+		s.VFS.Login = s.VFS.User
+	}
+
+	s.User = user
+	s.VFS.User = user
+	s.sudoUntil = time.Time{}
+
+	cmdline := []string{"bash"}
+
+	if login {
+		cmdline = []string{"-bash"}
+	}
+
+	parent := s.PID
+	s.PID = s.VFS.NextPID()
+	s.VFS.MountProcess(&Process{
+		PID:     s.PID,
+		PPID:    parent,
+		UID:     user.UID,
+		Name:    "bash",
+		Exe:     "/usr/bin/bash",
+		Cmdline: cmdline,
+		TTY:     s.tty,
+		VSZ:     8964,
+		RSS:     5248,
+		Started: time.Now(),
+	})
+	s.VFS.SetProcSelf(s.PID)
+
+	if login && user.Home != "" {
+		s.Chdir(user.Home)
+	}
+}
+
+// ExitShell ends the shell that SwitchUser started last, which puts the
+// session back to the user it was before. Without such a shell, the session
+// itself is over.
+func (s *Session) ExitShell() {
+	if len(s.shells) == 0 {
+		s.exited = true
+		return
+	}
+
+	prev := s.shells[len(s.shells)-1]
+	s.shells = s.shells[:len(s.shells)-1]
+
+	s.VFS.UnmountProcess(s.PID)
+	s.User = prev.user
+	s.VFS.User = prev.vfsUser
+	s.VFS.Login = prev.vfsLogin
+	s.PID = prev.pid
+	s.sudoUntil = prev.sudoUntil
+	s.VFS.SetProcSelf(s.PID)
+	s.Chdir(prev.pwd)
+}
+
+// Exited returns whether the attacker ended the session (e.g. with `exit`).
+func (s *Session) Exited() bool {
+	return s.exited
+}
+
+// home returns the home directory of the user that the session acts as.
+func (s *Session) home() string {
+	if s.VFS.Login != nil && s.User != nil && s.User.Home != "" {
+		return s.User.Home
+	}
+
+	return s.VFS.userPath(s.VFS.Home)
+}
+
 func (s *Session) GetPWD() string {
 	return s.pwd
 }
+
+// Emit sends an event about something that happened in this session to the
+// event handlers of the plugin manager.
+func (s *Session) Emit(event Event) {
+	if s.Manager != nil {
+		s.Manager.emit(s, event)
+	}
+}
@@ -0,0 +1,233 @@
+package plugin
+
+import (
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// noHistory keeps the answers to prompts out of the history of the terminal,
+// since bash doesn't remember what `read` reads either.
+type noHistory struct{}
+
+func (noHistory) Add(string)    {}
+func (noHistory) Len() int      { return 0 }
+func (noHistory) At(int) string { return "" }
+
+// SetPrompt sets the prompt of the shell, which the terminal goes back to
+// after a command reads a line with a prompt of its own.
+func (s *Session) SetPrompt(prompt string) {
+	s.prompt = prompt
+
+	if s.Term != nil {
+		s.Term.SetPrompt(prompt)
+	}
+}
+
+// ReadLine reads a line of input for the command that's running. Like `read`,
+// the input was either piped or redirected into the command, or the attacker
+// types it in the terminal after the prompt.
+func (s *Session) ReadLine(prompt string) (string, error) {
+	line, err := s.readInput(prompt, false)
+
+	if err == nil {
+		s.logInput(prompt, line, "line")
+	}
+
+	return line, err
+}
+
+// ReadPassword is like ReadLine, but what the attacker types isn't echoed.
+func (s *Session) ReadPassword(prompt string) (string, error) {
+	line, err := s.readInput(prompt, true)
+
+	if err == nil {
+		s.logInput(prompt, line, "password")
+	}
+
+	return line, err
+}
+
+// Confirm asks a yes or no question, like the `[y/N]` prompts of apt or rm -i.
+// An empty answer picks the default, and anything else but yes is a no.
+func (s *Session) Confirm(prompt string, def bool) bool {
+	answer, err := s.ReadLine(prompt)
+
+	if err != nil {
+		return false
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "":
+		return def
+	case "y", "yes":
+		return true
+	}
+
+	return false
+}
+
+// ReadKey reads a single key press, without waiting for enter and without
+// echoing it, like programs that put the terminal in raw mode do (e.g. `less`
+// or a "press any key" prompt). Special keys come back as their escape
+// sequence (e.g. "\x1b[A" for the up arrow).
+func (s *Session) ReadKey() (string, error) {
+	var key string
+	var err error
+
+	if s.stdin != nil {
+		key, err = readRune(s.stdin)
+	} else {
+		key, err = s.readRawKey()
+	}
+
+	if err == nil {
+		s.logInput("", key, "key")
+	}
+
+	return key, err
+}
+
+// readInput reads a line from the input of the command, or from the terminal
+// when nothing was piped or redirected into it.
+func (s *Session) readInput(prompt string, hidden bool) (string, error) {
+	if s.stdin != nil {
+		return s.readStdinLine()
+	} else if hidden {
+		return s.readPassword(prompt)
+	}
+
+	if s.Term == nil {
+		return "", io.EOF
+	}
+
+	history, autoComplete := s.Term.History, s.Term.AutoCompleteCallback
+	s.Term.History, s.Term.AutoCompleteCallback = noHistory{}, nil
+	s.Term.SetPrompt(prompt)
+
+	defer func() {
+		s.Term.History, s.Term.AutoCompleteCallback = history, autoComplete
+		s.Term.SetPrompt(s.prompt)
+	}()
+
+	return s.Term.ReadLine()
+}
+
+// readPassword reads a line from the terminal without echoing it.
+func (s *Session) readPassword(prompt string) (string, error) {
+	if s.Term == nil {
+		return "", io.EOF
+	}
+
+	return s.Term.ReadPassword(prompt)
+}
+
+// readStdinLine reads a single line from the input of the command, leaving the
+// rest of it for whatever reads it next.
+func (s *Session) readStdinLine() (string, error) {
+	if s.stdin == nil {
+		return "", io.EOF
+	}
+
+	line := []byte{}
+	buf := make([]byte, 1)
+
+	for {
+		n, err := s.stdin.Read(buf)
+
+		if n > 0 && buf[0] == '\n' {
+			return string(line), nil
+		} else if n > 0 {
+			line = append(line, buf[0])
+		}
+
+		if err != nil {
+			if len(line) > 0 {
+				return string(line), nil
+			}
+
+			return "", err
+		}
+	}
+}
+
+// readRawKey reads the next key from the channel of the session, bypassing the
+// line editing of the terminal. Whatever arrived along with the key (e.g. when
+// something was pasted) is kept for the next call.
+func (s *Session) readRawKey() (string, error) {
+	if len(s.keys) == 0 {
+		if s.Channel == nil {
+			return "", io.EOF
+		}
+
+		buf := make([]byte, 256)
+		n, err := s.Channel.Read(buf)
+
+		if n == 0 {
+			if err == nil {
+				err = io.EOF
+			}
+
+			return "", err
+		}
+
+		s.keys = buf[:n]
+	}
+
+	n := keyLen(s.keys)
+	key := string(s.keys[:n])
+	s.keys = s.keys[n:]
+
+	return key, nil
+}
+
+// keyLen returns the length of the first key in the input, which is either a
+// single character or the whole escape sequence of a special key.
+func keyLen(input []byte) int {
+	if len(input) > 2 && input[0] == 0x1b && (input[1] == '[' || input[1] == 'O') {
+		for i := 2; i < len(input); i++ {
+			if input[i] >= 0x40 && input[i] <= 0x7e {
+				return i + 1
+			}
+		}
+
+		return len(input)
+	}
+
+	_, size := utf8.DecodeRune(input)
+
+	return size
+}
+
+// readRune reads a single character from a reader, one byte at a time, so
+// that nothing after it is consumed.
+func readRune(r io.Reader) (string, error) {
+	char := []byte{}
+	buf := make([]byte, 1)
+
+	for !utf8.FullRune(char) {
+		n, err := r.Read(buf)
+
+		if n > 0 {
+			char = append(char, buf[0])
+		} else if err != nil {
+			if len(char) > 0 {
+				break
+			}
+
+			return "", err
+		}
+	}
+
+	return string(char), nil
+}
+
+// logInput emits what a command read from the attacker.
+func (s *Session) logInput(prompt, input, mode string) {
+	s.Emit(&InputEvent{
+		Command: s.command,
+		Prompt:  prompt,
+		Input:   input,
+		Mode:    mode,
+	})
+}
@@ -0,0 +1,747 @@
+package plugin
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// These are the exit statuses that bash uses when a command can't be run.
+const (
+	StatusNotExecutable = 126
+	StatusNotFound      = 127
+)
+
+// Run parses a line (or a whole script) of shell input and runs every command
+// in it, with support for quoting, pipes, redirections and `;`, `&&` and `||`.
+// It returns the exit status of the last command.
+func (s *Session) Run(input string) int {
+	// The step limit is for a whole line of input, along with everything that
+	// it runs.
+	if s.runs == 0 {
+		s.steps, s.aborted = 0, false
+	}
+
+	s.runs++
+	defer func() { s.runs-- }()
+
+	list, err := parseShell(input)
+
+	if err != nil {
+		s.ErrWrite(fmt.Sprintf("bash: %s\n", err))
+		s.status = 2
+		return s.status
+	}
+
+	return s.runList(list)
+}
+
+// SetStatus sets the exit status of the command that's currently running.
+func (s *Session) SetStatus(status int) {
+	s.status = status
+}
+
+// Status returns the exit status of the last command.
+func (s *Session) Status() int {
+	return s.status
+}
+
+// Stdin returns everything that was piped or redirected into the command that
+// is currently running.
+func (s *Session) Stdin() string {
+	if s.stdin == nil {
+		return ""
+	}
+
+	data, _ := io.ReadAll(s.stdin)
+
+	return string(data)
+}
+
+// stdoutWriter returns where the output of the current command should go.
+func (s *Session) stdoutWriter() io.Writer {
+	if s.stdout != nil {
+		return s.stdout
+	}
+
+	return s.termWriter()
+}
+
+// stderrWriter returns where the errors of the current command should go.
+func (s *Session) stderrWriter() io.Writer {
+	if s.stderr != nil {
+		return s.stderr
+	}
+
+	return s.termWriter()
+}
+
+// termWriter returns the terminal of the session.
+func (s *Session) termWriter() io.Writer {
+	if s.Term == nil {
+		return io.Discard
+	}
+
+	return s.Term
+}
+
+// isTerminal returns whether an output goes to the terminal of the session.
+func (s *Session) isTerminal(w io.Writer) bool {
+	return w == s.termWriter()
+}
+
+func (s *Session) runList(list *shellList) int {
+	for i, andOr := range list.items {
+		if s.interrupted() || !s.step() {
+			break
+		}
+
+		if list.async[i] {
+			s.runBackground(andOr)
+		} else {
+			s.runAndOr(andOr)
+		}
+	}
+
+	return s.status
+}
+
+func (s *Session) runAndOr(andOr *andOrList) int {
+	status := s.runPipeline(andOr.pipelines[0])
+
+	for i, op := range andOr.ops {
+		if (op == "&&" && status != 0) || (op == "||" && status == 0) {
+			continue
+		}
+
+		status = s.runPipeline(andOr.pipelines[i+1])
+	}
+
+	return status
+}
+
+// runPipeline runs each command of the pipeline in order, feeding the output
+// of one command to the input of the next.
+func (s *Session) runPipeline(pipeline *shellPipeline) int {
+	stdin, stdout := s.stdin, s.stdout
+	input := stdin
+
+	for i, cmd := range pipeline.cmds {
+		var buf *bytes.Buffer
+
+		s.stdin = input
+
+		if i < len(pipeline.cmds)-1 {
+			buf = &bytes.Buffer{}
+			s.stdout = buf
+		} else {
+			s.stdout = stdout
+		}
+
+		s.runNode(cmd)
+
+		if buf != nil {
+			input = buf
+		}
+	}
+
+	s.stdin, s.stdout = stdin, stdout
+
+	if pipeline.negate {
+		if s.status == 0 {
+			s.status = 1
+		} else {
+			s.status = 0
+		}
+	}
+
+	return s.status
+}
+
+func (s *Session) runNode(node shellNode) int {
+	switch node := node.(type) {
+	case *simpleCommand:
+		return s.runSimple(node)
+	case *ifClause:
+		return s.runIf(node)
+	case *forLoop:
+		return s.runFor(node)
+	case *whileLoop:
+		return s.runWhile(node)
+	case *braceGroup:
+		return s.runList(node.body)
+	case *funcDef:
+		return s.defineFunction(node)
+	case *redirected:
+		return s.runRedirected(node)
+	}
+
+	return s.status
+}
+
+// expandWord turns a word into the arguments that it stands for, by expanding
+// its braces, its tilde, its parameters and command substitutions, and then
+// its globs against the VFS. A glob that doesn't match anything is left as it
+// is.
+func (s *Session) expandWord(word shellWord) []string {
+	args := []string{}
+	words, err := expandBraces(word.chars())
+
+	if err != nil {
+		s.ErrWrite("bash: ", err.Error(), "\n")
+		s.status = 1
+		s.expandFailed = true
+
+		return nil
+	}
+
+	for _, chars := range words {
+		fields := s.expandParams(s.expandTilde(chars), true)
+
+		// A quoted word stays, even if it's empty, except for a "$@" without
+		// any positional parameters.
+		if len(fields) == 0 && word.isQuoted() && word.String() != "$@" {
+			fields = [][]shellChar{{}}
+		}
+
+		for _, field := range fields {
+			pattern, isGlob := globPattern(field)
+
+			if isGlob {
+				if matches := s.glob(pattern); len(matches) > 0 {
+					args = append(args, matches...)
+					continue
+				}
+			}
+
+			args = append(args, charsWord(field).String())
+		}
+	}
+
+	return args
+}
+
+// expandRedirect expands the target of a redirection, which has to be a
+// single word.
+func (s *Session) expandRedirect(word shellWord) (string, error) {
+	args := s.expandWord(word)
+
+	if len(args) != 1 {
+		return "", fmt.Errorf("%s: ambiguous redirect", word.String())
+	}
+
+	return args[0], nil
+}
+
+// expandWords expands every word of a command.
+func (s *Session) expandWords(words []shellWord) []string {
+	argv := []string{}
+
+	// Like bash, the expansion stops at the first word that can't be
+	// expanded.
+	for _, word := range words {
+		if argv = append(argv, s.expandWord(word)...); s.expandFailed {
+			break
+		}
+	}
+
+	return argv
+}
+
+// runSimple sets up the redirections of a command and then runs it.
+func (s *Session) runSimple(cmd *simpleCommand) int {
+	assigns := 0
+
+	for assigns < len(cmd.words) {
+		if _, _, ok := assignment(cmd.words[assigns]); !ok {
+			break
+		}
+
+		assigns++
+	}
+
+	s.substituted, s.expandFailed = false, false
+	argv := s.expandWords(cmd.words[assigns:])
+
+	// Like bash, a command doesn't run when one of its words can't be
+	// expanded (e.g. `$((1/0))`).
+	if s.expandFailed {
+		s.status = 1
+		return s.status
+	}
+	stdin, stdout, stderr := s.stdin, s.stdout, s.stderr
+	files, err := s.applyRedirects(cmd.redirects)
+
+	if err == nil && len(argv) > 0 {
+		restore := s.assignTemporarily(cmd.words[:assigns])
+		s.runCommand(argv)
+		restore()
+	}
+
+	s.stdin, s.stdout, s.stderr = stdin, stdout, stderr
+
+	if err != nil {
+		for _, file := range files {
+			file.Close()
+		}
+
+		s.ErrWrite(fmt.Sprintf("bash: %s\n", err))
+		s.status = 1
+		return s.status
+	} else if len(argv) == 0 {
+		// Without a command, the assignments are for the shell itself, and
+		// the status is the one of the last command substitution.
+		if !s.assignWords(cmd.words[:assigns]) || s.expandFailed {
+			s.status = 1
+		} else if !s.substituted {
+			s.status = 0
+		}
+	}
+
+	for _, file := range files {
+		if err := file.Close(); err != nil {
+			s.ErrWrite(fmt.Sprintf("bash: %s: %s\n", file.Name(), strError(err)))
+			s.status = 1
+		}
+	}
+
+	return s.status
+}
+
+// applyRedirects points the input and outputs of the session to wherever the
+// redirections of a command say. The files that were opened are returned, so
+// that they can be closed after the command runs.
+func (s *Session) applyRedirects(redirects []shellRedirect) ([]*VFSHandle, error) {
+	files := []*VFSHandle{}
+	fds := map[int]io.Writer{1: s.stdoutWriter(), 2: s.stderrWriter()}
+
+	for _, r := range redirects {
+		target := r.target.String()
+		fd := r.fd
+
+		if r.op != "<<<" && r.op != "<<" && r.op != "<<-" {
+			expanded, err := s.expandRedirect(r.target)
+
+			if err != nil {
+				return files, err
+			}
+
+			target = expanded
+		}
+
+		switch r.op {
+		case ">", ">|", ">>", "&>", "&>>", "<>":
+			if fd < 0 {
+				fd = 1
+			}
+
+			w, file, err := s.openRedirect(target, strings.HasSuffix(r.op, ">>"), fds)
+
+			if file != nil {
+				files = append(files, file)
+			}
+
+			if err != nil {
+				return files, err
+			}
+
+			fds[fd] = w
+
+			if strings.HasPrefix(r.op, "&") {
+				fds[2] = w
+			}
+		case ">&":
+			if fd < 0 {
+				fd = 1
+			}
+
+			if target == "-" {
+				fds[fd] = io.Discard
+			} else if n, err := strconv.Atoi(target); err == nil {
+				if w, ok := fds[n]; ok {
+					fds[fd] = w
+				} else {
+					return files, fmt.Errorf("%d: %s", n, ErrBadFD)
+				}
+			} else {
+				// `>& file` is the same as `&> file`.
+				w, file, err := s.openRedirect(target, false, fds)
+
+				if file != nil {
+					files = append(files, file)
+				}
+
+				if err != nil {
+					return files, err
+				}
+
+				fds[1], fds[2] = w, w
+			}
+		case "<":
+			contents, err := s.VFS.ReadFile(target)
+
+			if err != nil {
+				return files, fmt.Errorf("%s: %s", target, strError(err))
+			}
+
+			s.stdin = strings.NewReader(contents)
+		case "<<<":
+			s.stdin = strings.NewReader(target + "\n")
+		case "<<", "<<-":
+			body := r.heredoc
+
+			// The body is expanded unless any part of the delimiter was quoted.
+			if !r.target.isQuoted() {
+				body = s.expandText(body)
+			}
+
+			s.stdin = strings.NewReader(body)
+		}
+	}
+
+	s.stdout, s.stderr = fds[1], fds[2]
+
+	return files, nil
+}
+
+// openRedirect opens the file of an output redirection, which is truncated
+// right away unless the output is appended to it.
+func (s *Session) openRedirect(path string, append bool, fds map[int]io.Writer) (io.Writer, *VFSHandle, error) {
+	switch path {
+	case "":
+		return nil, nil, fmt.Errorf("ambiguous redirect")
+	case "/dev/null", "/dev/zero", "/dev/full":
+		return io.Discard, nil, nil
+	case "/dev/stdout", "/dev/fd/1":
+		return fds[1], nil, nil
+	case "/dev/stderr", "/dev/fd/2":
+		return fds[2], nil, nil
+	case "/dev/tty":
+		return s.termWriter(), nil, nil
+	}
+
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+
+	if append {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+
+	file, err := s.VFS.OpenFile(path, flag, 0664)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	return file, file, nil
+}
+
+// dirName returns the directory of an absolute path.
+func dirName(path string) string {
+	if i := strings.LastIndex(path, "/"); i > 0 {
+		return path[:i]
+	}
+
+	return "/"
+}
+
+// shellBuiltins are the commands that are part of the shell itself, so they
+// can't be replaced by plugins and have no file in the VFS. They're set in
+// init, since some of them run commands themselves.
+var shellBuiltins map[string]CommandFn
+
+func init() {
+	shellBuiltins = map[string]CommandFn{
+		"exit":     exitCommand,
+		"logout":   exitCommand,
+		"kill":     killCommand,
+		"jobs":     jobsCommand,
+		"disown":   disownCommand,
+		"source":   sourceCommand,
+		".":        sourceCommand,
+		"eval":     evalCommand,
+		"export":   exportCommand,
+		"unset":    unsetCommand,
+		"local":    localCommand,
+		"set":      setCommand,
+		"shift":    shiftCommand,
+		"read":     readCommand,
+		"test":     testCommand,
+		"[":        testCommand,
+		"true":     trueCommand,
+		":":        trueCommand,
+		"false":    falseCommand,
+		"break":    breakCommand,
+		"continue": breakCommand,
+		"return":   returnCommand,
+		"history":  historyCommand,
+	}
+}
+
+// commandPath is where the files of commands that are run by their name are
+// looked for.
+var commandPath = []string{"/usr/local/sbin", "/usr/local/bin", "/usr/sbin", "/usr/bin", "/sbin", "/bin"}
+
+// lookupCommand finds the function of a command, either by its name or, when
+// it contains a slash, by its path in the VFS. The path of the command's file
+// is returned along with it.
+func (s *Session) lookupCommand(name string) (CommandFn, string, *VFSFile, bool) {
+	if !strings.Contains(name, "/") {
+		commandFn, ok := s.Manager.GetCommand(name)
+
+		if !ok {
+			return nil, "", nil, false
+		}
+
+		for _, dir := range commandPath {
+			if _, file, err := s.VFS.FindFile(dir + "/" + name); err == nil && file.Type == T_FILE {
+				return commandFn, dir + "/" + name, file, true
+			}
+		}
+
+		return commandFn, "/usr/bin/" + name, nil, true
+	}
+
+	path := s.VFS.AbsPath(name)
+
+	if commandFn, ok := s.Manager.GetCommand(path); ok {
+		return commandFn, path, nil, true
+	}
+
+	_, file, err := s.VFS.FindFile(path)
+
+	if err != nil {
+		return nil, path, nil, false
+	} else if file.CmdFn != nil {
+		return file.CmdFn, path, file, true
+	}
+
+	return nil, path, file, false
+}
+
+// setuid makes the VFS act as the owner (or the group) of a file with the
+// setuid (or setgid) bit, the way the kernel does when it's executed. It
+// returns the function that switches back.
+func (s *Session) setuid(file *VFSFile) func() {
+	user := s.VFS.User
+
+	if user == nil || file.Mode&(os.ModeSetuid|os.ModeSetgid) == 0 {
+		return func() {}
+	}
+
+	effective := *user
+	owner, group := s.VFS.fileOwner(file)
+
+	if file.Mode&os.ModeSetuid != 0 && owner != user.Username {
+		effective.Username = owner
+
+		if u, err := s.VFS.LookupUser(owner); err == nil {
+			effective.UID = u.UID
+		} else if owner == "root" {
+			effective.UID = 0
+		}
+	}
+
+	if file.Mode&os.ModeSetgid != 0 {
+		effective.Group = group
+
+		if g, err := s.VFS.LookupGroup(group); err == nil {
+			effective.GID = g.GID
+		}
+	}
+
+	login := s.VFS.Login
+	depth := len(s.shells)
+
+	if login == nil {
+		s.VFS.Login = user
+	}
+
+	s.VFS.User = &effective
+
+	return func() {
+		// A shell that the program started (e.g. `sudo -i`) goes back to the
+		// real user when it exits.
+		if len(s.shells) > depth {
+			s.shells[depth].vfsUser = user
+			s.shells[depth].vfsLogin = login
+			return
+		}
+
+		s.VFS.User = user
+		s.VFS.Login = login
+	}
+}
+
+// startProcess adds the process of a command that's about to run to the
+// process table (and points /proc/self to it), and returns the function that
+// removes it once the command exits. A command that's run by exec takes over
+// the process of the one that ran it instead, and the processes of a job in
+// the background are left running.
+func (s *Session) startProcess(argv []string, path string) func() {
+	if s.execing {
+		s.execing = false
+
+		if p := s.VFS.FindProcess(s.VFS.procSelf()); p != nil && p.PID != s.PID {
+			replaced := *p
+			replaced.Name = truncateComm(filepath.Base(path))
+			replaced.Exe = path
+			replaced.Cmdline = argv
+			s.VFS.MountProcess(&replaced)
+
+			return func() {}
+		}
+	}
+
+	uid := 1000
+
+	if s.VFS.User != nil {
+		uid = s.VFS.User.UID
+	}
+
+	state := "R"
+
+	if s.tty != "" && s.job == nil {
+		state = "R+"
+	}
+
+	p := &Process{
+		PID:     s.VFS.NextPID(),
+		PPID:    s.PID,
+		UID:     uid,
+		Name:    truncateComm(filepath.Base(path)),
+		Exe:     path,
+		Cmdline: argv,
+		TTY:     s.tty,
+		State:   state,
+		VSZ:     7000 + len(argv)*128,
+		RSS:     3200 + len(argv)*64,
+		Started: time.Now(),
+	}
+
+	// The first process of a job gets the PID that the shell printed.
+	if j := s.job; j != nil && !slices.Contains(j.pids, j.pid) && s.VFS.FindProcess(j.pid) == nil {
+		p.PID = j.pid
+	}
+
+	s.VFS.MountProcess(p)
+	s.VFS.SetProcSelf(p.PID)
+
+	return func() {
+		if j, running := s.job, s.VFS.FindProcess(p.PID); j != nil && running != nil {
+			sleeping := *running
+			sleeping.State = strings.Replace(running.State, "R", "S", 1)
+			s.VFS.procs[p.PID] = &sleeping
+			j.pids = append(j.pids, p.PID)
+		} else {
+			s.VFS.UnmountProcess(p.PID)
+		}
+
+		s.VFS.SetProcSelf(s.PID)
+	}
+}
+
+// exec runs a command in place of the one that's running, like execve(2), so
+// that it takes over its process (e.g. for nohup). An error is returned if the
+// command can't be run.
+func (s *Session) exec(argv []string) error {
+	_, path, file, ok := s.lookupCommand(argv[0])
+
+	if !ok && (file == nil || !strings.Contains(argv[0], "/")) {
+		return ErrNotExist
+	} else if !ok && file.Type == T_DIR {
+		return ErrPermission
+	}
+
+	s.execing = true
+	defer func() { s.execing = false }()
+
+	if !ok {
+		return s.runFile(argv, path, file)
+	}
+
+	s.runCommand(argv)
+
+	return nil
+}
+
+// runCommand runs a single command with its (already expanded) arguments.
+func (s *Session) runCommand(argv []string) {
+	name := argv[0]
+	s.status = 0
+
+	if body, ok := s.functions[name]; ok {
+		s.callFunction(body, argv)
+		return
+	}
+
+	if s.Manager == nil {
+		s.ErrWrite(fmt.Sprintf("%s: command not found\n", name))
+		s.status = StatusNotFound
+		return
+	}
+
+	commandFn, isBuiltin := shellBuiltins[name]
+	var file *VFSFile
+	var path string
+	ok := isBuiltin
+
+	if !isBuiltin {
+		commandFn, path, file, ok = s.lookupCommand(name)
+
+		if s.job != nil && path != "" {
+			s.job.path, s.job.argv = path, argv
+		}
+	}
+
+	if ok {
+		args := &CmdArgs{
+			RawArgs: strings.Join(argv[1:], " "),
+			Argv:    argv[1:],
+		}
+		args.Parse()
+
+		if file != nil {
+			defer s.setuid(file)()
+		}
+
+		if !isBuiltin {
+			defer s.startProcess(argv, path)()
+		}
+
+		command := s.command
+		s.command = filepath.Base(name)
+		defer func() { s.command = command }()
+
+		commandFn(args, s)
+		return
+	}
+
+	switch {
+	case !strings.Contains(name, "/"):
+		s.ErrWrite(fmt.Sprintf("%s: command not found\n", name))
+		s.status = StatusNotFound
+	case file == nil:
+		s.ErrWrite(fmt.Sprintf("bash: %s: No such file or directory\n", name))
+		s.status = StatusNotFound
+	case file.Type == T_DIR:
+		s.ErrWrite(fmt.Sprintf("bash: %s: Is a directory\n", name))
+		s.status = StatusNotExecutable
+	default:
+		err := s.runFile(argv, path, file)
+
+		if errors.Is(err, ErrExecFormat) {
+			s.ErrWrite(fmt.Sprintf("bash: %s: cannot execute binary file: %s\n", name, err))
+			s.status = StatusNotExecutable
+		} else if err != nil {
+			s.ErrWrite(fmt.Sprintf("bash: %s: %s\n", name, err))
+			s.status = StatusNotExecutable
+		}
+	}
+}
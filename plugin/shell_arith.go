@@ -0,0 +1,258 @@
+package plugin
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// arithParser evaluates an arithmetic expression, like the one of `$((...))`,
+// with the usual precedence of C's operators.
+type arithParser struct {
+	s    *Session
+	expr string
+	pos  int
+}
+
+// arithLevels are the binary operators, from the lowest precedence to the
+// highest, with the longer ones first so that `<=` isn't taken for `<`.
+var arithLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"==", "!="},
+	{"<=", ">=", "<", ">"},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// arith evaluates an arithmetic expression. Names of variables stand for their
+// values, and a variable that isn't a number counts as 0.
+func (s *Session) arith(expr string) (int, error) {
+	p := &arithParser{s: s, expr: expr}
+
+	if strings.TrimSpace(expr) == "" {
+		return 0, nil
+	}
+
+	n, err := p.parseLevel(0)
+
+	if err == nil && p.skipSpace() < len(p.expr) {
+		err = p.syntaxError("syntax error in expression")
+	}
+
+	return n, err
+}
+
+// skipSpace moves past any whitespace, and returns the new position.
+func (p *arithParser) skipSpace() int {
+	for p.pos < len(p.expr) && strings.IndexByte(" \t\n", p.expr[p.pos]) >= 0 {
+		p.pos++
+	}
+
+	return p.pos
+}
+
+// syntaxError returns the error bash shows for the token at the position.
+func (p *arithParser) syntaxError(msg string) error {
+	return fmt.Errorf("%s: %s (error token is \"%s\")", p.expr, msg, p.expr[min(p.pos, len(p.expr)):])
+}
+
+// operator returns the operator of a level that's at the position, if any.
+func (p *arithParser) operator(level int) string {
+	rest := p.expr[p.skipSpace():]
+
+	for _, op := range arithLevels[level] {
+		// Don't take the first half of `||`, `&&`, `<<` or `**` for another
+		// one.
+		if strings.HasPrefix(rest, op) && !(len(op) == 1 && len(rest) > 1 && rest[1] == op[0] && strings.Contains("|&<>*", op)) {
+			return op
+		}
+	}
+
+	return ""
+}
+
+func (p *arithParser) parseLevel(level int) (int, error) {
+	if level == len(arithLevels) {
+		return p.parsePower()
+	}
+
+	left, err := p.parseLevel(level + 1)
+
+	for op := p.operator(level); err == nil && op != ""; op = p.operator(level) {
+		p.pos += len(op)
+		start := p.skipSpace()
+		var right int
+		right, err = p.parseLevel(level + 1)
+
+		if err != nil {
+			break
+		}
+
+		if (op == "/" || op == "%") && right == 0 {
+			p.pos = start
+			return 0, p.syntaxError("division by 0")
+		}
+
+		left = arithApply(op, left, right)
+	}
+
+	return left, err
+}
+
+// parsePower parses `**`, which binds tighter than the other binary operators
+// (but not the unary ones) and groups to the right, so 2**3**2 is 2**9.
+func (p *arithParser) parsePower() (int, error) {
+	base, err := p.parseUnary()
+
+	if err != nil || !strings.HasPrefix(p.expr[p.skipSpace():], "**") {
+		return base, err
+	}
+
+	p.pos += 2
+	start := p.skipSpace()
+	exp, err := p.parsePower()
+
+	if err != nil {
+		return 0, err
+	} else if exp < 0 {
+		p.pos = start
+		return 0, p.syntaxError("exponent less than 0")
+	}
+
+	n := 1
+
+	for ; exp > 0; exp >>= 1 {
+		if exp&1 == 1 {
+			n *= base
+		}
+
+		base *= base
+	}
+
+	return n, nil
+}
+
+// arithApply applies a binary operator.
+func arithApply(op string, a, b int) int {
+	switch op {
+	case "||":
+		return boolNum(a != 0 || b != 0)
+	case "&&":
+		return boolNum(a != 0 && b != 0)
+	case "|":
+		return a | b
+	case "^":
+		return a ^ b
+	case "&":
+		return a & b
+	case "==":
+		return boolNum(a == b)
+	case "!=":
+		return boolNum(a != b)
+	case "<=":
+		return boolNum(a <= b)
+	case ">=":
+		return boolNum(a >= b)
+	case "<":
+		return boolNum(a < b)
+	case ">":
+		return boolNum(a > b)
+	case "<<":
+		return a << (b & 63)
+	case ">>":
+		return a >> (b & 63)
+	case "+":
+		return a + b
+	case "-":
+		return a - b
+	case "*":
+		return a * b
+	case "/":
+		return a / b
+	}
+
+	return a % b
+}
+
+// boolNum turns a condition into 1 or 0, which is what the comparisons of
+// arithmetic expressions result in.
+func boolNum(b bool) int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+func (p *arithParser) parseUnary() (int, error) {
+	if p.skipSpace() >= len(p.expr) {
+		return 0, p.syntaxError("syntax error: operand expected")
+	}
+
+	switch c := p.expr[p.pos]; c {
+	case '-', '+', '!', '~':
+		p.pos++
+		n, err := p.parseUnary()
+
+		switch c {
+		case '-':
+			n = -n
+		case '!':
+			n = boolNum(n == 0)
+		case '~':
+			n = ^n
+		}
+
+		return n, err
+	case '(':
+		p.pos++
+		n, err := p.parseLevel(0)
+
+		if err != nil {
+			return 0, err
+		} else if p.skipSpace() >= len(p.expr) || p.expr[p.pos] != ')' {
+			return 0, p.syntaxError("missing `)'")
+		}
+
+		p.pos++
+
+		return n, nil
+	}
+
+	start := p.pos
+
+	if n := nameLen(p.expr[p.pos:]); n > 0 {
+		p.pos += n
+		value, _ := p.s.GetVar(p.expr[start:p.pos])
+		number, _ := strconv.ParseInt(strings.TrimSpace(value), 0, 64)
+
+		return int(number), nil
+	}
+
+	for p.pos < len(p.expr) && isAlnum(p.expr[p.pos]) {
+		p.pos++
+	}
+
+	if start == p.pos {
+		return 0, p.syntaxError("syntax error: operand expected")
+	}
+
+	n, err := strconv.ParseInt(p.expr[start:p.pos], 0, 64)
+
+	if err != nil {
+		p.pos = start
+		return 0, p.syntaxError("value too great for base")
+	}
+
+	return int(n), nil
+}
+
+// isAlnum returns whether a character is a letter or a digit.
+func isAlnum(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
@@ -0,0 +1,200 @@
+package plugin
+
+import (
+	"errors"
+	"slices"
+	"strings"
+)
+
+// maxSteps is how many commands (and iterations of loops) a line of input can
+// run, including the scripts that it runs, so that an endless loop can't hang
+// the session.
+const maxSteps = 10000
+
+// Incomplete returns whether the input ends in the middle of a command, like
+// an `if` without its `fi` or an unterminated quote, in which case bash would
+// ask for more lines with its secondary prompt.
+func (s *Session) Incomplete(input string) bool {
+	_, err := parseShell(input)
+
+	return errors.Is(err, errUnexpectedEOF) || (err != nil && strings.HasPrefix(err.Error(), "unexpected EOF"))
+}
+
+// step counts a command (or an iteration of a loop) towards the step limit,
+// and returns whether it can still run. Past the limit, everything stops as if
+// the attacker had pressed Ctrl-C.
+func (s *Session) step() bool {
+	s.steps++
+
+	if s.steps > maxSteps && !s.aborted {
+		s.aborted = true
+		s.status = 130
+	}
+
+	return !s.aborted
+}
+
+// interrupted returns whether the rest of the commands have to be skipped,
+// because of `exit`, `return`, `break` or `continue`, or the step limit.
+func (s *Session) interrupted() bool {
+	return s.exited || s.exiting || s.aborted || s.returning || s.breaking > 0 || s.continuing > 0
+}
+
+// loopOver handles `break` and `continue` at the end of an iteration of a loop,
+// and returns whether the loop is over. Both of them can be for a loop that
+// this one is in (e.g. `break 2`).
+func (s *Session) loopOver() bool {
+	switch {
+	case s.breaking > 0:
+		s.breaking--
+		return true
+	case s.continuing > 1:
+		s.continuing--
+		return true
+	case s.continuing == 1:
+		s.continuing = 0
+	}
+
+	return s.interrupted()
+}
+
+func (s *Session) runIf(node *ifClause) int {
+	for i, cond := range node.conds {
+		status := s.runList(cond)
+
+		if s.interrupted() {
+			return status
+		} else if status == 0 {
+			return s.runList(node.bodies[i])
+		}
+	}
+
+	if node.elseBody != nil {
+		return s.runList(node.elseBody)
+	}
+
+	s.status = 0
+
+	return s.status
+}
+
+func (s *Session) runFor(node *forLoop) int {
+	items := s.positional()
+
+	if node.in {
+		s.expandFailed = false
+
+		if items = s.expandWords(node.words); s.expandFailed {
+			s.status = 1
+			return s.status
+		}
+	}
+
+	s.status = 0
+	s.loops++
+	defer func() { s.loops-- }()
+
+	for _, item := range items {
+		if !s.step() {
+			break
+		}
+
+		if err := s.SetVar(node.name, item); err != nil {
+			s.ErrWrite("bash: ", err.Error(), "\n")
+			s.status = 1
+			break
+		}
+
+		s.runList(node.body)
+
+		if s.loopOver() {
+			break
+		}
+	}
+
+	return s.status
+}
+
+func (s *Session) runWhile(node *whileLoop) int {
+	status := 0
+	s.loops++
+	defer func() { s.loops-- }()
+
+	for s.step() {
+		cond := s.runList(node.cond)
+
+		if s.interrupted() {
+			status = cond
+			s.loopOver()
+			break
+		} else if (cond == 0) == node.until {
+			break
+		}
+
+		status = s.runList(node.body)
+
+		if s.loopOver() {
+			break
+		}
+	}
+
+	if !s.aborted {
+		s.status = status
+	}
+
+	return s.status
+}
+
+// runRedirected runs a compound command with its redirections, the same way
+// that runSimple does for a simple command.
+func (s *Session) runRedirected(node *redirected) int {
+	stdin, stdout, stderr := s.stdin, s.stdout, s.stderr
+	files, err := s.applyRedirects(node.redirects)
+
+	if err == nil {
+		s.runNode(node.node)
+	}
+
+	s.stdin, s.stdout, s.stderr = stdin, stdout, stderr
+
+	if err != nil {
+		s.ErrWrite("bash: ", err.Error(), "\n")
+		s.status = 1
+	}
+
+	for _, file := range files {
+		file.Close()
+	}
+
+	return s.status
+}
+
+// defineFunction keeps the body of a function, which then runs like a command.
+func (s *Session) defineFunction(node *funcDef) int {
+	if s.functions == nil {
+		s.functions = map[string]shellNode{}
+	}
+
+	s.functions[node.name] = node.body
+	s.status = 0
+
+	return s.status
+}
+
+// callFunction runs a function with its arguments as the positional
+// parameters. Its `return` ends only the function, and its local variables go
+// back to what they were.
+func (s *Session) callFunction(body shellNode, argv []string) {
+	args := s.args
+	s.args = slices.Concat([]string{s.param(0)}, argv[1:])
+	s.locals = append(s.locals, map[string]*string{})
+	s.returnable++
+
+	s.runNode(body)
+
+	s.returnable--
+	s.returning = false
+	s.args = args
+	s.restoreVars(s.locals[len(s.locals)-1])
+	s.locals = s.locals[:len(s.locals)-1]
+}
@@ -0,0 +1,293 @@
+package plugin
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrExecFormat is what execve(2) fails with for a file that isn't a program
+// that the machine can run.
+var ErrExecFormat = errors.New("Exec format error")
+
+// maxScriptDepth is how many scripts can run each other before the shell gives
+// up, so that a script that runs itself doesn't go on forever.
+const maxScriptDepth = 64
+
+// scriptShells are the interpreters of scripts that the session's shell runs
+// by itself.
+var scriptShells = []string{"sh", "bash", "dash", "ash", "busybox"}
+
+// elfMachines are the architectures of ELF files that file(1) knows, by their
+// e_machine.
+var elfMachines = map[uint16]string{
+	2:   "SPARC",
+	3:   "Intel 80386",
+	4:   "Motorola m68k",
+	8:   "MIPS",
+	20:  "PowerPC",
+	21:  "64-bit PowerPC",
+	22:  "IBM S/390",
+	40:  "ARM",
+	42:  "Renesas SH",
+	43:  "SPARC V9",
+	62:  "x86-64",
+	183: "ARM aarch64",
+	243: "UCB RISC-V",
+}
+
+// elfHeader is what matters to the kernel from the header of an ELF file.
+type elfHeader struct {
+	bits    int
+	order   binary.ByteOrder
+	kind    uint16
+	machine uint16
+}
+
+// parseELF parses the header of an ELF file, which fails if it's too short.
+func parseELF(contents string) (*elfHeader, bool) {
+	if !strings.HasPrefix(contents, "\x7fELF") || len(contents) < 52 {
+		return nil, false
+	}
+
+	h := &elfHeader{bits: 32, order: binary.LittleEndian}
+
+	if contents[4] == 2 {
+		h.bits = 64
+	}
+
+	if contents[5] == 2 {
+		h.order = binary.BigEndian
+	}
+
+	if h.bits == 64 && len(contents) < 64 {
+		return nil, false
+	}
+
+	h.kind = h.order.Uint16([]byte(contents[16:18]))
+	h.machine = h.order.Uint16([]byte(contents[18:20]))
+
+	return h, true
+}
+
+// String describes the file the way file(1) does, e.g. "ELF 32-bit LSB
+// executable, ARM".
+func (h *elfHeader) String() string {
+	order := "LSB"
+	kind := "relocatable"
+	machine, ok := elfMachines[h.machine]
+
+	if h.order == binary.BigEndian {
+		order = "MSB"
+	}
+
+	switch h.kind {
+	case 2:
+		kind = "executable"
+	case 3:
+		kind = "shared object"
+	case 4:
+		kind = "core file"
+	}
+
+	if !ok {
+		machine = fmt.Sprintf("*unknown arch 0x%x*", h.machine)
+	}
+
+	return fmt.Sprintf("ELF %d-bit %s %s, %s", h.bits, order, kind, machine)
+}
+
+// runsNatively returns whether the machine (which is x86-64, with support for
+// 32-bit programs) can run the program.
+func (h *elfHeader) runsNatively() bool {
+	if h.kind != 2 && h.kind != 3 {
+		return false
+	}
+
+	return (h.bits == 64 && h.machine == 62) || (h.bits == 32 && h.machine == 3)
+}
+
+// fileFormat describes what kind of program a file is, for the logs.
+func fileFormat(contents string) string {
+	if h, ok := parseELF(contents); ok {
+		return h.String()
+	} else if line, ok := strings.CutPrefix(contents, "#!"); ok {
+		line, _, _ = strings.Cut(line, "\n")
+		return "script, " + strings.TrimSpace(line)
+	} else if contents == "" {
+		return "empty"
+	} else if isBinary(contents) {
+		return "data"
+	}
+
+	return "text"
+}
+
+// isBinary returns whether a file that isn't a program is binary, in which
+// case bash refuses to run it as a script. Like bash, only the first line is
+// checked for NUL bytes.
+func isBinary(contents string) bool {
+	sample := contents[:min(len(contents), 80)]
+	line, _, _ := strings.Cut(sample, "\n")
+
+	return strings.Contains(line, "\x00")
+}
+
+// logExecution emits the event of a program that's about to be run, with the
+// contents of its file so that it can be found among the artifacts.
+func (s *Session) logExecution(path string, argv []string, background bool) {
+	data := ""
+
+	if _, file, err := s.VFS.FindFile(path); err == nil && file.Type == T_FILE {
+		data = s.VFS.FileContents(file)
+	}
+
+	if s.job != nil {
+		s.job.logged = true
+	}
+
+	s.Emit(&ExecutionEvent{
+		Path:       s.VFS.userPath(path),
+		Args:       argv,
+		Format:     fileFormat(data),
+		Background: background,
+		Data:       []byte(data),
+	})
+}
+
+// runFile runs a file of the VFS that isn't one of the commands, like a
+// program that the attacker uploaded, the way the kernel would. Programs for
+// the machine's architecture are assumed to fork into the background (which
+// is what malware does), others fail, and scripts are run by the shell. An
+// error is returned when the file can't be run at all.
+func (s *Session) runFile(argv []string, path string, file *VFSFile) error {
+	s.logExecution(path, argv, s.job != nil)
+
+	if !s.VFS.access(file).Exec {
+		return ErrPermission
+	}
+
+	contents := s.VFS.FileContents(file)
+
+	if strings.HasPrefix(contents, "#!") {
+		return s.runScript(argv, path, contents)
+	} else if strings.HasPrefix(contents, "\x7fELF") {
+		h, ok := parseELF(contents)
+
+		if !ok || !h.runsNatively() {
+			return ErrExecFormat
+		}
+
+		defer s.setuid(file)()
+		defer s.startProcess(argv, path)()
+
+		if s.job == nil {
+			s.daemonize(argv, path)
+		}
+
+		s.status = 0
+		return nil
+	} else if isBinary(contents) {
+		return ErrExecFormat
+	}
+
+	// Like bash, a file that the kernel can't run but isn't binary is run as a
+	// script by a copy of the shell.
+	defer s.startProcess(argv, path)()
+	s.runShellScript(contents, argv)
+
+	return nil
+}
+
+// runScript runs a file that starts with `#!` by its interpreter. Shell
+// scripts are run by the session's shell, and other interpreters are run as
+// the commands they are, with the path of the script.
+func (s *Session) runScript(argv []string, path, contents string) error {
+	line, _, _ := strings.Cut(contents[2:], "\n")
+	interpreter := strings.Fields(line)
+
+	if len(interpreter) == 0 {
+		return ErrExecFormat
+	}
+
+	// Like Linux, everything after the interpreter is a single argument.
+	if len(interpreter) > 1 {
+		interpreter = []string{interpreter[0], strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), interpreter[0]))}
+	}
+
+	_, _, interpreterFile, ok := s.lookupCommand(interpreter[0])
+
+	if !ok && interpreterFile == nil && !slices.Contains(scriptShells, filepath.Base(interpreter[0])) {
+		return fmt.Errorf("%s: bad interpreter: %w", interpreter[0], ErrNotExist)
+	}
+
+	name := filepath.Base(interpreter[0])
+
+	if name == "env" && len(interpreter) > 1 {
+		name = filepath.Base(strings.Fields(interpreter[1])[0])
+	}
+
+	if slices.Contains(scriptShells, name) {
+		defer s.startProcess(slices.Concat(interpreter, argv), path)()
+		s.runShellScript(contents, argv)
+
+		return nil
+	}
+
+	if !ok {
+		// The interpreter is there, but it's not something that we emulate,
+		// so it just seems to run.
+		s.status = 0
+		return nil
+	}
+
+	s.runCommand(slices.Concat(interpreter, argv))
+
+	return nil
+}
+
+// runShellScript runs a script in a copy of the session's shell, with its
+// arguments as the positional parameters (starting from $0). Like a new shell,
+// it only gets the variables that were exported, and its `exit` ends the
+// script, and not the session.
+func (s *Session) runShellScript(contents string, argv []string) {
+	if s.scripts >= maxScriptDepth {
+		s.ErrWrite("bash: fork: retry: Resource temporarily unavailable\n")
+		s.status = 254
+		return
+	}
+
+	s.subshell(func() {
+		s.environ()
+		s.args = argv
+		s.Run(contents)
+	})
+}
+
+// daemonize leaves a copy of a program running in the background, under init,
+// the way a daemon that forked is.
+func (s *Session) daemonize(argv []string, path string) {
+	uid := 1000
+
+	if s.VFS.User != nil {
+		uid = s.VFS.User.UID
+	}
+
+	s.VFS.MountProcess(&Process{
+		PID:     s.VFS.NextPID(),
+		PPID:    1,
+		UID:     uid,
+		Name:    truncateComm(filepath.Base(path)),
+		Exe:     path,
+		Cmdline: argv,
+		State:   "Ssl",
+		CPU:     0.3,
+		VSZ:     2400 + len(argv)*128,
+		RSS:     1100 + len(argv)*64,
+		Started: time.Now(),
+	})
+}
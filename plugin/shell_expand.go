@@ -0,0 +1,431 @@
+package plugin
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// shellChar is a single character of a word, along with how it was quoted,
+// which is what decides whether braces, tildes and globs are special.
+type shellChar struct {
+	c     byte
+	quote byte
+}
+
+// chars splits a word into its characters.
+func (w shellWord) chars() []shellChar {
+	chars := []shellChar{}
+
+	for _, part := range w {
+		for i := 0; i < len(part.text); i++ {
+			chars = append(chars, shellChar{part.text[i], part.quote})
+		}
+	}
+
+	return chars
+}
+
+// charsWord puts characters back together into a word. Each part is built up
+// in a buffer, since the words of droppers can be hundreds of kilobytes long.
+func charsWord(chars []shellChar) shellWord {
+	word := shellWord{}
+	text := []byte{}
+
+	for i, c := range chars {
+		text = append(text, c.c)
+
+		if i+1 == len(chars) || chars[i+1].quote != c.quote {
+			word = append(word, wordPart{text: string(text), quote: c.quote})
+			text = text[:0]
+		}
+	}
+
+	return word
+}
+
+// literalChars turns text into characters that won't be expanded any further.
+func literalChars(text string) []shellChar {
+	chars := make([]shellChar, len(text))
+
+	for i := 0; i < len(text); i++ {
+		chars[i] = shellChar{text[i], quoteSingle}
+	}
+
+	return chars
+}
+
+// isSpecial returns whether the character at `i` is the unquoted `c`.
+func isSpecial(chars []shellChar, i int, c byte) bool {
+	return i >= 0 && i < len(chars) && chars[i].c == c && chars[i].quote == quoteNone
+}
+
+// maxBraceWords is the most words that brace expansion can make, and
+// maxExpansion is the most bytes that they can take up. Past them, bash runs
+// out of memory, and so would the honeypot (e.g. with `echo {1..100000000}`).
+const (
+	maxBraceWords = 1 << 16
+	maxExpansion  = 4 << 20
+)
+
+// expansionError is the error that bash's malloc fails with when an expansion
+// doesn't fit in memory.
+func expansionError(size uint64) error {
+	return fmt.Errorf("xmalloc: cannot allocate %d bytes", size)
+}
+
+// expandBraces expands `{a,b}` and `{1..3}` into a word for each of them, the
+// way bash does before any other expansion. It fails when there would be too
+// many words.
+func expandBraces(chars []shellChar) ([][]shellChar, error) {
+	words := [][]shellChar{}
+	size := 0
+
+	if err := appendBraces(&words, &size, chars); err != nil {
+		return nil, err
+	}
+
+	return words, nil
+}
+
+// appendBraces expands the braces of a word, and appends the words that it
+// makes to the ones that were made so far.
+func appendBraces(words *[][]shellChar, size *int, chars []shellChar) error {
+	for start := range chars {
+		if !isSpecial(chars, start, '{') || isSpecial(chars, start-1, '$') {
+			continue
+		}
+
+		depth := 0
+		commas := []int{}
+		end := -1
+
+		for i := start; i < len(chars) && end < 0; i++ {
+			switch {
+			case isSpecial(chars, i, '{'):
+				depth++
+			case isSpecial(chars, i, '}'):
+				depth--
+
+				if depth == 0 {
+					end = i
+				}
+			case isSpecial(chars, i, ',') && depth == 1:
+				commas = append(commas, i)
+			}
+		}
+
+		if end < 0 {
+			break
+		}
+
+		var items [][]shellChar
+
+		if len(commas) > 0 {
+			from := start + 1
+
+			for _, comma := range append(commas, end) {
+				items = append(items, chars[from:comma])
+				from = comma + 1
+			}
+		} else if seq, ok, err := braceSequence(chars[start+1 : end]); err != nil {
+			return err
+		} else if ok {
+			for _, item := range seq {
+				items = append(items, literalChars(item))
+			}
+		} else {
+			continue
+		}
+
+		for _, item := range items {
+			word := slices.Concat(chars[:start], item, chars[end+1:])
+
+			if err := appendBraces(words, size, word); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	*words = append(*words, chars)
+	*size += len(chars) + 1
+
+	if len(*words) > maxBraceWords || *size > maxExpansion {
+		return expansionError(uint64(*size) * uint64(len(*words)))
+	}
+
+	return nil
+}
+
+// braceSequence expands the inside of a sequence expression, like `1..10`,
+// `01..10..3` or `a..z`. It fails when there would be too many items.
+func braceSequence(chars []shellChar) ([]string, bool, error) {
+	for _, c := range chars {
+		if c.quote != quoteNone {
+			return nil, false, nil
+		}
+	}
+
+	parts := strings.Split(charsWord(chars).String(), "..")
+
+	if len(parts) != 2 && len(parts) != 3 {
+		return nil, false, nil
+	}
+
+	step := 1
+
+	if len(parts) == 3 {
+		n, err := strconv.Atoi(parts[2])
+
+		if err != nil {
+			return nil, false, nil
+		} else if n < 0 {
+			n = -n
+		} else if n == 0 {
+			n = 1
+		}
+
+		step = max(n, 1)
+	}
+
+	from, errFrom := strconv.Atoi(parts[0])
+	to, errTo := strconv.Atoi(parts[1])
+	format := "%d"
+
+	if errFrom != nil || errTo != nil {
+		if len(parts[0]) != 1 || len(parts[1]) != 1 || errFrom == nil || errTo == nil {
+			return nil, false, nil
+		}
+
+		from, to = int(parts[0][0]), int(parts[1][0])
+		format = "%c"
+	} else if zeroPadded(parts[0]) || zeroPadded(parts[1]) {
+		format = fmt.Sprintf("%%0%dd", max(len(parts[0]), len(parts[1])))
+	}
+
+	// The difference is unsigned, so that it can't overflow.
+	count := uint64(max(from, to)-min(from, to))/uint64(step) + 1
+
+	if count > maxBraceWords {
+		return nil, false, expansionError(count * uint64(len(fmt.Sprintf(format, to))+1) * 8)
+	}
+
+	items := make([]string, count)
+
+	if from > to {
+		step = -step
+	}
+
+	for i := range items {
+		items[i] = fmt.Sprintf(format, from+i*step)
+	}
+
+	return items, true, nil
+}
+
+// zeroPadded returns whether a number of a sequence expression starts with a
+// zero, in which case all of the numbers get the same width.
+func zeroPadded(n string) bool {
+	n = strings.TrimPrefix(n, "-")
+	return len(n) > 1 && n[0] == '0'
+}
+
+// expandTilde replaces a leading `~`, `~user`, `~+` or `~-` with the directory
+// that it stands for.
+func (s *Session) expandTilde(chars []shellChar) []shellChar {
+	if !isSpecial(chars, 0, '~') {
+		return chars
+	}
+
+	end := 1
+
+	for end < len(chars) && !isSpecial(chars, end, '/') {
+		if chars[end].quote != quoteNone {
+			return chars
+		}
+
+		end++
+	}
+
+	name := charsWord(chars[1:end]).String()
+	dir := ""
+
+	switch {
+	case name == "":
+		dir = s.home()
+	case s.VFS.login() != nil && name == s.VFS.login().Username:
+		dir = s.VFS.userPath(s.VFS.Home)
+	case name == "+":
+		dir = s.VFS.userPath(s.VFS.PWD)
+	case name == "root":
+		dir = "/root"
+	case name != "-" && !strings.Contains(name, "{"):
+		if _, file, err := s.VFS.FindFile("/home/" + name); err == nil && file.Type == T_DIR {
+			dir = "/home/" + name
+		}
+	}
+
+	if dir == "" {
+		return chars
+	}
+
+	return slices.Concat(literalChars(dir), chars[end:])
+}
+
+// globPattern turns the characters of a word into a pattern for path.Match,
+// where quoted characters only match themselves. It also returns whether the
+// word has any unquoted glob characters at all.
+func globPattern(chars []shellChar) (string, bool) {
+	pattern := strings.Builder{}
+	isGlob := false
+
+	for i, c := range chars {
+		if c.quote != quoteNone {
+			if strings.IndexByte("*?[]\\", c.c) >= 0 {
+				pattern.WriteByte('\\')
+			}
+
+			pattern.WriteByte(c.c)
+			continue
+		}
+
+		switch c.c {
+		case '*', '?', '[':
+			isGlob = true
+		case '!', '^':
+			// Both `[!...]` and `[^...]` negate a bracket expression.
+			if isSpecial(chars, i-1, '[') {
+				pattern.WriteByte('^')
+				continue
+			}
+		case '\\':
+			pattern.WriteByte('\\')
+		}
+
+		pattern.WriteByte(c.c)
+	}
+
+	return pattern.String(), isGlob
+}
+
+// hasGlobMeta returns whether a part of a pattern has unescaped glob characters.
+func hasGlobMeta(pattern string) bool {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '*', '?', '[':
+			return true
+		}
+	}
+
+	return false
+}
+
+// unescapePattern removes the escapes from a part of a pattern that doesn't
+// have any glob characters.
+func unescapePattern(pattern string) string {
+	str := strings.Builder{}
+
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] == '\\' && i+1 < len(pattern) {
+			i++
+		}
+
+		str.WriteByte(pattern[i])
+	}
+
+	return str.String()
+}
+
+// joinGlob adds a name to a path that was matched so far.
+func joinGlob(dir, name string) string {
+	if dir == "" {
+		return name
+	} else if strings.HasSuffix(dir, "/") {
+		return dir + name
+	}
+
+	return dir + "/" + name
+}
+
+// glob returns the paths in the VFS that match a pattern, sorted. Like bash,
+// hidden files are only matched when the pattern starts with a dot, and the
+// files of a directory can only be matched if the user can read it.
+func (s *Session) glob(pattern string) []string {
+	matches := []string{""}
+	names := strings.Split(pattern, "/")
+	if strings.HasPrefix(pattern, "/") {
+		matches = []string{"/"}
+	}
+
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+
+		next := []string{}
+
+		for _, match := range matches {
+			if !hasGlobMeta(name) {
+				next = append(next, joinGlob(match, unescapePattern(name)))
+				continue
+			}
+
+			dirPath := match
+
+			if dirPath == "" {
+				dirPath = "."
+			}
+
+			realPath, dir, err := s.VFS.FindFile(dirPath)
+
+			if err != nil || dir.Type != T_DIR || !s.VFS.access(dir).Read {
+				continue
+			}
+
+			for _, entry := range dir.entries() {
+				entryName := entry.Name()
+
+				if realPath == "/home" && entryName == "{}" && s.VFS.login() != nil {
+					entryName = s.VFS.login().Username
+				}
+
+				if entryName[0] == '.' && name[0] != '.' {
+					continue
+				}
+
+				if ok, _ := path.Match(name, entryName); ok {
+					next = append(next, joinGlob(match, entryName))
+				}
+			}
+		}
+
+		matches = next
+	}
+
+	found := []string{}
+	dirsOnly := strings.HasSuffix(pattern, "/")
+
+	for _, match := range matches {
+		if _, _, err := s.VFS.LFindFile(match); err != nil {
+			continue
+		}
+
+		if dirsOnly {
+			if _, file, err := s.VFS.FindFile(match); err != nil || file.Type != T_DIR {
+				continue
+			}
+
+			match += "/"
+		}
+
+		found = append(found, match)
+	}
+
+	return found
+}
@@ -0,0 +1,1205 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// errUnexpectedEOF is the syntax error of input that ends in the middle of a
+// command, which an interactive shell reads more lines for.
+var errUnexpectedEOF = errors.New("syntax error: unexpected end of file")
+
+// These are the quoting states of a wordPart. Escaped characters are treated
+// as if they were single quoted.
+const (
+	quoteNone   byte = 0
+	quoteSingle byte = '\''
+	quoteDouble byte = '"'
+)
+
+// wordPart is a piece of a shell word. Quoted (or escaped) text is kept apart
+// from unquoted text, since it isn't subject to the same expansions.
+type wordPart struct {
+	text  string
+	quote byte
+}
+
+// shellWord is a single word of a command line, before any expansion.
+type shellWord []wordPart
+
+// String returns the text of the word with all of the quotes removed.
+func (w shellWord) String() string {
+	str := strings.Builder{}
+
+	for _, part := range w {
+		str.WriteString(part.text)
+	}
+
+	return str.String()
+}
+
+// source returns the word the way it could have been typed, with its quotes.
+func (w shellWord) source() string {
+	str := strings.Builder{}
+
+	for _, part := range w {
+		switch part.quote {
+		case quoteSingle:
+			str.WriteString("'" + part.text + "'")
+		case quoteDouble:
+			str.WriteString("\"" + part.text + "\"")
+		default:
+			str.WriteString(part.text)
+		}
+	}
+
+	return str.String()
+}
+
+// isQuoted returns whether any part of the word was quoted.
+func (w shellWord) isQuoted() bool {
+	for _, part := range w {
+		if part.quote != quoteNone {
+			return true
+		}
+	}
+
+	return false
+}
+
+// shellToken is either a word or an operator (when `op` is set).
+type shellToken struct {
+	op      string
+	word    shellWord
+	fd      int
+	heredoc string
+}
+
+// shellOperators are all of the control and redirection operators, longest
+// first, so that the lexer can always pick the longest match.
+var shellOperators = []string{
+	"&>>", "<<<", "<<-",
+	"&&", "||", ";;", "<<", ">>", "&>", ">&", "<&", ">|", "<>",
+	"|", "&", ";", "<", ">", "(", ")",
+}
+
+// shellLexer splits shell input into words and operators, following the
+// quoting rules of bash.
+type shellLexer struct {
+	input     string
+	pos       int
+	tokens    []shellToken
+	word      shellWord
+	inWord    bool
+	part      strings.Builder
+	partQuote byte
+	inPart    bool
+	heredocs  []int
+}
+
+// lexShell returns the tokens of the input.
+func lexShell(input string) ([]shellToken, error) {
+	l := &shellLexer{input: input}
+
+	if err := l.lex(); err != nil {
+		return nil, err
+	}
+
+	return l.tokens, nil
+}
+
+// addPart adds text to the current word, merging it with the previous part if
+// they have the same quoting. The part is built up in a buffer until the
+// quoting changes, since the words of droppers can be hundreds of kilobytes
+// long.
+func (l *shellLexer) addPart(text string, quote byte) {
+	if l.inPart && l.partQuote != quote {
+		l.endPart()
+	}
+
+	l.part.WriteString(text)
+	l.partQuote = quote
+	l.inPart = true
+	l.inWord = true
+}
+
+// endPart adds the part that's being built up to the current word.
+func (l *shellLexer) endPart() {
+	if l.inPart {
+		l.word = append(l.word, wordPart{text: l.part.String(), quote: l.partQuote})
+	}
+
+	l.part.Reset()
+	l.inPart = false
+}
+
+// endWord finishes the current word, if there is one.
+func (l *shellLexer) endWord() {
+	l.endPart()
+
+	if l.inWord {
+		l.tokens = append(l.tokens, shellToken{word: l.word, fd: -1})
+	}
+
+	l.word = nil
+	l.inWord = false
+}
+
+func (l *shellLexer) lex() error {
+	in := l.input
+
+	for l.pos < len(in) {
+		c := in[l.pos]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\r':
+			l.endWord()
+			l.pos++
+		case c == '\n':
+			l.endWord()
+			l.tokens = append(l.tokens, shellToken{op: "\n", fd: -1})
+			l.pos++
+
+			if err := l.readHeredocs(); err != nil {
+				return err
+			}
+		case c == '#' && !l.inWord:
+			for l.pos < len(in) && in[l.pos] != '\n' {
+				l.pos++
+			}
+		case c == '\\':
+			if l.pos+1 >= len(in) {
+				l.addPart("\\", quoteNone)
+				l.pos++
+			} else if in[l.pos+1] == '\n' {
+				// A line continuation.
+				l.pos += 2
+			} else {
+				l.addPart(in[l.pos+1:l.pos+2], quoteSingle)
+				l.pos += 2
+			}
+		case c == '\'':
+			end := strings.IndexByte(in[l.pos+1:], '\'')
+
+			if end < 0 {
+				return fmt.Errorf("unexpected EOF while looking for matching `''")
+			}
+
+			l.addPart(in[l.pos+1:l.pos+1+end], quoteSingle)
+			l.pos += end + 2
+		case c == '"':
+			if err := l.lexDoubleQuoted(); err != nil {
+				return err
+			}
+		case c == '$' && l.pos+1 < len(in) && in[l.pos+1] == '\'':
+			end, err := ansiQuoteEnd(in, l.pos+1)
+
+			if err != nil {
+				return err
+			}
+
+			l.addPart(unescapeANSI(in[l.pos+2:end]), quoteSingle)
+			l.pos = end + 1
+		case c == '$' && l.pos+1 < len(in) && (in[l.pos+1] == '(' || in[l.pos+1] == '{'):
+			end, err := matchingBracket(in, l.pos+1)
+
+			if err != nil {
+				return err
+			}
+
+			l.addPart(in[l.pos:end+1], quoteNone)
+			l.pos = end + 1
+		case c == '`':
+			end := strings.IndexByte(in[l.pos+1:], '`')
+
+			if end < 0 {
+				return fmt.Errorf("unexpected EOF while looking for matching ``'")
+			}
+
+			l.addPart(in[l.pos:l.pos+end+2], quoteNone)
+			l.pos += end + 2
+		case strings.IndexByte("|&;<>()", c) >= 0:
+			l.lexOperator()
+		default:
+			l.addPart(in[l.pos:l.pos+1], quoteNone)
+			l.pos++
+		}
+	}
+
+	l.endWord()
+
+	return nil
+}
+
+// lexDoubleQuoted reads a double quoted string, in which only some characters
+// can be escaped and expansions are still kept for later.
+func (l *shellLexer) lexDoubleQuoted() error {
+	in := l.input
+	l.pos++
+
+	// Make sure that an empty string ("") still makes a word.
+	l.addPart("", quoteDouble)
+
+	for l.pos < len(in) {
+		c := in[l.pos]
+
+		switch {
+		case c == '"':
+			l.pos++
+			return nil
+		case c == '\\' && l.pos+1 < len(in) && strings.IndexByte("$`\"\\\n", in[l.pos+1]) >= 0:
+			if in[l.pos+1] != '\n' {
+				l.addPart(in[l.pos+1:l.pos+2], quoteSingle)
+			}
+
+			l.pos += 2
+		case c == '$' && l.pos+1 < len(in) && (in[l.pos+1] == '(' || in[l.pos+1] == '{'):
+			end, err := matchingBracket(in, l.pos+1)
+
+			if err != nil {
+				return err
+			}
+
+			l.addPart(in[l.pos:end+1], quoteDouble)
+			l.pos = end + 1
+		default:
+			l.addPart(in[l.pos:l.pos+1], quoteDouble)
+			l.pos++
+		}
+	}
+
+	return fmt.Errorf("unexpected EOF while looking for matching `\"'")
+}
+
+// ansiQuoteEnd returns the position of the quote that closes the ANSI-C
+// quoted string ($'...') which starts at `start`. Unlike in a single quoted
+// string, a quote can be escaped in it.
+func ansiQuoteEnd(in string, start int) (int, error) {
+	for i := start + 1; i < len(in); i++ {
+		switch in[i] {
+		case '\\':
+			i++
+		case '\'':
+			return i, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unexpected EOF while looking for matching `''")
+}
+
+// unescapeANSI decodes the backslash escapes of an ANSI-C quoted string the
+// way bash does: the ones of C, \e for the escape character, \cX for control
+// characters and \u and \U for unicode characters. As in bash, a NUL byte ends
+// the string, and unknown escapes are kept as they are.
+func unescapeANSI(s string) string {
+	out := make([]byte, 0, len(s))
+
+	// number reads up to `max` digits of the base at `i` and returns the value
+	// and the number of digits.
+	number := func(i, max, base int) (uint64, int) {
+		n := 0
+
+		for n < max && i+n < len(s) {
+			if _, err := strconv.ParseUint(s[i+n:i+n+1], base, 8); err != nil {
+				break
+			}
+
+			n++
+		}
+
+		value, _ := strconv.ParseUint(s[i:i+n], base, 32)
+
+		return value, n
+	}
+
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 >= len(s) {
+			out = append(out, s[i])
+			continue
+		}
+
+		i++
+
+		switch c := s[i]; c {
+		case 'a':
+			out = append(out, '\a')
+		case 'b':
+			out = append(out, '\b')
+		case 'e', 'E':
+			out = append(out, 0x1b)
+		case 'f':
+			out = append(out, '\f')
+		case 'n':
+			out = append(out, '\n')
+		case 'r':
+			out = append(out, '\r')
+		case 't':
+			out = append(out, '\t')
+		case 'v':
+			out = append(out, '\v')
+		case '\\', '\'', '"', '?':
+			out = append(out, c)
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			value, n := number(i, 3, 8)
+
+			if byte(value) == 0 {
+				return string(out)
+			}
+
+			out = append(out, byte(value))
+			i += n - 1
+		case 'x', 'u', 'U':
+			max := map[byte]int{'x': 2, 'u': 4, 'U': 8}[c]
+			value, n := number(i+1, max, 16)
+
+			switch {
+			case n == 0:
+				out = append(out, '\\', c)
+			case value == 0:
+				return string(out)
+			case c == 'x':
+				out = append(out, byte(value))
+			default:
+				out = utf8.AppendRune(out, rune(value))
+			}
+
+			i += n
+		case 'c':
+			if i+1 >= len(s) {
+				out = append(out, '\\', c)
+				continue
+			}
+
+			i++
+
+			if s[i] == '?' {
+				out = append(out, 0x7f)
+			} else if ctrl := s[i] & 0x1f; ctrl == 0 {
+				return string(out)
+			} else {
+				out = append(out, ctrl)
+			}
+		default:
+			out = append(out, '\\', c)
+		}
+	}
+
+	return string(out)
+}
+
+// lexOperator reads the longest operator at the current position. A number
+// right before a redirection (e.g. `2>`) is the file descriptor.
+func (l *shellLexer) lexOperator() {
+	fd := -1
+	l.endPart()
+
+	if l.inWord && len(l.word) == 1 && l.word[0].quote == quoteNone && strings.IndexByte("<>", l.input[l.pos]) >= 0 {
+		if n, err := strconv.Atoi(l.word[0].text); err == nil && n >= 0 {
+			fd = n
+			l.word = nil
+			l.inWord = false
+		}
+	}
+
+	l.endWord()
+
+	for _, op := range shellOperators {
+		if strings.HasPrefix(l.input[l.pos:], op) {
+			l.tokens = append(l.tokens, shellToken{op: op, fd: fd})
+			l.pos += len(op)
+
+			if op == "<<" || op == "<<-" {
+				l.heredocs = append(l.heredocs, len(l.tokens)-1)
+			}
+
+			return
+		}
+	}
+}
+
+// readHeredocs reads the bodies of the here-documents that were started on
+// the line that just ended.
+func (l *shellLexer) readHeredocs() error {
+	for _, idx := range l.heredocs {
+		if idx+1 >= len(l.tokens) || l.tokens[idx+1].op != "" {
+			return fmt.Errorf("syntax error near unexpected token `newline'")
+		}
+
+		delim := l.tokens[idx+1].word.String()
+		stripTabs := l.tokens[idx].op == "<<-"
+		body := &strings.Builder{}
+
+		for l.pos < len(l.input) {
+			end := strings.IndexByte(l.input[l.pos:], '\n')
+			line := ""
+
+			if end < 0 {
+				line = l.input[l.pos:]
+				l.pos = len(l.input)
+			} else {
+				line = l.input[l.pos : l.pos+end]
+				l.pos += end + 1
+			}
+
+			if stripTabs {
+				line = strings.TrimLeft(line, "\t")
+			}
+
+			if line == delim {
+				break
+			}
+
+			body.WriteString(line)
+			body.WriteString("\n")
+		}
+
+		l.tokens[idx].heredoc = body.String()
+	}
+
+	l.heredocs = nil
+
+	return nil
+}
+
+// matchingBracket returns the position of the bracket that closes the one at
+// `start`, taking nested brackets and quotes into account.
+func matchingBracket(in string, start int) (int, error) {
+	open := in[start]
+	closing := byte(')')
+
+	if open == '{' {
+		closing = '}'
+	}
+
+	depth := 0
+
+	for i := start; i < len(in); i++ {
+		switch in[i] {
+		case '\\':
+			i++
+		case '\'':
+			if open == '(' && i > 0 && in[i-1] == '$' {
+				end, err := ansiQuoteEnd(in, i)
+
+				if err != nil {
+					return 0, err
+				}
+
+				i = end
+			} else if open == '(' {
+				end := strings.IndexByte(in[i+1:], '\'')
+
+				if end < 0 {
+					return 0, fmt.Errorf("unexpected EOF while looking for matching `''")
+				}
+
+				i += end + 1
+			}
+		case open:
+			depth++
+		case closing:
+			depth--
+
+			if depth == 0 {
+				return i, nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("unexpected EOF while looking for matching `%c'", closing)
+}
+
+// shellRedirect is a redirection of a command (e.g. `>/dev/null` or `2>&1`).
+type shellRedirect struct {
+	fd      int
+	op      string
+	target  shellWord
+	heredoc string
+}
+
+// shellNode is a command that can be part of a pipeline.
+type shellNode interface{}
+
+// simpleCommand is a command with its arguments and redirections.
+type simpleCommand struct {
+	words     []shellWord
+	redirects []shellRedirect
+}
+
+// String returns the command the way bash shows it in `jobs`, with a space
+// between every word and redirection.
+func (cmd *simpleCommand) String() string {
+	parts := []string{}
+
+	for _, word := range cmd.words {
+		parts = append(parts, word.source())
+	}
+
+	for _, r := range cmd.redirects {
+		fd := ""
+
+		if r.fd >= 0 {
+			fd = strconv.Itoa(r.fd)
+		}
+
+		// Bash puts a space after the operator, but not when it duplicates a
+		// file descriptor (e.g. `2>&1`).
+		if strings.HasSuffix(r.op, "&") {
+			parts = append(parts, fd+r.op+r.target.source())
+		} else {
+			parts = append(parts, fd+r.op+" "+r.target.source())
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// shellPipeline is a list of commands that are connected with pipes.
+type shellPipeline struct {
+	cmds   []shellNode
+	negate bool
+}
+
+// andOrList is a list of pipelines that are connected with `&&` and `||`.
+type andOrList struct {
+	pipelines []*shellPipeline
+	ops       []string
+}
+
+// String returns the commands the way bash shows them in `jobs`.
+func (andOr *andOrList) String() string {
+	str := ""
+
+	for i, pipeline := range andOr.pipelines {
+		if i > 0 {
+			str += " " + andOr.ops[i-1] + " "
+		}
+
+		if pipeline.negate {
+			str += "! "
+		}
+
+		for j, cmd := range pipeline.cmds {
+			if j > 0 {
+				str += " | "
+			}
+
+			str += fmt.Sprint(cmd)
+		}
+	}
+
+	return str
+}
+
+// shellList is a list of and-or lists separated by `;`, `&` or newlines. The
+// ones that are followed by `&` are meant to run in the background.
+type shellList struct {
+	items []*andOrList
+	async []bool
+}
+
+// String returns the commands the way bash shows them, on a single line.
+func (list *shellList) String() string {
+	str := ""
+
+	for i, andOr := range list.items {
+		str += andOr.String()
+
+		if list.async[i] {
+			str += " &"
+		} else if i < len(list.items)-1 {
+			str += ";"
+		}
+
+		if i < len(list.items)-1 {
+			str += " "
+		}
+	}
+
+	return str
+}
+
+// ifClause is an `if` command, with a body for each of its conditions (the
+// first one and those of `elif`), and the one of `else`, if there is one.
+type ifClause struct {
+	conds    []*shellList
+	bodies   []*shellList
+	elseBody *shellList
+}
+
+func (node *ifClause) String() string {
+	str := ""
+
+	for i, cond := range node.conds {
+		if i == 0 {
+			str += "if "
+		} else {
+			str += " elif "
+		}
+
+		str += cond.String() + "; then " + node.bodies[i].String() + ";"
+	}
+
+	if node.elseBody != nil {
+		str += " else " + node.elseBody.String() + ";"
+	}
+
+	return str + " fi"
+}
+
+// forLoop is a `for` loop, which goes over its words, or the positional
+// parameters when it has no `in`.
+type forLoop struct {
+	name  string
+	words []shellWord
+	in    bool
+	body  *shellList
+}
+
+func (node *forLoop) String() string {
+	str := "for " + node.name
+
+	if node.in {
+		str += " in"
+
+		for _, word := range node.words {
+			str += " " + word.source()
+		}
+	}
+
+	return str + "; do " + node.body.String() + "; done"
+}
+
+// whileLoop is a `while` loop, or an `until` loop, which runs until its
+// condition succeeds instead.
+type whileLoop struct {
+	cond  *shellList
+	body  *shellList
+	until bool
+}
+
+func (node *whileLoop) String() string {
+	keyword := "while"
+
+	if node.until {
+		keyword = "until"
+	}
+
+	return keyword + " " + node.cond.String() + "; do " + node.body.String() + "; done"
+}
+
+// braceGroup is a list of commands in braces, which run in the current shell.
+type braceGroup struct {
+	body *shellList
+}
+
+func (node *braceGroup) String() string {
+	return "{ " + node.body.String() + "; }"
+}
+
+// funcDef defines a function, like `name() { ...; }`.
+type funcDef struct {
+	name string
+	body shellNode
+}
+
+func (node *funcDef) String() string {
+	return fmt.Sprintf("%s () %s", node.name, node.body)
+}
+
+// redirected is a compound command with redirections, like `done < file`.
+type redirected struct {
+	node      shellNode
+	redirects []shellRedirect
+}
+
+func (node *redirected) String() string {
+	cmd := &simpleCommand{redirects: node.redirects}
+	return fmt.Sprintf("%s %s", node.node, cmd)
+}
+
+// shellParser builds the syntax tree of a list of tokens.
+type shellParser struct {
+	tokens []shellToken
+	pos    int
+}
+
+// parseShell parses the shell input into a list of commands.
+func parseShell(input string) (*shellList, error) {
+	tokens, err := lexShell(input)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p := &shellParser{tokens: tokens}
+	list, err := p.parseList()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos < len(p.tokens) {
+		return nil, p.unexpected()
+	}
+
+	return list, nil
+}
+
+func (p *shellParser) peek() *shellToken {
+	if p.pos >= len(p.tokens) {
+		return nil
+	}
+
+	return &p.tokens[p.pos]
+}
+
+// unexpected returns the error bash shows for the token at the current position.
+func (p *shellParser) unexpected() error {
+	tok := p.peek()
+
+	if tok == nil || tok.op == "\n" {
+		return fmt.Errorf("syntax error near unexpected token `newline'")
+	} else if tok.op != "" {
+		return fmt.Errorf("syntax error near unexpected token `%s'", tok.op)
+	}
+
+	return fmt.Errorf("syntax error near unexpected token `%s'", tok.word.String())
+}
+
+// skipNewlines skips over any newlines.
+func (p *shellParser) skipNewlines() {
+	for tok := p.peek(); tok != nil && tok.op == "\n"; tok = p.peek() {
+		p.pos++
+	}
+}
+
+func (p *shellParser) parseList() (*shellList, error) {
+	list := &shellList{}
+	p.skipNewlines()
+
+	for tok := p.peek(); tok != nil && p.startsCommand(tok) && !isReserved(tok, listEnds...); tok = p.peek() {
+		andOr, err := p.parseAndOr()
+
+		if err != nil {
+			return nil, err
+		}
+
+		async := false
+
+		if tok := p.peek(); tok != nil {
+			switch tok.op {
+			case "&":
+				async = true
+				p.pos++
+			case ";", "\n":
+				p.pos++
+			default:
+				list.items = append(list.items, andOr)
+				list.async = append(list.async, async)
+				return list, nil
+			}
+		}
+
+		list.items = append(list.items, andOr)
+		list.async = append(list.async, async)
+		p.skipNewlines()
+	}
+
+	return list, nil
+}
+
+// listEnds are the reserved words that end a list of commands.
+var listEnds = []string{"then", "elif", "else", "fi", "do", "done", "}"}
+
+// isReserved returns whether a token is one of the reserved words, which they
+// only are when they're unquoted and where a command could start.
+func isReserved(tok *shellToken, words ...string) bool {
+	if tok == nil || tok.op != "" || len(tok.word) != 1 || tok.word[0].quote != quoteNone {
+		return false
+	}
+
+	return slices.Contains(words, tok.word[0].text)
+}
+
+// expect skips over a reserved word, which has to be next.
+func (p *shellParser) expect(word string) error {
+	if tok := p.peek(); tok == nil {
+		return errUnexpectedEOF
+	} else if !isReserved(tok, word) {
+		return p.unexpected()
+	}
+
+	p.pos++
+
+	return nil
+}
+
+// startsCommand returns whether the token can be the start of a command.
+func (p *shellParser) startsCommand(tok *shellToken) bool {
+	if tok.op == "" {
+		return true
+	}
+
+	return strings.ContainsAny(tok.op, "<>")
+}
+
+func (p *shellParser) parseAndOr() (*andOrList, error) {
+	andOr := &andOrList{}
+
+	for {
+		pipeline, err := p.parsePipeline()
+
+		if err != nil {
+			return nil, err
+		}
+
+		andOr.pipelines = append(andOr.pipelines, pipeline)
+		tok := p.peek()
+
+		if tok == nil || (tok.op != "&&" && tok.op != "||") {
+			return andOr, nil
+		}
+
+		andOr.ops = append(andOr.ops, tok.op)
+		p.pos++
+		p.skipNewlines()
+	}
+}
+
+func (p *shellParser) parsePipeline() (*shellPipeline, error) {
+	pipeline := &shellPipeline{}
+
+	if tok := p.peek(); tok != nil && tok.op == "" && len(tok.word) == 1 && tok.word[0].quote == quoteNone && tok.word[0].text == "!" {
+		pipeline.negate = true
+		p.pos++
+	}
+
+	for {
+		cmd, err := p.parseCommand()
+
+		if err != nil {
+			return nil, err
+		}
+
+		pipeline.cmds = append(pipeline.cmds, cmd)
+
+		if tok := p.peek(); tok == nil || tok.op != "|" {
+			return pipeline, nil
+		}
+
+		p.pos++
+		p.skipNewlines()
+	}
+}
+
+func (p *shellParser) parseCommand() (shellNode, error) {
+	tok := p.peek()
+	var node shellNode
+	var err error
+
+	switch {
+	case isReserved(tok, "if"):
+		node, err = p.parseIf()
+	case isReserved(tok, "for"):
+		node, err = p.parseFor()
+	case isReserved(tok, "while", "until"):
+		node, err = p.parseWhile()
+	case isReserved(tok, "{"):
+		node, err = p.parseGroup()
+	case isReserved(tok, "function"):
+		p.pos++
+		node, err = p.parseFunction()
+	case p.isFunction():
+		node, err = p.parseFunction()
+	default:
+		return p.parseSimple()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	redirects := []shellRedirect{}
+
+	for tok := p.peek(); tok != nil && strings.ContainsAny(tok.op, "<>"); tok = p.peek() {
+		r, err := p.parseRedirect()
+
+		if err != nil {
+			return nil, err
+		}
+
+		redirects = append(redirects, r)
+	}
+
+	if len(redirects) > 0 {
+		return &redirected{node: node, redirects: redirects}, nil
+	}
+
+	return node, nil
+}
+
+func (p *shellParser) parseSimple() (shellNode, error) {
+	cmd := &simpleCommand{}
+
+	for tok := p.peek(); tok != nil; tok = p.peek() {
+		if tok.op == "" {
+			cmd.words = append(cmd.words, tok.word)
+			p.pos++
+			continue
+		}
+
+		if !strings.ContainsAny(tok.op, "<>") {
+			break
+		}
+
+		r, err := p.parseRedirect()
+
+		if err != nil {
+			return nil, err
+		}
+
+		cmd.redirects = append(cmd.redirects, r)
+	}
+
+	if len(cmd.words) == 0 && len(cmd.redirects) == 0 {
+		// Like after `&&` or `|`, a command that's missing at the very end
+		// may still come on the next line.
+		if p.peek() == nil {
+			return nil, errUnexpectedEOF
+		}
+
+		return nil, p.unexpected()
+	}
+
+	return cmd, nil
+}
+
+// parseRedirect parses a redirection operator and its target.
+func (p *shellParser) parseRedirect() (shellRedirect, error) {
+	tok := p.peek()
+	p.pos++
+	target := p.peek()
+
+	if target == nil || target.op != "" {
+		return shellRedirect{}, p.unexpected()
+	}
+
+	p.pos++
+
+	return shellRedirect{
+		fd:      tok.fd,
+		op:      tok.op,
+		target:  target.word,
+		heredoc: tok.heredoc,
+	}, nil
+}
+
+// parseBody parses the list of commands of a compound command, up to the
+// reserved word that ends it, which can't be empty.
+func (p *shellParser) parseBody(end string) (*shellList, error) {
+	list, err := p.parseList()
+
+	if err != nil {
+		return nil, err
+	} else if len(list.items) == 0 {
+		if p.peek() == nil {
+			return nil, errUnexpectedEOF
+		}
+
+		return nil, p.unexpected()
+	}
+
+	return list, p.expect(end)
+}
+
+// parseIf parses `if cond; then ...; elif cond; then ...; else ...; fi`.
+func (p *shellParser) parseIf() (shellNode, error) {
+	node := &ifClause{}
+	p.pos++
+
+	for {
+		cond, err := p.parseBody("then")
+
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := p.parseList()
+
+		if err != nil {
+			return nil, err
+		} else if len(body.items) == 0 {
+			if p.peek() == nil {
+				return nil, errUnexpectedEOF
+			}
+
+			return nil, p.unexpected()
+		}
+
+		node.conds = append(node.conds, cond)
+		node.bodies = append(node.bodies, body)
+
+		if isReserved(p.peek(), "elif") {
+			p.pos++
+			continue
+		} else if isReserved(p.peek(), "else") {
+			p.pos++
+
+			if node.elseBody, err = p.parseBody("fi"); err != nil {
+				return nil, err
+			}
+
+			return node, nil
+		}
+
+		return node, p.expect("fi")
+	}
+}
+
+// parseFor parses `for name in words; do ...; done`, where `in` and the words
+// can be left out.
+func (p *shellParser) parseFor() (shellNode, error) {
+	p.pos++
+	tok := p.peek()
+
+	if tok == nil {
+		return nil, errUnexpectedEOF
+	} else if tok.op != "" || !isName(tok.word.String()) || tok.word.isQuoted() {
+		return nil, fmt.Errorf("`%s': not a valid identifier", tok.word.String())
+	}
+
+	node := &forLoop{name: tok.word.String()}
+	p.pos++
+
+	if tok := p.peek(); tok != nil && tok.op == ";" {
+		p.pos++
+	}
+
+	p.skipNewlines()
+
+	if isReserved(p.peek(), "in") {
+		node.in = true
+		p.pos++
+
+		for tok := p.peek(); tok != nil && tok.op == ""; tok = p.peek() {
+			node.words = append(node.words, tok.word)
+			p.pos++
+		}
+
+		if tok := p.peek(); tok != nil && (tok.op == ";" || tok.op == "\n") {
+			p.pos++
+		} else if tok != nil {
+			return nil, p.unexpected()
+		}
+
+		p.skipNewlines()
+	}
+
+	if err := p.expect("do"); err != nil {
+		return nil, err
+	}
+
+	body, err := p.parseBody("done")
+
+	if err != nil {
+		return nil, err
+	}
+
+	node.body = body
+
+	return node, nil
+}
+
+// parseWhile parses `while cond; do ...; done` (or `until`).
+func (p *shellParser) parseWhile() (shellNode, error) {
+	node := &whileLoop{until: isReserved(p.peek(), "until")}
+	p.pos++
+
+	cond, err := p.parseBody("do")
+
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := p.parseBody("done")
+
+	if err != nil {
+		return nil, err
+	}
+
+	node.cond, node.body = cond, body
+
+	return node, nil
+}
+
+// parseGroup parses `{ ...; }`.
+func (p *shellParser) parseGroup() (shellNode, error) {
+	p.pos++
+	body, err := p.parseBody("}")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &braceGroup{body: body}, nil
+}
+
+// isFunction returns whether the next tokens start the definition of a
+// function, like `name() {`.
+func (p *shellParser) isFunction() bool {
+	if p.pos+2 >= len(p.tokens) || p.tokens[p.pos].op != "" {
+		return false
+	}
+
+	return p.tokens[p.pos+1].op == "(" && p.tokens[p.pos+2].op == ")"
+}
+
+// parseFunction parses the definition of a function, whose body is a compound
+// command. The parentheses after the name are optional after `function`.
+func (p *shellParser) parseFunction() (shellNode, error) {
+	tok := p.peek()
+
+	if tok == nil {
+		return nil, errUnexpectedEOF
+	} else if tok.op != "" {
+		return nil, p.unexpected()
+	}
+
+	node := &funcDef{name: tok.word.String()}
+	p.pos++
+
+	if tok := p.peek(); tok != nil && tok.op == "(" {
+		p.pos++
+
+		if tok := p.peek(); tok == nil || tok.op != ")" {
+			return nil, p.unexpected()
+		}
+
+		p.pos++
+	}
+
+	p.skipNewlines()
+
+	if !isReserved(p.peek(), "{", "if", "for", "while", "until") {
+		if p.peek() == nil {
+			return nil, errUnexpectedEOF
+		}
+
+		return nil, p.unexpected()
+	}
+
+	body, err := p.parseCommand()
+
+	if err != nil {
+		return nil, err
+	}
+
+	node.body = body
+
+	return node, nil
+}
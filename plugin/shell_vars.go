@@ -0,0 +1,795 @@
+package plugin
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// readonlyVars are the variables that bash doesn't let scripts change.
+var readonlyVars = []string{"UID", "EUID", "PPID"}
+
+// exportedVars are the variables that bash sets by itself and that are in the
+// environment of every command.
+var exportedVars = []string{"HOME", "LOGNAME", "PATH", "PWD", "SHELL", "SHLVL", "USER"}
+
+// errExpandFailed is returned by an assignment whose value couldn't be
+// expanded, which was already reported.
+var errExpandFailed = errors.New("expansion failed")
+
+// isName returns whether a string can be the name of a variable.
+func isName(name string) bool {
+	return name != "" && nameLen(name) == len(name)
+}
+
+// nameLen returns the length of the name of a variable at the start of a
+// string, which is 0 if there's none.
+func nameLen(str string) int {
+	for i := 0; i < len(str); i++ {
+		c := str[i]
+
+		if c != '_' && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (i == 0 || c < '0' || c > '9') {
+			return i
+		}
+	}
+
+	return len(str)
+}
+
+// GetVar returns the value of a shell variable, and whether it's set. The
+// variables that bash sets by itself (like $HOME or $PWD) follow the session,
+// unless a script set them.
+func (s *Session) GetVar(name string) (string, bool) {
+	if value, ok := s.vars[name]; ok {
+		return value, true
+	}
+
+	uid, euid := 1000, 1000
+
+	if s.User != nil {
+		uid = s.User.UID
+	}
+
+	if s.VFS.User != nil {
+		euid = s.VFS.User.UID
+	}
+
+	switch name {
+	case "HOME":
+		return s.home(), true
+	case "PWD":
+		return s.VFS.userPath(s.VFS.PWD), true
+	case "USER", "LOGNAME":
+		if s.User != nil {
+			return s.User.Username, true
+		}
+	case "UID":
+		return strconv.Itoa(uid), true
+	case "EUID":
+		return strconv.Itoa(euid), true
+	case "PPID":
+		if p := s.VFS.FindProcess(s.PID); p != nil {
+			return strconv.Itoa(p.PPID), true
+		}
+	case "SHELL":
+		return "/bin/bash", true
+	case "PATH":
+		return strings.Join(commandPath, ":"), true
+	case "HOSTNAME":
+		return s.VFS.Hostname(), true
+	case "SHLVL":
+		return strconv.Itoa(len(s.shells) + 1), true
+	case "RANDOM":
+		return strconv.Itoa(rand.IntN(32768)), true
+	case "IFS":
+		return " \t\n", true
+	}
+
+	return "", false
+}
+
+// SetVar sets a shell variable, which fails for the ones that are read-only.
+func (s *Session) SetVar(name, value string) error {
+	if slices.Contains(readonlyVars, name) {
+		return fmt.Errorf("%s: readonly variable", name)
+	}
+
+	if s.vars == nil {
+		s.vars = map[string]string{}
+	}
+
+	s.vars[name] = value
+
+	return nil
+}
+
+// unsetVar removes a shell variable.
+func (s *Session) unsetVar(name string) error {
+	if slices.Contains(readonlyVars, name) {
+		return fmt.Errorf("%s: cannot unset: readonly variable", name)
+	}
+
+	delete(s.vars, name)
+	delete(s.exported, name)
+
+	return nil
+}
+
+// isExported returns whether a variable is in the environment of commands.
+func (s *Session) isExported(name string) bool {
+	return s.exported[name] || slices.Contains(exportedVars, name)
+}
+
+// param returns a positional parameter, where $0 is the name of the shell (or
+// of the script).
+func (s *Session) param(n int) string {
+	if n < len(s.args) {
+		return s.args[n]
+	} else if n == 0 && s.Interactive {
+		return "-bash"
+	} else if n == 0 {
+		return "bash"
+	}
+
+	return ""
+}
+
+// positional returns the positional parameters, without $0.
+func (s *Session) positional() []string {
+	if len(s.args) < 2 {
+		return []string{}
+	}
+
+	return s.args[1:]
+}
+
+// setPositional replaces the positional parameters, but keeps $0.
+func (s *Session) setPositional(params []string) {
+	s.args = slices.Concat([]string{s.param(0)}, params)
+}
+
+// subshell runs something in a copy of the shell, like `$(...)` does, whose
+// variables, functions and `exit` don't affect the shell of the session.
+func (s *Session) subshell(fn func()) {
+	vars, exported, functions, args := maps.Clone(s.vars), maps.Clone(s.exported), maps.Clone(s.functions), s.args
+	loops, returnable, locals := s.loops, s.returnable, s.locals
+
+	s.loops, s.returnable, s.locals = 0, 0, nil
+	s.scripts++
+	fn()
+	s.scripts--
+
+	s.exiting, s.returning, s.breaking, s.continuing = false, false, 0, 0
+	s.vars, s.exported, s.functions, s.args = vars, exported, functions, args
+	s.loops, s.returnable, s.locals = loops, returnable, locals
+}
+
+// environ keeps only the variables that were exported, which are the ones that
+// another program (like a script that the shell runs) gets.
+func (s *Session) environ() {
+	vars := map[string]string{}
+
+	for name := range s.exported {
+		if value, ok := s.vars[name]; ok {
+			vars[name] = value
+		}
+	}
+
+	s.vars = vars
+	s.functions = nil
+}
+
+// assignment splits a word like `NAME=value` (or `NAME+=value`) into the name
+// and the value, if it's an assignment at all.
+func assignment(word shellWord) (string, shellWord, bool) {
+	if len(word) == 0 || word[0].quote != quoteNone {
+		return "", nil, false
+	}
+
+	name, value, ok := strings.Cut(word[0].text, "=")
+
+	if !ok || !isName(strings.TrimSuffix(name, "+")) {
+		return "", nil, false
+	}
+
+	rest := slices.Clone(word)
+	rest[0].text = value
+
+	return name, rest, true
+}
+
+// assign runs an assignment, which is neither split into fields nor globbed.
+func (s *Session) assign(name string, value shellWord) error {
+	failed := s.expandFailed
+	s.expandFailed = false
+	str := joinFields(s.expandParams(s.expandTilde(value.chars()), false))
+
+	// Like bash, the variable isn't changed when its value can't be expanded.
+	if s.expandFailed {
+		return errExpandFailed
+	}
+
+	s.expandFailed = failed
+
+	if name, ok := strings.CutSuffix(name, "+"); ok {
+		prev, _ := s.GetVar(name)
+
+		if size := len(prev) + len(str); size > maxExpansion {
+			s.expandFailed = true
+			return expansionError(uint64(size))
+		}
+
+		return s.SetVar(name, prev+str)
+	}
+
+	return s.SetVar(name, str)
+}
+
+// assignWords runs the assignments of a command line without a command, and
+// returns whether all of them succeeded.
+func (s *Session) assignWords(words []shellWord) bool {
+	ok := true
+
+	for _, word := range words {
+		name, value, _ := assignment(word)
+
+		if err := s.assign(name, value); err == errExpandFailed {
+			ok = false
+		} else if err != nil {
+			s.ErrWrite("bash: ", err.Error(), "\n")
+			ok = false
+		}
+	}
+
+	return ok
+}
+
+// assignTemporarily runs the assignments before a command (like `LANG=C ls`),
+// which are in its environment until it's over. It returns the function that
+// puts the variables back.
+func (s *Session) assignTemporarily(words []shellWord) func() {
+	prev := map[string]*string{}
+	exported := map[string]bool{}
+
+	for _, word := range words {
+		name, value, _ := assignment(word)
+		base := strings.TrimSuffix(name, "+")
+
+		if _, ok := prev[base]; !ok {
+			prev[base] = nil
+			exported[base] = s.exported[base]
+
+			if old, ok := s.vars[base]; ok {
+				prev[base] = &old
+			}
+		}
+
+		if err := s.assign(name, value); err != nil {
+			if err != errExpandFailed {
+				s.ErrWrite("bash: ", err.Error(), "\n")
+			}
+
+			continue
+		}
+
+		if s.exported == nil {
+			s.exported = map[string]bool{}
+		}
+
+		s.exported[base] = true
+	}
+
+	return func() {
+		s.restoreVars(prev)
+
+		for name, was := range exported {
+			if !was {
+				delete(s.exported, name)
+			}
+		}
+	}
+}
+
+// restoreVars puts variables back to the values that they had, where nil is
+// for the ones that weren't set.
+func (s *Session) restoreVars(prev map[string]*string) {
+	for name, old := range prev {
+		if old == nil {
+			delete(s.vars, name)
+		} else {
+			s.vars[name] = *old
+		}
+	}
+}
+
+// fieldBuilder puts the fields of a word together while it's expanded. A field
+// that was started is kept even if it's empty (e.g. for `"$empty"`).
+type fieldBuilder struct {
+	fields  [][]shellChar
+	field   []shellChar
+	started bool
+}
+
+func (f *fieldBuilder) add(chars ...shellChar) {
+	f.field = append(f.field, chars...)
+	f.started = true
+}
+
+func (f *fieldBuilder) end() {
+	if f.started {
+		f.fields = append(f.fields, f.field)
+	}
+
+	f.field = nil
+	f.started = false
+}
+
+// expandParams expands the parameters (like `$HOME`, `${1:-x}` or `$?`), the
+// command substitutions and the arithmetic of a word. With `split`, the results
+// that weren't double quoted are split into fields on $IFS, which is why more
+// than one field can come out. The results are never expanded again.
+func (s *Session) expandParams(chars []shellChar, split bool) [][]shellChar {
+	f := &fieldBuilder{}
+	ifs, _ := s.GetVar("IFS")
+	size := len(chars)
+
+	for i := 0; i < len(chars); i++ {
+		c := chars[i]
+
+		if c.quote == quoteSingle || (c.c != '$' && c.c != '`') {
+			f.add(c)
+			continue
+		}
+
+		values, end, ok := s.expandDollar(chars, i)
+
+		if !ok {
+			f.add(c)
+			continue
+		}
+
+		i = end
+		quoted := c.quote == quoteDouble || !split
+
+		// Like the braces, the word can't take up more memory than bash
+		// would have (e.g. with `x=$x$x` in a loop).
+		for _, value := range values {
+			size += len(value)
+		}
+
+		if size > maxExpansion {
+			s.ErrWrite("bash: ", expansionError(uint64(size)).Error(), "\n")
+			s.status = 1
+			s.expandFailed = true
+
+			return nil
+		}
+
+		for j, value := range values {
+			if j > 0 {
+				f.end()
+			}
+
+			if quoted {
+				f.add(literalChars(value)...)
+				continue
+			}
+
+			for k := 0; k < len(value); k++ {
+				if strings.IndexByte(ifs, value[k]) >= 0 {
+					f.end()
+				} else {
+					f.add(shellChar{value[k], quoteNone})
+				}
+			}
+		}
+	}
+
+	f.end()
+
+	return f.fields
+}
+
+// expandDollar expands the `$` (or backquote) at `i`, and returns the values
+// that it stands for along with where it ends. There's more than one value
+// only for `$@`. It's not an expansion at all if it's not followed by a name,
+// a special parameter, braces or parentheses.
+func (s *Session) expandDollar(chars []shellChar, i int) ([]string, int, bool) {
+	quote := chars[i].quote
+	end := i + 1
+
+	// Everything up to the end of the expansion has the same quoting, since
+	// the lexer kept it in one piece.
+	for end < len(chars) && chars[end].quote == quote {
+		end++
+	}
+
+	text := charsWord(chars[i:end]).String()
+
+	if text[0] == '`' {
+		closing := strings.IndexByte(text[1:], '`')
+
+		if closing < 0 {
+			return nil, i, false
+		}
+
+		return []string{s.substitute(text[1 : closing+1])}, i + closing + 1, true
+	}
+
+	if len(text) < 2 {
+		return nil, i, false
+	}
+
+	switch c := text[1]; {
+	case c == '(' || c == '{':
+		closing, err := matchingBracket(text, 1)
+
+		if err != nil {
+			return nil, i, false
+		}
+
+		inner := text[2:closing]
+
+		if c == '{' {
+			return s.expandBraceParam(inner, quote == quoteDouble), i + closing, true
+		} else if strings.HasPrefix(inner, "(") && strings.HasSuffix(inner, ")") {
+			n, err := s.arith(s.expandText(inner[1 : len(inner)-1]))
+
+			if err != nil {
+				s.ErrWrite("bash: ", err.Error(), "\n")
+				s.status = 1
+				s.expandFailed = true
+			}
+
+			return []string{strconv.Itoa(n)}, i + closing, true
+		}
+
+		return []string{s.substitute(inner)}, i + closing, true
+	case strings.IndexByte("?$!#@*-0123456789", c) >= 0:
+		return s.specialParam(c, quote == quoteDouble), i + 1, true
+	}
+
+	n := nameLen(text[1:])
+
+	if n == 0 {
+		return nil, i, false
+	}
+
+	value, _ := s.GetVar(text[1 : n+1])
+
+	return []string{value}, i + n, true
+}
+
+// specialParam returns the value of a special parameter, like `$?`. Only `$@`
+// (or an unquoted `$*`) has a value for each positional parameter.
+func (s *Session) specialParam(c byte, quoted bool) []string {
+	switch c {
+	case '?':
+		return []string{strconv.Itoa(s.status)}
+	case '$':
+		return []string{strconv.Itoa(s.PID)}
+	case '!':
+		if s.lastJob == 0 {
+			return []string{""}
+		}
+
+		return []string{strconv.Itoa(s.lastJob)}
+	case '#':
+		return []string{strconv.Itoa(len(s.positional()))}
+	case '-':
+		if s.Interactive {
+			return []string{"himBHs"}
+		}
+
+		return []string{"hB"}
+	case '@':
+		return s.positional()
+	case '*':
+		if quoted {
+			ifs, _ := s.GetVar("IFS")
+			return []string{strings.Join(s.positional(), ifs[:min(len(ifs), 1)])}
+		}
+
+		return s.positional()
+	}
+
+	return []string{s.param(int(c - '0'))}
+}
+
+// expandBraceParam expands what's between the braces of `${...}`, which can be
+// `#name` for the length of a variable or a name followed by an operator, like
+// `:-default`, `#prefix`, `%suffix`, `/pattern/replacement` or `:offset:length`.
+func (s *Session) expandBraceParam(expr string, quoted bool) []string {
+	if rest, ok := strings.CutPrefix(expr, "#"); ok && rest != "" {
+		values := s.expandBraceParam(rest, quoted)
+
+		if rest == "@" || rest == "*" {
+			return []string{strconv.Itoa(len(values))}
+		}
+
+		return []string{strconv.Itoa(len(strings.Join(values, " ")))}
+	}
+
+	n := nameLen(expr)
+
+	if n == 0 && expr != "" && strings.IndexByte("?$!#@*-0123456789", expr[0]) >= 0 {
+		n = 1
+
+		// Positional parameters can have more than one digit in braces.
+		for n < len(expr) && expr[0] >= '0' && expr[0] <= '9' && expr[n] >= '0' && expr[n] <= '9' {
+			n++
+		}
+	}
+
+	if n == 0 {
+		s.ErrWrite("bash: ${", expr, "}: bad substitution\n")
+		s.status = 1
+		s.expandFailed = true
+		return []string{""}
+	}
+
+	name, op := expr[:n], expr[n:]
+	var values []string
+	set := true
+
+	if n, err := strconv.Atoi(name); err == nil {
+		values, set = []string{s.param(n)}, n < len(s.args)
+	} else if n == 1 && !isName(name) {
+		values = s.specialParam(name[0], quoted)
+		set = name != "!" || s.lastJob != 0
+	} else {
+		value, ok := s.GetVar(name)
+		values, set = []string{value}, ok
+	}
+
+	value := strings.Join(values, " ")
+
+	if op == "" {
+		return values
+	}
+
+	// The operators that only check whether the variable is set don't care
+	// about it being empty, unless they start with a colon.
+	unset := !set || (strings.HasPrefix(op, ":") && value == "")
+
+	switch {
+	case strings.HasPrefix(op, ":-") || strings.HasPrefix(op, "-"):
+		if unset {
+			return []string{s.expandText(strings.TrimLeft(op, ":")[1:])}
+		}
+
+		return values
+	case strings.HasPrefix(op, ":=") || strings.HasPrefix(op, "="):
+		if unset {
+			value = s.expandText(strings.TrimLeft(op, ":")[1:])
+
+			if err := s.SetVar(name, value); err != nil {
+				s.ErrWrite("bash: ", err.Error(), "\n")
+			}
+
+			return []string{value}
+		}
+
+		return values
+	case strings.HasPrefix(op, ":+") || strings.HasPrefix(op, "+"):
+		if unset {
+			return []string{""}
+		}
+
+		return []string{s.expandText(strings.TrimLeft(op, ":")[1:])}
+	case strings.HasPrefix(op, ":?") || strings.HasPrefix(op, "?"):
+		if unset {
+			msg := s.expandText(strings.TrimLeft(op, ":")[1:])
+
+			if msg == "" {
+				msg = "parameter null or not set"
+			}
+
+			s.ErrWrite("bash: ", name, ": ", msg, "\n")
+			s.status = 1
+			s.expandFailed = true
+		}
+
+		return values
+	case strings.HasPrefix(op, "#"), strings.HasPrefix(op, "%"):
+		longest := strings.HasPrefix(op, "##") || strings.HasPrefix(op, "%%")
+		pattern := op[1:]
+
+		if longest {
+			pattern = op[2:]
+		}
+
+		return []string{trimPattern(value, s.expandText(pattern), op[0] == '#', longest)}
+	case strings.HasPrefix(op, "/"):
+		all := strings.HasPrefix(op, "//")
+		pattern, replacement, _ := strings.Cut(op[1:], "/")
+
+		if all {
+			pattern, replacement, _ = strings.Cut(op[2:], "/")
+		}
+
+		return []string{replacePattern(value, s.expandText(pattern), s.expandText(replacement), all)}
+	case strings.HasPrefix(op, ":"):
+		offset, length, hasLength := strings.Cut(op[1:], ":")
+		from, err := s.arith(strings.TrimSpace(offset))
+		to := len(value)
+
+		if hasLength && err == nil {
+			var n int
+			n, err = s.arith(strings.TrimSpace(length))
+			to = from + n
+		}
+
+		if err != nil {
+			s.ErrWrite("bash: ", err.Error(), "\n")
+			s.status = 1
+			return []string{""}
+		}
+
+		if from < 0 {
+			from += len(value)
+		}
+
+		from = max(min(from, len(value)), 0)
+		to = max(min(to, len(value)), from)
+
+		return []string{value[from:to]}
+	}
+
+	s.ErrWrite("bash: ${", expr, "}: bad substitution\n")
+	s.status = 1
+	s.expandFailed = true
+
+	return []string{""}
+}
+
+// patternRegexp turns a shell pattern into a regular expression, where `*`
+// matches anything (slashes too), unlike when it's used to match paths.
+func patternRegexp(pattern string) *regexp.Regexp {
+	expr := strings.Builder{}
+
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+
+			if end < 0 {
+				expr.WriteString(`\[`)
+				continue
+			}
+
+			class := pattern[i+1 : i+1+end]
+
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+
+			expr.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+
+	re, err := regexp.Compile("(?s)^(?:" + expr.String() + ")$")
+
+	if err != nil {
+		return regexp.MustCompile("(?s)^" + regexp.QuoteMeta(pattern) + "$")
+	}
+
+	return re
+}
+
+// trimPattern removes the shortest (or longest) prefix or suffix of a value
+// that matches a pattern, like `${x#pattern}` and `${x%pattern}` do.
+func trimPattern(value, pattern string, prefix, longest bool) string {
+	re := patternRegexp(pattern)
+
+	for n := range len(value) + 1 {
+		if longest {
+			n = len(value) - n
+		}
+
+		if prefix && re.MatchString(value[:n]) {
+			return value[n:]
+		} else if !prefix && re.MatchString(value[len(value)-n:]) {
+			return value[:len(value)-n]
+		}
+	}
+
+	return value
+}
+
+// replacePattern replaces the longest match of a pattern in a value (or all of
+// them), like `${x/pattern/replacement}` does.
+func replacePattern(value, pattern, replacement string, all bool) string {
+	if pattern == "" {
+		return value
+	}
+
+	re := patternRegexp(pattern)
+	out := strings.Builder{}
+
+	for i := 0; i < len(value); i++ {
+		matched := false
+
+		for end := len(value); end > i; end-- {
+			if re.MatchString(value[i:end]) {
+				out.WriteString(replacement)
+				i, matched = end-1, true
+				break
+			}
+		}
+
+		if !matched {
+			out.WriteByte(value[i])
+		} else if !all {
+			return out.String() + value[i+1:]
+		}
+	}
+
+	return out.String()
+}
+
+// expandText expands the parameters, command substitutions and arithmetic in
+// text the way they are in double quotes, which is also how the bodies of
+// here-documents are expanded.
+func (s *Session) expandText(text string) string {
+	chars := []shellChar{}
+
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\\' && i+1 < len(text) && strings.IndexByte("$`\\", text[i+1]) >= 0 {
+			chars = append(chars, shellChar{text[i+1], quoteSingle})
+			i++
+		} else {
+			chars = append(chars, shellChar{text[i], quoteDouble})
+		}
+	}
+
+	return joinFields(s.expandParams(chars, false))
+}
+
+// joinFields puts the fields of a word that wasn't split back together, which
+// only `$@` makes more than one of.
+func joinFields(fields [][]shellChar) string {
+	strs := make([]string, len(fields))
+
+	for i, field := range fields {
+		strs[i] = charsWord(field).String()
+	}
+
+	return strings.Join(strs, " ")
+}
+
+// substitute runs a command substitution in a subshell, and returns what the
+// command wrote, without the trailing newlines.
+func (s *Session) substitute(command string) string {
+	out := &bytes.Buffer{}
+	stdout, failed := s.stdout, s.expandFailed
+	s.stdout = out
+
+	s.subshell(func() {
+		s.Run(command)
+	})
+
+	s.stdout, s.expandFailed = stdout, failed
+	s.substituted = true
+
+	return strings.TrimRight(out.String(), "\n")
+}
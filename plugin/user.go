@@ -1,6 +1,191 @@
 package plugin
 
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ErrUnknownUser is returned when a user or group isn't in the VFS's
+// /etc/passwd or /etc/group.
+var ErrUnknownUser = errors.New("no such user")
+
+// User is a user of the machine, as /etc/passwd and /etc/group describe them.
+// Group is the name of the primary group and Groups are the supplementary
+// ones.
 type User struct {
 	Username string
 	Group    string
+	UID      int
+	GID      int
+	Groups   []string
+	Home     string
+	Shell    string
+}
+
+// IsRoot returns whether the user is the superuser, who can read and write
+// any file. Like in Linux, that's any user with the ID 0, whatever their name.
+func (u *User) IsRoot() bool {
+	return u != nil && u.UID == 0
+}
+
+// InGroup returns whether the user is a member of a group, either as their
+// primary group or as a supplementary one.
+func (u *User) InGroup(group string) bool {
+	return u.Group == group || slices.Contains(u.Groups, group)
+}
+
+// Group is a group of the machine, from /etc/group.
+type Group struct {
+	Name    string
+	GID     int
+	Members []string
+}
+
+// dbEntries splits a database like /etc/passwd into the fields of its lines,
+// skipping comments and lines with fewer than `n` fields.
+func dbEntries(contents string, n int) [][]string {
+	entries := [][]string{}
+
+	for _, line := range strings.Split(contents, "\n") {
+		if line = strings.TrimSpace(line); line == "" || line[0] == '#' {
+			continue
+		}
+
+		if fields := strings.Split(line, ":"); len(fields) >= n {
+			entries = append(entries, fields)
+		}
+	}
+
+	return entries
+}
+
+// Groups returns the groups in the VFS's /etc/group.
+func (vfs *VFS) Groups() []Group {
+	contents, _ := vfs.ReadFile("/etc/group")
+	groups := []Group{}
+
+	for _, fields := range dbEntries(contents, 4) {
+		gid, err := strconv.Atoi(fields[2])
+
+		if err != nil {
+			continue
+		}
+
+		group := Group{Name: fields[0], GID: gid}
+
+		for _, member := range strings.Split(fields[3], ",") {
+			if member != "" {
+				group.Members = append(group.Members, member)
+			}
+		}
+
+		groups = append(groups, group)
+	}
+
+	return groups
+}
+
+// Users returns the users in the VFS's /etc/passwd, along with the groups that
+// they're in.
+func (vfs *VFS) Users() []User {
+	contents, _ := vfs.ReadFile("/etc/passwd")
+	groups := vfs.Groups()
+	users := []User{}
+
+	for _, fields := range dbEntries(contents, 7) {
+		uid, errUID := strconv.Atoi(fields[2])
+		gid, errGID := strconv.Atoi(fields[3])
+
+		if errUID != nil || errGID != nil {
+			continue
+		}
+
+		user := User{
+			Username: fields[0],
+			Group:    fields[3],
+			UID:      uid,
+			GID:      gid,
+			Home:     fields[5],
+			Shell:    fields[6],
+		}
+
+		for _, group := range groups {
+			if group.GID == gid {
+				user.Group = group.Name
+			} else if slices.Contains(group.Members, user.Username) {
+				user.Groups = append(user.Groups, group.Name)
+			}
+		}
+
+		users = append(users, user)
+	}
+
+	return users
+}
+
+// LookupUser finds a user by their name.
+func (vfs *VFS) LookupUser(name string) (*User, error) {
+	for _, user := range vfs.Users() {
+		if user.Username == name {
+			return &user, nil
+		}
+	}
+
+	return nil, ErrUnknownUser
+}
+
+// LookupUID finds a user by their ID.
+func (vfs *VFS) LookupUID(uid int) (*User, error) {
+	for _, user := range vfs.Users() {
+		if user.UID == uid {
+			return &user, nil
+		}
+	}
+
+	return nil, ErrUnknownUser
+}
+
+// LookupGroup finds a group by its name.
+func (vfs *VFS) LookupGroup(name string) (*Group, error) {
+	for _, group := range vfs.Groups() {
+		if group.Name == name {
+			return &group, nil
+		}
+	}
+
+	return nil, ErrUnknownUser
+}
+
+// SessionUser returns the user that someone who logged in with a name becomes.
+// Attackers mostly log in with names that the machine doesn't have, in which
+// case they get the first free ID from 1000 onwards, like `adduser` would have
+// given them.
+func (vfs *VFS) SessionUser(name string) *User {
+	if user, err := vfs.LookupUser(name); err == nil {
+		return user
+	}
+
+	users := vfs.Users()
+	uid := 1000
+
+	for slices.ContainsFunc(users, func(u User) bool { return u.UID == uid }) {
+		uid++
+	}
+
+	home := "/home/" + name
+
+	if name == "root" {
+		uid, home = 0, "/root"
+	}
+
+	return &User{
+		Username: name,
+		Group:    name,
+		UID:      uid,
+		GID:      uid,
+		Home:     home,
+		Shell:    "/bin/bash",
+	}
 }
@@ -14,6 +14,17 @@ const T_FILE = 2
 const T_SYMLINK = 3
 const T_ANY = 4
 
+// These describe where the contents of a written file came from and are
+// passed to every WriteHook.
+const (
+	ArtifactSourceVFS         = "vfs"
+	ArtifactSourceSFTP        = "sftp"
+	ArtifactSourceSCP         = "scp"
+	ArtifactSourceDownload    = "download"
+	ArtifactSourcePlaceholder = "placeholder"
+	ArtifactSourceHistory     = "history"
+)
+
 // Perm is the basic permissions structure of a Linux file.
 type Perm struct {
 	Read  bool
@@ -35,83 +46,38 @@ type VFSFile struct {
 	ModTime  time.Time          `json:"mt"`
 	LinkTo   string             `json:"lt"`
 	NLink    int                `json:"nl"`
+	Template bool               `json:"tp,omitempty"`
 	CmdFn    CommandFn          `json:"-"`
+	Provider FileProvider       `json:"-"`
 }
 
-// findFile returns the directory of a file, which it finds by its name.
-func (f *VFSFile) findFile(name string, rest []string) (string, *VFSFile, error) {
-	if name == f.Name && len(rest) == 0 {
-		return name, f, nil
-	} else if name == f.Name && len(rest) > 0 {
-		if f.Type != T_DIR {
-			return "", nil, fmt.Errorf("file not a directory")
-		}
-
-		if file, ok := f.Files[rest[0]]; ok {
-			newPath, newFile, err := file.findFile(rest[0], rest[1:])
-
-			if err != nil {
-				return "", nil, err
-			}
-
-			if name == "" {
-				name = "/"
-			}
-
-			return filepath.Join(name, newPath), newFile, nil
-		}
-	}
-
-	return "", nil, fmt.Errorf("file not found")
-}
-
-// CanAccess returns the permissions the user has on the specific file.
+// CanAccess returns the permissions the user has on the specific file. Only
+// one set of the permission bits applies: the owner's, the group's (if the
+// user is in it) or everyone else's. Root can read and write anything, and can
+// execute anything that anyone can execute.
 func (f *VFSFile) CanAccess(user *User) Perm {
-	var buf [32]bool
-	w := 0
-
-	// 13, one for each of "dalTLDpSugct?"
-	for i := 0; i < 13; i++ {
-		if f.Mode&(1<<uint(32-1-i)) != 0 {
-			buf[w] = true
-			w++
-		}
-	}
-
-	if w == 0 {
-		buf[w] = false
-		w++
-	}
+	mode := f.Mode.Perm()
 
-	// 9, one for each of "rwxrwxrwx"
-	for i := 0; i < 9; i++ {
-		if f.Mode&(1<<uint(9-1-i)) != 0 {
-			buf[w] = true
-		} else {
-			buf[w] = false
+	if user.IsRoot() {
+		return Perm{
+			Read:  true,
+			Write: true,
+			Exec:  f.Type == T_DIR || mode&0111 != 0,
 		}
-
-		w++
 	}
 
-	rawPerms := buf[:w]
-	perm := Perm{}
-
-	if user.Username == f.Owner {
-		perm.Read = rawPerms[1]
-		perm.Write = rawPerms[2]
-		perm.Exec = rawPerms[3]
-	} else if user.Username != f.Owner && user.Group == f.Group {
-		perm.Read = rawPerms[4]
-		perm.Write = rawPerms[5]
-		perm.Exec = rawPerms[6]
-	} else if user.Username != f.Owner && user.Group != f.Group {
-		perm.Read = rawPerms[7]
-		perm.Write = rawPerms[8]
-		perm.Exec = rawPerms[9]
+	switch {
+	case user.Username == f.Owner:
+		mode >>= 6
+	case user.InGroup(f.Group):
+		mode >>= 3
 	}
 
-	return perm
+	return Perm{
+		Read:  mode&04 != 0,
+		Write: mode&02 != 0,
+		Exec:  mode&01 != 0,
+	}
 }
 
 // ForEach loops through all of the files.
@@ -134,12 +100,40 @@ func (f *VFSFile) StrMode() string {
 	return f.Mode.String()
 }
 
+// WriteHook is called every time the contents of a file are written to the
+// VFS. The path is the absolute path of the file, as the user would see it.
+type WriteHook func(path string, contents []byte, source string)
+
 // VFS is the recursive struct that describes the virtual file system.
 type VFS struct {
-	Root VFSFile `json:"root"`
-	Home string  `json:"home"`
-	PWD  string  `json:"-"`
-	User *User   `json:"-"`
+	Root       VFSFile          `json:"root"`
+	Home       string           `json:"home"`
+	PWD        string           `json:"-"`
+	User       *User            `json:"-"`
+	Login      *User            `json:"-"`
+	Machine    *Machine         `json:"-"`
+	Persona    *Persona         `json:"-"`
+	RemoteIP   string           `json:"-"`
+	writeHooks []WriteHook      `json:"-"`
+	base       *VFS             `json:"-"`
+	owned      map[string]bool  `json:"-"`
+	procs      map[int]*Process `json:"-"`
+}
+
+// AddWriteHook registers a function that will be called after any file is
+// written to the VFS.
+func (vfs *VFS) AddWriteHook(hook WriteHook) {
+	vfs.writeHooks = append(vfs.writeHooks, hook)
+}
+
+// userPath replaces the username placeholder in a path with the name of the
+// current user.
+func (vfs *VFS) userPath(path string) string {
+	if vfs.login() == nil || !strings.HasPrefix(path, "/home/{}") {
+		return path
+	}
+
+	return strings.Replace(path, "/home/{}", "/home/"+vfs.login().Username, 1)
 }
 
 // resolveDotPath is a helper function that converts a dot path to an absolute
@@ -152,12 +146,11 @@ func (vfs *VFS) resolveDotPath(path string) string {
 	return filepath.Join(vfs.PWD, path)
 }
 
-// FindFile returns the path and VFSFile of a file in the path.
-func (vfs *VFS) FindFile(path string) (string, *VFSFile, error) {
+// AbsPath converts a path, which can be relative to the working directory or
+// the home directory, into a clean absolute path.
+func (vfs *VFS) AbsPath(path string) string {
 	if path == "" {
-		path = "/"
-	} else if len(path) > 2 && strings.HasSuffix(path, "/") {
-		path = path[:len(path)-1]
+		return "/"
 	}
 
 	if strings.HasPrefix(path, "~") {
@@ -168,98 +161,159 @@ func (vfs *VFS) FindFile(path string) (string, *VFSFile, error) {
 		}
 	} else if path == "." || strings.HasPrefix(path, "./") {
 		path = vfs.resolveDotPath(path)
-	} else if path == ".." || strings.HasPrefix(path, "../") {
-		path = filepath.Join(vfs.PWD, path)
 	} else if !strings.HasPrefix(path, "/") {
 		path = filepath.Join(vfs.PWD, path)
 	}
 
-	username := "{}"
+	return filepath.Clean(path)
+}
+
+// maxSymlinks is how many symbolic links a single lookup follows before it
+// gives up, which is the same limit as Linux.
+const maxSymlinks = 40
+
+// FindFile returns the path and VFSFile of a file in the path, following any
+// symbolic links along the way (like stat(2)). The returned path is the real
+// path of the file.
+func (vfs *VFS) FindFile(path string) (string, *VFSFile, error) {
+	filePath, file, err := vfs.resolve(path, true)
 
-	if vfs.User != nil {
-		username = vfs.User.Username
+	if err != nil {
+		return "", nil, err
 	}
 
-	if path == "/" {
-		return "/", &vfs.Root, nil
-	} else if strings.HasPrefix(path, "/home/"+username) {
-		path = strings.Replace(path, "/home/"+username, "/home/{}", 1)
+	return filePath, file, nil
+}
+
+// LFindFile is like FindFile, but if the file itself is a symbolic link, the
+// link is returned instead of what it points to (like lstat(2)).
+func (vfs *VFS) LFindFile(path string) (string, *VFSFile, error) {
+	filePath, file, err := vfs.resolve(path, false)
+
+	if err != nil {
+		return "", nil, err
 	}
 
-	parts := strings.Split(path, "/")
+	return filePath, file, nil
+}
 
-	return vfs.Root.findFile(parts[0], parts[1:])
+// resolve walks a path from the root, following the symbolic links in it. The
+// last name of the path is only followed if `follow` is set. When it's the
+// last name that doesn't exist, the path where it would be is still returned
+// along with the error, so that it can be created.
+func (vfs *VFS) resolve(path string, follow bool) (string, *VFSFile, error) {
+	names := strings.Split(vfs.AbsPath(path), "/")
+	dirs := []*VFSFile{&vfs.Root}
+	current := "/"
+	links := 0
+
+	for len(names) > 0 {
+		name := names[0]
+		names = names[1:]
+		dir := dirs[len(dirs)-1]
+
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			if len(dirs) > 1 {
+				dirs = dirs[:len(dirs)-1]
+				current = dirName(current)
+			}
+
+			continue
+		}
+
+		if dir.Type != T_DIR {
+			return "", nil, ErrNotDir
+		} else if current == "/home" && vfs.login() != nil && name == vfs.login().Username {
+			name = "{}"
+		}
+
+		child, ok := dir.Files[name]
+
+		if !ok {
+			if len(names) == 0 {
+				return filepath.Join(current, name), nil, ErrNotExist
+			}
+
+			return "", nil, ErrNotExist
+		}
+
+		if child.Type == T_SYMLINK && (follow || len(names) > 0) {
+			if links++; links > maxSymlinks {
+				return "", nil, ErrLoop
+			}
+
+			if strings.HasPrefix(child.LinkTo, "/") {
+				dirs = dirs[:1]
+				current = "/"
+			}
+
+			names = append(strings.Split(child.LinkTo, "/"), names...)
+			continue
+		}
+
+		current = filepath.Join(current, name)
+		dirs = append(dirs, &child)
+	}
+
+	return current, dirs[len(dirs)-1], nil
 }
 
 // Mkdir creates a new directory at the given path.
 func (vfs *VFS) Mkdir(path string, mode os.FileMode) (*VFSFile, error) {
-	_, file, err := vfs.FindFile(filepath.Dir(path))
+	dirPath, file, err := vfs.writableDir(filepath.Dir(path))
 
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("cannot create directory ‘%s’: %w", path, ErrNotExist)
 	}
 
 	if file.Type != T_DIR {
-		return nil, fmt.Errorf("cannot create directory ‘%s‘: No such file or directory", path)
+		return nil, fmt.Errorf("cannot create directory ‘%s’: %w", path, ErrNotDir)
 	}
 
-	realUser := *vfs.User
-
-	if vfs.User.Username == realUser.Username {
-		realUser = User{
-			Username: "{}",
-			Group:    "{}",
-		}
-	}
-
-	perms := file.CanAccess(&realUser)
-
-	if !perms.Write {
-		return nil, fmt.Errorf("cannot create directory ‘%s‘: Permission denied", path)
+	if !vfs.access(file).Write {
+		return nil, fmt.Errorf("cannot create directory ‘%s’: %w", path, ErrPermission)
 	}
 
 	base := filepath.Base(path)
 
 	if _, ok := file.Files[base]; ok {
-		return nil, fmt.Errorf("file %q already exists", path)
+		return nil, fmt.Errorf("cannot create directory ‘%s’: %w", path, ErrExist)
 	}
 
 	if mode == 0 {
 		mode = 0775
 	}
 
+	// Directories in a setgid directory are setgid themselves.
+	owner, group := vfs.newOwner(file)
 	newFile := VFSFile{
 		Name:    base,
 		Type:    T_DIR,
-		Mode:    mode | os.ModeDir,
+		Mode:    mode | os.ModeDir | file.Mode&os.ModeSetgid,
 		Files:   make(map[string]VFSFile),
-		Owner:   "{}",
-		Group:   "{}",
+		Owner:   owner,
+		Group:   group,
 		ModTime: time.Now(),
 	}
 
 	file.Files[base] = newFile
+	vfs.claim(filepath.Join(dirPath, base), &newFile)
+	vfs.touchDir(dirPath)
 
 	return &newFile, nil
 }
 
 // Rmfile deletes a file in the filesystem.
 func (vfs *VFS) Rmfile(path string) error {
-	_, parentFolder, err := vfs.FindFile(filepath.Dir(path))
+	parentPath, parentFolder, err := vfs.writableDir(filepath.Dir(path))
 
 	if err != nil {
 		return err
 	}
 
-	realUser := *vfs.User
-
-	if vfs.User.Username == realUser.Username {
-		realUser = User{
-			Username: "{}",
-			Group:    "{}",
-		}
-	}
-
 	var file VFSFile
 	var ok bool
 
@@ -269,67 +323,52 @@ func (vfs *VFS) Rmfile(path string) error {
 		return fmt.Errorf("no such file or directory")
 	}
 
-	perms := file.CanAccess(&realUser)
-
-	if !perms.Write {
+	if !vfs.canUnlink(parentFolder, &file) {
 		return fmt.Errorf("permission denied")
 	}
 
 	delete(parentFolder.Files, base)
+	vfs.disown(filepath.Join(parentPath, base))
+	vfs.touchDir(parentPath)
 
 	return nil
 }
 
-// WriteFile adds contents to a specific file in the path.
-func (vfs *VFS) WriteFile(path, contents string) error {
-	_, parentFolder, err := vfs.FindFile(filepath.Dir(path))
+// ReadFile returns the contents of a regular file.
+func (vfs *VFS) ReadFile(path string) (string, error) {
+	_, file, err := vfs.FindFile(path)
 
 	if err != nil {
-		return err
+		return "", fmt.Errorf("no such file or directory")
 	}
 
-	realUser := *vfs.User
-
-	if vfs.User.Username == realUser.Username {
-		realUser = User{
-			Username: "{}",
-			Group:    "{}",
-		}
+	if file.Type == T_DIR {
+		return "", fmt.Errorf("is a directory")
 	}
 
-	base := filepath.Base(path)
-	perms := parentFolder.CanAccess(&realUser)
-
-	if !perms.Write {
-		return fmt.Errorf("permission denied")
-	}
+	return vfs.FileContents(file), nil
+}
 
-	file, ok := parentFolder.Files[base]
+// WriteFile adds contents to a specific file in the path.
+func (vfs *VFS) WriteFile(path, contents string) error {
+	return vfs.WriteFileFrom(path, contents, ArtifactSourceVFS)
+}
 
-	if ok && file.Type == T_DIR {
-		return fmt.Errorf("file is a directory")
-	} else if ok && !file.CanAccess(&realUser).Write {
-		return fmt.Errorf("permission denied")
-	}
+// WriteFileFrom is like WriteFile, but it also records where the contents came
+// from (for example an SFTP upload or a download), so that the write hooks can
+// tell them apart.
+func (vfs *VFS) WriteFileFrom(path, contents, source string) error {
+	handle, err := vfs.openFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0664, source)
 
-	// TODO: Implement all modes, read, write, append.
-	if ok {
-		file.Contents = contents
-	} else {
-		file = VFSFile{
-			Type:     T_FILE,
-			Mode:     0664,
-			Name:     base,
-			Contents: contents,
-			Owner:    "{}",
-			Group:    "{}",
-			ModTime:  time.Now(),
-		}
+	if err != nil {
+		return err
 	}
 
-	parentFolder.Files[base] = file
+	// Even empty contents count as a write.
+	handle.dirty = true
+	handle.WriteString(contents)
 
-	return nil
+	return handle.Close()
 }
 
 // ReadVFSJSONFile reads the JSON file which contains the the virtual file system model.
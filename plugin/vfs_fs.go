@@ -0,0 +1,265 @@
+package plugin
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"time"
+)
+
+// VFSFileSys is what the Sys method of the fs.FileInfo of a VFSFile returns.
+type VFSFileSys struct {
+	Owner string
+	Group string
+	NLink int
+}
+
+// vfsFileInfo describes a VFSFile as an fs.FileInfo.
+type vfsFileInfo struct {
+	name string
+	file VFSFile
+}
+
+func (fi *vfsFileInfo) Name() string       { return fi.name }
+func (fi *vfsFileInfo) ModTime() time.Time { return fi.file.ModTime }
+func (fi *vfsFileInfo) IsDir() bool        { return fi.file.Type == T_DIR }
+
+// Size returns the size of the contents of a file. Like on ext4, directories
+// take up a block and links are as long as the path they point to, and like
+// on procfs, dynamic files are empty.
+func (fi *vfsFileInfo) Size() int64 {
+	if fi.file.Provider != nil {
+		return 0
+	}
+
+	switch fi.file.Type {
+	case T_DIR:
+		return 4096
+	case T_SYMLINK:
+		return int64(len(fi.file.LinkTo))
+	}
+
+	return int64(len(fi.file.Contents))
+}
+
+// Mode returns the mode of the file, making sure that its type bits agree with
+// the type of the file.
+func (fi *vfsFileInfo) Mode() fs.FileMode {
+	mode := fi.file.Mode &^ (fs.ModeDir | fs.ModeSymlink)
+
+	switch fi.file.Type {
+	case T_DIR:
+		mode |= fs.ModeDir
+	case T_SYMLINK:
+		mode |= fs.ModeSymlink
+	}
+
+	return mode
+}
+
+// Sys returns the owner, group and number of links of the file.
+func (fi *vfsFileInfo) Sys() any {
+	nlink := fi.file.NLink
+
+	if nlink == 0 {
+		nlink = 1
+	}
+
+	return &VFSFileSys{
+		Owner: fi.file.Owner,
+		Group: fi.file.Group,
+		NLink: nlink,
+	}
+}
+
+// Info returns the file as an fs.FileInfo.
+func (f *VFSFile) Info() fs.FileInfo {
+	return &vfsFileInfo{name: f.Name, file: *f}
+}
+
+// entries returns the files of a directory as sorted fs.DirEntry values.
+func (f *VFSFile) entries() []fs.DirEntry {
+	names := make([]string, 0, len(f.Files))
+
+	for name := range f.Files {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+	entries := make([]fs.DirEntry, 0, len(names))
+
+	for _, name := range names {
+		file := f.Files[name]
+		entries = append(entries, fs.FileInfoToDirEntry(&vfsFileInfo{name: name, file: file}))
+	}
+
+	return entries
+}
+
+// VFSFS lets the VFS be used with anything that works on an fs.FS, such as
+// fs.WalkDir, fs.Glob, template.ParseFS or http.FileServer. Paths are relative
+// to the root of the VFS, the way io/fs wants them, symbolic links are
+// followed, and there are no permission checks. It's an adapter, rather than
+// the VFS itself, because the VFS already has a ReadFile that returns a
+// string.
+type VFSFS struct {
+	vfs *VFS
+}
+
+var (
+	_ fs.FS         = (*VFSFS)(nil)
+	_ fs.StatFS     = (*VFSFS)(nil)
+	_ fs.ReadDirFS  = (*VFSFS)(nil)
+	_ fs.ReadFileFS = (*VFSFS)(nil)
+)
+
+// FS returns the fs.FS view of the VFS.
+func (vfs *VFS) FS() *VFSFS {
+	return &VFSFS{vfs: vfs}
+}
+
+// find looks up a file by its io/fs path.
+func (fsys *VFSFS) find(op, name string) (string, *VFSFile, error) {
+	if !fs.ValidPath(name) {
+		return "", nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	// A valid path is always relative, and "." is the root.
+	if name == "." {
+		name = ""
+	}
+
+	filePath, file, err := fsys.vfs.FindFile("/" + name)
+
+	if err != nil {
+		return "", nil, &fs.PathError{Op: op, Path: name, Err: fsError(err)}
+	}
+
+	return filePath, file, nil
+}
+
+// Open opens a file for reading.
+func (fsys *VFSFS) Open(name string) (fs.File, error) {
+	filePath, file, err := fsys.find("open", name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &VFSHandle{
+		vfs:   fsys.vfs,
+		name:  name,
+		path:  filePath,
+		flag:  os.O_RDONLY,
+		data:  []byte(fsys.vfs.FileContents(file)),
+		isDir: file.Type == T_DIR,
+	}, nil
+}
+
+// Stat returns the fs.FileInfo of a file.
+func (fsys *VFSFS) Stat(name string) (fs.FileInfo, error) {
+	_, file, err := fsys.find("stat", name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &vfsFileInfo{name: path.Base(name), file: *file}, nil
+}
+
+// ReadDir returns the files of a directory, sorted by name.
+func (fsys *VFSFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	_, file, err := fsys.find("readdir", name)
+
+	if err != nil {
+		return nil, err
+	} else if file.Type != T_DIR {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: ErrNotDir}
+	}
+
+	return file.entries(), nil
+}
+
+// ReadFile returns the contents of a file.
+func (fsys *VFSFS) ReadFile(name string) ([]byte, error) {
+	_, file, err := fsys.find("read", name)
+
+	if err != nil {
+		return nil, err
+	} else if file.Type == T_DIR {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: ErrIsDir}
+	}
+
+	return []byte(fsys.vfs.FileContents(file)), nil
+}
+
+// fsError converts the errors of the VFS to the ones that io/fs checks for.
+func fsError(err error) error {
+	switch {
+	case errors.Is(err, ErrNotExist):
+		return fs.ErrNotExist
+	case errors.Is(err, ErrExist):
+		return fs.ErrExist
+	case errors.Is(err, ErrPermission):
+		return fs.ErrPermission
+	case errors.Is(err, ErrInvalid):
+		return fs.ErrInvalid
+	}
+
+	return err
+}
+
+// Stat returns the fs.FileInfo of the open file.
+func (h *VFSHandle) Stat() (fs.FileInfo, error) {
+	if h.closed {
+		return nil, fs.ErrClosed
+	}
+
+	_, file, err := h.vfs.FindFile(h.path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	info := &vfsFileInfo{name: path.Base(h.name), file: *file}
+
+	if !info.IsDir() {
+		info.file.Contents = string(h.data)
+	}
+
+	return info, nil
+}
+
+// ReadDir reads the files of an open directory, like fs.ReadDirFile.
+func (h *VFSHandle) ReadDir(n int) ([]fs.DirEntry, error) {
+	if h.closed {
+		return nil, fs.ErrClosed
+	} else if !h.isDir {
+		return nil, ErrNotDir
+	}
+
+	if h.entries == nil {
+		_, file, err := h.vfs.FindFile(h.path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		h.entries = file.entries()
+	}
+
+	entries := h.entries[h.dirOffset:]
+
+	if n > 0 && len(entries) == 0 {
+		return nil, io.EOF
+	} else if n > 0 && n < len(entries) {
+		entries = entries[:n]
+	}
+
+	h.dirOffset += len(entries)
+
+	return entries, nil
+}
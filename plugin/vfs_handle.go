@@ -0,0 +1,273 @@
+package plugin
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrBadFD is returned when a handle is used in a way it wasn't opened for,
+// like writing to a file that was opened only for reading.
+var ErrBadFD = errors.New("Bad file descriptor")
+
+// VFSHandle is a file of the VFS that was opened with OpenFile. Writes are
+// kept in the handle and are stored in the VFS (and passed to the write hooks)
+// when the handle is synced or closed.
+type VFSHandle struct {
+	vfs       *VFS
+	name      string
+	path      string
+	flag      int
+	data      []byte
+	offset    int64
+	isDir     bool
+	dirty     bool
+	closed    bool
+	source    string
+	entries   []fs.DirEntry
+	dirOffset int
+}
+
+// OpenFile opens a file with the same flags as os.OpenFile (O_RDONLY, O_WRONLY,
+// O_RDWR, O_APPEND, O_CREATE, O_EXCL and O_TRUNC). New files are created with
+// the permissions in `perm`.
+func (vfs *VFS) OpenFile(path string, flag int, perm os.FileMode) (*VFSHandle, error) {
+	return vfs.openFile(path, flag, perm, ArtifactSourceVFS)
+}
+
+func (vfs *VFS) openFile(path string, flag int, perm os.FileMode, source string) (*VFSHandle, error) {
+	access := flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR)
+	readable := access == os.O_RDONLY || access == os.O_RDWR
+	writable := access == os.O_WRONLY || access == os.O_RDWR
+	handle := &VFSHandle{
+		vfs:    vfs,
+		name:   path,
+		flag:   flag,
+		source: source,
+	}
+
+	filePath, file, err := vfs.resolve(path, true)
+
+	if err == ErrNotExist && filePath != "" && flag&os.O_CREATE != 0 {
+		dirPath, dir, err := vfs.lookupDir(filePath)
+
+		if err != nil {
+			return nil, err
+		} else if !vfs.access(dir).Write {
+			return nil, ErrPermission
+		}
+
+		base := filepath.Base(filePath)
+		owner, group := vfs.newOwner(dir)
+		dir.Files[base] = VFSFile{
+			Type:    T_FILE,
+			Name:    base,
+			Mode:    perm.Perm(),
+			Owner:   owner,
+			Group:   group,
+			ModTime: time.Now(),
+		}
+		vfs.touchDir(dirPath)
+		handle.path = filepath.Join(dirPath, base)
+
+		return handle, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+		return nil, ErrExist
+	} else if file.Type == T_DIR && writable {
+		return nil, ErrIsDir
+	}
+
+	if perms := vfs.access(file); (readable && !perms.Read) || (writable && !perms.Write) {
+		return nil, ErrPermission
+	}
+
+	handle.path = filePath
+	handle.isDir = file.Type == T_DIR
+	handle.data = []byte(vfs.FileContents(file))
+
+	if writable && flag&os.O_TRUNC != 0 {
+		handle.data = nil
+		vfs.updateFile(filePath, func(f *VFSFile) {
+			f.Contents = ""
+			f.Template = false
+			f.ModTime = time.Now()
+		})
+	}
+
+	return handle, nil
+}
+
+// Truncate changes the size of a file, like `truncate -s`.
+func (vfs *VFS) Truncate(path string, size int64) error {
+	handle, err := vfs.OpenFile(path, os.O_WRONLY, 0)
+
+	if err != nil {
+		return err
+	}
+
+	if err = handle.Truncate(size); err != nil {
+		return err
+	}
+
+	return handle.Close()
+}
+
+// storeFile replaces the contents of a file and passes them to the write hooks.
+// If the file was removed in the meantime, the hooks still get the contents.
+// Like on Linux, writing to a file as anyone but root drops its setuid and
+// setgid bits.
+func (vfs *VFS) storeFile(path, contents, source string) {
+	vfs.updateFile(path, func(f *VFSFile) {
+		f.Contents = contents
+		f.Template = false
+		f.ModTime = time.Now()
+
+		if !vfs.isRoot() {
+			f.Mode &^= os.ModeSetuid | os.ModeSetgid
+		}
+	})
+
+	for _, hook := range vfs.writeHooks {
+		hook(vfs.userPath(path), []byte(contents), source)
+	}
+}
+
+// Name returns the path that the file was opened with.
+func (h *VFSHandle) Name() string {
+	return h.name
+}
+
+func (h *VFSHandle) readable() bool {
+	access := h.flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR)
+	return access == os.O_RDONLY || access == os.O_RDWR
+}
+
+func (h *VFSHandle) writable() bool {
+	access := h.flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR)
+	return access == os.O_WRONLY || access == os.O_RDWR
+}
+
+// Read reads from the current offset of the file.
+func (h *VFSHandle) Read(p []byte) (int, error) {
+	if h.closed {
+		return 0, fs.ErrClosed
+	} else if !h.readable() {
+		return 0, ErrBadFD
+	} else if h.isDir {
+		return 0, ErrIsDir
+	} else if h.offset >= int64(len(h.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, h.data[h.offset:])
+	h.offset += int64(n)
+
+	return n, nil
+}
+
+// Write writes at the current offset of the file, or at the end of it when
+// the file was opened with O_APPEND. Writing past the end fills the gap with
+// zeros.
+func (h *VFSHandle) Write(p []byte) (int, error) {
+	if h.closed {
+		return 0, fs.ErrClosed
+	} else if !h.writable() {
+		return 0, ErrBadFD
+	}
+
+	if h.flag&os.O_APPEND != 0 {
+		h.offset = int64(len(h.data))
+	}
+
+	end := h.offset + int64(len(p))
+
+	if end > int64(len(h.data)) {
+		h.data = append(h.data, make([]byte, end-int64(len(h.data)))...)
+	}
+
+	copy(h.data[h.offset:], p)
+	h.offset = end
+	h.dirty = true
+
+	return len(p), nil
+}
+
+// WriteString is like Write, but with a string.
+func (h *VFSHandle) WriteString(s string) (int, error) {
+	return h.Write([]byte(s))
+}
+
+// Seek sets the offset for the next read or write.
+func (h *VFSHandle) Seek(offset int64, whence int) (int64, error) {
+	if h.closed {
+		return 0, fs.ErrClosed
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += h.offset
+	case io.SeekEnd:
+		offset += int64(len(h.data))
+	}
+
+	if offset < 0 {
+		return 0, ErrInvalid
+	}
+
+	h.offset = offset
+
+	return offset, nil
+}
+
+// Truncate changes the size of the file, without moving the offset.
+func (h *VFSHandle) Truncate(size int64) error {
+	if h.closed {
+		return fs.ErrClosed
+	} else if !h.writable() {
+		return ErrBadFD
+	} else if size < 0 {
+		return ErrInvalid
+	}
+
+	if size > int64(len(h.data)) {
+		h.data = append(h.data, make([]byte, size-int64(len(h.data)))...)
+	} else {
+		h.data = h.data[:size]
+	}
+
+	h.dirty = true
+
+	return nil
+}
+
+// Sync stores everything that was written so far in the VFS.
+func (h *VFSHandle) Sync() error {
+	if h.closed {
+		return fs.ErrClosed
+	}
+
+	if h.dirty {
+		h.vfs.storeFile(h.path, string(h.data), h.source)
+		h.dirty = false
+	}
+
+	return nil
+}
+
+// Close stores the contents of the file and closes the handle.
+func (h *VFSHandle) Close() error {
+	if err := h.Sync(); err != nil {
+		return err
+	}
+
+	h.closed = true
+
+	return nil
+}
@@ -0,0 +1,726 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// These are the errors of the VFS operations. They read like strerror(3), so
+// that they can be shown to the attacker as they are.
+var (
+	ErrNotExist     = errors.New("No such file or directory")
+	ErrExist        = errors.New("File exists")
+	ErrPermission   = errors.New("Permission denied")
+	ErrNotPermitted = errors.New("Operation not permitted")
+	ErrIsDir        = errors.New("Is a directory")
+	ErrNotDir       = errors.New("Not a directory")
+	ErrNotEmpty     = errors.New("Directory not empty")
+	ErrInvalid      = errors.New("Invalid argument")
+	ErrLoop         = errors.New("Too many levels of symbolic links")
+)
+
+// specialBits are the bits of a mode that chmod can change, besides the
+// permissions.
+const specialBits = os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// user returns the session's user. A VFS that isn't used by a session acts as
+// the "{}" placeholder that the files of the session's user are owned by.
+func (vfs *VFS) user() *User {
+	if vfs.User == nil {
+		return &User{Username: "{}", Group: "{}", UID: 1000, GID: 1000}
+	}
+
+	return vfs.User
+}
+
+// login returns the user that logged in, who the "{}" placeholder stands for.
+// It's the same as User, unless the session acts as someone else, like after
+// `su` or while it runs a setuid program.
+func (vfs *VFS) login() *User {
+	if vfs.Login != nil {
+		return vfs.Login
+	}
+
+	return vfs.User
+}
+
+// isRoot returns whether the session's user is root, who can do anything.
+func (vfs *VFS) isRoot() bool {
+	return vfs.User.IsRoot()
+}
+
+// fileOwner returns the owner and group of a file, with the placeholder that
+// the files of the session's user are owned by replaced with their names.
+func (vfs *VFS) fileOwner(f *VFSFile) (string, string) {
+	owner, group := f.Owner, f.Group
+
+	if login := vfs.login(); login != nil {
+		if owner == "{}" {
+			owner = login.Username
+		}
+
+		if group == "{}" {
+			group = login.Group
+		}
+	}
+
+	return owner, group
+}
+
+// access returns the permissions that the session's user has on a file.
+func (vfs *VFS) access(f *VFSFile) Perm {
+	file := *f
+	file.Owner, file.Group = vfs.fileOwner(f)
+
+	return file.CanAccess(vfs.user())
+}
+
+// owns returns whether the session's user owns a file, which root always does.
+func (vfs *VFS) owns(f *VFSFile) bool {
+	owner, _ := vfs.fileOwner(f)
+	return vfs.isRoot() || owner == vfs.user().Username
+}
+
+// canUnlink returns whether the session's user can remove a file from a
+// directory. In a sticky directory, like /tmp, only the owner of the file or
+// of the directory can.
+func (vfs *VFS) canUnlink(dir, file *VFSFile) bool {
+	if !vfs.access(dir).Write {
+		return false
+	}
+
+	return dir.Mode&os.ModeSticky == 0 || vfs.owns(file) || vfs.owns(dir)
+}
+
+// newOwner returns the owner and group of a file that the session's user
+// creates in a directory. In a directory with the setgid bit, files get the
+// group of the directory instead of the user's.
+func (vfs *VFS) newOwner(dir *VFSFile) (string, string) {
+	user := vfs.user()
+	owner, group := vfs.ownerName(user.Username), vfs.ownerName(user.Group)
+
+	if dir.Mode&os.ModeSetgid != 0 {
+		group = dir.Group
+	}
+
+	return owner, group
+}
+
+// ownerName converts the name of the session's user into the placeholder that
+// the files of the VFS are owned by. Root keeps its own name, since its files
+// aren't in a home directory that stands for whoever logged in.
+func (vfs *VFS) ownerName(name string) string {
+	if login := vfs.login(); login != nil && !login.IsRoot() && (name == login.Username || name == login.Group) {
+		return "{}"
+	}
+
+	return name
+}
+
+// internalPath returns the absolute path of a file the way it's stored in the
+// VFS, meaning with the placeholder in place of the user's home directory.
+func (vfs *VFS) internalPath(path string) string {
+	path = vfs.AbsPath(path)
+
+	if login := vfs.login(); login != nil && strings.HasPrefix(path, "/home/"+login.Username) {
+		path = strings.Replace(path, "/home/"+login.Username, "/home/{}", 1)
+	}
+
+	return path
+}
+
+// realPath returns the path of a file, which may not exist yet, with the
+// symbolic links of its directories resolved.
+func (vfs *VFS) realPath(path string) string {
+	if filePath, _, _ := vfs.resolve(path, false); filePath != "" {
+		return filePath
+	}
+
+	return vfs.internalPath(path)
+}
+
+// lookupDir finds the directory a new file would go into, making sure it can
+// be changed.
+func (vfs *VFS) lookupDir(path string) (string, *VFSFile, error) {
+	dirPath, dir, err := vfs.FindFile(dirName(vfs.internalPath(path)))
+
+	if err != nil {
+		return "", nil, err
+	} else if dir.Type != T_DIR {
+		return "", nil, ErrNotDir
+	}
+
+	return vfs.writableDir(dirPath)
+}
+
+// updateFile changes a file in place. Directories hold their files by value,
+// so the changed copy has to be put back into the parent.
+func (vfs *VFS) updateFile(path string, fn func(*VFSFile)) error {
+	filePath, _, err := vfs.FindFile(path)
+
+	if err != nil {
+		return err
+	}
+
+	if filePath == "/" {
+		fn(&vfs.Root)
+		return nil
+	}
+
+	_, parent, err := vfs.writableDir(dirName(filePath))
+
+	if err != nil {
+		return err
+	}
+
+	base := filepath.Base(filePath)
+	file := parent.Files[base]
+	fn(&file)
+	parent.Files[base] = file
+
+	return nil
+}
+
+// touchDir updates the modification time of a directory whose files changed.
+func (vfs *VFS) touchDir(path string) {
+	vfs.updateFile(path, func(f *VFSFile) {
+		f.ModTime = time.Now()
+	})
+}
+
+// claim marks a directory that was created by an overlay (and every directory
+// in it) as its own, since nothing in there is shared with the base.
+func (vfs *VFS) claim(path string, file *VFSFile) {
+	if vfs.owned == nil || file.Type != T_DIR {
+		return
+	}
+
+	vfs.owned[path] = true
+
+	for name, child := range file.Files {
+		vfs.claim(filepath.Join(path, name), &child)
+	}
+}
+
+// disown forgets about the directories of an overlay under a path that was
+// removed, since anything that's put there later may come from the base.
+func (vfs *VFS) disown(path string) {
+	for owned := range vfs.owned {
+		if owned == path || strings.HasPrefix(owned, path+"/") {
+			delete(vfs.owned, owned)
+		}
+	}
+}
+
+// MkdirAll creates a directory along with any parents that don't exist yet,
+// like `mkdir -p`.
+func (vfs *VFS) MkdirAll(path string, mode os.FileMode) error {
+	current := "/"
+
+	for _, name := range strings.Split(vfs.internalPath(path), "/") {
+		if name == "" {
+			continue
+		}
+
+		current = filepath.Join(current, name)
+		_, file, err := vfs.FindFile(current)
+
+		if err == nil {
+			if file.Type != T_DIR {
+				return fmt.Errorf("cannot create directory ‘%s’: %w", path, ErrNotDir)
+			}
+
+			continue
+		}
+
+		if _, err := vfs.Mkdir(current, mode); err != nil {
+			return fmt.Errorf("cannot create directory ‘%s’: %w", path, errors.Unwrap(err))
+		}
+	}
+
+	return nil
+}
+
+// RemoveAll removes a file, or a directory and everything in it, like
+// `rm -r`. Every directory that has files in it has to be writable.
+func (vfs *VFS) RemoveAll(path string) error {
+	filePath, file, err := vfs.LFindFile(path)
+
+	if err != nil {
+		return fmt.Errorf("cannot remove ‘%s’: %w", path, err)
+	} else if filePath == "/" {
+		return fmt.Errorf("it is dangerous to operate recursively on ‘/’")
+	}
+
+	parentPath, parent, err := vfs.writableDir(dirName(filePath))
+
+	if err != nil {
+		return fmt.Errorf("cannot remove ‘%s’: %w", path, err)
+	}
+
+	if !vfs.canUnlink(parent, file) || !vfs.canRemoveAll(file) {
+		return fmt.Errorf("cannot remove ‘%s’: %w", path, ErrPermission)
+	}
+
+	delete(parent.Files, filepath.Base(filePath))
+	vfs.disown(filePath)
+	vfs.touchDir(parentPath)
+
+	return nil
+}
+
+// canRemoveAll returns whether the user can remove everything in a directory.
+func (vfs *VFS) canRemoveAll(dir *VFSFile) bool {
+	if dir.Type != T_DIR || len(dir.Files) == 0 {
+		return true
+	}
+
+	for _, file := range dir.Files {
+		if !vfs.canUnlink(dir, &file) || !vfs.canRemoveAll(&file) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Rename moves a file or directory to a new path, like rename(2). The files
+// of a directory go along with it.
+func (vfs *VFS) Rename(src, dst string) error {
+	srcPath, file, err := vfs.LFindFile(src)
+
+	if err != nil {
+		return fmt.Errorf("cannot stat ‘%s’: %w", src, err)
+	}
+
+	dstPath := vfs.realPath(dst)
+
+	if srcPath == dstPath {
+		return fmt.Errorf("‘%s’ and ‘%s’ are the same file", src, dst)
+	} else if srcPath == "/" || (file.Type == T_DIR && strings.HasPrefix(dstPath, srcPath+"/")) {
+		return fmt.Errorf("cannot move ‘%s’ to a subdirectory of itself, ‘%s’", src, dst)
+	}
+
+	srcDirPath, srcDir, err := vfs.writableDir(dirName(srcPath))
+
+	if err != nil {
+		return fmt.Errorf("cannot move ‘%s’ to ‘%s’: %w", src, dst, err)
+	}
+
+	dstDirPath, dstDir, err := vfs.lookupDir(dstPath)
+
+	if err != nil {
+		return fmt.Errorf("cannot move ‘%s’ to ‘%s’: %w", src, dst, err)
+	}
+
+	if !vfs.canUnlink(srcDir, file) || !vfs.access(dstDir).Write {
+		return fmt.Errorf("cannot move ‘%s’ to ‘%s’: %w", src, dst, ErrPermission)
+	}
+
+	dstBase := filepath.Base(dstPath)
+
+	if existing, ok := dstDir.Files[dstBase]; ok {
+		if !vfs.canUnlink(dstDir, &existing) {
+			return fmt.Errorf("cannot move ‘%s’ to ‘%s’: %w", src, dst, ErrPermission)
+		} else if existing.Type == T_DIR && file.Type != T_DIR {
+			return fmt.Errorf("cannot overwrite directory ‘%s’ with non-directory", dst)
+		} else if existing.Type != T_DIR && file.Type == T_DIR {
+			return fmt.Errorf("cannot overwrite non-directory ‘%s’ with directory ‘%s’", dst, src)
+		} else if existing.Type == T_DIR && len(existing.Files) > 0 {
+			return fmt.Errorf("cannot move ‘%s’ to ‘%s’: %w", src, dst, ErrNotEmpty)
+		}
+	}
+
+	// Directories are copied, since an overlay only knows which of them are
+	// its own by their path.
+	moved := srcDir.Files[filepath.Base(srcPath)]
+	moved = copyFile(&moved)
+	moved.Name = dstBase
+
+	delete(srcDir.Files, filepath.Base(srcPath))
+	vfs.disown(srcPath)
+	vfs.disown(filepath.Join(dstDirPath, dstBase))
+	dstDir.Files[dstBase] = moved
+	vfs.claim(filepath.Join(dstDirPath, dstBase), &moved)
+
+	vfs.touchDir(srcDirPath)
+	vfs.touchDir(dstDirPath)
+
+	// A file that's moved into place (e.g. into /etc/cron.d) is as good as
+	// written there.
+	vfs.runWriteHooks(filepath.Join(dstDirPath, dstBase), &moved)
+
+	return nil
+}
+
+// runWriteHooks runs the write hooks for a file that ended up at a path
+// without being written to, and for the files in it if it's a directory.
+func (vfs *VFS) runWriteHooks(path string, file *VFSFile) {
+	switch file.Type {
+	case T_FILE:
+		for _, hook := range vfs.writeHooks {
+			hook(vfs.userPath(path), []byte(file.Contents), ArtifactSourceVFS)
+		}
+	case T_DIR:
+		for _, entry := range file.entries() {
+			child := file.Files[entry.Name()]
+			vfs.runWriteHooks(filepath.Join(path, entry.Name()), &child)
+		}
+	}
+}
+
+// Copy copies a file to a new path, like `cp`. Directories are only copied
+// when `recursive` is set (`cp -r`), and then their files are copied into the
+// destination, even if it already exists.
+func (vfs *VFS) Copy(src, dst string, recursive bool) error {
+	// Like `cp`, links are only copied as they are when copying recursively.
+	find := vfs.FindFile
+
+	if recursive {
+		find = vfs.LFindFile
+	}
+
+	srcPath, file, err := find(src)
+
+	if err != nil {
+		return fmt.Errorf("cannot stat ‘%s’: %w", src, err)
+	}
+
+	dstPath := vfs.realPath(dst)
+
+	if file.Type == T_DIR && !recursive {
+		return fmt.Errorf("-r not specified; omitting directory ‘%s’", src)
+	} else if !vfs.access(file).Read {
+		return fmt.Errorf("cannot open ‘%s’ for reading: %w", src, ErrPermission)
+	} else if srcPath == dstPath {
+		return fmt.Errorf("‘%s’ and ‘%s’ are the same file", src, dst)
+	} else if file.Type == T_DIR && strings.HasPrefix(dstPath, srcPath+"/") {
+		return fmt.Errorf("cannot copy a directory, ‘%s’, into itself, ‘%s’", src, dst)
+	}
+
+	_, existing, err := vfs.FindFile(dstPath)
+	exists := err == nil
+
+	switch {
+	case exists && existing.Type == T_DIR && file.Type != T_DIR:
+		return fmt.Errorf("cannot overwrite directory ‘%s’ with non-directory", dst)
+	case exists && existing.Type != T_DIR && file.Type == T_DIR:
+		return fmt.Errorf("cannot overwrite non-directory ‘%s’ with directory ‘%s’", dst, src)
+	case file.Type == T_SYMLINK:
+		if exists {
+			return fmt.Errorf("cannot create symbolic link ‘%s’: %w", dst, ErrExist)
+		}
+
+		return vfs.Symlink(file.LinkTo, dstPath)
+	case file.Type != T_DIR:
+		if err := vfs.WriteFile(dstPath, vfs.FileContents(file)); err != nil {
+			return fmt.Errorf("cannot create regular file ‘%s’: %s", dst, strError(err))
+		}
+
+		if !exists {
+			return vfs.updateFile(dstPath, func(f *VFSFile) {
+				f.Mode = file.Mode
+			})
+		}
+
+		return nil
+	}
+
+	if !exists {
+		if _, err := vfs.Mkdir(dstPath, file.Mode.Perm()); err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0, len(file.Files))
+
+	for name := range file.Files {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := vfs.Copy(filepath.Join(srcPath, name), filepath.Join(dstPath, name), true); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Chmod changes the permissions of a file, which only its owner can do. Like
+// chmod(2), the setgid bit is dropped when the owner isn't in the group of
+// the file.
+func (vfs *VFS) Chmod(path string, mode os.FileMode) error {
+	_, file, err := vfs.FindFile(path)
+
+	if err != nil {
+		return fmt.Errorf("cannot access ‘%s’: %w", path, err)
+	} else if !vfs.owns(file) {
+		return fmt.Errorf("changing permissions of ‘%s’: %w", path, ErrNotPermitted)
+	}
+
+	if _, group := vfs.fileOwner(file); !vfs.isRoot() && !vfs.user().InGroup(group) {
+		mode &^= os.ModeSetgid
+	}
+
+	return vfs.updateFile(path, func(f *VFSFile) {
+		f.Mode = (f.Mode &^ (os.ModePerm | specialBits)) | (mode & (os.ModePerm | specialBits))
+	})
+}
+
+// Chown changes the owner and group of a file. Either of them can be empty to
+// leave it as it is. Only root can give a file away, but the owner can change
+// the group to one that they're in. Like chown(2), this drops the setuid and
+// setgid bits of regular files.
+func (vfs *VFS) Chown(path, owner, group string) error {
+	_, file, err := vfs.FindFile(path)
+
+	if err != nil {
+		return fmt.Errorf("cannot access ‘%s’: %w", path, err)
+	}
+
+	fileOwner, fileGroup := vfs.fileOwner(file)
+	user := vfs.user()
+
+	if !vfs.isRoot() {
+		if fileOwner != user.Username || (owner != "" && owner != fileOwner) || (group != "" && group != fileGroup && !user.InGroup(group)) {
+			return fmt.Errorf("changing ownership of ‘%s’: %w", path, ErrNotPermitted)
+		}
+	}
+
+	owner = vfs.ownerName(owner)
+	group = vfs.ownerName(group)
+
+	return vfs.updateFile(path, func(f *VFSFile) {
+		if owner != "" {
+			f.Owner = owner
+		}
+
+		if group != "" {
+			f.Group = group
+		}
+
+		if f.Type == T_FILE {
+			f.Mode &^= os.ModeSetuid | os.ModeSetgid
+		}
+	})
+}
+
+// Symlink creates a symbolic link that points to the target, like `ln -s`.
+// The target doesn't need to exist.
+func (vfs *VFS) Symlink(target, link string) error {
+	dirPath, dir, err := vfs.lookupDir(link)
+
+	if err != nil {
+		return fmt.Errorf("failed to create symbolic link ‘%s’: %w", link, err)
+	}
+
+	base := filepath.Base(vfs.internalPath(link))
+
+	if _, ok := dir.Files[base]; ok {
+		return fmt.Errorf("failed to create symbolic link ‘%s’: %w", link, ErrExist)
+	} else if !vfs.access(dir).Write {
+		return fmt.Errorf("failed to create symbolic link ‘%s’: %w", link, ErrPermission)
+	}
+
+	owner, group := vfs.newOwner(dir)
+	dir.Files[base] = VFSFile{
+		Type:    T_SYMLINK,
+		Name:    base,
+		Mode:    os.ModeSymlink | 0777,
+		LinkTo:  target,
+		Owner:   owner,
+		Group:   group,
+		ModTime: time.Now(),
+	}
+	vfs.touchDir(dirPath)
+
+	return nil
+}
+
+// Touch creates an empty file, or updates the modification time of a file
+// that already exists, like `touch`.
+func (vfs *VFS) Touch(path string) error {
+	filePath, _, err := vfs.resolve(path, true)
+
+	if err == nil {
+		if err := vfs.Chtimes(path, time.Now()); err != nil {
+			return fmt.Errorf("cannot touch ‘%s’: %w", path, errors.Unwrap(err))
+		}
+
+		return nil
+	} else if filePath == "" {
+		return fmt.Errorf("cannot touch ‘%s’: %w", path, err)
+	}
+
+	// A link that points nowhere creates the file it points to.
+	dirPath, dir, err := vfs.lookupDir(filePath)
+
+	if err != nil {
+		return fmt.Errorf("cannot touch ‘%s’: %w", path, err)
+	} else if !vfs.access(dir).Write {
+		return fmt.Errorf("cannot touch ‘%s’: %w", path, ErrPermission)
+	}
+
+	base := filepath.Base(filePath)
+	owner, group := vfs.newOwner(dir)
+	dir.Files[base] = VFSFile{
+		Type:    T_FILE,
+		Name:    base,
+		Mode:    0664,
+		Owner:   owner,
+		Group:   group,
+		ModTime: time.Now(),
+	}
+	vfs.touchDir(dirPath)
+
+	return nil
+}
+
+// Chtimes sets the modification time of a file, which its owner, or anyone
+// who can write to it, can do.
+func (vfs *VFS) Chtimes(path string, mtime time.Time) error {
+	_, file, err := vfs.FindFile(path)
+
+	if err != nil {
+		return fmt.Errorf("setting times of ‘%s’: %w", path, err)
+	}
+
+	if !vfs.owns(file) && !vfs.access(file).Write {
+		return fmt.Errorf("setting times of ‘%s’: %w", path, ErrPermission)
+	}
+
+	return vfs.updateFile(path, func(f *VFSFile) {
+		f.ModTime = mtime
+	})
+}
+
+// ParseMode applies a mode the way `chmod` takes it, either in octal (`755`)
+// or symbolic (`u+x,go-w`), to the current mode of a file.
+func ParseMode(spec string, mode os.FileMode) (os.FileMode, error) {
+	invalid := fmt.Errorf("invalid mode: ‘%s’", spec)
+
+	if spec == "" {
+		return 0, invalid
+	}
+
+	if spec[0] >= '0' && spec[0] <= '7' {
+		var n uint64
+
+		for _, c := range spec {
+			if c < '0' || c > '7' {
+				return 0, invalid
+			}
+
+			n = n*8 + uint64(c-'0')
+		}
+
+		if n > 07777 {
+			return 0, invalid
+		}
+
+		newMode := os.FileMode(n & 0777)
+
+		if n&04000 != 0 {
+			newMode |= os.ModeSetuid
+		}
+
+		if n&02000 != 0 {
+			newMode |= os.ModeSetgid
+		}
+
+		if n&01000 != 0 {
+			newMode |= os.ModeSticky
+		}
+
+		return newMode, nil
+	}
+
+	perm := mode & (os.ModePerm | specialBits)
+
+	for _, clause := range strings.Split(spec, ",") {
+		who := strings.IndexAny(clause, "+-=")
+
+		if who < 0 {
+			return 0, invalid
+		}
+
+		var mask os.FileMode
+
+		for _, c := range clause[:who] {
+			switch c {
+			case 'u':
+				mask |= 0700 | os.ModeSetuid
+			case 'g':
+				mask |= 0070 | os.ModeSetgid
+			case 'o':
+				mask |= 0007 | os.ModeSticky
+			case 'a':
+				mask |= 0777 | specialBits
+			default:
+				return 0, invalid
+			}
+		}
+
+		if who == 0 {
+			// Without anyone, the change applies to everyone (ignoring the umask).
+			mask = 0777 | specialBits
+		}
+
+		ops := clause[who:]
+
+		for len(ops) > 0 {
+			op := ops[0]
+			end := strings.IndexAny(ops[1:], "+-=")
+
+			if end < 0 {
+				end = len(ops) - 1
+			}
+
+			var bits os.FileMode
+
+			for _, c := range ops[1 : end+1] {
+				switch c {
+				case 'r':
+					bits |= 0444
+				case 'w':
+					bits |= 0222
+				case 'x':
+					bits |= 0111
+				case 'X':
+					if mode.IsDir() || perm&0111 != 0 {
+						bits |= 0111
+					}
+				case 's':
+					bits |= os.ModeSetuid | os.ModeSetgid
+				case 't':
+					bits |= os.ModeSticky
+				default:
+					return 0, invalid
+				}
+			}
+
+			bits &= mask
+
+			switch op {
+			case '+':
+				perm |= bits
+			case '-':
+				perm &^= bits
+			case '=':
+				perm = (perm &^ mask) | bits
+			}
+
+			ops = ops[end+1:]
+		}
+	}
+
+	return perm, nil
+}
@@ -0,0 +1,204 @@
+package plugin
+
+import (
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// VFSChange is a single difference between an overlay and the VFS it's based
+// on. A changed directory only carries its own attributes (its Files are nil),
+// while a new directory carries everything that's in it.
+type VFSChange struct {
+	Path    string   `json:"p"`
+	Deleted bool     `json:"d,omitempty"`
+	File    *VFSFile `json:"f,omitempty"`
+}
+
+// Overlay returns a copy-on-write view of the VFS. The overlay shares all of
+// its files with the base until they change, and then only the directories on
+// the way to the change are copied, so that every session can have its own
+// version of the file system cheaply. The base must not change afterwards.
+func (vfs *VFS) Overlay() *VFS {
+	return &VFS{
+		Root:     vfs.Root,
+		Home:     vfs.Home,
+		PWD:      vfs.PWD,
+		User:     vfs.User,
+		Login:    vfs.Login,
+		Machine:  vfs.machine(),
+		Persona:  vfs.Persona,
+		RemoteIP: vfs.RemoteIP,
+		base:     vfs,
+		owned:    make(map[string]bool),
+		procs:    maps.Clone(vfs.procs),
+	}
+}
+
+// writableDir finds a directory that is about to be changed. In an overlay,
+// the directory (and every directory above it) is copied first, so that the
+// change doesn't leak into the base.
+func (vfs *VFS) writableDir(path string) (string, *VFSFile, error) {
+	dirPath, dir, err := vfs.FindFile(path)
+
+	if err != nil || vfs.owned == nil || dir.Type != T_DIR {
+		return dirPath, dir, err
+	}
+
+	return dirPath, vfs.own(dirPath), nil
+}
+
+// own copies the directories along the path, unless they were already copied
+// by this overlay, and returns the directory at the end of it.
+func (vfs *VFS) own(path string) *VFSFile {
+	if !vfs.owned["/"] {
+		vfs.Root.Files = maps.Clone(vfs.Root.Files)
+		vfs.owned["/"] = true
+	}
+
+	dir := &vfs.Root
+	current := "/"
+
+	for _, name := range strings.Split(path, "/") {
+		if name == "" {
+			continue
+		}
+
+		current = filepath.Join(current, name)
+		child, ok := dir.Files[name]
+
+		if !ok {
+			return nil
+		}
+
+		if child.Type == T_DIR && !vfs.owned[current] {
+			child.Files = maps.Clone(child.Files)
+			dir.Files[name] = child
+			vfs.owned[current] = true
+		}
+
+		dir = &child
+	}
+
+	return dir
+}
+
+// Diff returns everything that changed in the overlay, compared to its base,
+// ordered so that parents come before their children.
+func (vfs *VFS) Diff() []VFSChange {
+	changes := []VFSChange{}
+
+	if vfs.base != nil {
+		vfs.diffDir("/", &vfs.Root, &vfs.base.Root, &changes)
+	}
+
+	return changes
+}
+
+// diffDir compares the files of a directory with the same directory of the base.
+// Directories that were never copied can't have changed, so they're skipped.
+func (vfs *VFS) diffDir(path string, dir, baseDir *VFSFile, changes *[]VFSChange) {
+	if !vfs.owned[path] {
+		return
+	}
+
+	names := slices.Sorted(maps.Keys(dir.Files))
+
+	for name := range baseDir.Files {
+		if _, ok := dir.Files[name]; !ok {
+			names = append(names, name)
+		}
+	}
+
+	for _, name := range names {
+		filePath := filepath.Join(path, name)
+
+		if isVirtualPath(filePath) {
+			continue
+		}
+		file, ok := dir.Files[name]
+		baseFile, baseOk := baseDir.Files[name]
+
+		switch {
+		case !ok:
+			*changes = append(*changes, VFSChange{Path: filePath, Deleted: true})
+		case !baseOk:
+			*changes = append(*changes, VFSChange{Path: filePath, File: &file})
+		case file.Type == T_DIR && baseFile.Type == T_DIR:
+			if !sameFile(&file, &baseFile) {
+				attrs := file
+				attrs.Files = nil
+				*changes = append(*changes, VFSChange{Path: filePath, File: &attrs})
+			}
+
+			vfs.diffDir(filePath, &file, &baseFile, changes)
+		case !sameFile(&file, &baseFile):
+			*changes = append(*changes, VFSChange{Path: filePath, File: &file})
+		}
+	}
+}
+
+// Apply replays the changes of a diff onto the VFS. Changes to directories
+// that don't exist anymore are skipped.
+func (vfs *VFS) Apply(changes []VFSChange) {
+	for _, change := range changes {
+		_, dir, err := vfs.writableDir(dirName(change.Path))
+
+		if err != nil || dir.Type != T_DIR {
+			continue
+		}
+
+		name := filepath.Base(change.Path)
+
+		if change.Deleted {
+			delete(dir.Files, name)
+			continue
+		} else if change.File == nil {
+			continue
+		}
+
+		file := copyFile(change.File)
+		existing, ok := dir.Files[name]
+
+		if file.Type == T_DIR && file.Files == nil {
+			if ok && existing.Type == T_DIR {
+				file.Files = existing.Files
+			} else {
+				file.Files = make(map[string]VFSFile)
+			}
+		}
+
+		dir.Files[name] = file
+	}
+}
+
+// sameFile returns whether two files are the same, without looking at what's
+// inside of directories.
+func sameFile(a, b *VFSFile) bool {
+	return a.Type == b.Type &&
+		a.Name == b.Name &&
+		a.Contents == b.Contents &&
+		a.Mode == b.Mode &&
+		a.Owner == b.Owner &&
+		a.Group == b.Group &&
+		a.ModTime.Equal(b.ModTime) &&
+		a.LinkTo == b.LinkTo &&
+		a.NLink == b.NLink
+}
+
+// copyFile makes a deep copy of a file, so that it doesn't share any of its
+// directories with the original.
+func copyFile(f *VFSFile) VFSFile {
+	file := *f
+
+	if f.Files != nil {
+		file.Files = make(map[string]VFSFile, len(f.Files))
+
+		for name, child := range f.Files {
+			file.Files[name] = copyFile(&child)
+		}
+	}
+
+	return file
+}
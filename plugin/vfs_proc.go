@@ -0,0 +1,534 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// FileProvider produces the contents of a file every time that it's read, for
+// the files of /proc and /sys, which would give the honeypot away if they never
+// changed.
+type FileProvider interface {
+	Contents(vfs *VFS) string
+}
+
+// ProviderFunc lets a plain function be used as a FileProvider.
+type ProviderFunc func(vfs *VFS) string
+
+// Contents calls the function.
+func (fn ProviderFunc) Contents(vfs *VFS) string {
+	return fn(vfs)
+}
+
+// Machine describes the hardware, kernel and uptime that /proc and /sys
+// report.
+type Machine struct {
+	Booted   time.Time
+	CPUs     int
+	CPUModel string
+	CPUMHz   float64
+	MemTotal int // In kB.
+	Release  string
+	Version  string
+	Compiler string
+	Vendor   string
+	Product  string
+	MAC      string
+	lastPID  atomic.Int64
+}
+
+// DefaultMachine returns a small VM that has been up for a few weeks.
+func DefaultMachine() *Machine {
+	return &Machine{
+		Booted:   time.Now().Add(-time.Duration(3*24+rand.IntN(40*24)) * time.Hour),
+		CPUs:     2,
+		CPUModel: "Intel(R) Xeon(R) CPU E5-2680 v4 @ 2.40GHz",
+		CPUMHz:   2399.998,
+		MemTotal: 4026500,
+		Release:  "5.15.0-91-generic",
+		Version:  "#101-Ubuntu SMP Tue Nov 14 13:30:08 UTC 2023",
+		Compiler: "(buildd@lcy02-amd64-045) (gcc (Ubuntu 11.4.0-1ubuntu1~22.04) 11.4.0, GNU ld (GNU Binutils for Ubuntu) 2.38)",
+		Vendor:   "QEMU",
+		Product:  "Standard PC (i440FX + PIIX, 1996)",
+		MAC:      fmt.Sprintf("52:54:00:%02x:%02x:%02x", rand.IntN(256), rand.IntN(256), rand.IntN(256)),
+	}
+}
+
+// FileContents returns the contents of a file, which for dynamic files are
+// produced right now and for templated files are rendered for the session.
+func (vfs *VFS) FileContents(file *VFSFile) string {
+	contents := file.Contents
+
+	if file.Provider != nil {
+		contents = file.Provider.Contents(vfs)
+	}
+
+	if file.Template {
+		return vfs.render(contents)
+	}
+
+	return contents
+}
+
+// machine returns the machine of the VFS, or a default one.
+func (vfs *VFS) machine() *Machine {
+	if vfs.Machine == nil {
+		vfs.Machine = DefaultMachine()
+	}
+
+	return vfs.Machine
+}
+
+// NextPID returns the PID of a new process. Like on a real system, PIDs keep
+// going up (for every session of the machine), starting from wherever the
+// machine got to since it booted.
+func (vfs *VFS) NextPID() int {
+	m := vfs.machine()
+	m.lastPID.CompareAndSwap(0, int64(1000+rand.IntN(int(time.Since(m.Booted).Hours())+1000)))
+
+	return int(m.lastPID.Add(int64(1 + rand.IntN(3))))
+}
+
+// isVirtualPath returns whether a path is a file system that's made up when
+// it's read, and thus never part of the changes of a session.
+func isVirtualPath(path string) bool {
+	return path == "/proc" || path == "/sys"
+}
+
+// mount puts a file into the VFS, creating the directories on the way to it,
+// without any permission checks.
+func (vfs *VFS) mount(path string, file VFSFile) {
+	parent := "/"
+
+	for _, name := range strings.Split(dirName(path), "/") {
+		if name == "" {
+			continue
+		}
+
+		_, dir, _ := vfs.writableDir(parent)
+		current := filepath.Join(parent, name)
+
+		if existing, ok := dir.Files[name]; !ok || existing.Type != T_DIR {
+			newDir := VFSFile{
+				Type:    T_DIR,
+				Name:    name,
+				Mode:    os.ModeDir | 0555,
+				Owner:   "root",
+				Group:   "root",
+				ModTime: vfs.machine().Booted,
+				Files:   make(map[string]VFSFile),
+			}
+			dir.Files[name] = newDir
+			vfs.claim(current, &newDir)
+		}
+
+		parent = current
+	}
+
+	_, dir, _ := vfs.writableDir(parent)
+	file.Name = filepath.Base(path)
+
+	if file.Owner == "" {
+		file.Owner, file.Group = "root", "root"
+	}
+
+	if file.ModTime.IsZero() {
+		file.ModTime = vfs.machine().Booted
+	}
+
+	dir.Files[file.Name] = file
+	vfs.claim(path, &file)
+}
+
+// mountProvider adds a dynamic file to the VFS.
+func (vfs *VFS) mountProvider(path string, mode os.FileMode, fn ProviderFunc) {
+	vfs.mount(path, VFSFile{Type: T_FILE, Mode: mode, Provider: fn})
+}
+
+// mountText adds a file with fixed contents to the VFS.
+func (vfs *VFS) mountText(path, contents string) {
+	vfs.mount(path, VFSFile{Type: T_FILE, Mode: 0444, Contents: contents})
+}
+
+// MountProc replaces /proc and /sys with files that are generated from the
+// machine of the VFS. Whatever was in them before (for example the /proc of the
+// host that the VFS was cloned from) is thrown away.
+func (vfs *VFS) MountProc() {
+	_, root, _ := vfs.writableDir("/")
+	delete(root.Files, "proc")
+	delete(root.Files, "sys")
+	vfs.disown("/proc")
+	vfs.disown("/sys")
+
+	vfs.mountProvider("/proc/uptime", 0444, procUptime)
+	vfs.mountProvider("/proc/loadavg", 0444, procLoadavg)
+	vfs.mountProvider("/proc/meminfo", 0444, procMeminfo)
+	vfs.mountProvider("/proc/cpuinfo", 0444, procCpuinfo)
+	vfs.mountProvider("/proc/mounts", 0444, procMounts)
+	vfs.mountProvider("/proc/version", 0444, func(vfs *VFS) string {
+		m := vfs.machine()
+		return fmt.Sprintf("Linux version %s %s %s\n", m.Release, m.Compiler, m.Version)
+	})
+	vfs.mountProvider("/proc/sys/kernel/hostname", 0644, func(vfs *VFS) string {
+		return vfs.Hostname() + "\n"
+	})
+	vfs.mountProvider("/proc/sys/kernel/osrelease", 0444, func(vfs *VFS) string {
+		return vfs.machine().Release + "\n"
+	})
+	vfs.mountProvider("/proc/sys/kernel/version", 0444, func(vfs *VFS) string {
+		return vfs.machine().Version + "\n"
+	})
+	vfs.mountText("/proc/sys/kernel/ostype", "Linux\n")
+	vfs.mount("/proc/self", VFSFile{Type: T_SYMLINK, Mode: os.ModeSymlink | 0777, LinkTo: "1"})
+	vfs.procs = make(map[int]*Process)
+	vfs.mountSystemProcesses()
+
+	cpus := func(vfs *VFS) string {
+		if n := vfs.machine().CPUs; n > 1 {
+			return fmt.Sprintf("0-%d\n", n-1)
+		}
+
+		return "0\n"
+	}
+
+	for _, name := range []string{"online", "possible", "present"} {
+		vfs.mountProvider("/sys/devices/system/cpu/"+name, 0444, cpus)
+	}
+
+	vfs.mountText("/sys/class/net/lo/address", "00:00:00:00:00:00\n")
+	vfs.mountText("/sys/class/net/lo/operstate", "unknown\n")
+	vfs.mountText("/sys/class/net/lo/mtu", "65536\n")
+	vfs.mountProvider("/sys/class/net/eth0/address", 0444, func(vfs *VFS) string {
+		return vfs.machine().MAC + "\n"
+	})
+	vfs.mountText("/sys/class/net/eth0/operstate", "up\n")
+	vfs.mountText("/sys/class/net/eth0/mtu", "1500\n")
+	vfs.mountProvider("/sys/class/dmi/id/sys_vendor", 0444, func(vfs *VFS) string {
+		return vfs.machine().Vendor + "\n"
+	})
+	vfs.mountProvider("/sys/class/dmi/id/product_name", 0444, func(vfs *VFS) string {
+		return vfs.machine().Product + "\n"
+	})
+
+	// Most distributions have /etc/mtab as a link to the mounts of the process.
+	if _, _, err := vfs.LFindFile("/etc/mtab"); err != nil {
+		if _, etc, err := vfs.FindFile("/etc"); err == nil && etc.Type == T_DIR {
+			vfs.mount("/etc/mtab", VFSFile{Type: T_SYMLINK, Mode: os.ModeSymlink | 0777, LinkTo: "../proc/self/mounts"})
+		}
+	}
+}
+
+// MountProcess adds a process to the process table of the machine, and its
+// /proc/<pid> directory to the VFS.
+func (vfs *VFS) MountProcess(p *Process) {
+	if vfs.procs == nil {
+		vfs.procs = make(map[int]*Process)
+	}
+
+	vfs.procs[p.PID] = p
+	dir := fmt.Sprintf("/proc/%d", p.PID)
+	owner := "root"
+
+	if user, err := vfs.LookupUID(p.UID); err == nil {
+		owner = vfs.ownerName(user.Username)
+	} else if p.UID != 0 {
+		owner = "{}"
+	}
+
+	// The files are generated from the process that the reading VFS has, which
+	// may have changed since (e.g. if it was stopped).
+	pid := p.PID
+	process := func(fn func(vfs *VFS, p *Process) string) ProviderFunc {
+		return func(vfs *VFS) string {
+			if p := vfs.FindProcess(pid); p != nil {
+				return fn(vfs, p)
+			}
+
+			return ""
+		}
+	}
+	files := map[string]ProviderFunc{
+		"cmdline": process(procCmdline),
+		"comm":    process(func(_ *VFS, p *Process) string { return p.Name + "\n" }),
+		"status":  process(procStatus),
+		"stat":    process(procStat),
+		"mounts":  procMounts,
+	}
+
+	for name, fn := range files {
+		vfs.mount(dir+"/"+name, VFSFile{
+			Type:     T_FILE,
+			Mode:     0444,
+			Owner:    owner,
+			Group:    owner,
+			ModTime:  p.Started,
+			Provider: fn,
+		})
+	}
+
+	vfs.mount(dir+"/exe", VFSFile{
+		Type:    T_SYMLINK,
+		Mode:    os.ModeSymlink | 0777,
+		LinkTo:  p.Exe,
+		Owner:   owner,
+		Group:   owner,
+		ModTime: p.Started,
+	})
+	vfs.updateFile(dir, func(f *VFSFile) {
+		f.Owner, f.Group = owner, owner
+		f.ModTime = p.Started
+	})
+}
+
+// UnmountProcess removes a process that exited from the process table, along
+// with its /proc/<pid> directory.
+func (vfs *VFS) UnmountProcess(pid int) {
+	delete(vfs.procs, pid)
+	path := fmt.Sprintf("/proc/%d", pid)
+
+	if _, proc, err := vfs.writableDir("/proc"); err == nil {
+		delete(proc.Files, filepath.Base(path))
+		vfs.disown(path)
+	}
+}
+
+// SetProcSelf points /proc/self to a process.
+func (vfs *VFS) SetProcSelf(pid int) {
+	vfs.updateFile("/proc", func(f *VFSFile) {
+		self := f.Files["self"]
+		self.LinkTo = fmt.Sprint(pid)
+		f.Files["self"] = self
+	})
+}
+
+// procSelf returns the PID that /proc/self points to, which is the process of
+// the command that's running.
+func (vfs *VFS) procSelf() int {
+	path, _, err := vfs.FindFile("/proc/self")
+
+	if err != nil {
+		return 0
+	}
+
+	pid, _ := strconv.Atoi(filepath.Base(path))
+	return pid
+}
+
+// uptime returns how long the machine has been up.
+func (vfs *VFS) uptime() time.Duration {
+	return time.Since(vfs.machine().Booted)
+}
+
+// wave returns a number between 0 and 1 that slowly goes up and down, so that
+// things like the load and the free memory move around like they would on a
+// real machine.
+func wave(period time.Duration, phase float64) float64 {
+	t := float64(time.Now().UnixNano()) / float64(period)
+	return (math.Sin(2*math.Pi*t+phase) + 1) / 2
+}
+
+func procUptime(vfs *VFS) string {
+	up := vfs.uptime().Seconds()
+	idle := up * float64(vfs.machine().CPUs) * 0.97
+
+	return fmt.Sprintf("%.2f %.2f\n", up, idle)
+}
+
+func procLoadavg(vfs *VFS) string {
+	load := 0.02 + 0.3*wave(17*time.Minute, 0)
+	running := 1 + rand.IntN(2)
+	total := len(vfs.procs) + 20 + int(20*wave(43*time.Minute, 1))
+
+	return fmt.Sprintf("%.2f %.2f %.2f %d/%d %d\n", load*1.4, load, load*0.8, running, total, vfs.machine().lastPID.Load())
+}
+
+func procMeminfo(vfs *VFS) string {
+	total := vfs.machine().MemTotal
+	cached := total/4 + int(float64(total/20)*wave(3*time.Hour, 2))
+	buffers := total / 40
+	free := total/3 + int(float64(total/25)*wave(11*time.Minute, 0.5)) + rand.IntN(512)
+	available := free + cached + buffers - total/50
+	swap := total / 2
+	lines := [][2]any{
+		{"MemTotal", total},
+		{"MemFree", free},
+		{"MemAvailable", available},
+		{"Buffers", buffers},
+		{"Cached", cached},
+		{"SwapCached", 0},
+		{"Active", total / 5},
+		{"Inactive", total / 4},
+		{"Active(anon)", total / 40},
+		{"Inactive(anon)", total / 12},
+		{"Active(file)", total/5 - total/40},
+		{"Inactive(file)", total/4 - total/12},
+		{"Unevictable", 0},
+		{"Mlocked", 0},
+		{"SwapTotal", swap},
+		{"SwapFree", swap},
+		{"Dirty", rand.IntN(200)},
+		{"Writeback", 0},
+		{"AnonPages", total / 10},
+		{"Mapped", total / 30},
+		{"Shmem", total / 400},
+		{"KReclaimable", total / 30},
+		{"Slab", total / 20},
+		{"SReclaimable", total / 30},
+		{"SUnreclaim", total / 60},
+		{"KernelStack", 2976},
+		{"PageTables", 3808},
+		{"CommitLimit", total/2 + swap},
+		{"Committed_AS", total / 3},
+		{"VmallocTotal", 34359738367},
+		{"VmallocUsed", 14520},
+		{"VmallocChunk", 0},
+		{"HugePages_Total", 0},
+		{"HugePages_Free", 0},
+		{"Hugepagesize", 2048},
+	}
+	out := strings.Builder{}
+
+	for _, line := range lines {
+		if strings.HasPrefix(line[0].(string), "HugePages_") {
+			fmt.Fprintf(&out, "%-16s%8d\n", line[0].(string)+":", line[1])
+		} else {
+			fmt.Fprintf(&out, "%-16s%8d kB\n", line[0].(string)+":", line[1])
+		}
+	}
+
+	return out.String()
+}
+
+func procCpuinfo(vfs *VFS) string {
+	m := vfs.machine()
+	out := strings.Builder{}
+
+	for i := 0; i < m.CPUs; i++ {
+		fmt.Fprintf(&out, "processor\t: %d\n", i)
+		fmt.Fprintf(&out, "vendor_id\t: GenuineIntel\n")
+		fmt.Fprintf(&out, "cpu family\t: 6\n")
+		fmt.Fprintf(&out, "model\t\t: 79\n")
+		fmt.Fprintf(&out, "model name\t: %s\n", m.CPUModel)
+		fmt.Fprintf(&out, "stepping\t: 1\n")
+		fmt.Fprintf(&out, "microcode\t: 0xb000040\n")
+		fmt.Fprintf(&out, "cpu MHz\t\t: %.3f\n", m.CPUMHz)
+		fmt.Fprintf(&out, "cache size\t: 16384 KB\n")
+		fmt.Fprintf(&out, "physical id\t: %d\n", i)
+		fmt.Fprintf(&out, "siblings\t: 1\n")
+		fmt.Fprintf(&out, "core id\t\t: 0\n")
+		fmt.Fprintf(&out, "cpu cores\t: 1\n")
+		fmt.Fprintf(&out, "apicid\t\t: %d\n", i)
+		fmt.Fprintf(&out, "initial apicid\t: %d\n", i)
+		fmt.Fprintf(&out, "fpu\t\t: yes\n")
+		fmt.Fprintf(&out, "fpu_exception\t: yes\n")
+		fmt.Fprintf(&out, "cpuid level\t: 13\n")
+		fmt.Fprintf(&out, "wp\t\t: yes\n")
+		fmt.Fprintf(&out, "flags\t\t: fpu vme de pse tsc msr pae mce cx8 apic sep mtrr pge mca cmov pat pse36 clflush mmx fxsr sse sse2 ss syscall nx pdpe1gb rdtscp lm constant_tsc arch_perfmon rep_good nopl xtopology cpuid tsc_known_freq pni pclmulqdq vmx ssse3 fma cx16 pcid sse4_1 sse4_2 x2apic movbe popcnt tsc_deadline_timer aes xsave avx f16c rdrand hypervisor lahf_lm abm 3dnowprefetch cpuid_fault invpcid_single pti ssbd ibrs ibpb stibp tpr_shadow vnmi flexpriority ept vpid ept_ad fsgsbase tsc_adjust bmi1 hle avx2 smep bmi2 erms invpcid rtm rdseed adx smap xsaveopt arat umip md_clear arch_capabilities\n")
+		fmt.Fprintf(&out, "bugs\t\t: cpu_meltdown spectre_v1 spectre_v2 spec_store_bypass l1tf mds swapgs taa itlb_multihit mmio_stale_data\n")
+		fmt.Fprintf(&out, "bogomips\t: %.2f\n", m.CPUMHz*2)
+		fmt.Fprintf(&out, "clflush size\t: 64\n")
+		fmt.Fprintf(&out, "cache_alignment\t: 64\n")
+		fmt.Fprintf(&out, "address sizes\t: 40 bits physical, 48 bits virtual\n")
+		fmt.Fprintf(&out, "power management:\n\n")
+	}
+
+	return out.String()
+}
+
+func procMounts(vfs *VFS) string {
+	total := vfs.machine().MemTotal
+
+	return fmt.Sprintf(`sysfs /sys sysfs rw,nosuid,nodev,noexec,relatime 0 0
+proc /proc proc rw,nosuid,nodev,noexec,relatime 0 0
+udev /dev devtmpfs rw,nosuid,relatime,size=%dk,nr_inodes=%d,mode=755,inode64 0 0
+devpts /dev/pts devpts rw,nosuid,noexec,relatime,gid=5,mode=620,ptmxmode=000 0 0
+tmpfs /run tmpfs rw,nosuid,nodev,noexec,relatime,size=%dk,mode=755,inode64 0 0
+/dev/vda1 / ext4 rw,relatime,discard,errors=remount-ro 0 0
+tmpfs /dev/shm tmpfs rw,nosuid,nodev,inode64 0 0
+tmpfs /run/lock tmpfs rw,nosuid,nodev,noexec,relatime,size=5120k,inode64 0 0
+cgroup2 /sys/fs/cgroup cgroup2 rw,nosuid,nodev,noexec,relatime,nsdelegate,memory_recursiveprot 0 0
+/dev/vda15 /boot/efi vfat rw,relatime,fmask=0077,dmask=0077,codepage=437,iocharset=iso8859-1,shortname=mixed,errors=remount-ro 0 0
+tmpfs /run/user/0 tmpfs rw,nosuid,nodev,relatime,size=%dk,nr_inodes=%d,mode=700,inode64 0 0
+`, total/2-total/40, total/8, total/10, total/10, total/40)
+}
+
+// procStates are how /proc/<pid>/status describes the states of a process.
+var procStates = map[byte]string{
+	'R': "R (running)",
+	'S': "S (sleeping)",
+	'D': "D (disk sleep)",
+	'T': "T (stopped)",
+	'Z': "Z (zombie)",
+	'I': "I (idle)",
+}
+
+func procCmdline(vfs *VFS, p *Process) string {
+	if len(p.Cmdline) == 0 {
+		return ""
+	}
+
+	return strings.Join(p.Cmdline, "\x00") + "\x00"
+}
+
+func procStatus(vfs *VFS, p *Process) string {
+	state := p.Stat()
+
+	return fmt.Sprintf(`Name:	%s
+Umask:	0022
+State:	%s
+Tgid:	%d
+Ngid:	0
+Pid:	%d
+PPid:	%d
+TracerPid:	0
+Uid:	%d	%d	%d	%d
+Gid:	%d	%d	%d	%d
+FDSize:	256
+Groups:	%d
+VmPeak:	%8d kB
+VmSize:	%8d kB
+VmLck:	       0 kB
+VmPin:	       0 kB
+VmHWM:	%8d kB
+VmRSS:	%8d kB
+Threads:	1
+SigQ:	0/15243
+SigPnd:	0000000000000000
+ShdPnd:	0000000000000000
+SigBlk:	0000000000010000
+SigIgn:	0000000000380004
+SigCgt:	000000004b817efb
+CapInh:	0000000000000000
+CapPrm:	0000000000000000
+CapEff:	0000000000000000
+CapBnd:	000001ffffffffff
+CapAmb:	0000000000000000
+NoNewPrivs:	0
+Seccomp:	0
+Cpus_allowed_list:	0-%d
+voluntary_ctxt_switches:	%d
+nonvoluntary_ctxt_switches:	%d
+`, p.Name, procStates[state[0]], p.PID, p.PID, p.PPID,
+		p.UID, p.UID, p.UID, p.UID, p.UID, p.UID, p.UID, p.UID, p.UID,
+		p.VSZ+256, p.VSZ, p.RSS+128, p.RSS,
+		max(vfs.machine().CPUs-1, 0),
+		int(time.Since(p.Started).Seconds())/3+40, 3+rand.IntN(5))
+}
+
+func procStat(vfs *VFS, p *Process) string {
+	// The start time is in clock ticks since the machine booted.
+	started := int(p.Started.Sub(vfs.machine().Booted).Seconds() * 100)
+	running := int(time.Since(p.Started).Seconds())
+	ticks := int(p.CPUTime().Seconds() * 100)
+
+	return fmt.Sprintf("%d (%s) %c %d %d %d 34816 %d 4194560 %d 0 0 0 %d %d 0 0 20 0 1 0 %d %d %d 18446744073709551615 0 0 0 0 0 0 65536 3686404 1266761467 1 0 0 17 %d 0 0 0 0 0\n",
+		p.PID, p.Name, p.Stat()[0], p.PPID, p.PID, p.PID, p.PID, 1200+running/10, ticks*2/3, ticks/3,
+		max(started, 0), p.VSZ*1024, p.RSS/4, p.PID%max(vfs.machine().CPUs, 1))
+}
@@ -0,0 +1,81 @@
+package plugin
+
+import (
+	"strings"
+	"text/template"
+	"time"
+)
+
+// TemplateData is what the contents of a templated file can refer to, as in
+// `Welcome to {{.Hostname}}, {{.Username}}` or `{{.Now.Format "Jan 2"}}`.
+type TemplateData struct {
+	Username string
+	Home     string
+	Hostname string
+	IP       string
+	RemoteIP string
+	Kernel   string
+	Distro   string
+	Now      time.Time
+	Booted   time.Time
+	Machine  *Machine
+	Persona  *Persona
+}
+
+// templateFuncs are the functions that templates can call, on top of the ones
+// that text/template has.
+var templateFuncs = template.FuncMap{
+	// ago returns the time that was a duration ago, like `{{(ago "26h").Format "Mon Jan _2 15:04"}}`.
+	"ago": func(d string) time.Time {
+		duration, _ := time.ParseDuration(d)
+		return time.Now().Add(-duration)
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// templateData returns what the templates of the VFS are rendered with.
+func (vfs *VFS) templateData() *TemplateData {
+	m := vfs.machine()
+	data := &TemplateData{
+		Username: "{}",
+		Home:     vfs.userPath(vfs.Home),
+		Hostname: vfs.Hostname(),
+		IP:       vfs.localIP(),
+		RemoteIP: vfs.RemoteIP,
+		Kernel:   m.Release,
+		Now:      time.Now(),
+		Booted:   m.Booted,
+		Machine:  m,
+		Persona:  vfs.Persona,
+	}
+
+	if vfs.User != nil {
+		data.Username = vfs.User.Username
+	}
+
+	if vfs.Persona != nil {
+		data.Distro = vfs.Persona.Distro.PrettyName
+	}
+
+	return data
+}
+
+// render executes the contents of a templated file. Contents that aren't a
+// valid template are returned as they are, since that's what the file would
+// have had on a real machine.
+func (vfs *VFS) render(contents string) string {
+	tmpl, err := template.New("").Funcs(templateFuncs).Option("missingkey=zero").Parse(contents)
+
+	if err != nil {
+		return contents
+	}
+
+	out := strings.Builder{}
+
+	if err = tmpl.Execute(&out, vfs.templateData()); err != nil {
+		return contents
+	}
+
+	return out.String()
+}
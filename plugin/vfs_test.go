@@ -2,7 +2,15 @@ package plugin_test
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/wisepythagoras/honeyshell/plugin"
 )
@@ -15,6 +23,8 @@ func TestPathResolution(t *testing.T) {
 	vfs.User = &plugin.User{
 		Username: "{}",
 		Group:    "{}",
+		UID:      1000,
+		GID:      1000,
 	}
 
 	if err != nil {
@@ -60,6 +70,8 @@ func TestChdir(t *testing.T) {
 	vfs.User = &plugin.User{
 		Username: "{}",
 		Group:    "{}",
+		UID:      1000,
+		GID:      1000,
 	}
 
 	if err != nil {
@@ -71,6 +83,8 @@ func TestChdir(t *testing.T) {
 		User: &plugin.User{
 			Username: "test",
 			Group:    "test",
+			UID:      1000,
+			GID:      1000,
 		},
 	}
 	session.Chdir(vfs.Home)
@@ -130,3 +144,542 @@ func TestChdir(t *testing.T) {
 		t.Error("File found where there should't be one")
 	}
 }
+
+func TestOverlay(t *testing.T) {
+	base := &plugin.VFS{}
+	err := json.Unmarshal([]byte(testVfs), base)
+	base.User = &plugin.User{
+		Username: "{}",
+		Group:    "{}",
+		UID:      1000,
+		GID:      1000,
+	}
+
+	if err != nil {
+		t.Errorf("Error: %s", err)
+	}
+
+	first := base.Overlay()
+	second := base.Overlay()
+
+	if err = first.WriteFile("/home/{}/dropped", "payload"); err != nil {
+		t.Errorf("Error: %s", err)
+	}
+
+	if _, err = first.Mkdir("/home/{}/.cache", 0700); err != nil {
+		t.Errorf("Error: %s", err)
+	}
+
+	if err = first.WriteFile("/home/{}/.cache/x", "more"); err != nil {
+		t.Errorf("Error: %s", err)
+	}
+
+	if err = first.Rmfile("/home/{}/test.txt"); err != nil {
+		t.Errorf("Error: %s", err)
+	}
+
+	for _, vfs := range []*plugin.VFS{base, second} {
+		if _, _, err = vfs.FindFile("/home/{}/dropped"); err == nil {
+			t.Error("A file of the overlay leaked into another VFS")
+		}
+
+		if _, _, err = vfs.FindFile("/home/{}/test.txt"); err != nil {
+			t.Error("A file deleted in the overlay is gone from another VFS")
+		}
+	}
+
+	changes := first.Diff()
+	paths := []string{}
+
+	for _, change := range changes {
+		paths = append(paths, change.Path)
+	}
+
+	// The home directory itself changed too, since its modification time was updated.
+	if strings.Join(paths, " ") != "/home/{} /home/{}/.cache /home/{}/dropped /home/{}/test.txt" {
+		t.Errorf("Unexpected changes %q", paths)
+	}
+
+	if changes[0].File.Files != nil {
+		t.Error("A changed directory carries its files")
+	}
+
+	if !changes[3].Deleted {
+		t.Error("The removed file isn't marked as deleted")
+	}
+
+	// Replaying the diff onto another overlay should give the same files.
+	data, _ := json.Marshal(changes)
+	replayed := []plugin.VFSChange{}
+	json.Unmarshal(data, &replayed)
+	second.Apply(replayed)
+
+	if contents, err := second.ReadFile("/home/{}/.cache/x"); err != nil || contents != "more" {
+		t.Errorf("Unexpected contents %q (%v)", contents, err)
+	}
+
+	if _, _, err = second.FindFile("/home/{}/test.txt"); err == nil {
+		t.Error("The deleted file wasn't deleted by the diff")
+	}
+
+	if _, _, err = base.FindFile("/home/{}/.cache"); err == nil {
+		t.Error("Applying a diff changed the base")
+	}
+}
+
+func TestMutations(t *testing.T) {
+	vfs := &plugin.VFS{}
+	err := json.Unmarshal([]byte(testVfs), vfs)
+	vfs.User = &plugin.User{
+		Username: "test",
+		Group:    "test",
+		UID:      1000,
+		GID:      1000,
+	}
+
+	if err != nil {
+		t.Errorf("Error: %s", err)
+	}
+
+	vfs = vfs.Overlay()
+	vfs.PWD = vfs.Home
+
+	if err = vfs.MkdirAll("a/b/c", 0); err != nil {
+		t.Errorf("Error: %s", err)
+	}
+
+	if err = vfs.Copy("test.txt", "a/b/c/copy.txt", false); err != nil {
+		t.Errorf("Error: %s", err)
+	}
+
+	if err = vfs.Copy("a", "a2", false); err == nil || err.Error() != "-r not specified; omitting directory ‘a’" {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	if err = vfs.Copy("a", "a2", true); err != nil {
+		t.Errorf("Error: %s", err)
+	}
+
+	if contents, err := vfs.ReadFile("/home/test/a2/b/c/copy.txt"); err != nil || contents != "This is a test file" {
+		t.Errorf("Unexpected contents %q (%v)", contents, err)
+	}
+
+	if err = vfs.Rename("a2/b", "moved"); err != nil {
+		t.Errorf("Error: %s", err)
+	}
+
+	if _, _, err = vfs.FindFile("a2/b"); err == nil {
+		t.Error("The moved directory is still there")
+	}
+
+	if _, _, err = vfs.FindFile("moved/c/copy.txt"); err != nil {
+		t.Errorf("Error: %s", err)
+	}
+
+	if err = vfs.Rename("moved", "moved/c/inside"); err == nil {
+		t.Error("A directory was moved into itself")
+	}
+
+	if err = vfs.Touch("empty"); err != nil {
+		t.Errorf("Error: %s", err)
+	}
+
+	mode, err := plugin.ParseMode("u+x,go=r", 0644)
+
+	if err != nil || mode != 0744 {
+		t.Errorf("Unexpected mode %v (%v)", mode, err)
+	}
+
+	if err = vfs.Chmod("empty", mode); err != nil {
+		t.Errorf("Error: %s", err)
+	}
+
+	if _, f, _ := vfs.FindFile("empty"); f.Mode != 0744 {
+		t.Errorf("Unexpected mode %v", f.Mode)
+	}
+
+	if err = vfs.Chmod("/etc/issue", 0777); !errors.Is(err, plugin.ErrNotPermitted) {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	if err = vfs.Chown("empty", "root", ""); err == nil || err.Error() != "changing ownership of ‘empty’: Operation not permitted" {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	if err = vfs.Symlink("/etc/issue", "link"); err != nil {
+		t.Errorf("Error: %s", err)
+	}
+
+	if _, f, _ := vfs.LFindFile("link"); f.Type != plugin.T_SYMLINK || f.LinkTo != "/etc/issue" {
+		t.Errorf("Unexpected link %+v", f)
+	}
+
+	if err = vfs.Symlink("/etc/issue", "link"); !errors.Is(err, plugin.ErrExist) {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	if err = vfs.RemoveAll("a"); err != nil {
+		t.Errorf("Error: %s", err)
+	}
+
+	if _, _, err = vfs.FindFile("a/b"); err == nil {
+		t.Error("The removed directory is still there")
+	}
+
+	if err = vfs.RemoveAll("/etc"); !errors.Is(err, plugin.ErrPermission) {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	if err = vfs.Touch("/nope/file"); err == nil || err.Error() != "cannot touch ‘/nope/file’: No such file or directory" {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
+
+func TestFileHandles(t *testing.T) {
+	vfs := &plugin.VFS{}
+	err := json.Unmarshal([]byte(testVfs), vfs)
+	vfs.User = &plugin.User{
+		Username: "{}",
+		Group:    "{}",
+		UID:      1000,
+		GID:      1000,
+	}
+
+	if err != nil {
+		t.Errorf("Error: %s", err)
+	}
+
+	written := []string{}
+	vfs.AddWriteHook(func(path string, contents []byte, source string) {
+		written = append(written, path+":"+string(contents))
+	})
+
+	f, err := vfs.OpenFile("/home/{}/test.txt", os.O_WRONLY|os.O_APPEND, 0)
+
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	f.WriteString(", appended")
+	f.Close()
+
+	// Like `dd conv=notrunc seek=5`.
+	f, _ = vfs.OpenFile("/home/{}/test.txt", os.O_RDWR, 0)
+	f.Seek(5, io.SeekStart)
+	f.WriteString("IS")
+	f.Seek(0, io.SeekStart)
+	data, _ := io.ReadAll(f)
+	f.Close()
+
+	if string(data) != "This IS a test file, appended" {
+		t.Errorf("Unexpected contents %q", data)
+	}
+
+	if err = vfs.Truncate("/home/{}/test.txt", 4); err != nil {
+		t.Errorf("Error: %s", err)
+	}
+
+	if contents, _ := vfs.ReadFile("/home/{}/test.txt"); contents != "This" {
+		t.Errorf("Unexpected contents %q", contents)
+	}
+
+	if _, err = vfs.OpenFile("/home/{}/test.txt", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644); !errors.Is(err, plugin.ErrExist) {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	if _, err = vfs.OpenFile("/etc/issue", os.O_WRONLY, 0); !errors.Is(err, plugin.ErrPermission) {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	f, _ = vfs.OpenFile("/etc/issue", os.O_RDONLY, 0)
+
+	if _, err = f.WriteString("x"); !errors.Is(err, plugin.ErrBadFD) {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	if strings.Join(written, "|") != "/home/{}/test.txt:This is a test file, appended|/home/{}/test.txt:This IS a test file, appended|/home/{}/test.txt:This" {
+		t.Errorf("Unexpected writes %q", written)
+	}
+}
+
+func TestSymlinks(t *testing.T) {
+	vfs := &plugin.VFS{}
+	err := json.Unmarshal([]byte(testVfs), vfs)
+	vfs.User = &plugin.User{
+		Username: "{}",
+		Group:    "{}",
+		UID:      1000,
+		GID:      1000,
+	}
+	vfs.PWD = vfs.Home
+
+	if err != nil {
+		t.Errorf("Error: %s", err)
+	}
+
+	vfs.Symlink("../../etc", "rel")
+	vfs.Symlink("/etc", "abs")
+	vfs.Symlink("rel/issue", "issue")
+	vfs.Symlink("loop", "loop")
+	vfs.Symlink("new.txt", "dangling")
+
+	for _, path := range []string{"rel/issue", "abs/issue", "issue", "/home/{}/rel/../rel/issue"} {
+		p, f, err := vfs.FindFile(path)
+
+		if err != nil {
+			t.Errorf("%s: %s", path, err)
+		} else if p != "/etc/issue" || f.Contents != "Ubuntu 22.04" {
+			t.Errorf("%s: unexpected file %s %+v", path, p, f)
+		}
+	}
+
+	if p, f, err := vfs.LFindFile("issue"); err != nil || p != "/home/{}/issue" || f.Type != plugin.T_SYMLINK {
+		t.Errorf("Unexpected link %s %+v %v", p, f, err)
+	}
+
+	if _, _, err = vfs.FindFile("loop"); !errors.Is(err, plugin.ErrLoop) {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	if err = vfs.WriteFile("dangling", "created"); err != nil {
+		t.Errorf("Error: %s", err)
+	}
+
+	if contents, _ := vfs.ReadFile("new.txt"); contents != "created" {
+		t.Errorf("Unexpected contents %q", contents)
+	}
+
+	if err = vfs.RemoveAll("rel"); err != nil {
+		t.Errorf("Error: %s", err)
+	}
+
+	if _, _, err = vfs.FindFile("/etc/hostname"); err != nil {
+		t.Error("Removing a link removed what it points to")
+	}
+}
+
+func TestFS(t *testing.T) {
+	vfs := &plugin.VFS{}
+	err := json.Unmarshal([]byte(testVfs), vfs)
+
+	if err != nil {
+		t.Errorf("Error: %s", err)
+	}
+
+	fsys := vfs.FS()
+
+	if err = fstest.TestFS(fsys, "etc/issue", "etc/hostname", "home/{}/test.txt"); err != nil {
+		t.Error(err)
+	}
+
+	matches, err := fs.Glob(fsys, "etc/*")
+
+	if err != nil || strings.Join(matches, " ") != "etc/hostname etc/issue" {
+		t.Errorf("Unexpected matches %q (%v)", matches, err)
+	}
+
+	info, err := fs.Stat(fsys, "etc/issue")
+
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	} else if info.Size() != 12 || info.Mode() != 0644 {
+		t.Errorf("Unexpected info %d %s", info.Size(), info.Mode())
+	} else if sys := info.Sys().(*plugin.VFSFileSys); sys.Owner != "root" || sys.NLink != 1 {
+		t.Errorf("Unexpected sys %+v", sys)
+	}
+
+	if _, err = fs.ReadFile(fsys, "etc/nope"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	// Hidden files are found where they are, rather than without their dot.
+	vfs.User = &plugin.User{Username: "root", Group: "root"}
+	vfs.WriteFile("/.dockerenv", "hidden")
+	vfs.WriteFile("/dockerenv", "visible")
+
+	if data, err := fs.ReadFile(fsys, ".dockerenv"); err != nil || string(data) != "hidden" {
+		t.Errorf("Unexpected contents %q (%v)", data, err)
+	}
+}
+
+func TestProc(t *testing.T) {
+	base := &plugin.VFS{}
+	err := json.Unmarshal([]byte(testVfs), base)
+
+	if err != nil {
+		t.Errorf("Error: %s", err)
+	}
+
+	base.Machine = plugin.DefaultMachine()
+	base.Machine.Booted = time.Now().Add(-time.Hour)
+	base.MountProc()
+
+	vfs := base.Overlay()
+	vfs.User = &plugin.User{Username: "bob", Group: "bob", UID: 1001, GID: 1001}
+	vfs.MountProcess(&plugin.Process{
+		PID:     1234,
+		PPID:    1,
+		UID:     1000,
+		Name:    "bash",
+		Exe:     "/usr/bin/bash",
+		Cmdline: []string{"bash", "-i"},
+		Started: time.Now(),
+	})
+	vfs.SetProcSelf(1234)
+
+	uptime, err := vfs.ReadFile("/proc/uptime")
+
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	} else if !strings.HasPrefix(uptime, "3600.") && !strings.HasPrefix(uptime, "3599.") {
+		t.Errorf("Unexpected uptime %q", uptime)
+	}
+
+	if cmdline, _ := vfs.ReadFile("/proc/self/cmdline"); cmdline != "bash\x00-i\x00" {
+		t.Errorf("Unexpected cmdline %q", cmdline)
+	}
+
+	if status, _ := vfs.ReadFile("/proc/1234/status"); !strings.Contains(status, "Pid:\t1234\n") {
+		t.Errorf("Unexpected status %q", status)
+	}
+
+	if mounts, _ := vfs.ReadFile("/etc/mtab"); !strings.Contains(mounts, "proc /proc proc") {
+		t.Errorf("Unexpected mounts %q", mounts)
+	}
+
+	if info, err := fs.Stat(vfs.FS(), "proc/meminfo"); err != nil || info.Size() != 0 {
+		t.Errorf("Unexpected info %v (%v)", info, err)
+	}
+
+	if _, _, err = base.FindFile("/proc/1234"); err == nil {
+		t.Error("The process leaked into the base")
+	}
+
+	vfs.UnmountProcess(1234)
+
+	if changes := vfs.Diff(); len(changes) != 0 {
+		t.Errorf("Unexpected changes %+v", changes)
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	base := &plugin.VFS{}
+	err := json.Unmarshal([]byte(testVfs), base)
+
+	if err != nil {
+		t.Errorf("Error: %s", err)
+	}
+
+	etc := base.Root.Files["etc"]
+	issue := etc.Files["issue"]
+	issue.Contents = "Hi {{.Username}} from {{.RemoteIP}} on {{.Hostname}}, it's {{.Now.Year}} {{if .Persona}}{{.Persona.Distro.ID}}{{end}}"
+	issue.Template = true
+	etc.Files["issue"] = issue
+
+	vfs := base.Overlay()
+	vfs.User = &plugin.User{Username: "bob", Group: "bob", UID: 1001, GID: 1001}
+	vfs.RemoteIP = "203.0.113.5"
+	expected := fmt.Sprintf("Hi bob from 203.0.113.5 on test-hostname, it's %d ", time.Now().Year())
+
+	if contents, _ := vfs.ReadFile("/etc/issue"); contents != expected {
+		t.Errorf("%q != %q", contents, expected)
+	}
+
+	vfs.SetPersona(plugin.NewPersona("test", "debian"))
+
+	if contents, _ := vfs.ReadFile("/etc/issue"); !strings.HasSuffix(contents, " debian") {
+		t.Errorf("Unexpected contents %q", contents)
+	}
+
+	vfs.User = &plugin.User{Username: "root", Group: "root"}
+
+	if err = vfs.WriteFile("/etc/issue", "{{.Username}}"); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	if contents, _ := vfs.ReadFile("/etc/issue"); contents != "{{.Username}}" {
+		t.Errorf("Written contents were rendered: %q", contents)
+	}
+}
+
+func TestUsers(t *testing.T) {
+	vfs := &plugin.VFS{}
+	err := json.Unmarshal([]byte(testVfs), vfs)
+
+	if err != nil {
+		t.Errorf("Error: %s", err)
+	}
+
+	root := &plugin.User{Username: "root", Group: "root"}
+	vfs.User = root
+	vfs.WriteFile("/etc/passwd", "root:x:0:0:root:/root:/bin/bash\nalice:x:1001:1001::/home/alice:/bin/sh\nbob:x:1002:1002::/home/bob:/bin/bash\n")
+	vfs.WriteFile("/etc/group", "root:x:0:\nalice:x:1001:\nbob:x:1002:\ndevs:x:2000:alice,bob\n")
+
+	bob, err := vfs.LookupUser("bob")
+
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	} else if bob.UID != 1002 || bob.Group != "bob" || strings.Join(bob.Groups, ",") != "devs" || bob.Home != "/home/bob" {
+		t.Errorf("Unexpected user %+v", bob)
+	}
+
+	if user := vfs.SessionUser("mallory"); user.UID != 1000 || user.Home != "/home/mallory" {
+		t.Errorf("Unexpected session user %+v", user)
+	}
+
+	alice, _ := vfs.LookupUser("alice")
+
+	if _, err = vfs.Mkdir("/tmp", 0777|os.ModeSticky); err != nil {
+		t.Fatalf("Error: %s", err)
+	} else if _, err = vfs.Mkdir("/srv", 0775|os.ModeSetgid); err != nil {
+		t.Fatalf("Error: %s", err)
+	} else if err = vfs.Chown("/srv", "", "devs"); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	vfs.User = alice
+
+	if err = vfs.WriteFile("/tmp/a", "a"); err != nil {
+		t.Errorf("Error: %s", err)
+	}
+
+	if err = vfs.WriteFile("/srv/b", "b"); err != nil {
+		t.Errorf("Error: %s", err)
+	} else if _, file, _ := vfs.FindFile("/srv/b"); file.Owner != "{}" || file.Group != "devs" {
+		t.Errorf("Unexpected owner %s:%s", file.Owner, file.Group)
+	}
+
+	if err = vfs.WriteFile("/etc/passwd", ""); !errors.Is(err, plugin.ErrPermission) {
+		t.Errorf("Expected %v, got %v", plugin.ErrPermission, err)
+	}
+
+	// Bob is in the group of the file, but /tmp is sticky.
+	vfs.User, vfs.Login = bob, alice
+
+	if err = vfs.WriteFile("/srv/b", "bob"); err != nil {
+		t.Errorf("Error: %s", err)
+	}
+
+	if err = vfs.RemoveAll("/tmp/a"); !errors.Is(err, plugin.ErrPermission) {
+		t.Errorf("Expected %v, got %v", plugin.ErrPermission, err)
+	}
+
+	vfs.User = root
+
+	if err = vfs.RemoveAll("/tmp/a"); err != nil {
+		t.Errorf("Error: %s", err)
+	}
+
+	if _, file, _ := vfs.FindFile("/etc/passwd"); !file.CanAccess(root).Write || file.CanAccess(root).Exec {
+		t.Errorf("Unexpected permissions for root %+v", file.CanAccess(root))
+	}
+
+	// Any user with the ID 0 is root, whatever their name.
+	vfs.WriteFile("/etc/passwd", "root:x:0:0:root:/root:/bin/bash\ntoor:x:0:0::/root:/bin/sh\n")
+
+	if toor, err := vfs.LookupUser("toor"); err != nil || !toor.IsRoot() {
+		t.Fatalf("Expected toor to be root (%v)", err)
+	} else if vfs.User = toor; vfs.WriteFile("/etc/shadow", "") != nil {
+		t.Error("Expected toor to be able to write to /etc")
+	}
+}